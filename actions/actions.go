@@ -42,6 +42,63 @@ type Action interface {
 	sealed()
 }
 
+// maxChunkSize is the maximal size of Action output sent in a single result.
+const maxChunkSize = 1024 * 1024
+
+// Chunk represents a part of StreamingAction output.
+type Chunk struct {
+	Data     []byte
+	Progress float32 // from 0 to 1; 0 if unknown
+}
+
+// go-sumtype:decl StreamingAction
+
+// StreamingAction describes an Action that emits output in ordered chunks instead of returning it at once.
+type StreamingAction interface {
+	// ID returns an Action ID.
+	ID() string
+	// Type returns an Action type.
+	Type() string
+	// RunStream runs an Action, passes output chunks to send in order, and returns error.
+	// It should stop and return when send returns error.
+	RunStream(ctx context.Context, send func(Chunk) error) error
+
+	sealed()
+}
+
+// Stream returns StreamingAction for a given Action.
+// Actions that don't implement StreamingAction themselves are run as usual,
+// and their output is split into chunks after that.
+func Stream(a Action) StreamingAction {
+	if sa, ok := a.(StreamingAction); ok {
+		return sa
+	}
+	return &oneShotAction{a}
+}
+
+// oneShotAction adapts Action to StreamingAction interface.
+type oneShotAction struct {
+	Action
+}
+
+// RunStream implements StreamingAction.
+func (a *oneShotAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	b, err := a.Run(ctx)
+
+	n := (len(b) + maxChunkSize - 1) / maxChunkSize
+	for i := 0; i < n; i++ {
+		end := (i + 1) * maxChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if e := send(Chunk{Data: b[i*maxChunkSize : end], Progress: float32(i+1) / float32(n)}); e != nil {
+			return e
+		}
+	}
+
+	return err
+}
+
 // readRows reads and closes given *sql.Rows, returning columns, data rows, and first encountered error.
 func readRows(rows *sql.Rows) (columns []string, dataRows [][]interface{}, err error) {
 	defer func() {
@@ -17,31 +17,75 @@ package actions
 
 import (
 	"context"
+	"fmt"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+
+	"github.com/percona/pmm-agent/utils/redact"
+)
+
+const (
+	prometheusNamespace = "pmm_agent"
+	prometheusSubsystem = "actions"
 )
 
-// ActionResult represents an Action result.
+// ActionResult represents an Action result or a chunk of it.
+// Chunks of the same Action are sent in order; the last result has Partial unset and contains Error.
 type ActionResult struct {
-	ID     string
-	Output []byte
-	Error  string
+	ID       string
+	Output   []byte
+	Error    string
+	Partial  bool    // true for all chunks except the last one
+	Progress float32 // from 0 to 1 for chunks; 0 if unknown
+}
+
+// Limits represent ConcurrentRunner limits. Zero and negative values mean no limit.
+type Limits struct {
+	MaxRunning           int // running Actions
+	MaxRunningPerService int // running Actions for the same service (DSN)
+	MaxQueued            int // Actions waiting for start
+}
+
+// queuedAction represents an Action waiting for start.
+type queuedAction struct {
+	service string
+	queued  time.Time
+	start   chan struct{} // closed by schedule
 }
 
 // ConcurrentRunner represents concurrent Action runner.
 // Action runner is component that can run an Actions.
+//
+// Actions are started in FIFO order when limits allow that; Actions for other services may overtake
+// Actions for a service that already runs the maximal number of Actions.
 type ConcurrentRunner struct {
-	ctx     context.Context
-	l       *logrus.Entry
-	results chan ActionResult
+	ctx      context.Context
+	l        *logrus.Entry
+	limits   Limits
+	redactor *redact.Redactor
+	results  chan ActionResult
 
+	// counts queued and running Actions; Add is called only with m held and stopped unset,
+	// so it is never called concurrently with Wait
 	runningActions sync.WaitGroup
 
+	m                 sync.Mutex
+	stopped           bool
+	queue             []*queuedAction
+	running           int
+	runningPerService map[string]int
+
 	rw            sync.RWMutex
 	actionsCancel map[string]context.CancelFunc
+
+	mQueueWait prometheus.Histogram
+	mRejected  prometheus.Counter
 }
 
 // NewConcurrentRunner returns new runner.
@@ -49,17 +93,42 @@ type ConcurrentRunner struct {
 //
 // ConcurrentRunner is stopped when context passed to NewConcurrentRunner is canceled.
 // Results are reported via Results() channel which must be read until it is closed.
-func NewConcurrentRunner(ctx context.Context) *ConcurrentRunner {
+//
+// Output of all Actions is redacted by redactor (that may be nil) before it is reported.
+// Streamed output is redacted chunk by chunk, so rules do not match text split between chunks.
+func NewConcurrentRunner(ctx context.Context, limits Limits, redactor *redact.Redactor) *ConcurrentRunner {
 	r := &ConcurrentRunner{
-		ctx:           ctx,
-		l:             logrus.WithField("component", "actions-runner"),
-		results:       make(chan ActionResult),
-		actionsCancel: make(map[string]context.CancelFunc),
+		ctx:               ctx,
+		l:                 logrus.WithField("component", "actions-runner"),
+		limits:            limits,
+		redactor:          redactor,
+		results:           make(chan ActionResult),
+		runningPerService: make(map[string]int),
+		actionsCancel:     make(map[string]context.CancelFunc),
+
+		mQueueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "queue_wait_seconds",
+			Help:      "Time spent by Actions in the queue before start.",
+			Buckets:   []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}),
+		mRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "rejected_total",
+			Help:      "A total number of Actions rejected due to the full queue.",
+		}),
 	}
 
 	// let all actions finish and send their results before closing it
 	go func() {
 		<-ctx.Done()
+
+		r.m.Lock()
+		r.stopped = true
+		r.m.Unlock()
+
 		r.runningActions.Wait()
 		r.l.Infof("Done.")
 		close(r.results)
@@ -68,69 +137,207 @@ func NewConcurrentRunner(ctx context.Context) *ConcurrentRunner {
 	return r
 }
 
-// Start starts an Action in a separate goroutine.
-func (r *ConcurrentRunner) Start(a Action, timeout time.Duration) {
-	if err := r.ctx.Err(); err != nil {
-		r.l.Errorf("Ignoring Start: %s.", err)
-		return
-	}
+// Start starts an Action in a separate goroutine when limits allow that.
+// Service is a key (typically, DSN) for per-service limit; empty service is limited only by global limits.
+func (r *ConcurrentRunner) Start(a Action, service string, timeout time.Duration) {
+	r.StartStreaming(Stream(a), service, timeout)
+}
+
+// StartStreaming starts a StreamingAction in a separate goroutine when limits allow that.
+// See Start for details.
+//
+// Timeout is counted from the Action start, not including time spent in the queue;
+// queued Action can be canceled with Stop.
+func (r *ConcurrentRunner) StartStreaming(a StreamingAction, service string, timeout time.Duration) {
 	actionID, actionType := a.ID(), a.Type()
+	l := r.l.WithFields(logrus.Fields{"id": actionID, "type": actionType})
+
+	q := &queuedAction{
+		service: service,
+		queued:  time.Now(),
+		start:   make(chan struct{}),
+	}
 
-	// FIXME There is a data race. Add must not be called concurrently with Wait, but it can be:
-	// 0. no actions are running, WaitGroup has 0
-	// 1. Start is called
-	// 2. ctx is canceled on this line
-	// 3. Wait is called in the goroutine above
-	// 4. Add is called below
-	// 5. Add panics with "sync: WaitGroup misuse: Add called concurrently with Wait"
-	// See skipped test (run it in a loop with race detector).
-	// https://jira.percona.com/browse/PMM-4112
-	// https://jira.percona.com/browse/PMM-7206
+	r.m.Lock()
+	if r.stopped || r.ctx.Err() != nil {
+		r.m.Unlock()
+		l.Errorf("Ignoring Start: %s.", context.Canceled)
+		return
+	}
 	r.runningActions.Add(1)
+	r.queue = append(r.queue, q)
+	r.schedule()
+	rejected := r.limits.MaxQueued > 0 && len(r.queue) > r.limits.MaxQueued && r.dequeue(q)
+	r.m.Unlock()
+
+	if rejected {
+		r.mRejected.Inc()
+		l.Warnf("Rejected: %d Actions are queued.", r.limits.MaxQueued)
+		go func() {
+			defer r.runningActions.Done()
+			r.results <- ActionResult{
+				ID:    actionID,
+				Error: fmt.Sprintf("too many Actions are queued (%d), try again later", r.limits.MaxQueued),
+			}
+		}()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.rw.Lock()
+	r.actionsCancel[actionID] = cancel
+	r.rw.Unlock()
 
-	ctx, cancel := context.WithTimeout(r.ctx, timeout)
 	run := func(ctx context.Context) {
 		defer r.runningActions.Done()
 		defer cancel()
 
-		r.rw.Lock()
-		r.actionsCancel[actionID] = cancel
-		r.rw.Unlock()
+		// remove before sending the final result
+		forget := func() {
+			r.rw.Lock()
+			delete(r.actionsCancel, actionID)
+			r.rw.Unlock()
+		}
+
+		select {
+		case <-q.start:
+		case <-ctx.Done():
+			r.m.Lock()
+			dequeued := r.dequeue(q)
+			r.m.Unlock()
+
+			// start was granted concurrently; run as usual, Action will see canceled context
+			if !dequeued {
+				<-q.start
+				break
+			}
+
+			forget()
+			l.Warnf("Canceled in queue: %s.", ctx.Err())
+			r.results <- ActionResult{
+				ID:    actionID,
+				Error: ctx.Err().Error(),
+			}
+			return
+		}
+
+		wait := time.Since(q.queued)
+		r.mQueueWait.Observe(wait.Seconds())
+		l.Infof("Starting after %s in queue...", wait)
 
-		l := r.l.WithFields(logrus.Fields{"id": actionID, "type": actionType})
-		l.Infof("Starting...")
+		ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
 
-		b, err := a.Run(ctx)
+		// hold back the last chunk to send it with the final result
+		var last *Chunk
+		var chunks int
+		dialect := outputDialect(actionType)
+		err := a.RunStream(ctx, func(c Chunk) error {
+			var err error
+			if c.Data, err = r.redactor.Output(dialect, c.Data); err != nil {
+				return errors.Wrap(err, "failed to redact output")
+			}
 
-		r.rw.Lock()
-		delete(r.actionsCancel, actionID)
-		r.rw.Unlock()
+			if last != nil {
+				r.results <- ActionResult{
+					ID:       actionID,
+					Output:   last.Data,
+					Partial:  true,
+					Progress: last.Progress,
+				}
+			}
+			last = &c
+			chunks++
+			return nil
+		})
 
+		r.release(q)
+		forget()
 		if err == nil {
-			l.Infof("Done without error.")
+			l.Infof("Done without error, %d chunk(s).", chunks)
 		} else {
 			l.Warnf("Done with error: %s.", err)
 		}
 
-		var errorS string
-		if err != nil {
-			errorS = err.Error()
+		res := ActionResult{
+			ID: actionID,
 		}
-		r.results <- ActionResult{
-			ID:     actionID,
-			Output: b,
-			Error:  errorS,
+		if last != nil {
+			res.Output = last.Data
+			res.Progress = last.Progress
+		}
+		if err != nil {
+			res.Error = err.Error()
 		}
+		r.results <- res
 	}
+
 	go pprof.Do(ctx, pprof.Labels("actionID", actionID, "type", actionType), run)
 }
 
+// outputDialect returns query language of Action output by Action type.
+func outputDialect(actionType string) redact.Dialect {
+	switch {
+	case strings.HasPrefix(actionType, "postgresql-"):
+		return redact.PostgreSQL
+	case strings.HasPrefix(actionType, "mongodb-"):
+		return redact.MongoDB
+	default:
+		return redact.MySQL
+	}
+}
+
+// schedule starts queued Actions in order while limits allow that.
+// Must be called with r.m held.
+func (r *ConcurrentRunner) schedule() {
+	for i := 0; i < len(r.queue); {
+		if r.limits.MaxRunning > 0 && r.running >= r.limits.MaxRunning {
+			return
+		}
+
+		q := r.queue[i]
+		if q.service != "" && r.limits.MaxRunningPerService > 0 && r.runningPerService[q.service] >= r.limits.MaxRunningPerService {
+			i++
+			continue
+		}
+
+		r.queue = append(r.queue[:i], r.queue[i+1:]...)
+		r.running++
+		r.runningPerService[q.service]++
+		close(q.start)
+	}
+}
+
+// dequeue removes Action from the queue. It returns false if Action is not there.
+// Must be called with r.m held.
+func (r *ConcurrentRunner) dequeue(q *queuedAction) bool {
+	for i, e := range r.queue {
+		if e == q {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release frees limits taken by finished Action and starts queued Actions.
+func (r *ConcurrentRunner) release(q *queuedAction) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.running--
+	if r.runningPerService[q.service]--; r.runningPerService[q.service] == 0 {
+		delete(r.runningPerService, q.service)
+	}
+	r.schedule()
+}
+
 // Results returns channel with Actions results.
 func (r *ConcurrentRunner) Results() <-chan ActionResult {
 	return r.results
 }
 
-// Stop stops running Action.
+// Stop stops running or queued Action.
 func (r *ConcurrentRunner) Stop(id string) {
 	r.rw.RLock()
 	defer r.rw.RUnlock()
@@ -138,3 +345,36 @@ func (r *ConcurrentRunner) Stop(id string) {
 		cancel()
 	}
 }
+
+// Describe implements prometheus.Collector.
+func (r *ConcurrentRunner) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(r, ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *ConcurrentRunner) Collect(ch chan<- prometheus.Metric) {
+	r.m.Lock()
+	running, queued := r.running, len(r.queue)
+	r.m.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, float64(running))
+	ch <- prometheus.MustNewConstMetric(queuedDesc, prometheus.GaugeValue, float64(queued))
+	r.mQueueWait.Collect(ch)
+	r.mRejected.Collect(ch)
+}
+
+var (
+	runningDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "running"),
+		"The current number of running Actions.",
+		nil, nil)
+	queuedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "queued"),
+		"The current number of Actions waiting for start.",
+		nil, nil)
+)
+
+// check interfaces
+var (
+	_ prometheus.Collector = (*ConcurrentRunner)(nil)
+)
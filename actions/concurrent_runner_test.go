@@ -17,11 +17,16 @@ package actions
 
 import (
 	"context"
+	"errors"
 	"sort"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/config"
+	"github.com/percona/pmm-agent/utils/redact"
 )
 
 // assertResults checks expected results in any order.
@@ -45,12 +50,12 @@ func assertResults(t *testing.T, cr *ConcurrentRunner, expected ...ActionResult)
 func TestConcurrentRunnerRun(t *testing.T) {
 	t.Parallel()
 
-	cr := NewConcurrentRunner(context.Background())
+	cr := NewConcurrentRunner(context.Background(), Limits{}, nil)
 	a1 := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "echo", []string{"test"})
 	a2 := NewProcessAction("/action_id/84140ab2-612d-4d93-9360-162a4bd5de14", "echo", []string{"test2"})
 
-	cr.Start(a1, 5*time.Second)
-	cr.Start(a2, 5*time.Second)
+	cr.Start(a1, "", 5*time.Second)
+	cr.Start(a2, "", 5*time.Second)
 
 	expected := []ActionResult{
 		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Output: []byte("test\n")},
@@ -60,15 +65,33 @@ func TestConcurrentRunnerRun(t *testing.T) {
 	assert.Empty(t, cr.actionsCancel)
 }
 
+func TestConcurrentRunnerRedact(t *testing.T) {
+	t.Parallel()
+
+	redactor, err := redact.New(&config.QueryExamples{
+		Rules: []config.RedactRule{{Pattern: `secret`, Replacement: "***"}},
+	})
+	require.NoError(t, err)
+
+	cr := NewConcurrentRunner(context.Background(), Limits{}, redactor)
+	a := NewProcessAction("/action_id/0f3b2a0e-4a49-4bc8-9a4b-0b4f1b5b5f0a", "echo", []string{"my secret"})
+	cr.Start(a, "", 5*time.Second)
+
+	expected := []ActionResult{
+		{ID: "/action_id/0f3b2a0e-4a49-4bc8-9a4b-0b4f1b5b5f0a", Output: []byte("my ***\n")},
+	}
+	assertResults(t, cr, expected...)
+}
+
 func TestConcurrentRunnerTimeout(t *testing.T) {
 	t.Parallel()
 
-	cr := NewConcurrentRunner(context.Background())
+	cr := NewConcurrentRunner(context.Background(), Limits{}, nil)
 	a1 := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "sleep", []string{"20"})
 	a2 := NewProcessAction("/action_id/84140ab2-612d-4d93-9360-162a4bd5de14", "sleep", []string{"30"})
 
-	cr.Start(a1, time.Second)
-	cr.Start(a2, time.Second)
+	cr.Start(a1, "", time.Second)
+	cr.Start(a2, "", time.Second)
 
 	// https://github.com/golang/go/issues/21880
 	expected := []ActionResult{
@@ -82,12 +105,12 @@ func TestConcurrentRunnerTimeout(t *testing.T) {
 func TestConcurrentRunnerStop(t *testing.T) {
 	t.Parallel()
 
-	cr := NewConcurrentRunner(context.Background())
+	cr := NewConcurrentRunner(context.Background(), Limits{}, nil)
 	a1 := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "sleep", []string{"20"})
 	a2 := NewProcessAction("/action_id/84140ab2-612d-4d93-9360-162a4bd5de14", "sleep", []string{"30"})
 
-	cr.Start(a1, 5*time.Second)
-	cr.Start(a2, 5*time.Second)
+	cr.Start(a1, "", 5*time.Second)
+	cr.Start(a2, "", 5*time.Second)
 
 	<-time.After(time.Second)
 
@@ -107,12 +130,12 @@ func TestConcurrentRunnerCancel(t *testing.T) {
 	t.Parallel()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	cr := NewConcurrentRunner(ctx)
+	cr := NewConcurrentRunner(ctx, Limits{}, nil)
 	a1 := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "sleep", []string{"20"})
 	a2 := NewProcessAction("/action_id/84140ab2-612d-4d93-9360-162a4bd5de14", "sleep", []string{"30"})
 
-	cr.Start(a1, 5*time.Second)
-	cr.Start(a2, 5*time.Second)
+	cr.Start(a1, "", 5*time.Second)
+	cr.Start(a2, "", 5*time.Second)
 
 	cancel()
 
@@ -130,19 +153,222 @@ func TestConcurrentRunnerCancel(t *testing.T) {
 }
 
 func TestConcurrentRunnerCancelEmpty(t *testing.T) {
-	t.Skip("https://jira.percona.com/browse/PMM-4112")
 	t.Parallel()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cr := NewConcurrentRunner(ctx)
-	a := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "sleep", []string{"20"})
+	// https://jira.percona.com/browse/PMM-4112
+	// run it in a loop with race detector to check for WaitGroup misuse
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cr := NewConcurrentRunner(ctx, Limits{}, nil)
+		a := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "sleep", []string{"20"})
+
+		go cancel()
+		cr.Start(a, "", 5*time.Second)
+
+		// Start is either ignored, or Action is canceled
+		var results []ActionResult
+		for r := range cr.Results() {
+			results = append(results, r)
+		}
+		require.LessOrEqual(t, len(results), 1)
+		if len(results) == 1 {
+			assert.Equal(t, "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", results[0].ID)
+			assert.Contains(t, []string{"signal: killed", context.Canceled.Error()}, results[0].Error)
+		}
+		assert.Empty(t, cr.actionsCancel)
+	}
+}
+
+// blockingAction runs until unblocked or canceled.
+type blockingAction struct {
+	id      string
+	started chan struct{}
+	unblock chan struct{}
+}
 
-	go cancel()
-	cr.Start(a, 5*time.Second)
+func newBlockingAction(id string) *blockingAction {
+	return &blockingAction{
+		id:      id,
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+}
+
+func (a *blockingAction) ID() string   { return a.id }
+func (a *blockingAction) Type() string { return "test-blocking" }
+func (a *blockingAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	close(a.started)
+	select {
+	case <-a.unblock:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+func (a *blockingAction) sealed() {}
+
+func TestConcurrentRunnerLimits(t *testing.T) {
+	t.Parallel()
+
+	cr := NewConcurrentRunner(context.Background(), Limits{MaxRunning: 2, MaxRunningPerService: 1, MaxQueued: 2}, nil)
+	a1 := newBlockingAction("a1")
+	a2 := newBlockingAction("a2")
+	a3 := newBlockingAction("a3")
+	a4 := newBlockingAction("a4")
+	a5 := newBlockingAction("a5")
+	a6 := newBlockingAction("a6")
+
+	cr.StartStreaming(a1, "s1", 5*time.Second)
+	cr.StartStreaming(a2, "s1", 5*time.Second) // queued: per-service limit
+	cr.StartStreaming(a3, "s2", 5*time.Second)
+	cr.StartStreaming(a4, "s3", 5*time.Second) // queued: global limit
+	cr.StartStreaming(a5, "", 5*time.Second)   // rejected: queue is full
+	<-a1.started
+	<-a3.started
+
+	assert.Equal(t, ActionResult{ID: "a5", Error: "too many Actions are queued (2), try again later"}, <-cr.Results())
+
+	cr.m.Lock()
+	assert.Equal(t, 2, cr.running)
+	assert.Len(t, cr.queue, 2)
+	cr.m.Unlock()
+
+	// a2 is the first in the queue, and its service is free now
+	close(a1.unblock)
+	assert.Equal(t, ActionResult{ID: "a1"}, <-cr.Results())
+	<-a2.started
+
+	// a4 is still waiting for global limit, and can be stopped there
+	cr.Stop(a4.ID())
+	assert.Equal(t, ActionResult{ID: "a4", Error: context.Canceled.Error()}, <-cr.Results())
+
+	cr.StartStreaming(a6, "s3", 5*time.Second)
+	close(a3.unblock)
+	assert.Equal(t, ActionResult{ID: "a3"}, <-cr.Results())
+	<-a6.started
+
+	close(a2.unblock)
+	close(a6.unblock)
+	assertResults(t, cr, ActionResult{ID: "a2"}, ActionResult{ID: "a6"})
+
+	cr.m.Lock()
+	assert.Zero(t, cr.running)
+	assert.Empty(t, cr.queue)
+	assert.Empty(t, cr.runningPerService)
+	cr.m.Unlock()
+	assert.Empty(t, cr.actionsCancel)
+}
+
+func TestConcurrentRunnerTimeoutInQueue(t *testing.T) {
+	t.Parallel()
+
+	cr := NewConcurrentRunner(context.Background(), Limits{MaxRunning: 1}, nil)
+	a1 := newBlockingAction("a1")
+	a2 := newBlockingAction("a2")
+
+	cr.StartStreaming(a1, "", 5*time.Second)
+	cr.StartStreaming(a2, "", time.Second) // queued: global limit
+	<-a1.started
+
+	// time in the queue is not counted
+	time.Sleep(2 * time.Second)
+	close(a1.unblock)
+	assert.Equal(t, ActionResult{ID: "a1"}, <-cr.Results())
+	<-a2.started
+	close(a2.unblock)
+	assert.Equal(t, ActionResult{ID: "a2"}, <-cr.Results())
+
+	assert.Empty(t, cr.actionsCancel)
+}
+
+// testStreamingAction emits given chunks.
+type testStreamingAction struct {
+	id     string
+	chunks []string
+	err    error
+}
+
+func (a *testStreamingAction) ID() string   { return a.id }
+func (a *testStreamingAction) Type() string { return "test-streaming" }
+func (a *testStreamingAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	for i, c := range a.chunks {
+		if err := send(Chunk{Data: []byte(c), Progress: float32(i+1) / float32(len(a.chunks))}); err != nil {
+			return err
+		}
+	}
+	return a.err
+}
+func (a *testStreamingAction) sealed() {}
+
+func TestConcurrentRunnerStreaming(t *testing.T) {
+	t.Parallel()
+
+	cr := NewConcurrentRunner(context.Background(), Limits{}, nil)
+	cr.StartStreaming(&testStreamingAction{
+		id:     "/action_id/6a479303-5081-46d0-baa0-87d6248c987b",
+		chunks: []string{"1", "2", "3", "4"},
+		err:    errors.New("failed"),
+	}, "", 5*time.Second)
 
 	expected := []ActionResult{
-		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Error: context.Canceled.Error()},
+		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Output: []byte("1"), Partial: true, Progress: 0.25},
+		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Output: []byte("2"), Partial: true, Progress: 0.5},
+		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Output: []byte("3"), Partial: true, Progress: 0.75},
+		{ID: "/action_id/6a479303-5081-46d0-baa0-87d6248c987b", Output: []byte("4"), Progress: 1, Error: "failed"},
 	}
-	assertResults(t, cr, expected...)
+	for _, e := range expected {
+		assert.Equal(t, e, <-cr.Results())
+	}
+	assert.Empty(t, cr.actionsCancel)
+}
+
+func TestConcurrentRunnerLargeOutput(t *testing.T) {
+	t.Parallel()
+
+	cr := NewConcurrentRunner(context.Background(), Limits{}, nil)
+	a := NewProcessAction("/action_id/6a479303-5081-46d0-baa0-87d6248c987b", "head", []string{"-c", "2500000", "/dev/zero"})
+	cr.Start(a, "", 5*time.Second)
+
+	var output []byte
+	for {
+		r := <-cr.Results()
+		assert.Empty(t, r.Error)
+		assert.LessOrEqual(t, len(r.Output), maxChunkSize)
+		output = append(output, r.Output...)
+		if !r.Partial {
+			break
+		}
+	}
+	assert.Len(t, output, 2500000)
 	assert.Empty(t, cr.actionsCancel)
 }
+
+// testAction returns given output.
+type testAction struct {
+	id     string
+	output []byte
+}
+
+func (a *testAction) ID() string                              { return a.id }
+func (a *testAction) Type() string                            { return "test" }
+func (a *testAction) Run(ctx context.Context) ([]byte, error) { return a.output, nil }
+func (a *testAction) sealed()                                 {}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	a := &testAction{id: "test", output: make([]byte, 2*maxChunkSize+1)}
+	var chunks []Chunk
+	err := Stream(a).RunStream(context.Background(), func(c Chunk) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	assert.Len(t, chunks[0].Data, maxChunkSize)
+	assert.Len(t, chunks[2].Data, 1)
+	assert.Equal(t, float32(1), chunks[2].Progress)
+
+	pa := NewProcessAction("test", "true", nil)
+	assert.Equal(t, pa, Stream(pa), "process Action streams itself")
+}
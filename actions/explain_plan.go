@@ -0,0 +1,331 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Warnings of normalized plan nodes.
+const (
+	planWarningFullScan  = "full_scan" // all rows of the table or collection are read
+	planWarningFilesort  = "filesort"  // rows are sorted without index
+	planWarningTemporary = "temporary" // temporary table or disk is used
+)
+
+// planNode represents a node of the normalized plan tree, common for MySQL, PostgreSQL and MongoDB.
+// It is returned alongside the raw explain output.
+type planNode struct {
+	NodeType      string      `json:"node_type"`                // MySQL operation or access type, PostgreSQL node type, MongoDB stage
+	Object        string      `json:"object,omitempty"`         // table or collection
+	Index         string      `json:"index,omitempty"`          // used index
+	Condition     string      `json:"condition,omitempty"`      // filter applied to rows read by node
+	EstimatedRows *float64    `json:"estimated_rows,omitempty"` // per scan or loop
+	ActualRows    *float64    `json:"actual_rows,omitempty"`    // per scan or loop
+	Cost          *float64    `json:"cost,omitempty"`           // total cost in engine units
+	Warnings      []string    `json:"warnings,omitempty"`
+	Children      []*planNode `json:"children,omitempty"`
+}
+
+// warn adds warning if it is not already present.
+func (n *planNode) warn(warning string) {
+	for _, w := range n.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	n.Warnings = append(n.Warnings, warning)
+}
+
+// planNumber returns a number from JSON value that can be a number or a string (as in MySQL cost_info).
+func planNumber(v interface{}) *float64 {
+	switch v := v.(type) {
+	case float64:
+		return &v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return &f
+		}
+	}
+	return nil
+}
+
+// planString returns a string from JSON value, or empty string.
+func planString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// planMap returns an object from JSON value, or nil.
+func planMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// planMaps returns objects from JSON array value, skipping other elements.
+func planMaps(v interface{}) []map[string]interface{} {
+	a, _ := v.([]interface{})
+	res := make([]map[string]interface{}, 0, len(a))
+	for _, e := range a {
+		if m := planMap(e); m != nil {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// normalizeMySQLPlan returns normalized plan for MySQL `EXPLAIN FORMAT=JSON` output.
+func normalizeMySQLPlan(b []byte) (*planNode, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	qb := planMap(m["query_block"])
+	if qb == nil {
+		return nil, errors.New("query_block not found")
+	}
+	return mysqlPlanNode("query_block", qb), nil
+}
+
+// mysqlPlanNode returns normalized plan node for MySQL operation (query_block, table, nested_loop, ordering_operation, etc.).
+func mysqlPlanNode(operation string, m map[string]interface{}) *planNode {
+	node := &planNode{
+		NodeType: operation,
+	}
+
+	if cost := planMap(m["cost_info"]); cost != nil {
+		if node.Cost = planNumber(cost["query_cost"]); node.Cost == nil {
+			node.Cost = planNumber(cost["prefix_cost"])
+		}
+	}
+	// MariaDB uses filesort and temporary_table operations
+	if m["using_filesort"] == true || operation == "filesort" {
+		node.warn(planWarningFilesort)
+	}
+	if m["using_temporary_table"] == true || operation == "temporary_table" {
+		node.warn(planWarningTemporary)
+	}
+
+	if operation == "table" {
+		if accessType := planString(m["access_type"]); accessType != "" {
+			node.NodeType = accessType
+		}
+		node.Object = planString(m["table_name"])
+		node.Index = planString(m["key"])
+		node.Condition = planString(m["attached_condition"])
+		if node.EstimatedRows = planNumber(m["rows_examined_per_scan"]); node.EstimatedRows == nil {
+			node.EstimatedRows = planNumber(m["rows"]) // MySQL 5.6
+		}
+		if node.NodeType == "ALL" {
+			node.warn(planWarningFullScan)
+		}
+	}
+
+	// nested operations; keys are sorted to make output stable,
+	// arrays (nested_loop, query_specifications, *_subqueries) keep order of elements
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case map[string]interface{}:
+			if k != "cost_info" {
+				node.Children = append(node.Children, mysqlPlanNode(k, v))
+			}
+		case []interface{}:
+			elements := planMaps(v)
+			if len(elements) == 0 {
+				continue
+			}
+			group := &planNode{NodeType: k}
+			for _, e := range elements {
+				group.Children = append(group.Children, mysqlPlanNode(k, e).Children...)
+			}
+			node.Children = append(node.Children, group)
+		}
+	}
+
+	return node
+}
+
+// normalizePostgreSQLPlan returns normalized plan for PostgreSQL `EXPLAIN (FORMAT JSON)` output.
+func normalizePostgreSQLPlan(b []byte) (*planNode, error) {
+	var a []map[string]interface{}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(a) == 0 || planMap(a[0]["Plan"]) == nil {
+		return nil, errors.New("Plan not found")
+	}
+	return postgresqlPlanNode(planMap(a[0]["Plan"])), nil
+}
+
+// postgresqlPlanNode returns normalized plan node for PostgreSQL plan node.
+func postgresqlPlanNode(m map[string]interface{}) *planNode {
+	node := &planNode{
+		NodeType:      planString(m["Node Type"]),
+		Index:         planString(m["Index Name"]),
+		Condition:     planString(m["Filter"]),
+		EstimatedRows: planNumber(m["Plan Rows"]),
+		ActualRows:    planNumber(m["Actual Rows"]),
+		Cost:          planNumber(m["Total Cost"]),
+	}
+
+	for _, k := range []string{"Relation Name", "CTE Name", "Function Name"} {
+		if node.Object = planString(m[k]); node.Object != "" {
+			break
+		}
+	}
+	if schema := planString(m["Schema"]); schema != "" && m["Relation Name"] != nil {
+		node.Object = schema + "." + node.Object
+	}
+
+	switch node.NodeType {
+	case "Seq Scan":
+		node.warn(planWarningFullScan)
+	case "Sort", "Incremental Sort":
+		node.warn(planWarningFilesort)
+	}
+	if planString(m["Sort Space Type"]) == "Disk" {
+		node.warn(planWarningTemporary)
+	}
+
+	for _, child := range planMaps(m["Plans"]) {
+		node.Children = append(node.Children, postgresqlPlanNode(child))
+	}
+
+	return node
+}
+
+// normalizeMongoDBPlan returns normalized plan for MongoDB explain command output in relaxed Extended JSON.
+func normalizeMongoDBPlan(b []byte) (*planNode, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// aggregate with pipeline stages
+	if stages := planMaps(m["stages"]); len(stages) != 0 {
+		node := &planNode{NodeType: "aggregate"}
+		for _, stage := range stages {
+			if cursor := planMap(stage["$cursor"]); cursor != nil {
+				child, err := mongodbPlan(cursor)
+				if err != nil {
+					return nil, err
+				}
+				node.Children = append(node.Children, child)
+				continue
+			}
+
+			// stage document also contains execution statistics
+			for k := range stage {
+				if strings.HasPrefix(k, "$") {
+					node.Children = append(node.Children, &planNode{
+						NodeType:   k,
+						ActualRows: planNumber(stage["nReturned"]),
+					})
+					break
+				}
+			}
+		}
+		return node, nil
+	}
+
+	return mongodbPlan(m)
+}
+
+// mongodbPlan returns normalized plan for MongoDB explain document with queryPlanner and optional executionStats.
+func mongodbPlan(m map[string]interface{}) (*planNode, error) {
+	queryPlanner := planMap(m["queryPlanner"])
+	winningPlan := planMap(queryPlanner["winningPlan"])
+	if winningPlan == nil {
+		return nil, errors.New("queryPlanner.winningPlan not found")
+	}
+	namespace := planString(queryPlanner["namespace"])
+
+	// slot-based execution engine (MongoDB 5.1+) has a different execution stages tree
+	if queryPlan := planMap(winningPlan["queryPlan"]); queryPlan != nil {
+		node := mongodbPlanNode(namespace, queryPlan)
+		if executionStats := planMap(m["executionStats"]); executionStats != nil {
+			node.ActualRows = planNumber(executionStats["nReturned"])
+		}
+		return node, nil
+	}
+
+	if executionStages := planMap(planMap(m["executionStats"])["executionStages"]); executionStages != nil {
+		return mongodbPlanNode(namespace, executionStages), nil
+	}
+	return mongodbPlanNode(namespace, winningPlan), nil
+}
+
+// mongodbPlanNode returns normalized plan node for MongoDB plan or execution stage.
+func mongodbPlanNode(namespace string, m map[string]interface{}) *planNode {
+	node := &planNode{
+		NodeType:   planString(m["stage"]),
+		Index:      planString(m["indexName"]),
+		ActualRows: planNumber(m["nReturned"]),
+	}
+	if filter := planMap(m["filter"]); len(filter) != 0 {
+		if b, err := json.Marshal(filter); err == nil {
+			node.Condition = string(b)
+		}
+	}
+
+	switch node.NodeType {
+	case "COLLSCAN":
+		node.warn(planWarningFullScan)
+	case "SORT":
+		node.warn(planWarningFilesort)
+	}
+	if m["usedDisk"] == true {
+		node.warn(planWarningTemporary)
+	}
+
+	var children []map[string]interface{}
+	if inputStage := planMap(m["inputStage"]); inputStage != nil {
+		children = append(children, inputStage)
+	}
+	children = append(children, planMaps(m["inputStages"])...)
+	for _, child := range children {
+		node.Children = append(node.Children, mongodbPlanNode(namespace, child))
+	}
+
+	// sharded cluster
+	for _, shard := range planMaps(m["shards"]) {
+		if plan := planMap(shard["winningPlan"]); plan != nil {
+			if queryPlan := planMap(plan["queryPlan"]); queryPlan != nil {
+				plan = queryPlan
+			}
+			node.Children = append(node.Children, mongodbPlanNode(namespace, plan))
+		}
+		if stages := planMap(shard["executionStages"]); stages != nil {
+			node.Children = append(node.Children, mongodbPlanNode(namespace, stages))
+		}
+	}
+
+	if len(node.Children) == 0 && node.NodeType != "EOF" {
+		node.Object = namespace
+	}
+
+	return node
+}
@@ -0,0 +1,228 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNormalizePlan(t *testing.T) {
+	t.Parallel()
+
+	read := func(t *testing.T, name string) []byte {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join("testdata", "explain", name)) //nolint:gosec
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("MySQL", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := normalizeMySQLPlan(read(t, "mysql_join.json"))
+		require.NoError(t, err)
+		expected := &planNode{
+			NodeType: "query_block",
+			Cost:     pointer.ToFloat64(1528.41),
+			Children: []*planNode{{
+				NodeType: "ordering_operation",
+				Warnings: []string{planWarningFilesort},
+				Children: []*planNode{{
+					NodeType: "grouping_operation",
+					Warnings: []string{planWarningTemporary},
+					Children: []*planNode{{
+						NodeType: "nested_loop",
+						Children: []*planNode{{
+							NodeType:      "ALL",
+							Object:        "country",
+							EstimatedRows: pointer.ToFloat64(239),
+							Cost:          pointer.ToFloat64(24.9),
+							Warnings:      []string{planWarningFullScan},
+						}, {
+							NodeType:      "ref",
+							Object:        "city",
+							Index:         "CountryCode",
+							Condition:     "(`world`.`city`.`Population` > 100000)",
+							EstimatedRows: pointer.ToFloat64(18),
+							Cost:          pointer.ToFloat64(1528.41),
+						}},
+					}},
+				}},
+			}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("MySQLSubquery", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := normalizeMySQLPlan(read(t, "mysql_subquery.json"))
+		require.NoError(t, err)
+		expected := &planNode{
+			NodeType: "query_block",
+			Children: []*planNode{{
+				NodeType:      "range",
+				Object:        "city",
+				Index:         "PRIMARY",
+				Condition:     "(`world`.`city`.`ID` < 11)",
+				EstimatedRows: pointer.ToFloat64(10),
+				Children: []*planNode{{
+					NodeType: "attached_subqueries",
+					Children: []*planNode{{
+						NodeType: "query_block",
+						Children: []*planNode{{
+							NodeType:      "index",
+							Object:        "country",
+							Index:         "PRIMARY",
+							EstimatedRows: pointer.ToFloat64(239),
+						}},
+					}},
+				}},
+			}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := normalizePostgreSQLPlan(read(t, "postgresql_analyze.json"))
+		require.NoError(t, err)
+		expected := &planNode{
+			NodeType:      "Sort",
+			EstimatedRows: pointer.ToFloat64(496),
+			ActualRows:    pointer.ToFloat64(490),
+			Cost:          pointer.ToFloat64(106.22),
+			Warnings:      []string{planWarningFilesort, planWarningTemporary},
+			Children: []*planNode{{
+				NodeType:      "Hash Join",
+				EstimatedRows: pointer.ToFloat64(496),
+				ActualRows:    pointer.ToFloat64(490),
+				Cost:          pointer.ToFloat64(82.72),
+				Children: []*planNode{{
+					NodeType:      "Seq Scan",
+					Object:        "city",
+					Condition:     "(population > 1000000)",
+					EstimatedRows: pointer.ToFloat64(496),
+					ActualRows:    pointer.ToFloat64(490),
+					Cost:          pointer.ToFloat64(72.79),
+					Warnings:      []string{planWarningFullScan},
+				}, {
+					NodeType:      "Hash",
+					EstimatedRows: pointer.ToFloat64(239),
+					ActualRows:    pointer.ToFloat64(239),
+					Cost:          pointer.ToFloat64(5.39),
+					Children: []*planNode{{
+						NodeType:      "Index Scan",
+						Object:        "public.country",
+						Index:         "country_pkey",
+						EstimatedRows: pointer.ToFloat64(239),
+						ActualRows:    pointer.ToFloat64(239),
+						Cost:          pointer.ToFloat64(5.39),
+					}},
+				}},
+			}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("MongoDB", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := normalizeMongoDBPlan(read(t, "mongodb_find.json"))
+		require.NoError(t, err)
+		expected := &planNode{
+			NodeType:   "SORT",
+			ActualRows: pointer.ToFloat64(2),
+			Warnings:   []string{planWarningFilesort, planWarningTemporary},
+			Children: []*planNode{{
+				NodeType:   "FETCH",
+				ActualRows: pointer.ToFloat64(2),
+				Children: []*planNode{{
+					NodeType:   "IXSCAN",
+					Object:     "test.coll",
+					Index:      "k_1",
+					ActualRows: pointer.ToFloat64(2),
+				}},
+			}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("MongoDBResult", func(t *testing.T) {
+		t.Parallel()
+
+		var result bson.Raw
+		require.NoError(t, bson.UnmarshalExtJSON(read(t, "mongodb_find.json"), false, &result))
+		actual, err := addMongoDBNormalizedPlan(result)
+		require.NoError(t, err)
+
+		// existing fields are not changed
+		elements, err := result.Elements()
+		require.NoError(t, err)
+		actualElements, err := actual.Elements()
+		require.NoError(t, err)
+		require.Len(t, actualElements, len(elements)+1)
+		assert.Equal(t, elements, actualElements[:len(elements)])
+		assert.True(t, strings.HasPrefix(actual.String(), strings.TrimSuffix(result.String(), "}")))
+
+		assert.Equal(t, "normalized_plan", actualElements[len(elements)].Key())
+		assert.Equal(t, "SORT", actual.Lookup("normalized_plan", "node_type").StringValue())
+		assert.Equal(t, "k_1", actual.Lookup("normalized_plan", "children", "0", "children", "0", "index").StringValue())
+	})
+
+	t.Run("MongoDBAggregate", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := normalizeMongoDBPlan(read(t, "mongodb_aggregate.json"))
+		require.NoError(t, err)
+		expected := &planNode{
+			NodeType: "aggregate",
+			Children: []*planNode{{
+				NodeType:   "PROJECTION_SIMPLE",
+				ActualRows: pointer.ToFloat64(5),
+				Children: []*planNode{{
+					NodeType: "COLLSCAN",
+					Object:   "test.coll",
+					Warnings: []string{planWarningFullScan},
+				}},
+			}, {
+				NodeType:   "$group",
+				ActualRows: pointer.ToFloat64(3),
+			}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := normalizeMySQLPlan([]byte(`{"warnings": []}`))
+		assert.EqualError(t, err, "query_block not found")
+		_, err = normalizePostgreSQLPlan([]byte(`[]`))
+		assert.EqualError(t, err, "Plan not found")
+		_, err = normalizeMongoDBPlan([]byte(`{"ok": 1}`))
+		assert.EqualError(t, err, "queryPlanner.winningPlan not found")
+	})
+}
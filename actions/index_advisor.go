@@ -0,0 +1,537 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of index suggestions, in order of priority.
+const (
+	indexSuggestionMissing   = "missing_index"
+	indexSuggestionDuplicate = "duplicate_index"
+	indexSuggestionUnused    = "unused_index"
+)
+
+var indexSuggestionPriority = map[string]int{
+	indexSuggestionMissing:   0,
+	indexSuggestionDuplicate: 1,
+	indexSuggestionUnused:    2,
+}
+
+// indexSuggestion represents a single index advisor suggestion.
+type indexSuggestion struct {
+	Kind      string   `json:"kind"`
+	Table     string   `json:"table"`
+	Index     string   `json:"index,omitempty"` // existing index for duplicate and unused ones
+	Columns   []string `json:"columns"`
+	Statement string   `json:"statement"`
+	Reason    string   `json:"reason"`
+	Score     float64  `json:"score"` // estimated number of affected rows; used for ranking suggestions of the same kind
+}
+
+// indexAdvice is an index advisor Actions' output.
+type indexAdvice struct {
+	Query       string            `json:"query"`
+	Suggestions []indexSuggestion `json:"suggestions"`
+	Notes       []string          `json:"notes,omitempty"` // things that were not analyzed, and why
+}
+
+// tableIndex represents existing index.
+type tableIndex struct {
+	Name    string
+	Type    string   // BTREE, FULLTEXT, btree, gin, etc.
+	Columns []string // column names with prefix lengths, or expressions
+	Unique  bool     // unique or primary key, can't be dropped without changing constraints
+	Partial bool     // index with WHERE clause, can't be compared with other indexes
+	Unused  bool     // not used since statistics reset
+}
+
+// tableMetadata represents table metadata used by index advisor.
+type tableMetadata struct {
+	Name        string // table name with optional schema; plan nodes may use an alias instead
+	Rows        float64
+	Indexes     []tableIndex
+	Cardinality map[string]float64 // estimated number of distinct values per column, if known
+}
+
+// indexDialect builds engine-specific index statements.
+type indexDialect interface {
+	createIndex(table string, columns []string) string
+	dropIndex(table, index string) string
+}
+
+// adviseIndexes returns ranked index suggestions for the query plan and touched tables' metadata
+// keyed by plan node object.
+func adviseIndexes(plan *planNode, tables map[string]*tableMetadata, dialect indexDialect) ([]indexSuggestion, []string) {
+	var res []indexSuggestion
+	var notes []string
+
+	objects := make(map[string]bool)
+	walkPlan(plan, func(node *planNode) {
+		if node.Object != "" {
+			objects[lastPart(node.Object)] = true
+		}
+	})
+
+	seen := make(map[string]bool)
+	walkPlan(plan, func(node *planNode) {
+		if node.Object == "" || node.Condition == "" || !hasWarning(node, planWarningFullScan) {
+			return
+		}
+		table := tables[node.Object]
+		if table == nil {
+			return
+		}
+
+		equality, ranges, err := conditionColumns(node.Condition, lastPart(node.Object), objects)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("condition on table %s is not analyzed: %s", node.Object, err))
+			return
+		}
+		if len(equality) == 0 && len(ranges) == 0 {
+			return
+		}
+
+		// the most selective columns first; unknown cardinality columns keep order of the condition
+		sort.SliceStable(equality, func(i, j int) bool {
+			return table.Cardinality[equality[i]] > table.Cardinality[equality[j]]
+		})
+		columns := equality
+		access := "ref"
+		if len(ranges) != 0 {
+			// index can be used for a single range condition after equality ones
+			columns = append(columns, ranges[0])
+			if len(equality) == 0 {
+				access = "range"
+			}
+		}
+
+		key := table.Name + "(" + strings.Join(columns, ",") + ")"
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		if index := coveringIndex(table, equality, columns); index != nil {
+			notes = append(notes, fmt.Sprintf(
+				"table %s is fully scanned although index %s covers columns (%s); table statistics may be outdated",
+				table.Name, index.Name, strings.Join(columns, ", "),
+			))
+			return
+		}
+
+		rows := table.Rows
+		if node.EstimatedRows != nil && *node.EstimatedRows > 0 {
+			rows = *node.EstimatedRows
+		}
+		res = append(res, indexSuggestion{
+			Kind:      indexSuggestionMissing,
+			Table:     table.Name,
+			Columns:   columns,
+			Statement: dialect.createIndex(table.Name, columns),
+			Reason: fmt.Sprintf("full scan reads about %.0f rows to filter by %s; index allows %s access instead",
+				rows, strings.Join(columns, ", "), access),
+			Score: rows,
+		})
+	})
+
+	// the same table may be used under different aliases
+	byName := make(map[string]*tableMetadata, len(tables))
+	for _, table := range tables {
+		byName[table.Name] = table
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		table := byName[name]
+		redundant := make(map[string]bool)
+		for _, index := range table.Indexes {
+			if other := redundantWith(table, index); other != nil {
+				redundant[index.Name] = true
+				reason := fmt.Sprintf("columns (%s) are a leftmost prefix of index %s (%s)",
+					strings.Join(index.Columns, ", "), other.Name, strings.Join(other.Columns, ", "))
+				if len(index.Columns) == len(other.Columns) {
+					reason = fmt.Sprintf("index has the same columns as index %s", other.Name)
+				}
+				res = append(res, indexSuggestion{
+					Kind:      indexSuggestionDuplicate,
+					Table:     name,
+					Index:     index.Name,
+					Columns:   index.Columns,
+					Statement: dialect.dropIndex(name, index.Name),
+					Reason:    reason + "; it only slows down writes and takes space",
+					Score:     table.Rows,
+				})
+			}
+		}
+
+		for _, index := range table.Indexes {
+			if index.Unused && !index.Unique && !redundant[index.Name] {
+				res = append(res, indexSuggestion{
+					Kind:      indexSuggestionUnused,
+					Table:     name,
+					Index:     index.Name,
+					Columns:   index.Columns,
+					Statement: dialect.dropIndex(name, index.Name),
+					Reason:    "index was not used since statistics reset; it only slows down writes and takes space",
+					Score:     table.Rows,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if pi, pj := indexSuggestionPriority[res[i].Kind], indexSuggestionPriority[res[j].Kind]; pi != pj {
+			return pi < pj
+		}
+		return res[i].Score > res[j].Score
+	})
+	return res, notes
+}
+
+// walkPlan calls f for every plan node in depth-first order.
+func walkPlan(node *planNode, f func(*planNode)) {
+	if node == nil {
+		return
+	}
+	f(node)
+	for _, child := range node.Children {
+		walkPlan(child, f)
+	}
+}
+
+func hasWarning(node *planNode, warning string) bool {
+	for _, w := range node.Warnings {
+		if w == warning {
+			return true
+		}
+	}
+	return false
+}
+
+// lastPart returns the last part of dot-separated name.
+func lastPart(name string) string {
+	return name[strings.LastIndexByte(name, '.')+1:]
+}
+
+// coveringIndex returns existing index that starts with given columns (equality ones in any order), or nil.
+func coveringIndex(table *tableMetadata, equality, columns []string) *tableIndex {
+	for i, index := range table.Indexes {
+		if index.Partial || len(index.Columns) < len(columns) {
+			continue
+		}
+		if sameColumns(index.Columns[:len(equality)], equality) &&
+			sameColumns(index.Columns[len(equality):len(columns)], columns[len(equality):]) {
+			return &table.Indexes[i]
+		}
+	}
+	return nil
+}
+
+// sameColumns returns true if both lists contain the same columns, in any order.
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]int, len(a))
+	for _, c := range a {
+		m[c]++
+	}
+	for _, c := range b {
+		if m[c] == 0 {
+			return false
+		}
+		m[c]--
+	}
+	return true
+}
+
+// redundantWith returns other index of the same type which columns start with given index's columns, or nil.
+// Unique indexes are never redundant. From indexes with the same columns, all but the last one by name are redundant.
+func redundantWith(table *tableMetadata, index tableIndex) *tableIndex {
+	if index.Unique || index.Partial || len(index.Columns) == 0 {
+		return nil
+	}
+
+	for i, other := range table.Indexes {
+		if other.Name == index.Name || other.Partial || other.Type != index.Type || len(other.Columns) < len(index.Columns) {
+			continue
+		}
+		if len(other.Columns) == len(index.Columns) && !other.Unique && other.Name < index.Name {
+			continue
+		}
+
+		prefix := true
+		for j, c := range index.Columns {
+			if other.Columns[j] != c {
+				prefix = false
+				break
+			}
+		}
+		if prefix {
+			return &table.Indexes[i]
+		}
+	}
+	return nil
+}
+
+// comparison kinds for conditionColumns
+const (
+	comparisonNone = iota
+	comparisonEquality
+	comparisonRange
+)
+
+// conditionColumns returns columns of given table compared with equality and range operators in the condition.
+// Columns can be qualified by table name or alias (but not by other known table name) as in
+// MySQL's `attached_condition` and PostgreSQL's `Filter`. Conditions with OR are not supported.
+func conditionColumns(condition, table string, tables map[string]bool) (equality, ranges []string, err error) {
+	tokens, err := lexQuery(condition, dialectMySQL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kinds := make(map[string]int)
+	var order []string
+	add := func(ref []token, kind int) {
+		if len(ref) == 0 {
+			return
+		}
+		column := unquoteIdent(ref[len(ref)-1])
+		if len(ref) > 1 {
+			if q := unquoteIdent(ref[len(ref)-3]); q != table && tables[q] {
+				return
+			}
+		}
+		if _, ok := kinds[column]; !ok {
+			order = append(order, column)
+		}
+		if kinds[column] == comparisonNone || kind == comparisonEquality {
+			kinds[column] = kind
+		}
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.is("OR", "XOR") || (t.isPunct('|') && i+1 < len(tokens) && tokens[i+1].isPunct('|')) {
+			return nil, nil, errors.New("condition contains OR")
+		}
+
+		kind, n := comparisonAt(tokens, i)
+		if kind == comparisonNone {
+			continue
+		}
+		add(refBefore(tokens, i), kind)
+		// join condition
+		if kind == comparisonEquality && tokens[i].isPunct('=') {
+			add(refAfter(tokens, i+n), kind)
+		}
+		i += n - 1
+	}
+
+	for _, column := range order {
+		switch kinds[column] {
+		case comparisonEquality:
+			equality = append(equality, column)
+		case comparisonRange:
+			ranges = append(ranges, column)
+		}
+	}
+	return equality, ranges, nil
+}
+
+// comparisonAt returns the kind and the number of tokens of comparison operator at given position.
+func comparisonAt(tokens []token, i int) (int, int) {
+	op := func(s string) bool {
+		if i+len(s) > len(tokens) {
+			return false
+		}
+		for j := 0; j < len(s); j++ {
+			t := tokens[i+j]
+			if !t.isPunct(s[j]) || (j > 0 && t.space) {
+				return false
+			}
+		}
+		return true
+	}
+	next := func(j int) token {
+		if i+j < len(tokens) {
+			return tokens[i+j]
+		}
+		return token{}
+	}
+	prev := token{}
+	if i > 0 {
+		prev = tokens[i-1]
+	}
+
+	t := tokens[i]
+	switch {
+	case op("<=>"):
+		return comparisonEquality, 3
+	case op("<>"), op("!="), op("!~~"), op(":="):
+		return comparisonNone, 2
+	case op("<="), op(">="):
+		return comparisonRange, 2
+	case op("~~"):
+		// PostgreSQL's LIKE
+		if isPrefixPattern(next(2)) {
+			return comparisonRange, 2
+		}
+		return comparisonNone, 2
+	case op("="):
+		if prev.isPunct('<') || prev.isPunct('>') || prev.isPunct('!') || prev.isPunct(':') {
+			return comparisonNone, 1
+		}
+		return comparisonEquality, 1
+	case op("<"), op(">"):
+		return comparisonRange, 1
+	case prev.is("NOT"):
+		return comparisonNone, 1
+	case t.is("IN"):
+		return comparisonEquality, 1
+	case t.is("BETWEEN"):
+		return comparisonRange, 1
+	case t.is("LIKE"):
+		if isPrefixPattern(next(1)) {
+			return comparisonRange, 1
+		}
+	case t.is("IS"):
+		if next(1).is("NULL") {
+			return comparisonEquality, 1
+		}
+	}
+	return comparisonNone, 1
+}
+
+// isPrefixPattern returns true if token is a LIKE pattern that does not start with wildcard.
+func isPrefixPattern(t token) bool {
+	return t.kind == tokenString && len(t.text) > 2 && t.text[1] != '%' && t.text[1] != '_'
+}
+
+// nonColumnWords are words that can't be column names in conditions.
+var nonColumnWords = []string{"AND", "OR", "XOR", "NOT", "NULL", "TRUE", "FALSE", "IS", "IN", "ANY", "ALL", "SOME"}
+
+// isNameToken returns true if token can be a part of column reference.
+// Double-quoted strings are PostgreSQL's quoted identifiers; MySQL's conditions use single quotes for strings.
+func isNameToken(t token) bool {
+	switch t.kind {
+	case tokenQuotedIdent:
+		return true
+	case tokenString:
+		return t.text[0] == '"'
+	case tokenWord:
+		return !t.is(nonColumnWords...)
+	default:
+		return false
+	}
+}
+
+// isRefBoundary returns true if token can precede or follow a column in a simple comparison.
+func isRefBoundary(t token) bool {
+	return t.isPunct('(') || t.isPunct(')') || t.isPunct(',') || t.is("AND", "NOT", "WHERE")
+}
+
+// refBefore returns tokens of a column reference (name, or qualified name) just before given position,
+// skipping PostgreSQL's cast of parenthesized column as in `((name)::text = 'x'::text)`.
+func refBefore(tokens []token, i int) []token {
+	end := i
+	// skip cast: `(ref)::type`
+	j := end - 1
+	for j >= 0 && (tokens[j].kind == tokenWord || tokens[j].isPunct('[') || tokens[j].isPunct(']')) {
+		j--
+	}
+	if j >= 2 && j < end-1 && tokens[j].isPunct(':') && tokens[j-1].isPunct(':') && tokens[j-2].isPunct(')') {
+		end = j - 2
+	}
+
+	start := end - 1
+	if start < 0 || !isNameToken(tokens[start]) {
+		return nil
+	}
+	for start >= 2 && tokens[start-1].isPunct('.') && isNameToken(tokens[start-2]) {
+		start -= 2
+	}
+	if end != i && !(start > 0 && tokens[start-1].isPunct('(')) {
+		return nil
+	}
+	if start > 0 && !isRefBoundary(tokens[start-1]) {
+		return nil
+	}
+	// parenthesized column should be preceded by boundary too
+	if end != i && start > 1 && !isRefBoundary(tokens[start-2]) {
+		return nil
+	}
+	return tokens[start:end]
+}
+
+// refAfter returns tokens of a column reference (name, or qualified name) starting at given position.
+func refAfter(tokens []token, i int) []token {
+	if i >= len(tokens) || !isNameToken(tokens[i]) {
+		return nil
+	}
+	end := i + 1
+	for end+1 < len(tokens) && tokens[end].isPunct('.') && isNameToken(tokens[end+1]) {
+		end += 2
+	}
+	if end < len(tokens) && !isRefBoundary(tokens[end]) {
+		return nil
+	}
+	return tokens[i:end]
+}
+
+// unquoteIdent returns identifier without MySQL or PostgreSQL quotes.
+func unquoteIdent(t token) string {
+	s := t.text
+	if len(s) >= 2 && (s[0] == '`' || s[0] == '"') && s[len(s)-1] == s[0] {
+		q := s[:1]
+		return strings.ReplaceAll(s[1:len(s)-1], q+q, q)
+	}
+	return s
+}
+
+// metadataNumber returns a number from a data row value, or 0.
+func metadataNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// metadataString returns a string from a data row value, or empty string for NULL.
+func metadataString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,307 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionColumns(t *testing.T) {
+	t.Parallel()
+
+	tables := map[string]bool{"city": true, "country": true}
+	for _, tc := range []struct {
+		condition string
+		table     string
+		equality  []string
+		ranges    []string
+		err       string
+	}{{
+		condition: "((`world`.`city`.`CountryCode` = 'NLD') and (`world`.`city`.`Population` > 1000))",
+		table:     "city",
+		equality:  []string{"CountryCode"},
+		ranges:    []string{"Population"},
+	}, {
+		condition: "(`world`.`city`.`CountryCode` = `world`.`country`.`Code`)",
+		table:     "city",
+		equality:  []string{"CountryCode"},
+	}, {
+		condition: "(`world`.`city`.`CountryCode` = `world`.`country`.`Code`)",
+		table:     "country",
+		equality:  []string{"Code"},
+	}, {
+		condition: "((`world`.`city`.`Name` like 'Ams%') and (`world`.`city`.`District` like '%Holland') and (`world`.`city`.`ID` <> 5))",
+		table:     "city",
+		ranges:    []string{"Name"},
+	}, {
+		condition: "((`world`.`city`.`ID` between 1 and 10) and (year(`world`.`city`.`Updated`) = 2020) and (`world`.`city`.`Name` is null))",
+		table:     "city",
+		equality:  []string{"Name"},
+		ranges:    []string{"ID"},
+	}, {
+		condition: "((`world`.`city`.`ID` >= 5) and (`world`.`city`.`ID` <=> 7) and (`world`.`city`.`District` not in ('a','b')))",
+		table:     "city",
+		equality:  []string{"ID"},
+	}, {
+		condition: "((`world`.`city`.`CountryCode` = 'NLD') or (`world`.`city`.`Population` > 1000))",
+		table:     "city",
+		err:       "condition contains OR",
+	}, {
+		condition: `((population > 1000000) AND ((name)::text ~~ 'A%'::text) AND (countrycode = ANY ('{NLD,BEL}'::bpchar[])))`,
+		table:     "city",
+		equality:  []string{"countrycode"},
+		ranges:    []string{"population", "name"},
+	}, {
+		condition: `((c."Name")::text = 'x'::text)`,
+		table:     "city",
+		equality:  []string{"Name"},
+	}, {
+		condition: `((population + 1) > 1000)`,
+		table:     "city",
+	}} {
+		equality, ranges, err := conditionColumns(tc.condition, tc.table, tables)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "%s", tc.condition)
+			continue
+		}
+		require.NoError(t, err, "%s", tc.condition)
+		assert.Equal(t, tc.equality, equality, "%s", tc.condition)
+		assert.Equal(t, tc.ranges, ranges, "%s", tc.condition)
+	}
+}
+
+func TestParsePostgreSQLIndexDef(t *testing.T) {
+	t.Parallel()
+
+	for def, expected := range map[string]*tableIndex{
+		"CREATE UNIQUE INDEX city_pkey ON public.city USING btree (id)": {
+			Type: "btree", Columns: []string{"id"}, Unique: true,
+		},
+		`CREATE INDEX city_idx ON public.city USING btree (countrycode, "Name" DESC NULLS LAST) INCLUDE (district)`: {
+			Type: "btree", Columns: []string{"countrycode", "Name"},
+		},
+		"CREATE INDEX city_lower ON public.city USING btree (lower((name)::text)) WHERE (population > 0)": {
+			Type: "btree", Columns: []string{"lower((name)::text)"}, Partial: true,
+		},
+		"CREATE INDEX city_gin ON public.city USING gin (tags)": {
+			Type: "gin", Columns: []string{"tags"},
+		},
+	} {
+		actual, err := parsePostgreSQLIndexDef(def)
+		require.NoError(t, err, "%s", def)
+		assert.Equal(t, expected, actual, "%s", def)
+	}
+
+	_, err := parsePostgreSQLIndexDef("CREATE INDEX broken ON t USING btree (a")
+	assert.EqualError(t, err, `cannot parse index definition "CREATE INDEX broken ON t USING btree (a": unbalanced parentheses`)
+}
+
+func TestAdviseIndexes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("MySQL", func(t *testing.T) {
+		t.Parallel()
+
+		plan := &planNode{
+			NodeType: "query_block",
+			Children: []*planNode{{
+				NodeType: "nested_loop",
+				Children: []*planNode{{
+					NodeType:      "ALL",
+					Object:        "city",
+					EstimatedRows: pointer.ToFloat64(4079),
+					Condition:     "((`world`.`city`.`District` = 'Noord-Holland') and (`world`.`city`.`CountryCode` = 'NLD') and (`world`.`city`.`Population` > 100000))",
+					Warnings:      []string{planWarningFullScan},
+				}, {
+					NodeType:      "ALL",
+					Object:        "country",
+					EstimatedRows: pointer.ToFloat64(239),
+					Condition:     "(`world`.`country`.`Code` = `world`.`city`.`CountryCode`)",
+					Warnings:      []string{planWarningFullScan},
+				}},
+			}},
+		}
+		tables := map[string]*tableMetadata{
+			"city": {
+				Name: "city",
+				Rows: 4079,
+				Indexes: []tableIndex{
+					{Name: "PRIMARY", Type: "BTREE", Columns: []string{"ID"}, Unique: true},
+					{Name: "CountryCode", Type: "BTREE", Columns: []string{"CountryCode"}},
+					{Name: "CountryCode_2", Type: "BTREE", Columns: []string{"CountryCode"}},
+					{Name: "District", Type: "BTREE", Columns: []string{"District"}, Unused: true},
+					{Name: "District_Name", Type: "BTREE", Columns: []string{"District", "Name"}},
+					{Name: "Name", Type: "FULLTEXT", Columns: []string{"Name"}, Unused: true},
+				},
+				Cardinality: map[string]float64{"ID": 4079, "CountryCode": 232, "District": 1367},
+			},
+			"country": {
+				Name: "country",
+				Rows: 239,
+				Indexes: []tableIndex{
+					{Name: "PRIMARY", Type: "BTREE", Columns: []string{"Code"}, Unique: true},
+				},
+			},
+		}
+
+		suggestions, notes := adviseIndexes(plan, tables, mysqlIndexDialect{})
+		expected := []indexSuggestion{{
+			Kind:      indexSuggestionMissing,
+			Table:     "city",
+			Columns:   []string{"District", "CountryCode", "Population"},
+			Statement: "ALTER TABLE `city` ADD INDEX `idx_District_CountryCode_Population` (`District`, `CountryCode`, `Population`)",
+			Reason:    "full scan reads about 4079 rows to filter by District, CountryCode, Population; index allows ref access instead",
+			Score:     4079,
+		}, {
+			Kind:      indexSuggestionDuplicate,
+			Table:     "city",
+			Index:     "CountryCode",
+			Columns:   []string{"CountryCode"},
+			Statement: "ALTER TABLE `city` DROP INDEX `CountryCode`",
+			Reason:    "index has the same columns as index CountryCode_2; it only slows down writes and takes space",
+			Score:     4079,
+		}, {
+			Kind:      indexSuggestionDuplicate,
+			Table:     "city",
+			Index:     "District",
+			Columns:   []string{"District"},
+			Statement: "ALTER TABLE `city` DROP INDEX `District`",
+			Reason:    "columns (District) are a leftmost prefix of index District_Name (District, Name); it only slows down writes and takes space",
+			Score:     4079,
+		}, {
+			Kind:      indexSuggestionUnused,
+			Table:     "city",
+			Index:     "Name",
+			Columns:   []string{"Name"},
+			Statement: "ALTER TABLE `city` DROP INDEX `Name`",
+			Reason:    "index was not used since statistics reset; it only slows down writes and takes space",
+			Score:     4079,
+		}}
+		assert.Equal(t, expected, suggestions)
+		assert.Equal(t, []string{
+			"table country is fully scanned although index PRIMARY covers columns (Code); table statistics may be outdated",
+		}, notes)
+	})
+
+	t.Run("MySQLAliases", func(t *testing.T) {
+		t.Parallel()
+
+		// self-join of the same table with different aliases
+		plan := &planNode{
+			NodeType: "query_block",
+			Children: []*planNode{{
+				NodeType: "nested_loop",
+				Children: []*planNode{{
+					NodeType:      "ALL",
+					Object:        "c1",
+					EstimatedRows: pointer.ToFloat64(4079),
+					Condition:     "(`c1`.`District` = 'Noord-Holland')",
+					Warnings:      []string{planWarningFullScan},
+				}, {
+					NodeType:      "ALL",
+					Object:        "c2",
+					EstimatedRows: pointer.ToFloat64(4079),
+					Condition:     "((`c2`.`District` = `c1`.`District`) and (`c2`.`Population` > 1000))",
+					Warnings:      []string{planWarningFullScan},
+				}},
+			}},
+		}
+		city := &tableMetadata{
+			Name: "world.city",
+			Rows: 4079,
+			Indexes: []tableIndex{
+				{Name: "PRIMARY", Type: "BTREE", Columns: []string{"ID"}, Unique: true},
+				{Name: "Name", Type: "BTREE", Columns: []string{"Name"}, Unused: true},
+			},
+		}
+		tables := map[string]*tableMetadata{"c1": city, "c2": city}
+
+		suggestions, notes := adviseIndexes(plan, tables, mysqlIndexDialect{})
+		expected := []indexSuggestion{{
+			Kind:      indexSuggestionMissing,
+			Table:     "world.city",
+			Columns:   []string{"District"},
+			Statement: "ALTER TABLE `world`.`city` ADD INDEX `idx_District` (`District`)",
+			Reason:    "full scan reads about 4079 rows to filter by District; index allows ref access instead",
+			Score:     4079,
+		}, {
+			Kind:      indexSuggestionMissing,
+			Table:     "world.city",
+			Columns:   []string{"District", "Population"},
+			Statement: "ALTER TABLE `world`.`city` ADD INDEX `idx_District_Population` (`District`, `Population`)",
+			Reason:    "full scan reads about 4079 rows to filter by District, Population; index allows ref access instead",
+			Score:     4079,
+		}, {
+			Kind:      indexSuggestionUnused,
+			Table:     "world.city",
+			Index:     "Name",
+			Columns:   []string{"Name"},
+			Statement: "ALTER TABLE `world`.`city` DROP INDEX `Name`",
+			Reason:    "index was not used since statistics reset; it only slows down writes and takes space",
+			Score:     4079,
+		}}
+		assert.Equal(t, expected, suggestions)
+		assert.Empty(t, notes)
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		t.Parallel()
+
+		plan := &planNode{
+			NodeType: "Hash Join",
+			Children: []*planNode{{
+				NodeType:      "Seq Scan",
+				Object:        "public.city",
+				EstimatedRows: pointer.ToFloat64(12),
+				Condition:     "((population > 1000000) AND ((name)::text = 'Amsterdam'::text))",
+				Warnings:      []string{planWarningFullScan},
+			}, {
+				NodeType:  "Seq Scan",
+				Object:    "country",
+				Condition: "((continent = 'Europe'::text) OR (region = 'Europe'::text))",
+				Warnings:  []string{planWarningFullScan},
+			}},
+		}
+		tables := map[string]*tableMetadata{
+			"public.city": {
+				Name: "public.city",
+				Rows: 4079,
+				Indexes: []tableIndex{
+					{Name: "city_pkey", Type: "btree", Columns: []string{"id"}, Unique: true, Unused: true},
+				},
+			},
+			"country": {Name: "country", Rows: 239},
+		}
+
+		suggestions, notes := adviseIndexes(plan, tables, postgresqlIndexDialect{})
+		expected := []indexSuggestion{{
+			Kind:      indexSuggestionMissing,
+			Table:     "public.city",
+			Columns:   []string{"name", "population"},
+			Statement: `CREATE INDEX CONCURRENTLY ON "public"."city" ("name", "population")`,
+			Reason:    "full scan reads about 12 rows to filter by name, population; index allows ref access instead",
+			Score:     12,
+		}}
+		assert.Equal(t, expected, suggestions)
+		assert.Equal(t, []string{"condition on table country is not analyzed: condition contains OR"}, notes)
+
+		assert.Equal(t, `DROP INDEX CONCURRENTLY "public"."city_idx"`, postgresqlIndexDialect{}.dropIndex("public.city", "city_idx"))
+	})
+}
@@ -0,0 +1,145 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Kill and cancel query Actions change the state of the database, so they have guardrails:
+// an explicit target identifier and expected query fingerprint are required, the fingerprint of the query
+// the target is currently running (computed the same way as QAN does) should match it,
+// and every attempt is recorded locally: as a JSON line in killQueryAuditFile in the Action's temporary directory,
+// and with a dedicated logger.
+//
+// The query may change between the check and the kill; that window is as small as we can make it.
+
+// killQueryAuditFile is a name of the local audit log file in the Action's temporary directory.
+const killQueryAuditFile = "kill-query-audit.jsonl"
+
+// killQueryAuditM serializes writes to audit log files of concurrently running Actions.
+var killQueryAuditM sync.Mutex
+
+// killQueryAuditRecord represents a single line of the audit log.
+type killQueryAuditRecord struct {
+	Time        time.Time `json:"time"`
+	ActionID    string    `json:"action_id"`
+	ActionType  string    `json:"action_type"`
+	Target      string    `json:"target"`
+	Fingerprint string    `json:"fingerprint"`
+	Query       string    `json:"query,omitempty"`
+	Result      string    `json:"result"` // "done" or "failed"
+	Error       string    `json:"error,omitempty"`
+}
+
+// killQueryResult represents kill and cancel query Actions output.
+type killQueryResult struct {
+	Target      string `json:"target"`
+	Fingerprint string `json:"fingerprint"`
+	Query       string `json:"query"`
+}
+
+// checkKillQueryFingerprint returns error if none of the fingerprints of the running query matches expected one.
+// The same query may have several fingerprint forms (for example, MySQL's slowlog fingerprint
+// and performance_schema digest text), so all known forms should be passed.
+func checkKillQueryFingerprint(expected string, actual ...string) error {
+	quoted := make([]string, len(actual))
+	for i, a := range actual {
+		if a == expected {
+			return nil
+		}
+		quoted[i] = strconv.Quote(a)
+	}
+	return errors.Errorf("query fingerprint mismatch: expected %q, got %s", expected, strings.Join(quoted, " or "))
+}
+
+// auditKillQuery records kill or cancel query Action attempt and returns the Action output.
+// The audit log file is written only if tempDir is set; the dedicated logger is always used.
+func auditKillQuery(a Action, tempDir string, res *killQueryResult, err error) ([]byte, error) {
+	rec := &killQueryAuditRecord{
+		Time:        time.Now().UTC(),
+		ActionID:    a.ID(),
+		ActionType:  a.Type(),
+		Target:      res.Target,
+		Fingerprint: res.Fingerprint,
+		Query:       res.Query,
+		Result:      "done",
+	}
+	if err != nil {
+		rec.Result = "failed"
+		rec.Error = err.Error()
+	}
+
+	l := logrus.WithFields(logrus.Fields{
+		"component":   "actions-audit",
+		"id":          rec.ActionID,
+		"type":        rec.ActionType,
+		"target":      rec.Target,
+		"fingerprint": rec.Fingerprint,
+		"query":       rec.Query,
+	})
+	if err != nil {
+		l.Warnf("Refused or failed: %s.", err)
+	} else {
+		l.Infof("Done.")
+	}
+
+	if tempDir != "" {
+		if wErr := writeKillQueryAudit(filepath.Join(tempDir, killQueryAuditFile), rec); wErr != nil {
+			l.Errorf("Failed to write audit record: %s.", wErr)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(res)
+	return b, errors.WithStack(err)
+}
+
+// writeKillQueryAudit appends audit record to the given file as a JSON line.
+func writeKillQueryAudit(path string, rec *killQueryAuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	b = append(b, '\n')
+
+	killQueryAuditM.Lock()
+	defer killQueryAuditM.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Close())
+}
@@ -0,0 +1,129 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillQueryGuardrails(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		action   Action
+		expected string
+	}{{
+		name:     "MySQLNoThreadID",
+		action:   NewMySQLKillQueryAction(MySQLKillQueryActionParams{DSN: "root:root-password@tcp(127.0.0.1:3306)/world", Fingerprint: "select sleep(?)"}),
+		expected: "thread ID is required",
+	}, {
+		name:     "MySQLNoFingerprint",
+		action:   NewMySQLKillQueryAction(MySQLKillQueryActionParams{DSN: "root:root-password@tcp(127.0.0.1:3306)/world", ThreadID: 42}),
+		expected: "query fingerprint is required",
+	}, {
+		name:     "PostgreSQLNoPID",
+		action:   NewPostgreSQLCancelQueryAction(PostgreSQLCancelQueryActionParams{DSN: "postgres://127.0.0.1", Fingerprint: "SELECT pg_sleep($1)"}),
+		expected: "PID is required",
+	}, {
+		name:     "PostgreSQLNoFingerprint",
+		action:   NewPostgreSQLCancelQueryAction(PostgreSQLCancelQueryActionParams{DSN: "postgres://127.0.0.1", PID: 42, Terminate: true}),
+		expected: "query fingerprint is required",
+	}, {
+		name:     "MongoDBNoOpID",
+		action:   NewMongoDBKillOpAction(MongoDBKillOpActionParams{DSN: "mongodb://127.0.0.1", Fingerprint: "FIND people name"}),
+		expected: "operation ID is required",
+	}, {
+		name:     "MongoDBNoFingerprint",
+		action:   NewMongoDBKillOpAction(MongoDBKillOpActionParams{DSN: "mongodb://127.0.0.1", OpID: "42"}),
+		expected: "query fingerprint is required",
+	}} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			b, err := tc.action.Run(ctx)
+			assert.EqualError(t, err, tc.expected)
+			assert.Nil(t, b)
+		})
+	}
+}
+
+func TestCheckKillQueryFingerprint(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, checkKillQueryFingerprint("select sleep(?)", "select sleep(?)"))
+	assert.EqualError(t, checkKillQueryFingerprint("select sleep(?)", "select * from city"),
+		`query fingerprint mismatch: expected "select sleep(?)", got "select * from city"`)
+
+	// MySQL slowlog fingerprint, performance_schema digest and digest text
+	actual := []string{"select sleep(?)", "0123456789abcdef", "SELECT `SLEEP` (?)"}
+	for _, expected := range actual {
+		assert.NoError(t, checkKillQueryFingerprint(expected, actual...))
+	}
+	assert.EqualError(t, checkKillQueryFingerprint("SELECT SLEEP (?)", actual...),
+		"query fingerprint mismatch: expected \"SELECT SLEEP (?)\", "+
+			"got \"select sleep(?)\" or \"0123456789abcdef\" or \"SELECT `SLEEP` (?)\"")
+}
+
+func TestAuditKillQuery(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := NewMySQLKillQueryAction(MySQLKillQueryActionParams{ID: "/action_id/1", ThreadID: 42, Fingerprint: "select sleep(?)", TempDir: dir})
+
+	b, err := auditKillQuery(a, dir, &killQueryResult{Target: "42", Fingerprint: "select sleep(?)", Query: "SELECT SLEEP(100)"}, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"target": "42", "fingerprint": "select sleep(?)", "query": "SELECT SLEEP(100)"}`, string(b))
+
+	_, err = auditKillQuery(a, dir, &killQueryResult{Target: "42", Fingerprint: "select sleep(?)"}, assert.AnError)
+	require.Equal(t, assert.AnError, err)
+
+	f, err := os.ReadFile(filepath.Join(dir, killQueryAuditFile)) //nolint:gosec
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(f)), "\n")
+	require.Len(t, lines, 2)
+
+	var done, failed killQueryAuditRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &done))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &failed))
+
+	assert.False(t, done.Time.IsZero())
+	done.Time = time.Time{}
+	assert.Equal(t, killQueryAuditRecord{
+		ActionID:    "/action_id/1",
+		ActionType:  "mysql-kill-query",
+		Target:      "42",
+		Fingerprint: "select sleep(?)",
+		Query:       "SELECT SLEEP(100)",
+		Result:      "done",
+	}, done)
+	assert.Equal(t, "failed", failed.Result)
+	assert.Equal(t, assert.AnError.Error(), failed.Error)
+	assert.Empty(t, failed.Query)
+}
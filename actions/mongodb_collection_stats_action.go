@@ -0,0 +1,133 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+type mongodbCollectionStatsAction struct {
+	params MongoDBCollectionActionParams
+}
+
+// NewMongoDBCollectionStatsAction creates MongoDB collection stats Action.
+// This is an Action that returns collection storage statistics.
+// It is not started by the client yet, see NewMongoDBShowIndexAction.
+func NewMongoDBCollectionStatsAction(params MongoDBCollectionActionParams) Action {
+	return &mongodbCollectionStatsAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mongodbCollectionStatsAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mongodbCollectionStatsAction) Type() string {
+	return "mongodb-collection-stats"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mongodbCollectionStatsAction) Run(ctx context.Context) ([]byte, error) {
+	if a.params.Database == "" || a.params.Collection == "" {
+		return nil, errors.New("database and collection are required")
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer client.Disconnect(ctx) //nolint:errcheck
+
+	stats, err := getMongoDBCollectionStats(ctx, client.Database(a.params.Database), a.params.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{
+		"shard", "host", "count", "size_bytes", "avg_obj_size_bytes", "storage_size_bytes", "free_storage_size_bytes",
+		"indexes", "total_index_size_bytes", "index_sizes", "capped",
+	}
+	dataRows := make([][]interface{}, len(stats))
+	for i, s := range stats {
+		ss := s.StorageStats
+		dataRows[i] = []interface{}{
+			s.Shard, s.Host, ss.Count, ss.Size, ss.AvgObjSize, ss.StorageSize, ss.FreeStorageSize,
+			ss.NIndexes, ss.TotalIndexSize, ss.IndexSizes, ss.Capped,
+		}
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *mongodbCollectionStatsAction) sealed() {}
+
+// mongodbCollectionStats represents $collStats aggregation stage result document.
+// There is one document per shard on mongos.
+type mongodbCollectionStats struct {
+	Shard        string              `bson:"shard"`
+	Host         string              `bson:"host"`
+	StorageStats mongodbStorageStats `bson:"storageStats"`
+}
+
+// mongodbStorageStats represents $collStats storageStats field and collStats command result document.
+type mongodbStorageStats struct {
+	Count           int64            `bson:"count"`
+	Size            int64            `bson:"size"`
+	AvgObjSize      float64          `bson:"avgObjSize"`
+	StorageSize     int64            `bson:"storageSize"`
+	FreeStorageSize int64            `bson:"freeStorageSize"` // MongoDB 4.4+
+	NIndexes        int64            `bson:"nindexes"`
+	TotalIndexSize  int64            `bson:"totalIndexSize"`
+	IndexSizes      map[string]int64 `bson:"indexSizes"`
+	Capped          bool             `bson:"capped"`
+}
+
+// getMongoDBCollectionStats returns storage statistics of given collection.
+// It uses $collStats aggregation stage, and falls back to deprecated collStats command for MongoDB before 3.4.
+func getMongoDBCollectionStats(ctx context.Context, db *mongo.Database, collection string) ([]mongodbCollectionStats, error) {
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}}
+	cursor, err := db.Collection(collection).Aggregate(ctx, pipeline)
+	if err == nil {
+		var res []mongodbCollectionStats
+		if err = cursor.All(ctx, &res); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return res, nil
+	}
+
+	var ss mongodbStorageStats
+	if e := db.RunCommand(ctx, bson.D{{Key: "collStats", Value: collection}}).Decode(&ss); e != nil {
+		// return the error for the current version
+		return nil, errors.WithStack(err)
+	}
+	return []mongodbCollectionStats{{StorageStats: ss}}, nil
+}
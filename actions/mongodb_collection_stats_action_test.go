@@ -0,0 +1,79 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMongoDBCollectionStats(t *testing.T) {
+	t.Parallel()
+
+	database := "test_collection_stats"
+	dsn := tests.GetTestMongoDBDSN(t)
+	client := tests.OpenTestMongoDB(t, dsn)
+	defer client.Database(database).Drop(context.Background()) //nolint:errcheck
+	prepareMongoDBPeople(t, client, database)
+
+	a := NewMongoDBCollectionStatsAction(MongoDBCollectionActionParams{
+		DSN:        dsn,
+		TempDir:    createTempDir(t),
+		Database:   database,
+		Collection: "people",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual [][]interface{}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	require.Len(t, actual, 2)
+
+	row := make(map[string]interface{})
+	for i, c := range actual[0] {
+		row[c.(string)] = actual[1][i]
+	}
+	assert.Equal(t, 2.0, row["count"])
+	assert.Equal(t, 4.0, row["indexes"])
+	assert.NotZero(t, row["size_bytes"])
+	assert.NotZero(t, row["total_index_size_bytes"])
+	assert.Len(t, row["index_sizes"], 4)
+	assert.Equal(t, false, row["capped"])
+}
+
+func TestMongoDBCollectionActionsParams(t *testing.T) {
+	t.Parallel()
+
+	for _, a := range []Action{
+		NewMongoDBShowIndexAction(MongoDBCollectionActionParams{DSN: "mongodb://127.0.0.1:27017"}),
+		NewMongoDBIndexStatsAction(MongoDBCollectionActionParams{DSN: "mongodb://127.0.0.1:27017"}),
+		NewMongoDBCollectionStatsAction(MongoDBCollectionActionParams{DSN: "mongodb://127.0.0.1:27017"}),
+	} {
+		_, err := a.Run(context.Background())
+		assert.EqualError(t, err, "database and collection are required", a.Type())
+	}
+}
@@ -17,6 +17,7 @@ package actions
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -27,6 +28,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 
 	"github.com/percona/pmm-agent/utils/templates"
 )
@@ -94,8 +96,50 @@ func (a *mongodbExplainAction) Run(ctx context.Context) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Output is explain command output as before; plan tree common for all databases is added as
+	// the last top-level normalized_plan field, so existing fields are not changed.
+	if withPlan, err := addMongoDBNormalizedPlan(result); err == nil {
+		result = withPlan
+	}
+
 	// We need it because result
 	return []byte(result.String()), nil
 }
 
+// addMongoDBNormalizedPlan returns explain result with appended normalized_plan field.
+// Other fields are copied as is.
+func addMongoDBNormalizedPlan(result bson.Raw) (bson.Raw, error) {
+	if err := result.Validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// plan is normalized from relaxed Extended JSON to get plain numbers
+	b, err := bson.MarshalExtJSON(result, false, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	plan, err := normalizeMongoDBPlan(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = json.Marshal(plan); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var planDoc bson.Raw
+	if err = bson.UnmarshalExtJSON(b, false, &planDoc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// copy elements without the document length prefix and trailing null byte
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	doc = append(doc, result[4:len(result)-1]...)
+	doc = bsoncore.AppendDocumentElement(doc, "normalized_plan", planDoc)
+	if doc, err = bsoncore.AppendDocumentEnd(doc, idx); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bson.Raw(doc), nil
+}
+
 func (a *mongodbExplainAction) sealed() {}
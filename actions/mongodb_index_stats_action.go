@@ -0,0 +1,128 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+type mongodbIndexStatsAction struct {
+	params MongoDBCollectionActionParams
+}
+
+// NewMongoDBIndexStatsAction creates MongoDB index stats Action.
+// This is an Action that returns $indexStats index usage counters and detects unused indexes.
+// It is not started by the client yet, see NewMongoDBShowIndexAction.
+func NewMongoDBIndexStatsAction(params MongoDBCollectionActionParams) Action {
+	return &mongodbIndexStatsAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mongodbIndexStatsAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mongodbIndexStatsAction) Type() string {
+	return "mongodb-index-stats"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mongodbIndexStatsAction) Run(ctx context.Context) ([]byte, error) {
+	if a.params.Database == "" || a.params.Collection == "" {
+		return nil, errors.New("database and collection are required")
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer client.Disconnect(ctx) //nolint:errcheck
+
+	collection := client.Database(a.params.Database).Collection(a.params.Collection)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var stats []mongodbIndexStats
+	if err = cursor.All(ctx, &stats); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	columns, dataRows, err := mongodbIndexStatsRows(stats)
+	if err != nil {
+		return nil, err
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *mongodbIndexStatsAction) sealed() {}
+
+// mongodbIndexStats represents $indexStats aggregation stage result document.
+// There is one document per index per host; on mongos, per index per shard.
+type mongodbIndexStats struct {
+	Name     string `bson:"name"`
+	Key      bson.D `bson:"key"`
+	Host     string `bson:"host"`
+	Shard    string `bson:"shard"`
+	Accesses struct {
+		Ops   int64     `bson:"ops"`
+		Since time.Time `bson:"since"`
+	} `bson:"accesses"`
+}
+
+// mongodbIndexStatsRows returns columns and data rows for given index stats.
+// Index is unused if it was not accessed on any host, and it is not _id index that can't be dropped.
+// Counters are reset on server restart and index rebuild, so "since" column should be checked too.
+func mongodbIndexStatsRows(stats []mongodbIndexStats) ([]string, [][]interface{}, error) {
+	columns := []string{"index", "key", "shard", "host", "ops", "since", "unused"}
+
+	used := make(map[string]bool)
+	for _, s := range stats {
+		if s.Accesses.Ops > 0 || s.Name == "_id_" {
+			used[s.Name] = true
+		}
+	}
+
+	var dataRows [][]interface{}
+	for _, s := range stats {
+		key, err := bson.MarshalExtJSON(s.Key, false, false)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		dataRows = append(dataRows, []interface{}{
+			s.Name, string(key), s.Shard, s.Host, s.Accesses.Ops, s.Accesses.Since, !used[s.Name],
+		})
+	}
+	return columns, dataRows, nil
+}
@@ -0,0 +1,102 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMongoDBIndexStats(t *testing.T) {
+	t.Parallel()
+
+	database := "test_index_stats"
+	dsn := tests.GetTestMongoDBDSN(t)
+	client := tests.OpenTestMongoDB(t, dsn)
+	defer client.Database(database).Drop(context.Background()) //nolint:errcheck
+	prepareMongoDBPeople(t, client, database)
+
+	// use "name" index
+	err := client.Database(database).Collection("people").FindOne(context.Background(), bson.M{"last_name": "Brannigan"}).Err()
+	require.NoError(t, err)
+
+	a := NewMongoDBIndexStatsAction(MongoDBCollectionActionParams{
+		DSN:        dsn,
+		TempDir:    createTempDir(t),
+		Database:   database,
+		Collection: "people",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual [][]interface{}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	require.Len(t, actual, 5)
+	assert.Equal(t, []interface{}{"index", "key", "shard", "host", "ops", "since", "unused"}, actual[0])
+
+	unused := make(map[string]bool)
+	for _, row := range actual[1:] {
+		unused[row[0].(string)] = row[6].(bool)
+	}
+	assert.Equal(t, map[string]bool{"_id_": false, "name": false, "expire_at": true, "age": true}, unused)
+}
+
+func TestMongoDBIndexStatsRows(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	stat := func(name, host string, ops int64) mongodbIndexStats {
+		s := mongodbIndexStats{
+			Name: name,
+			Key:  bson.D{{Key: name, Value: int32(1)}},
+			Host: host,
+		}
+		s.Accesses.Ops = ops
+		s.Accesses.Since = since
+		return s
+	}
+
+	// on mongos, "name" index is used only on the second shard
+	columns, dataRows, err := mongodbIndexStatsRows([]mongodbIndexStats{
+		stat("_id_", "rs1:27017", 0),
+		stat("name", "rs1:27017", 0),
+		stat("name", "rs2:27017", 5),
+		stat("age", "rs1:27017", 0),
+		stat("age", "rs2:27017", 0),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"index", "key", "shard", "host", "ops", "since", "unused"}, columns)
+	expected := [][]interface{}{
+		{"_id_", `{"_id_":1}`, "", "rs1:27017", int64(0), since, false},
+		{"name", `{"name":1}`, "", "rs1:27017", int64(0), since, false},
+		{"name", `{"name":1}`, "", "rs2:27017", int64(5), since, false},
+		{"age", `{"age":1}`, "", "rs1:27017", int64(0), since, true},
+		{"age", `{"age":1}`, "", "rs2:27017", int64(0), since, true},
+	}
+	assert.Equal(t, expected, dataRows)
+}
@@ -0,0 +1,167 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/fingerprinter"
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// MongoDBKillOpActionParams represent MongoDB killOp Action params.
+type MongoDBKillOpActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+	// OpID is an operation ID from currentOp: a number for mongod, or "shard:number" string for mongos.
+	OpID string
+	// Fingerprint is an expected QAN fingerprint of the running operation.
+	Fingerprint string
+}
+
+type mongodbKillOpAction struct {
+	params MongoDBKillOpActionParams
+}
+
+// NewMongoDBKillOpAction creates MongoDB killOp Action.
+// This is an Action that kills the operation with given ID if it still has expected fingerprint.
+// It is not started by the client yet, see NewMySQLKillQueryAction.
+func NewMongoDBKillOpAction(params MongoDBKillOpActionParams) Action {
+	return &mongodbKillOpAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mongodbKillOpAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mongodbKillOpAction) Type() string {
+	return "mongodb-kill-op"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mongodbKillOpAction) Run(ctx context.Context) ([]byte, error) {
+	res := &killQueryResult{
+		Target:      a.params.OpID,
+		Fingerprint: a.params.Fingerprint,
+	}
+	var err error
+	res.Query, err = a.kill(ctx)
+	return auditKillQuery(a, a.params.TempDir, res, err)
+}
+
+// kill checks the operation and kills it; it returns the killed operation's command as JSON.
+func (a *mongodbKillOpAction) kill(ctx context.Context) (string, error) {
+	if a.params.OpID == "" {
+		return "", errors.New("operation ID is required")
+	}
+	if a.params.Fingerprint == "" {
+		return "", errors.New("query fingerprint is required")
+	}
+
+	// mongod uses numeric operation IDs
+	var opID interface{} = a.params.OpID
+	if id, err := strconv.ParseInt(a.params.OpID, 10, 32); err == nil {
+		opID = int32(id)
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer client.Disconnect(ctx) //nolint:errcheck
+
+	admin := client.Database("admin")
+	var currentOp struct {
+		InProg []bson.Raw `bson:"inprog"`
+	}
+	err = admin.RunCommand(ctx, bson.D{{Key: "currentOp", Value: 1}, {Key: "$all", Value: true}, {Key: "opid", Value: opID}}).Decode(&currentOp)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(currentOp.InProg) == 0 {
+		return "", errors.Errorf("operation %s not found", a.params.OpID)
+	}
+
+	fingerprint, command, err := mongodbOpFingerprint(currentOp.InProg[0])
+	if err != nil {
+		return "", err
+	}
+	if err = checkKillQueryFingerprint(a.params.Fingerprint, fingerprint); err != nil {
+		return "", err
+	}
+
+	if err = admin.RunCommand(ctx, bson.D{{Key: "killOp", Value: 1}, {Key: "op", Value: opID}}).Err(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return command, nil
+}
+
+func (a *mongodbKillOpAction) sealed() {}
+
+// mongodbOpFingerprint returns QAN fingerprint and command (as JSON) of currentOp operation.
+// Operation documents have the same op, ns, and command fields as profiler documents;
+// other fields (like locks) have different types, so only those are used.
+func mongodbOpFingerprint(op bson.Raw) (string, string, error) {
+	var doc struct {
+		Op                 string `bson:"op"`
+		Ns                 string `bson:"ns"`
+		Command            bson.D `bson:"command"`
+		OriginatingCommand bson.D `bson:"originatingCommand"`
+	}
+	if err := bson.Unmarshal(op, &doc); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	if doc.Op == "none" || len(doc.Command) == 0 {
+		return "", "", errors.New("operation is not running a command")
+	}
+
+	fp, err := fingerprinter.NewFingerprinter(fingerprinter.DefaultKeyFilters()).Fingerprint(proto.SystemProfile{
+		Op:                 doc.Op,
+		Ns:                 doc.Ns,
+		Command:            doc.Command,
+		OriginatingCommand: doc.OriginatingCommand,
+	})
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	command, err := bson.MarshalExtJSON(doc.Command, false, false)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	return fp.Fingerprint, string(command), nil
+}
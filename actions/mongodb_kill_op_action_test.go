@@ -0,0 +1,211 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMongoDBKillOp(t *testing.T) {
+	t.Parallel()
+
+	database := "test_kill_op"
+	dsn := tests.GetTestMongoDBDSN(t)
+	client := tests.OpenTestMongoDB(t, dsn)
+	defer client.Database(database).Drop(context.Background()) //nolint:errcheck
+
+	people := client.Database(database).Collection("people")
+	docs := make([]interface{}, 50)
+	for i := range docs {
+		docs[i] = bson.M{"name": fmt.Sprintf("person %d", i)}
+	}
+	_, err := people.InsertMany(context.Background(), docs)
+	require.NoError(t, err)
+
+	// startSlowFind runs a slow find (about 100 ms per document) in a separate goroutine
+	// and returns its operation ID, fingerprint, and done channel.
+	startSlowFind := func(t *testing.T, comment string) (string, string, <-chan error) {
+		t.Helper()
+
+		done := make(chan error, 1)
+		go func() {
+			filter := bson.M{"$where": "function() { var d = new Date(); while (new Date() - d < 100) {} return true; }"}
+			cursor, err := people.Find(context.Background(), filter, options.Find().SetComment(comment))
+			if err == nil {
+				for cursor.Next(context.Background()) {
+				}
+				err = cursor.Err()
+				cursor.Close(context.Background()) //nolint:errcheck
+			}
+			done <- err
+		}()
+
+		var op bson.Raw
+		require.Eventually(t, func() bool {
+			var currentOp struct {
+				InProg []bson.Raw `bson:"inprog"`
+			}
+			err := client.Database("admin").RunCommand(context.Background(), bson.D{
+				{Key: "currentOp", Value: 1},
+				{Key: "command.comment", Value: comment},
+			}).Decode(&currentOp)
+			if err != nil || len(currentOp.InProg) == 0 {
+				return false
+			}
+			op = currentOp.InProg[0]
+			return true
+		}, 3*time.Second, 10*time.Millisecond)
+
+		var opID string
+		switch v := op.Lookup("opid"); v.Type {
+		case bsontype.Int32:
+			opID = strconv.FormatInt(int64(v.Int32()), 10)
+		case bsontype.Int64:
+			opID = strconv.FormatInt(v.Int64(), 10)
+		default:
+			opID = v.StringValue()
+		}
+
+		fingerprint, _, err := mongodbOpFingerprint(op)
+		require.NoError(t, err)
+		return opID, fingerprint, done
+	}
+
+	t.Run("Kill", func(t *testing.T) {
+		t.Parallel()
+
+		opID, fingerprint, done := startSlowFind(t, "pmm-agent-test-kill")
+		a := NewMongoDBKillOpAction(MongoDBKillOpActionParams{
+			DSN:         dsn,
+			TempDir:     createTempDir(t),
+			OpID:        opID,
+			Fingerprint: fingerprint,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual killQueryResult
+		require.NoError(t, json.Unmarshal(b, &actual))
+		assert.Equal(t, opID, actual.Target)
+		assert.Equal(t, fingerprint, actual.Fingerprint)
+		assert.Contains(t, actual.Query, "people")
+
+		select {
+		case err = <-done:
+			assert.Error(t, err, "operation was not interrupted")
+		case <-time.After(3 * time.Second):
+			t.Fatal("operation was not killed")
+		}
+	})
+
+	t.Run("FingerprintMismatch", func(t *testing.T) {
+		t.Parallel()
+
+		opID, fingerprint, done := startSlowFind(t, "pmm-agent-test-mismatch")
+		a := NewMongoDBKillOpAction(MongoDBKillOpActionParams{
+			DSN:         dsn,
+			TempDir:     createTempDir(t),
+			OpID:        opID,
+			Fingerprint: "FIND people name",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		assert.EqualError(t, err, fmt.Sprintf("query fingerprint mismatch: expected %q, got %q", "FIND people name", fingerprint))
+
+		select {
+		case <-done:
+			t.Fatal("operation was killed")
+		case <-time.After(100 * time.Millisecond):
+		}
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+
+		a := NewMongoDBKillOpAction(MongoDBKillOpActionParams{
+			DSN:         dsn,
+			TempDir:     createTempDir(t),
+			OpID:        "2147483647",
+			Fingerprint: "FIND people name",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		assert.EqualError(t, err, "operation 2147483647 not found")
+	})
+}
+
+func TestMongoDBOpFingerprint(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Find", func(t *testing.T) {
+		t.Parallel()
+
+		op, err := bson.Marshal(bson.D{
+			{Key: "opid", Value: 42},
+			{Key: "op", Value: "command"},
+			{Key: "ns", Value: "test.people"},
+			{Key: "locks", Value: bson.D{{Key: "Global", Value: "r"}}},
+			{Key: "command", Value: bson.D{
+				{Key: "find", Value: "people"},
+				{Key: "filter", Value: bson.D{{Key: "name", Value: "Alice"}, {Key: "age", Value: bson.D{{Key: "$gt", Value: 30}}}}},
+				{Key: "sort", Value: bson.D{{Key: "age", Value: 1}}},
+				{Key: "$db", Value: "test"},
+			}},
+		})
+		require.NoError(t, err)
+
+		fingerprint, command, err := mongodbOpFingerprint(op)
+		require.NoError(t, err)
+		assert.Equal(t, "FIND people age,name", fingerprint)
+		assert.Equal(t, `{"find":"people","filter":{"name":"Alice","age":{"$gt":30}},"sort":{"age":1},"$db":"test"}`, command)
+	})
+
+	t.Run("Idle", func(t *testing.T) {
+		t.Parallel()
+
+		op, err := bson.Marshal(bson.D{
+			{Key: "opid", Value: 42},
+			{Key: "op", Value: "none"},
+			{Key: "ns", Value: ""},
+			{Key: "command", Value: bson.D{}},
+		})
+		require.NoError(t, err)
+
+		_, _, err = mongodbOpFingerprint(op)
+		assert.EqualError(t, err, "operation is not running a command")
+	})
+}
@@ -0,0 +1,153 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// MongoDBCollectionActionParams represent MongoDB collection-level Actions params.
+type MongoDBCollectionActionParams struct {
+	ID         string
+	DSN        string
+	Files      *agentpb.TextFiles
+	TempDir    string
+	Database   string
+	Collection string
+}
+
+type mongodbShowIndexAction struct {
+	params MongoDBCollectionActionParams
+}
+
+// NewMongoDBShowIndexAction creates MongoDB show index Action.
+// This is an Action that returns collection indexes in the same layout as MySQL SHOW INDEX: one row per indexed field.
+//
+// MongoDB collection Actions (this one, index stats and collection stats) are not started by the client yet:
+// agentpb has only MongoDB explain and admin command params, not collection-level ones.
+func NewMongoDBShowIndexAction(params MongoDBCollectionActionParams) Action {
+	return &mongodbShowIndexAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mongodbShowIndexAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mongodbShowIndexAction) Type() string {
+	return "mongodb-show-index"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mongodbShowIndexAction) Run(ctx context.Context) ([]byte, error) {
+	if a.params.Database == "" || a.params.Collection == "" {
+		return nil, errors.New("database and collection are required")
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer client.Disconnect(ctx) //nolint:errcheck
+
+	cursor, err := client.Database(a.params.Database).Collection(a.params.Collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var indexes []mongodbIndexSpec
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	columns, dataRows, err := mongodbShowIndexRows(indexes)
+	if err != nil {
+		return nil, err
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *mongodbShowIndexAction) sealed() {}
+
+// mongodbIndexSpec represents listIndexes command result document.
+type mongodbIndexSpec struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	Hidden                  bool     `bson:"hidden"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+}
+
+// mongodbShowIndexRows returns columns and data rows for given indexes.
+func mongodbShowIndexRows(indexes []mongodbIndexSpec) ([]string, [][]interface{}, error) {
+	columns := []string{"index", "seq_in_index", "field", "direction", "unique", "sparse", "hidden", "ttl_seconds", "partial_filter"}
+
+	var dataRows [][]interface{}
+	for _, index := range indexes {
+		var ttl, partial interface{}
+		if index.ExpireAfterSeconds != nil {
+			ttl = *index.ExpireAfterSeconds
+		}
+		if index.PartialFilterExpression != nil {
+			b, err := bson.MarshalExtJSON(index.PartialFilterExpression, false, false)
+			if err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			partial = string(b)
+		}
+
+		for i, e := range index.Key {
+			dataRows = append(dataRows, []interface{}{
+				index.Name, i + 1, e.Key, mongodbIndexDirection(e.Value), index.Unique, index.Sparse, index.Hidden, ttl, partial,
+			})
+		}
+	}
+	return columns, dataRows, nil
+}
+
+// mongodbIndexDirection returns 1 or -1 for ascending and descending index fields,
+// and index type for special ones ("text", "hashed", "2dsphere", etc).
+func mongodbIndexDirection(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return v
+	}
+}
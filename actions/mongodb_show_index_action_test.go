@@ -0,0 +1,113 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+// prepareMongoDBPeople creates "people" collection with a few documents and indexes in the given database.
+func prepareMongoDBPeople(t *testing.T, client *mongo.Client, database string) {
+	t.Helper()
+
+	ctx := context.Background()
+	people := client.Database(database).Collection("people")
+	_, err := people.InsertMany(ctx, []interface{}{
+		bson.M{"last_name": "Brannigan", "first_name": "Zapp", "age": 40},
+		bson.M{"last_name": "Farnsworth", "first_name": "Hubert", "age": 160},
+	})
+	require.NoError(t, err)
+
+	_, err = people.Indexes().CreateMany(ctx, []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "last_name", Value: 1}, {Key: "first_name", Value: -1}},
+		Options: options.Index().SetName("name").SetUnique(true),
+	}, {
+		Keys:    bson.D{{Key: "expire_at", Value: 1}},
+		Options: options.Index().SetName("expire_at").SetExpireAfterSeconds(3600).SetSparse(true),
+	}, {
+		Keys:    bson.D{{Key: "age", Value: 1}},
+		Options: options.Index().SetName("age").SetPartialFilterExpression(bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 18}}}}),
+	}})
+	require.NoError(t, err)
+}
+
+func TestMongoDBShowIndex(t *testing.T) {
+	t.Parallel()
+
+	database := "test_show_index"
+	dsn := tests.GetTestMongoDBDSN(t)
+	client := tests.OpenTestMongoDB(t, dsn)
+	defer client.Database(database).Drop(context.Background()) //nolint:errcheck
+	prepareMongoDBPeople(t, client, database)
+
+	a := NewMongoDBShowIndexAction(MongoDBCollectionActionParams{
+		DSN:        dsn,
+		TempDir:    createTempDir(t),
+		Database:   database,
+		Collection: "people",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual [][]interface{}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	expected := [][]interface{}{
+		{"index", "seq_in_index", "field", "direction", "unique", "sparse", "hidden", "ttl_seconds", "partial_filter"},
+		{"_id_", 1.0, "_id", 1.0, false, false, false, nil, nil},
+		{"name", 1.0, "last_name", 1.0, true, false, false, nil, nil},
+		{"name", 2.0, "first_name", -1.0, true, false, false, nil, nil},
+		{"expire_at", 1.0, "expire_at", 1.0, false, true, false, 3600.0, nil},
+		{"age", 1.0, "age", 1.0, false, false, false, nil, `{"age":{"$gt":18}}`},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestMongoDBShowIndexRows(t *testing.T) {
+	t.Parallel()
+
+	indexes := []mongodbIndexSpec{{
+		Name: "text",
+		Key:  bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+	}, {
+		Name: "location",
+		Key:  bson.D{{Key: "location", Value: "2dsphere"}, {Key: "created", Value: -1.0}},
+	}}
+	columns, dataRows, err := mongodbShowIndexRows(indexes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"index", "seq_in_index", "field", "direction", "unique", "sparse", "hidden", "ttl_seconds", "partial_filter"}, columns)
+	expected := [][]interface{}{
+		{"text", 1, "_fts", "text", false, false, false, nil, nil},
+		{"text", 2, "_ftsx", int64(1), false, false, false, nil, nil},
+		{"location", 1, "location", "2dsphere", false, false, false, nil, nil},
+		{"location", 2, "created", int64(-1), false, false, false, nil, nil},
+	}
+	assert.Equal(t, expected, dataRows)
+}
@@ -0,0 +1,538 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// mongodbNoReplicationEnabled is an error code returned by replSetGetStatus for standalone instances.
+const mongodbNoReplicationEnabled = 76
+
+// MongoDBSummaryActionParams represent MongoDB summary Action params.
+type MongoDBSummaryActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+}
+
+type mongodbSummaryAction struct {
+	params MongoDBSummaryActionParams
+}
+
+// NewMongoDBSummaryAction creates MongoDB summary Action.
+// This is an Action that collects the same information as pt-mongodb-summary using the Go driver
+// and returns it as JSON document with summary data and text report.
+// The runner uses RunStream that sends only the text report, as the replaced tool did.
+func NewMongoDBSummaryAction(params MongoDBSummaryActionParams) Action {
+	return &mongodbSummaryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mongodbSummaryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mongodbSummaryAction) Type() string {
+	return "mongodb-summary"
+}
+
+// Run runs an Action and returns output and error.
+// Output is a JSON document with summary data and text report.
+func (a *mongodbSummaryAction) Run(ctx context.Context) ([]byte, error) {
+	summary, report, err := a.summary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSummary(summary, report)
+}
+
+// RunStream runs an Action and passes output chunks to send.
+// Output is a text report only, the same as pt-mongodb-summary output that PMM Server expects.
+func (a *mongodbSummaryAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	_, report, err := a.summary(ctx)
+	if err != nil {
+		return err
+	}
+	return sendSummaryReport(report, send)
+}
+
+// summary collects summary data and text report.
+func (a *mongodbSummaryAction) summary(ctx context.Context) (*mongodbSummary, string, error) {
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	defer client.Disconnect(ctx) //nolint:errcheck
+
+	summary, err := getMongoDBSummary(ctx, client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var report strings.Builder
+	if err = writeMongoDBSummaryReport(&report, summary); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return summary, report.String(), nil
+}
+
+func (a *mongodbSummaryAction) sealed() {}
+
+// check interfaces
+var (
+	_ Action          = (*mongodbSummaryAction)(nil)
+	_ StreamingAction = (*mongodbSummaryAction)(nil)
+)
+
+// mongodbSummary represents MongoDB instance summary.
+// Optional sections are nil if they are not applicable to the instance or can't be collected;
+// the reason of the latter is added to notes.
+type mongodbSummary struct {
+	Host       *mongodbHostSummary       `json:"host,omitempty"`
+	Server     mongodbServerSummary      `json:"server"`
+	ReplicaSet *mongodbReplicaSetSummary `json:"replica_set,omitempty"`
+	Sharding   *mongodbShardingSummary   `json:"sharding,omitempty"`
+	Oplog      *mongodbOplogSummary      `json:"oplog,omitempty"`
+	Databases  []mongodbDatabaseSummary  `json:"databases"`
+	Notes      []string                  `json:"notes,omitempty"`
+}
+
+// mongodbHostSummary represents hostInfo command result.
+type mongodbHostSummary struct {
+	Hostname  string `json:"hostname"`
+	OS        string `json:"os"`
+	CPUArch   string `json:"cpu_arch"`
+	CPUCores  int64  `json:"cpu_cores"`
+	MemSizeMB int64  `json:"mem_size_mb"`
+}
+
+// mongodbServerSummary represents serverStatus command result.
+type mongodbServerSummary struct {
+	Host                 string           `json:"host"`
+	Version              string           `json:"version"`
+	Process              string           `json:"process"`
+	PID                  int64            `json:"pid"`
+	UptimeSeconds        int64            `json:"uptime_seconds"`
+	StorageEngine        string           `json:"storage_engine"`
+	ConnectionsCurrent   int64            `json:"connections_current"`
+	ConnectionsAvailable int64            `json:"connections_available"`
+	Opcounters           map[string]int64 `json:"opcounters"`
+}
+
+// mongodbReplicaSetSummary represents replSetGetStatus command result.
+type mongodbReplicaSetSummary struct {
+	Name    string                    `json:"name"`
+	Members []mongodbReplicaSetMember `json:"members"`
+}
+
+// mongodbReplicaSetMember represents replica set member.
+type mongodbReplicaSetMember struct {
+	Name   string    `json:"name"`
+	State  string    `json:"state"`
+	Health float64   `json:"health"`
+	Optime time.Time `json:"optime"`
+	Self   bool      `json:"self"`
+}
+
+// mongodbShardingSummary represents sharded cluster status as seen by mongos.
+type mongodbShardingSummary struct {
+	Shards             []mongodbShard `json:"shards"`
+	Databases          int64          `json:"databases"`
+	ShardedCollections int64          `json:"sharded_collections"`
+	BalancerMode       string         `json:"balancer_mode"`
+	BalancerInRound    bool           `json:"balancer_in_round"`
+}
+
+// mongodbShard represents config.shards document.
+type mongodbShard struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+}
+
+// mongodbOplogSummary represents oplog size and time window.
+type mongodbOplogSummary struct {
+	SizeBytes     int64     `json:"size_bytes"`
+	MaxSizeBytes  int64     `json:"max_size_bytes"`
+	First         time.Time `json:"first"`
+	Last          time.Time `json:"last"`
+	WindowSeconds int64     `json:"window_seconds"`
+}
+
+// mongodbDatabaseSummary represents dbStats and profile command results.
+type mongodbDatabaseSummary struct {
+	Name           string `json:"name"`
+	SizeOnDisk     int64  `json:"size_on_disk"`
+	Collections    int64  `json:"collections"`
+	Objects        int64  `json:"objects"`
+	DataSize       int64  `json:"data_size"`
+	StorageSize    int64  `json:"storage_size"`
+	Indexes        int64  `json:"indexes"`
+	IndexSize      int64  `json:"index_size"`
+	ProfilingLevel int64  `json:"profiling_level"`
+	SlowMS         int64  `json:"slow_ms"`
+}
+
+// getMongoDBSummary collects MongoDB instance summary.
+// Only serverStatus and listDatabases failures are fatal.
+func getMongoDBSummary(ctx context.Context, client *mongo.Client) (*mongodbSummary, error) {
+	summary := new(mongodbSummary)
+	admin := client.Database("admin")
+
+	var serverStatus struct {
+		Host    string
+		Version string
+		Process string
+		PID     int64
+		Uptime  int64
+		Engine  struct {
+			Name string
+		} `bson:"storageEngine"`
+		Connections struct {
+			Current   int64
+			Available int64
+		}
+		Opcounters map[string]int64
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&serverStatus); err != nil {
+		return nil, errors.Wrap(err, "serverStatus failed")
+	}
+	summary.Server = mongodbServerSummary{
+		Host:                 serverStatus.Host,
+		Version:              serverStatus.Version,
+		Process:              serverStatus.Process,
+		PID:                  serverStatus.PID,
+		UptimeSeconds:        serverStatus.Uptime,
+		StorageEngine:        serverStatus.Engine.Name,
+		ConnectionsCurrent:   serverStatus.Connections.Current,
+		ConnectionsAvailable: serverStatus.Connections.Available,
+		Opcounters:           serverStatus.Opcounters,
+	}
+
+	note := func(section string, err error) {
+		summary.Notes = append(summary.Notes, fmt.Sprintf("%s: %s", section, err))
+	}
+
+	var hostInfo struct {
+		System struct {
+			Hostname  string
+			CPUArch   string `bson:"cpuArch"`
+			NumCores  int64  `bson:"numCores"`
+			MemSizeMB int64  `bson:"memSizeMB"`
+		}
+		OS struct {
+			Name    string
+			Version string
+		} `bson:"os"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hostInfo", Value: 1}}).Decode(&hostInfo); err == nil {
+		summary.Host = &mongodbHostSummary{
+			Hostname:  hostInfo.System.Hostname,
+			OS:        strings.TrimSpace(hostInfo.OS.Name + " " + hostInfo.OS.Version),
+			CPUArch:   hostInfo.System.CPUArch,
+			CPUCores:  hostInfo.System.NumCores,
+			MemSizeMB: hostInfo.System.MemSizeMB,
+		}
+	} else {
+		note("hostInfo", err)
+	}
+
+	if serverStatus.Process == "mongos" {
+		sharding, err := getMongoDBShardingSummary(ctx, client)
+		if err == nil {
+			summary.Sharding = sharding
+		} else {
+			note("sharding status", err)
+		}
+	} else {
+		var replSetStatus struct {
+			Set     string
+			Members []struct {
+				Name       string
+				StateStr   string `bson:"stateStr"`
+				Health     float64
+				OptimeDate time.Time `bson:"optimeDate"`
+				Self       bool
+			}
+		}
+		err := admin.RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&replSetStatus)
+		var cmdErr mongo.CommandError
+		switch {
+		case err == nil:
+			summary.ReplicaSet = &mongodbReplicaSetSummary{
+				Name:    replSetStatus.Set,
+				Members: make([]mongodbReplicaSetMember, len(replSetStatus.Members)),
+			}
+			for i, m := range replSetStatus.Members {
+				summary.ReplicaSet.Members[i] = mongodbReplicaSetMember{
+					Name:   m.Name,
+					State:  m.StateStr,
+					Health: m.Health,
+					Optime: m.OptimeDate.UTC(),
+					Self:   m.Self,
+				}
+			}
+
+			if summary.Oplog, err = getMongoDBOplogSummary(ctx, client); err != nil {
+				note("oplog", err)
+			}
+		case errors.As(err, &cmdErr) && cmdErr.Code == mongodbNoReplicationEnabled:
+			// standalone instance
+		default:
+			note("replSetGetStatus", err)
+		}
+	}
+
+	databases, err := client.ListDatabases(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listDatabases failed")
+	}
+	sort.Slice(databases.Databases, func(i, j int) bool { return databases.Databases[i].Name < databases.Databases[j].Name })
+	for _, d := range databases.Databases {
+		db := mongodbDatabaseSummary{
+			Name:       d.Name,
+			SizeOnDisk: d.SizeOnDisk,
+		}
+
+		var dbStats struct {
+			Collections int64
+			Objects     int64
+			DataSize    int64 `bson:"dataSize"`
+			StorageSize int64 `bson:"storageSize"`
+			Indexes     int64
+			IndexSize   int64 `bson:"indexSize"`
+		}
+		if err = client.Database(d.Name).RunCommand(ctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&dbStats); err == nil {
+			db.Collections = dbStats.Collections
+			db.Objects = dbStats.Objects
+			db.DataSize = dbStats.DataSize
+			db.StorageSize = dbStats.StorageSize
+			db.Indexes = dbStats.Indexes
+			db.IndexSize = dbStats.IndexSize
+		} else {
+			note("dbStats "+d.Name, err)
+		}
+
+		var profile struct {
+			Was    int64
+			SlowMS int64 `bson:"slowms"`
+		}
+		if err = client.Database(d.Name).RunCommand(ctx, bson.D{{Key: "profile", Value: -1}}).Decode(&profile); err == nil {
+			db.ProfilingLevel = profile.Was
+			db.SlowMS = profile.SlowMS
+		} else {
+			note("profile "+d.Name, err)
+		}
+
+		summary.Databases = append(summary.Databases, db)
+	}
+
+	return summary, nil
+}
+
+// getMongoDBShardingSummary returns sharded cluster status; client should be connected to mongos.
+func getMongoDBShardingSummary(ctx context.Context, client *mongo.Client) (*mongodbShardingSummary, error) {
+	config := client.Database("config")
+	res := new(mongodbShardingSummary)
+
+	cursor, err := config.Collection("shards").Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var shards []struct {
+		ID   string `bson:"_id"`
+		Host string
+	}
+	if err = cursor.All(ctx, &shards); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res.Shards = make([]mongodbShard, len(shards))
+	for i, s := range shards {
+		res.Shards[i] = mongodbShard{ID: s.ID, Host: s.Host}
+	}
+
+	if res.Databases, err = config.Collection("databases").CountDocuments(ctx, bson.D{}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	filter := bson.D{{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if res.ShardedCollections, err = config.Collection("collections").CountDocuments(ctx, filter); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var balancer struct {
+		Mode    string
+		InRound bool `bson:"inBalancerRound"`
+	}
+	if err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStatus", Value: 1}}).Decode(&balancer); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res.BalancerMode = balancer.Mode
+	res.BalancerInRound = balancer.InRound
+
+	return res, nil
+}
+
+// getMongoDBOplogSummary returns oplog size and time window; client should be connected to replica set member.
+func getMongoDBOplogSummary(ctx context.Context, client *mongo.Client) (*mongodbOplogSummary, error) {
+	local := client.Database("local")
+
+	var stats struct {
+		Size    int64
+		MaxSize int64 `bson:"maxSize"`
+	}
+	if err := local.RunCommand(ctx, bson.D{{Key: "collStats", Value: "oplog.rs"}}).Decode(&stats); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	entryTS := func(order int) (primitive.Timestamp, error) {
+		var entry struct {
+			TS primitive.Timestamp `bson:"ts"`
+		}
+		opts := options.FindOne().SetSort(bson.D{{Key: "$natural", Value: order}}).SetProjection(bson.D{{Key: "ts", Value: 1}})
+		err := local.Collection("oplog.rs").FindOne(ctx, bson.D{}, opts).Decode(&entry)
+		return entry.TS, errors.WithStack(err)
+	}
+	first, err := entryTS(1)
+	if err != nil {
+		return nil, err
+	}
+	last, err := entryTS(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongodbOplogSummary{
+		SizeBytes:     stats.Size,
+		MaxSizeBytes:  stats.MaxSize,
+		First:         time.Unix(int64(first.T), 0).UTC(),
+		Last:          time.Unix(int64(last.T), 0).UTC(),
+		WindowSeconds: int64(last.T) - int64(first.T),
+	}, nil
+}
+
+// writeMongoDBSummaryReport writes text report in pt-mongodb-summary format.
+func writeMongoDBSummaryReport(w io.Writer, s *mongodbSummary) error {
+	section := func(title string) { writeReportSection(w, title) }
+	value := func(name string, format string, args ...interface{}) { writeReportValue(w, name, format, args...) }
+
+	if s.Host != nil {
+		section("This host")
+		value("Hostname", "%s", s.Host.Hostname)
+		value("OS", "%s", s.Host.OS)
+		value("CPU", "%d cores, %s", s.Host.CPUCores, s.Host.CPUArch)
+		value("Memory", "%s", humanizeBytes(s.Host.MemSizeMB<<20))
+	}
+
+	section("Report On " + s.Server.Host)
+	value("Version", "%s", s.Server.Version)
+	value("Process Type", "%s", s.Server.Process)
+	value("PID", "%d", s.Server.PID)
+	value("Uptime", "%s", humanizeSeconds(s.Server.UptimeSeconds))
+	value("Storage Engine", "%s", s.Server.StorageEngine)
+	value("Connections", "%d current, %d available", s.Server.ConnectionsCurrent, s.Server.ConnectionsAvailable)
+
+	section("Operations counters")
+	ops := make([]string, 0, len(s.Server.Opcounters))
+	for op := range s.Server.Opcounters {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		value(op, "%d", s.Server.Opcounters[op])
+	}
+
+	if s.ReplicaSet != nil {
+		section("Replica set " + s.ReplicaSet.Name)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Member\tState\tHealth\tOptime\t")
+		for _, m := range s.ReplicaSet.Members {
+			name := m.Name
+			if m.Self {
+				name += " (self)"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%g\t%s\t\n", name, m.State, m.Health, m.Optime.Format(time.RFC3339))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if s.Oplog != nil {
+		section("Oplog")
+		value("Oplog Size", "%s", humanizeBytes(s.Oplog.MaxSizeBytes))
+		value("Oplog Used", "%s", humanizeBytes(s.Oplog.SizeBytes))
+		value("Oplog Length", "%s", humanizeSeconds(s.Oplog.WindowSeconds))
+		value("Oplog First", "%s", s.Oplog.First.Format(time.RFC3339))
+		value("Oplog Last", "%s", s.Oplog.Last.Format(time.RFC3339))
+	}
+
+	if s.Sharding != nil {
+		section("Cluster wide")
+		value("Shards", "%d", len(s.Sharding.Shards))
+		for _, shard := range s.Sharding.Shards {
+			value(shard.ID, "%s", shard.Host)
+		}
+		value("Databases", "%d", s.Sharding.Databases)
+		value("Sharded Collections", "%d", s.Sharding.ShardedCollections)
+		value("Balancer", "%s, in round: %t", s.Sharding.BalancerMode, s.Sharding.BalancerInRound)
+	}
+
+	section("Databases")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Database\tCollections\tObjects\tData Size\tStorage Size\tIndexes\tIndex Size\tProfiling\tSlow ms\t")
+	for _, d := range s.Databases {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\t%s\t%d\t%s\t%d\t%d\t\n", d.Name, d.Collections, d.Objects,
+			humanizeBytes(d.DataSize), humanizeBytes(d.StorageSize), d.Indexes, humanizeBytes(d.IndexSize), d.ProfilingLevel, d.SlowMS)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(s.Notes) != 0 {
+		section("Notes")
+		for _, n := range s.Notes {
+			fmt.Fprintf(w, "  %s\n", n)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMongoDBSummary(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMongoDBDSN(t)
+
+	a := NewMongoDBSummaryAction(MongoDBSummaryActionParams{
+		DSN:     dsn,
+		TempDir: createTempDir(t),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual struct {
+		Summary mongodbSummary
+		Report  string
+	}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	t.Logf("Report:\n%s", actual.Report)
+
+	assert.Equal(t, "mongod", actual.Summary.Server.Process)
+	assert.NotEmpty(t, actual.Summary.Server.Version)
+	assert.NotNil(t, actual.Summary.Host)
+	assert.Nil(t, actual.Summary.ReplicaSet)
+	assert.Nil(t, actual.Summary.Sharding)
+
+	var names []string
+	for _, d := range actual.Summary.Databases {
+		names = append(names, d.Name)
+	}
+	assert.Contains(t, names, "admin")
+
+	assert.Contains(t, actual.Report, "# Report On ")
+	assert.Contains(t, actual.Report, "# Databases ")
+}
+
+func TestWriteMongoDBSummaryReport(t *testing.T) {
+	t.Parallel()
+
+	s := &mongodbSummary{
+		Server: mongodbServerSummary{
+			Host:                 "mongo1:27017",
+			Version:              "4.4.13",
+			Process:              "mongod",
+			PID:                  1,
+			UptimeSeconds:        90061,
+			StorageEngine:        "wiredTiger",
+			ConnectionsCurrent:   5,
+			ConnectionsAvailable: 838855,
+			Opcounters:           map[string]int64{"query": 10, "insert": 2},
+		},
+		ReplicaSet: &mongodbReplicaSetSummary{
+			Name: "rs0",
+			Members: []mongodbReplicaSetMember{
+				{Name: "mongo1:27017", State: "PRIMARY", Health: 1, Optime: time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC), Self: true},
+				{Name: "mongo2:27017", State: "SECONDARY", Health: 1, Optime: time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)},
+			},
+		},
+		Oplog: &mongodbOplogSummary{
+			SizeBytes:     1536,
+			MaxSizeBytes:  1 << 30,
+			First:         time.Date(2022, 4, 1, 11, 0, 0, 0, time.UTC),
+			Last:          time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC),
+			WindowSeconds: 3600,
+		},
+		Databases: []mongodbDatabaseSummary{
+			{Name: "admin", Collections: 1, Objects: 2, DataSize: 100, StorageSize: 4096, Indexes: 1, IndexSize: 4096, SlowMS: 100},
+		},
+		Notes: []string{"hostInfo: (Unauthorized) not authorized"},
+	}
+
+	var report strings.Builder
+	err := writeMongoDBSummaryReport(&report, s)
+	require.NoError(t, err)
+	actual := report.String()
+	t.Logf("Report:\n%s", actual)
+
+	for _, expected := range []string{
+		"# Report On mongo1:27017 #",
+		"                  Version | 4.4.13\n",
+		"                   Uptime | 1 days, 1 hours, 1 minutes, 1 seconds\n",
+		"                   insert | 2\n",
+		"# Replica set rs0 #",
+		"  mongo1:27017 (self)  PRIMARY    1       2022-04-01T12:00:00Z",
+		"               Oplog Size | 1.00 GiB\n",
+		"               Oplog Used | 1.50 KiB\n",
+		"             Oplog Length | 0 days, 1 hours, 0 minutes, 0 seconds\n",
+		"  admin     1            2        100 B      4.00 KiB",
+		"  hostInfo: (Unauthorized) not authorized\n",
+	} {
+		assert.Contains(t, actual, expected)
+	}
+	assert.NotContains(t, actual, "# This host")
+	assert.NotContains(t, actual, "# Cluster wide")
+}
@@ -0,0 +1,240 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/tlshelpers"
+)
+
+// MySQLDeadlockActionParams represent MySQL latest deadlock Action params.
+type MySQLDeadlockActionParams struct {
+	ID       string
+	DSN      string
+	TLSFiles *agentpb.TextFiles
+}
+
+type mysqlDeadlockAction struct {
+	params MySQLDeadlockActionParams
+}
+
+// NewMySQLDeadlockAction creates MySQL latest deadlock Action.
+// This is an Action that parses LATEST DETECTED DEADLOCK section of SHOW ENGINE INNODB STATUS output.
+// Like NewMySQLLockWaitsAction, it is not started by the client until agentpb defines its params.
+func NewMySQLDeadlockAction(params MySQLDeadlockActionParams) Action {
+	return &mysqlDeadlockAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mysqlDeadlockAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mysqlDeadlockAction) Type() string {
+	return "mysql-latest-deadlock"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mysqlDeadlockAction) Run(ctx context.Context) ([]byte, error) {
+	db, err := mysqlOpen(a.params.DSN, a.params.TLSFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+	defer tlshelpers.DeregisterMySQLCerts()
+
+	var typ, name, status string
+	if err = db.QueryRowContext(ctx, "SHOW /* pmm-agent */ ENGINE INNODB STATUS").Scan(&typ, &name, &status); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := struct {
+		// nil if there were no deadlocks since server start
+		Deadlock *mysqlDeadlock `json:"deadlock"`
+	}{
+		Deadlock: parseMySQLDeadlock(status),
+	}
+	b, err := json.Marshal(res)
+	return b, errors.WithStack(err)
+}
+
+func (a *mysqlDeadlockAction) sealed() {}
+
+// mysqlDeadlock represents LATEST DETECTED DEADLOCK section of SHOW ENGINE INNODB STATUS output.
+type mysqlDeadlock struct {
+	Time         string                     `json:"time"`
+	Transactions []mysqlDeadlockTransaction `json:"transactions"`
+	RolledBack   int                        `json:"rolled_back"` // transaction number, 0 if unknown
+}
+
+// mysqlDeadlockTransaction represents a single transaction involved in a deadlock.
+type mysqlDeadlockTransaction struct {
+	Number        int                 `json:"number"` // (1), (2), etc
+	TrxID         string              `json:"trx_id"`
+	ActiveSeconds int64               `json:"active_seconds"`
+	State         string              `json:"state"`
+	ThreadID      int64               `json:"thread_id"`
+	QueryID       int64               `json:"query_id"`
+	Host          string              `json:"host"`
+	User          string              `json:"user"`
+	Query         string              `json:"query"`
+	Holds         []mysqlDeadlockLock `json:"holds"`
+	WaitsFor      []mysqlDeadlockLock `json:"waits_for"`
+}
+
+// mysqlDeadlockLock represents a lock held or waited by a deadlocked transaction.
+type mysqlDeadlockLock struct {
+	Type  string `json:"type"` // RECORD or TABLE
+	Table string `json:"table"`
+	Index string `json:"index,omitempty"`
+	Mode  string `json:"mode"`
+}
+
+var (
+	mysqlDeadlockTrxRE       = regexp.MustCompile(`^\*\*\* \((\d+)\) TRANSACTION:$`)
+	mysqlDeadlockHoldsRE     = regexp.MustCompile(`^\*\*\* \((\d+)\) HOLDS THE LOCK\(S\):$`)
+	mysqlDeadlockWaitingRE   = regexp.MustCompile(`^\*\*\* \((\d+)\) WAITING FOR THIS LOCK TO BE GRANTED:$`)
+	mysqlDeadlockRollbackRE  = regexp.MustCompile(`^\*\*\* WE ROLL BACK TRANSACTION \((\d+)\)$`)
+	mysqlDeadlockTrxInfoRE   = regexp.MustCompile(`^TRANSACTION (\w+), ACTIVE (\d+) sec(?: (.+))?$`)
+	mysqlDeadlockThreadRE    = regexp.MustCompile(`^MySQL thread id (\d+), OS thread handle \w+, query id (\d+) (\S+) (\S+)`)
+	mysqlDeadlockRecordRE    = regexp.MustCompile(`^RECORD LOCKS space id \d+ page no \d+ n bits \d+ index (\S+) of table (\S+) trx id \w+ lock[_ ]mode (.+?)(?: waiting)?$`)
+	mysqlDeadlockTableLockRE = regexp.MustCompile(`^TABLE LOCK table (\S+) trx id \w+ lock mode (.+?)(?: waiting)?$`)
+)
+
+// parseMySQLDeadlock parses LATEST DETECTED DEADLOCK section of SHOW ENGINE INNODB STATUS output.
+// It returns nil if there is no such section.
+func parseMySQLDeadlock(status string) *mysqlDeadlock {
+	const header = "LATEST DETECTED DEADLOCK\n"
+	start := strings.Index(status, header)
+	if start < 0 {
+		return nil
+	}
+	section := status[start+len(header):]
+
+	res := &mysqlDeadlock{
+		Transactions: []mysqlDeadlockTransaction{},
+	}
+	var trx *mysqlDeadlockTransaction
+	var locks *[]mysqlDeadlockLock
+	var inQuery bool
+	s := bufio.NewScanner(strings.NewReader(section))
+	s.Buffer(nil, len(section)+1)
+	for s.Scan() {
+		line := s.Text()
+
+		// the next section header
+		if strings.HasPrefix(line, "------------") && res.Time != "" {
+			break
+		}
+
+		if inQuery {
+			if line == "" || strings.HasPrefix(line, "*** ") {
+				inQuery = false
+			} else {
+				if trx.Query != "" {
+					trx.Query += "\n"
+				}
+				trx.Query += line
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "------------"):
+			continue
+
+		case res.Time == "":
+			// for example, "2022-04-01 12:00:00 0x7f1c8c0f9700" or "2022-04-01 12:00:00 7f1c8c0f9700"
+			if f := strings.Fields(line); len(f) >= 2 {
+				res.Time = f[0] + " " + f[1]
+			}
+
+		case mysqlDeadlockTrxRE.MatchString(line):
+			n, _ := strconv.Atoi(mysqlDeadlockTrxRE.FindStringSubmatch(line)[1])
+			res.Transactions = append(res.Transactions, mysqlDeadlockTransaction{
+				Number:   n,
+				Holds:    []mysqlDeadlockLock{},
+				WaitsFor: []mysqlDeadlockLock{},
+			})
+			trx = &res.Transactions[len(res.Transactions)-1]
+			locks = nil
+
+		case mysqlDeadlockHoldsRE.MatchString(line):
+			if trx = mysqlDeadlockFind(res, mysqlDeadlockHoldsRE.FindStringSubmatch(line)[1]); trx != nil {
+				locks = &trx.Holds
+			}
+
+		case mysqlDeadlockWaitingRE.MatchString(line):
+			if trx = mysqlDeadlockFind(res, mysqlDeadlockWaitingRE.FindStringSubmatch(line)[1]); trx != nil {
+				locks = &trx.WaitsFor
+			}
+
+		case mysqlDeadlockRollbackRE.MatchString(line):
+			res.RolledBack, _ = strconv.Atoi(mysqlDeadlockRollbackRE.FindStringSubmatch(line)[1])
+			trx, locks = nil, nil
+
+		case trx == nil:
+			continue
+
+		case locks != nil:
+			if m := mysqlDeadlockRecordRE.FindStringSubmatch(line); m != nil {
+				*locks = append(*locks, mysqlDeadlockLock{Type: "RECORD", Index: m[1], Table: m[2], Mode: m[3]})
+			} else if m := mysqlDeadlockTableLockRE.FindStringSubmatch(line); m != nil {
+				*locks = append(*locks, mysqlDeadlockLock{Type: "TABLE", Table: m[1], Mode: m[2]})
+			}
+			// skip record dumps
+
+		case mysqlDeadlockTrxInfoRE.MatchString(line):
+			m := mysqlDeadlockTrxInfoRE.FindStringSubmatch(line)
+			trx.TrxID = m[1]
+			trx.ActiveSeconds, _ = strconv.ParseInt(m[2], 10, 64)
+			trx.State = m[3]
+
+		case mysqlDeadlockThreadRE.MatchString(line):
+			m := mysqlDeadlockThreadRE.FindStringSubmatch(line)
+			trx.ThreadID, _ = strconv.ParseInt(m[1], 10, 64)
+			trx.QueryID, _ = strconv.ParseInt(m[2], 10, 64)
+			trx.Host, trx.User = m[3], m[4]
+			// the query follows thread information until the next empty line
+			inQuery = true
+		}
+	}
+
+	return res
+}
+
+// mysqlDeadlockFind returns deadlock transaction with given number, or nil.
+func mysqlDeadlockFind(d *mysqlDeadlock, number string) *mysqlDeadlockTransaction {
+	n, _ := strconv.Atoi(number)
+	for i := range d.Transactions {
+		if d.Transactions[i].Number == n {
+			return &d.Transactions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestParseMySQLDeadlock(t *testing.T) {
+	t.Parallel()
+
+	read := func(t *testing.T, name string) string {
+		t.Helper()
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "mysql", name)) //nolint:gosec
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("MySQL80", func(t *testing.T) {
+		t.Parallel()
+
+		expected := &mysqlDeadlock{
+			Time: "2022-04-01 12:00:00",
+			Transactions: []mysqlDeadlockTransaction{{
+				Number:        1,
+				TrxID:         "2073",
+				ActiveSeconds: 10,
+				State:         "starting index read",
+				ThreadID:      9,
+				QueryID:       74,
+				Host:          "localhost",
+				User:          "root",
+				Query:         "UPDATE city\nSET Population = Population + 1\nWHERE ID = 2",
+				Holds: []mysqlDeadlockLock{
+					{Type: "RECORD", Table: "`world`.`city`", Index: "PRIMARY", Mode: "X locks rec but not gap"},
+				},
+				WaitsFor: []mysqlDeadlockLock{
+					{Type: "RECORD", Table: "`world`.`city`", Index: "PRIMARY", Mode: "X locks rec but not gap"},
+				},
+			}, {
+				Number:        2,
+				TrxID:         "2074",
+				ActiveSeconds: 5,
+				State:         "starting index read",
+				ThreadID:      10,
+				QueryID:       75,
+				Host:          "10.0.0.7",
+				User:          "app",
+				Query:         "UPDATE city SET Population = Population - 1 WHERE ID = 1",
+				Holds: []mysqlDeadlockLock{
+					{Type: "TABLE", Table: "`world`.`city`", Mode: "IX"},
+					{Type: "RECORD", Table: "`world`.`city`", Index: "PRIMARY", Mode: "X locks rec but not gap"},
+				},
+				WaitsFor: []mysqlDeadlockLock{
+					{Type: "RECORD", Table: "`world`.`city`", Index: "PRIMARY", Mode: "X locks rec but not gap"},
+				},
+			}},
+			RolledBack: 2,
+		}
+		assert.Equal(t, expected, parseMySQLDeadlock(read(t, "innodb_status_80.txt")))
+	})
+
+	t.Run("MySQL57", func(t *testing.T) {
+		t.Parallel()
+
+		// MySQL 5.7 does not print locks held by the first transaction
+		actual := parseMySQLDeadlock(read(t, "innodb_status_57.txt"))
+		require.NotNil(t, actual)
+		require.Len(t, actual.Transactions, 2)
+		assert.Equal(t, 1, actual.RolledBack)
+
+		trx := actual.Transactions[0]
+		assert.Equal(t, "421912", trx.TrxID)
+		assert.Equal(t, "SELECT * FROM t WHERE id = 1 FOR UPDATE", trx.Query)
+		assert.Empty(t, trx.Holds)
+		assert.Equal(t, []mysqlDeadlockLock{{Type: "RECORD", Table: "`test`.`t`", Index: "PRIMARY", Mode: "X locks rec but not gap"}}, trx.WaitsFor)
+
+		trx = actual.Transactions[1]
+		assert.Equal(t, "421913", trx.TrxID)
+		assert.Equal(t, int64(7), trx.ActiveSeconds)
+		assert.Equal(t, "SELECT * FROM t WHERE id = 2 FOR UPDATE", trx.Query)
+		assert.Len(t, trx.Holds, 1)
+		assert.Len(t, trx.WaitsFor, 1)
+	})
+
+	t.Run("NoDeadlock", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Nil(t, parseMySQLDeadlock("\n-----------------\nBACKGROUND THREAD\n-----------------\n"))
+	})
+}
+
+func TestMySQLDeadlock(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMySQLDSN(t)
+	a := NewMySQLDeadlockAction(MySQLDeadlockActionParams{
+		DSN: dsn,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	// deadlock may or may not happen since server start
+	var actual struct {
+		Deadlock *mysqlDeadlock
+	}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	if actual.Deadlock != nil {
+		assert.NotEmpty(t, actual.Deadlock.Time)
+	}
+}
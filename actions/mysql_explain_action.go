@@ -40,6 +40,9 @@ type explainResponse struct {
 	ExplainResult []byte `json:"explain_result"`
 	Query         string `json:"explained_query"`
 	IsDMLQuery    bool   `json:"is_dml"`
+
+	// NormalizedPlan is a plan tree common for all databases, nil if not available.
+	NormalizedPlan *planNode `json:"normalized_plan,omitempty"`
 }
 
 // ErrCannotEncodeExplainResponse cannot JSON encode the explain response.
@@ -72,9 +75,12 @@ func (a *mysqlExplainAction) Run(ctx context.Context) ([]byte, error) {
 	// query has a copy of the original params.Query field if the query is a SELECT or the equivalent
 	// SELECT after converting DML queries.
 	query := a.query
-	isDMLQuery := isDMLQuery(query)
+	isDMLQuery := isDMLQuery(query, dialectMySQL)
 	if isDMLQuery {
-		query = dmlToSelect(query)
+		// newer MySQL versions can explain DML query as is
+		if q, err := dmlToSelect(query, dialectMySQL); err == nil {
+			query = q
+		}
 	}
 	db, err := mysqlOpen(a.params.Dsn, a.params.TlsFiles)
 	if err != nil {
@@ -101,6 +107,9 @@ func (a *mysqlExplainAction) Run(ctx context.Context) ([]byte, error) {
 		response.ExplainResult, err = a.explainDefault(ctx, tx)
 	case agentpb.MysqlExplainOutputFormat_MYSQL_EXPLAIN_OUTPUT_FORMAT_JSON:
 		response.ExplainResult, err = a.explainJSON(ctx, tx)
+		if err == nil {
+			response.NormalizedPlan, _ = normalizeMySQLPlan(response.ExplainResult)
+		}
 	case agentpb.MysqlExplainOutputFormat_MYSQL_EXPLAIN_OUTPUT_FORMAT_TRADITIONAL_JSON:
 		response.ExplainResult, err = a.explainTraditionalJSON(ctx, tx)
 	default:
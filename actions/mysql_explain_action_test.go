@@ -102,6 +102,8 @@ id |select_type |table |partitions |type |possible_keys |key  |key_len |ref  |ro
 		require.NoError(t, err)
 
 		assert.Equal(t, 1, m.Get("query_block.select_id").Int())
+		require.NotNil(t, er.NormalizedPlan)
+		assert.Equal(t, "query_block", er.NormalizedPlan.NodeType)
 
 		var table map[string]interface{}
 		switch mySQLVendor {
@@ -200,7 +202,7 @@ id |select_type |table |partitions |type |possible_keys |key  |key_len |ref  |ro
 		err = json.Unmarshal(resp, &er)
 		assert.NoError(t, err)
 		assert.Equal(t, er.IsDMLQuery, true)
-		assert.Equal(t, er.Query, `SELECT * FROM city  WHERE Name='Rosario'`)
+		assert.Equal(t, er.Query, `SELECT * FROM city WHERE Name = 'Rosario'`)
 	})
 
 	t.Run("LittleBobbyTables", func(t *testing.T) {
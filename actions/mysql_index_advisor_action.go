@@ -0,0 +1,337 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/tlshelpers"
+)
+
+// MySQLIndexAdvisorActionParams represent MySQL index advisor Action params.
+type MySQLIndexAdvisorActionParams struct {
+	ID       string
+	DSN      string
+	TLSFiles *agentpb.TextFiles
+	Query    string
+}
+
+type mysqlIndexAdvisorAction struct {
+	params MySQLIndexAdvisorActionParams
+}
+
+// NewMySQLIndexAdvisorAction creates MySQL index advisor Action.
+// This is an Action that explains given query, reads indexes and statistics of touched tables,
+// and returns ranked suggestions: missing composite indexes, duplicate and unused indexes.
+//
+// It is not reachable from PMM Server yet: there is no index advisor case in StartActionRequest params.
+func NewMySQLIndexAdvisorAction(params MySQLIndexAdvisorActionParams) Action {
+	return &mysqlIndexAdvisorAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mysqlIndexAdvisorAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mysqlIndexAdvisorAction) Type() string {
+	return "mysql-index-advisor"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mysqlIndexAdvisorAction) Run(ctx context.Context) ([]byte, error) {
+	explain := NewMySQLExplainAction(a.params.ID, &agentpb.StartActionRequest_MySQLExplainParams{
+		Dsn:          a.params.DSN,
+		Query:        a.params.Query,
+		OutputFormat: agentpb.MysqlExplainOutputFormat_MYSQL_EXPLAIN_OUTPUT_FORMAT_JSON,
+		TlsFiles:     a.params.TLSFiles,
+	})
+	b, err := explain.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var er explainResponse
+	if err = json.Unmarshal(b, &er); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if er.NormalizedPlan == nil {
+		return nil, errors.New("cannot parse explain output")
+	}
+
+	db, err := mysqlOpen(a.params.DSN, a.params.TLSFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+	defer tlshelpers.DeregisterMySQLCerts()
+
+	res := indexAdvice{
+		Query: er.Query,
+	}
+
+	// EXPLAIN output contains table aliases without schemas
+	names := mysqlQueryTables(er.Query)
+	metadata := make(map[string]*tableMetadata)
+	tables := make(map[string]*tableMetadata)
+	walkPlan(er.NormalizedPlan, func(node *planNode) {
+		if node.Object == "" || tables[node.Object] != nil {
+			return
+		}
+		// derived tables, subqueries and CTEs
+		if strings.HasPrefix(node.Object, "<") {
+			return
+		}
+
+		name := node.Object
+		if n, ok := names[name]; ok {
+			name = n
+		}
+		table := metadata[name]
+		if table == nil {
+			var err error
+			if table, err = mysqlTableMetadata(ctx, db, name); err != nil {
+				res.Notes = append(res.Notes, fmt.Sprintf("table %s is not analyzed: %s", name, err))
+				return
+			}
+			metadata[name] = table
+		}
+		tables[node.Object] = table
+	})
+
+	suggestions, notes := adviseIndexes(er.NormalizedPlan, tables, mysqlIndexDialect{})
+	res.Suggestions = append([]indexSuggestion{}, suggestions...)
+	res.Notes = append(res.Notes, notes...)
+	return json.Marshal(res)
+}
+
+func (a *mysqlIndexAdvisorAction) sealed() {}
+
+// mysqlQueryTables returns tables referenced in query's FROM and JOIN clauses, keyed by names used in EXPLAIN output
+// (aliases or table names). Values are table names with optional schema.
+func mysqlQueryTables(query string) map[string]string {
+	// tokens before lexing error are enough
+	tokens, _ := lexQuery(query, dialectMySQL)
+	isName := func(j int) bool {
+		if j >= len(tokens) {
+			return false
+		}
+		t := tokens[j]
+		return t.kind == tokenQuotedIdent || (t.kind == tokenWord && !t.is(mysqlNonAliasWords...))
+	}
+
+	res := make(map[string]string)
+	for i, t := range tokens {
+		if !t.is("FROM", "JOIN", "STRAIGHT_JOIN", "UPDATE") {
+			continue
+		}
+
+		// comma-separated table references; derived tables are skipped
+		for j := i + 1; isName(j); j++ {
+			parts := []string{unquoteIdent(tokens[j])}
+			if j+2 < len(tokens) && tokens[j+1].isPunct('.') && isName(j+2) {
+				parts = append(parts, unquoteIdent(tokens[j+2]))
+				j += 2
+			}
+			alias := parts[len(parts)-1]
+			switch {
+			case j+2 < len(tokens) && tokens[j+1].is("AS"):
+				alias = unquoteIdent(tokens[j+2])
+				j += 2
+			case isName(j + 1):
+				alias = unquoteIdent(tokens[j+1])
+				j++
+			}
+			if _, ok := res[alias]; !ok {
+				res[alias] = strings.Join(parts, ".")
+			}
+
+			if j+1 >= len(tokens) || !tokens[j+1].isPunct(',') {
+				break
+			}
+			j++
+		}
+	}
+	return res
+}
+
+// mysqlNonAliasWords are keywords that can follow table name in table reference.
+var mysqlNonAliasWords = []string{
+	"WHERE", "JOIN", "INNER", "CROSS", "LEFT", "RIGHT", "NATURAL", "STRAIGHT_JOIN", "ON", "USING",
+	"USE", "IGNORE", "FORCE", "PARTITION", "SET", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT",
+	"UNION", "EXCEPT", "INTERSECT", "FOR", "LOCK", "INTO", "AS",
+}
+
+// mysqlTableMetadata returns metadata of given table (with optional schema) from `SHOW INDEX`, `SHOW TABLE STATUS`
+// and index usage statistics from performance_schema, if it is enabled.
+func mysqlTableMetadata(ctx context.Context, db *sql.DB, name string) (*tableMetadata, error) {
+	var schema, from string
+	table := lastPart(name)
+	if table != name {
+		schema = name[:len(name)-len(table)-1]
+		from = " FROM " + quoteMySQLIdent(schema)
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW /* pmm-agent */ TABLE STATUS"+from+" WHERE Name = ?", table)
+	if err != nil {
+		return nil, err
+	}
+	columns, dataRows, err := readRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataRows) == 0 {
+		return nil, errors.Errorf("table %q not found", name)
+	}
+	res := &tableMetadata{
+		Name:        name,
+		Rows:        metadataNumber(metadataValue(columns, dataRows[0], "Rows")),
+		Cardinality: make(map[string]float64),
+	}
+
+	if rows, err = db.QueryContext(ctx, "SHOW /* pmm-agent */ INDEX IN "+quoteMySQLIdent(table)+from); err != nil {
+		return nil, err
+	}
+	if columns, dataRows, err = readRows(rows); err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]*tableIndex)
+	var names []string
+	for _, row := range dataRows {
+		name := metadataString(metadataValue(columns, row, "Key_name"))
+		index := indexes[name]
+		if index == nil {
+			index = &tableIndex{
+				Name:   name,
+				Type:   metadataString(metadataValue(columns, row, "Index_type")),
+				Unique: metadataNumber(metadataValue(columns, row, "Non_unique")) == 0,
+			}
+			indexes[name] = index
+			names = append(names, name)
+		}
+
+		column := metadataString(metadataValue(columns, row, "Column_name"))
+		if column == "" {
+			// functional key part (MySQL 8.0.13+)
+			column = "(" + metadataString(metadataValue(columns, row, "Expression")) + ")"
+		}
+		if subPart := metadataString(metadataValue(columns, row, "Sub_part")); subPart != "" {
+			column += "(" + subPart + ")"
+		}
+		index.Columns = append(index.Columns, column)
+
+		if len(index.Columns) == 1 {
+			cardinality := metadataNumber(metadataValue(columns, row, "Cardinality"))
+			if cardinality > res.Cardinality[column] {
+				res.Cardinality[column] = cardinality
+			}
+		}
+	}
+
+	unused, err := mysqlUnusedIndexes(ctx, db, schema, table)
+	if err != nil {
+		unused = nil // performance_schema may be disabled; ignore error
+	}
+	for _, name := range unused {
+		if index := indexes[name]; index != nil {
+			index.Unused = true
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		res.Indexes = append(res.Indexes, *indexes[name])
+	}
+	return res, nil
+}
+
+// mysqlUnusedIndexes returns names of indexes of the given table in the given schema (or in the current database
+// if schema is empty) that were not used since server start.
+func mysqlUnusedIndexes(ctx context.Context, db *sql.DB, schema, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT /* pmm-agent */ INDEX_NAME "+
+		"FROM performance_schema.table_io_waits_summary_by_index_usage "+
+		"WHERE OBJECT_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND OBJECT_NAME = ? "+
+		"AND INDEX_NAME IS NOT NULL AND COUNT_STAR = 0", schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, name)
+	}
+	return res, rows.Err()
+}
+
+// metadataValue returns a value of the named column from a data row, or nil.
+func metadataValue(columns []string, row []interface{}, column string) interface{} {
+	for i, c := range columns {
+		if c == column && i < len(row) {
+			return row[i]
+		}
+	}
+	return nil
+}
+
+// mysqlIndexDialect builds MySQL index statements.
+type mysqlIndexDialect struct{}
+
+func (mysqlIndexDialect) createIndex(table string, columns []string) string {
+	name := "idx_" + strings.Join(columns, "_")
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteMySQLIdent(c)
+	}
+	return fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", quoteMySQLName(table), quoteMySQLIdent(name), strings.Join(quoted, ", "))
+}
+
+func (mysqlIndexDialect) dropIndex(table, index string) string {
+	return fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", quoteMySQLName(table), quoteMySQLIdent(index))
+}
+
+// quoteMySQLName returns quoted MySQL name with optional schema.
+func quoteMySQLName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = quoteMySQLIdent(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteMySQLIdent returns quoted MySQL identifier.
+func quoteMySQLIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
+
+// check interfaces
+var (
+	_ indexDialect = mysqlIndexDialect{}
+)
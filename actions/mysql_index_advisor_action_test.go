@@ -0,0 +1,140 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMySQLIndexAdvisor(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMySQLDSN(t)
+
+	t.Run("MissingIndex", func(t *testing.T) {
+		a := NewMySQLIndexAdvisorAction(MySQLIndexAdvisorActionParams{
+			DSN:   dsn,
+			Query: "SELECT * FROM city WHERE Population > 1000000 AND District = 'Noord-Holland'",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		t.Logf("Full JSON:\n%s", b)
+
+		var actual indexAdvice
+		require.NoError(t, json.Unmarshal(b, &actual))
+		require.NotEmpty(t, actual.Suggestions)
+		assert.Equal(t, indexSuggestionMissing, actual.Suggestions[0].Kind)
+		assert.Equal(t, "city", actual.Suggestions[0].Table)
+		assert.Equal(t, []string{"District", "Population"}, actual.Suggestions[0].Columns)
+	})
+
+	t.Run("Aliased", func(t *testing.T) {
+		a := NewMySQLIndexAdvisorAction(MySQLIndexAdvisorActionParams{
+			DSN:   dsn,
+			Query: "SELECT * FROM world.city AS c WHERE c.Population > 1000000 AND c.District = 'Noord-Holland'",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual indexAdvice
+		require.NoError(t, json.Unmarshal(b, &actual))
+		assert.Empty(t, actual.Notes)
+		require.NotEmpty(t, actual.Suggestions)
+		assert.Equal(t, indexSuggestionMissing, actual.Suggestions[0].Kind)
+		assert.Equal(t, "world.city", actual.Suggestions[0].Table)
+		assert.Equal(t, []string{"District", "Population"}, actual.Suggestions[0].Columns)
+		assert.Equal(t, "ALTER TABLE `world`.`city` ADD INDEX `idx_District_Population` (`District`, `Population`)",
+			actual.Suggestions[0].Statement)
+	})
+
+	t.Run("Joined", func(t *testing.T) {
+		a := NewMySQLIndexAdvisorAction(MySQLIndexAdvisorActionParams{
+			DSN: dsn,
+			Query: "SELECT ci.Name FROM city ci JOIN country co ON co.Code = ci.CountryCode " +
+				"WHERE ci.District = 'Noord-Holland' AND co.Continent = 'Europe'",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual indexAdvice
+		require.NoError(t, json.Unmarshal(b, &actual))
+		assert.Empty(t, actual.Notes)
+		require.NotEmpty(t, actual.Suggestions)
+		for _, s := range actual.Suggestions {
+			assert.Contains(t, []string{"city", "country"}, s.Table, "%+v", s)
+		}
+	})
+
+	t.Run("IndexUsed", func(t *testing.T) {
+		a := NewMySQLIndexAdvisorAction(MySQLIndexAdvisorActionParams{
+			DSN:   dsn,
+			Query: "SELECT * FROM city WHERE ID = 1",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual indexAdvice
+		require.NoError(t, json.Unmarshal(b, &actual))
+		for _, s := range actual.Suggestions {
+			assert.NotEqual(t, indexSuggestionMissing, s.Kind, "%+v", s)
+		}
+	})
+}
+
+func TestMySQLQueryTables(t *testing.T) {
+	t.Parallel()
+
+	for query, expected := range map[string]map[string]string{
+		"SELECT * FROM city WHERE ID = 1":              {"city": "city"},
+		"SELECT * FROM world.city AS c WHERE c.ID = 1": {"c": "world.city"},
+		"SELECT ci.Name FROM `world`.`city` ci JOIN country AS co ON co.Code = ci.CountryCode": {
+			"ci": "world.city",
+			"co": "country",
+		},
+		"SELECT * FROM city c1, city c2 LEFT JOIN country USING (Code) WHERE c1.ID = c2.ID": {
+			"c1":      "city",
+			"c2":      "city",
+			"country": "country",
+		},
+		"SELECT * FROM (SELECT * FROM city c WHERE c.ID > 1) d STRAIGHT_JOIN country FORCE INDEX (PRIMARY)": {
+			"c":       "city",
+			"country": "country",
+		},
+		"UPDATE city c SET c.Name = 'x' WHERE c.ID = 1": {"c": "city"},
+	} {
+		assert.Equal(t, expected, mysqlQueryTables(query), "%s", query)
+	}
+}
@@ -0,0 +1,160 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/percona/go-mysql/query"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/tlshelpers"
+)
+
+// MySQLKillQueryActionParams represent MySQL kill query Action params.
+type MySQLKillQueryActionParams struct {
+	ID       string
+	DSN      string
+	TLSFiles *agentpb.TextFiles
+	// TempDir is a directory for the audit log file; the file is not written if empty.
+	TempDir string
+	// ThreadID is a processlist ID of the connection running the query.
+	ThreadID uint64
+	// Fingerprint is an expected QAN fingerprint of the running query:
+	// slowlog fingerprint, or performance_schema digest or digest text.
+	Fingerprint string
+}
+
+type mysqlKillQueryAction struct {
+	params MySQLKillQueryActionParams
+}
+
+// NewMySQLKillQueryAction creates MySQL kill query Action.
+// This is an Action that runs `KILL QUERY` for given thread if it still runs the query with expected fingerprint.
+// The connection itself is not killed.
+//
+// agentpb has no StartActionRequest params for kill and cancel query Actions, so the client does not start them yet;
+// the same applies to NewPostgreSQLCancelQueryAction and NewMongoDBKillOpAction.
+func NewMySQLKillQueryAction(params MySQLKillQueryActionParams) Action {
+	return &mysqlKillQueryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mysqlKillQueryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mysqlKillQueryAction) Type() string {
+	return "mysql-kill-query"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mysqlKillQueryAction) Run(ctx context.Context) ([]byte, error) {
+	res := &killQueryResult{
+		Target:      strconv.FormatUint(a.params.ThreadID, 10),
+		Fingerprint: a.params.Fingerprint,
+	}
+	var err error
+	res.Query, err = a.kill(ctx)
+	return auditKillQuery(a, a.params.TempDir, res, err)
+}
+
+// kill checks the thread and kills its query; it returns the killed query.
+func (a *mysqlKillQueryAction) kill(ctx context.Context) (string, error) {
+	if a.params.ThreadID == 0 {
+		return "", errors.New("thread ID is required")
+	}
+	if a.params.Fingerprint == "" {
+		return "", errors.New("query fingerprint is required")
+	}
+
+	db, err := mysqlOpen(a.params.DSN, a.params.TLSFiles)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close() //nolint:errcheck
+	defer tlshelpers.DeregisterMySQLCerts()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	var own uint64
+	var info sql.NullString
+	err = conn.QueryRowContext(ctx, "SELECT /* pmm-agent */ CONNECTION_ID(), INFO FROM information_schema.PROCESSLIST WHERE ID = ?",
+		a.params.ThreadID).Scan(&own, &info)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", errors.Errorf("thread %d not found", a.params.ThreadID)
+	case err != nil:
+		return "", errors.WithStack(err)
+	case own == a.params.ThreadID:
+		return "", errors.New("can't kill own query")
+	case !info.Valid:
+		return "", errors.Errorf("thread %d is not running a query", a.params.ThreadID)
+	}
+
+	// QAN uses slowlog fingerprints or performance_schema digests depending on the query source
+	fingerprints := []string{query.Fingerprint(info.String)}
+	if digests, err := mysqlThreadDigests(ctx, conn, a.params.ThreadID); err == nil {
+		fingerprints = append(fingerprints, digests...)
+	}
+	if err = checkKillQueryFingerprint(a.params.Fingerprint, fingerprints...); err != nil {
+		return "", err
+	}
+
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf("KILL /* pmm-agent */ QUERY %d", a.params.ThreadID)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return info.String, nil
+}
+
+func (a *mysqlKillQueryAction) sealed() {}
+
+// mysqlThreadDigests returns performance_schema digests and digest texts of statements currently executed by the thread.
+// It returns error if performance_schema is disabled.
+func mysqlThreadDigests(ctx context.Context, conn *sql.Conn, threadID uint64) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT /* pmm-agent */ s.DIGEST, s.DIGEST_TEXT "+
+		"FROM performance_schema.events_statements_current s JOIN performance_schema.threads t USING (THREAD_ID) "+
+		"WHERE t.PROCESSLIST_ID = ?", threadID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []string
+	for rows.Next() {
+		var digest, digestText sql.NullString
+		if err = rows.Scan(&digest, &digestText); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, s := range []sql.NullString{digest, digestText} {
+			if s.Valid && s.String != "" {
+				res = append(res, s.String)
+			}
+		}
+	}
+	return res, errors.WithStack(rows.Err())
+}
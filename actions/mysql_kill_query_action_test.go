@@ -0,0 +1,161 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMySQLKillQuery(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMySQLDSN(t)
+	db := tests.OpenTestMySQL(t)
+	defer db.Close() //nolint:errcheck
+
+	// startSleep runs a long query in a separate connection and returns its thread ID and done channel.
+	startSleep := func(t *testing.T) (uint64, <-chan struct{}) {
+		t.Helper()
+
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		var threadID uint64
+		err = conn.QueryRowContext(context.Background(), "SELECT CONNECTION_ID()").Scan(&threadID)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer conn.Close() //nolint:errcheck
+			_, _ = conn.ExecContext(context.Background(), "SELECT SLEEP(5)")
+		}()
+
+		require.Eventually(t, func() bool {
+			var n int
+			err := db.QueryRow("SELECT COUNT(*) FROM information_schema.PROCESSLIST WHERE ID = ? AND INFO IS NOT NULL", threadID).Scan(&n)
+			return err == nil && n == 1
+		}, 3*time.Second, 10*time.Millisecond)
+
+		return threadID, done
+	}
+
+	t.Run("Kill", func(t *testing.T) {
+		t.Parallel()
+
+		threadID, done := startSleep(t)
+		a := NewMySQLKillQueryAction(MySQLKillQueryActionParams{
+			DSN:         dsn,
+			ThreadID:    threadID,
+			Fingerprint: "select sleep(?)",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual killQueryResult
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+		assert.Equal(t, "select sleep(?)", actual.Fingerprint)
+		assert.Equal(t, "SELECT SLEEP(5)", actual.Query)
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("query was not killed")
+		}
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		t.Parallel()
+
+		threadID, done := startSleep(t)
+
+		var digestText string
+		err := db.QueryRow("SELECT s.DIGEST_TEXT FROM performance_schema.events_statements_current s "+
+			"JOIN performance_schema.threads t USING (THREAD_ID) WHERE t.PROCESSLIST_ID = ?", threadID).Scan(&digestText)
+		if err != nil || digestText == "" {
+			<-done
+			t.Skipf("performance_schema digest is not available: %v", err)
+		}
+
+		a := NewMySQLKillQueryAction(MySQLKillQueryActionParams{
+			DSN:         dsn,
+			ThreadID:    threadID,
+			Fingerprint: digestText,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err = a.Run(ctx)
+		require.NoError(t, err)
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("query was not killed")
+		}
+	})
+
+	t.Run("FingerprintMismatch", func(t *testing.T) {
+		t.Parallel()
+
+		threadID, done := startSleep(t)
+		a := NewMySQLKillQueryAction(MySQLKillQueryActionParams{
+			DSN:         dsn,
+			ThreadID:    threadID,
+			Fingerprint: "select * from city",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		require.Error(t, err)
+		// performance_schema digest forms may follow slowlog fingerprint
+		assert.Contains(t, err.Error(), `query fingerprint mismatch: expected "select * from city", got "select sleep(?)"`)
+
+		select {
+		case <-done:
+			t.Fatal("query was killed")
+		case <-time.After(100 * time.Millisecond):
+		}
+		<-done
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+
+		a := NewMySQLKillQueryAction(MySQLKillQueryActionParams{
+			DSN:         dsn,
+			ThreadID:    1 << 40,
+			Fingerprint: "select sleep(?)",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		assert.EqualError(t, err, "thread 1099511627776 not found")
+	})
+}
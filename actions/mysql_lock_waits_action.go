@@ -0,0 +1,254 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/tlshelpers"
+)
+
+// MySQL lock waits queries return the same columns of mysqlLockWait.
+// Tables used by sys.innodb_lock_waits view are queried directly, so sys schema is not required.
+const (
+	// MySQL 8.0+.
+	mysqlLockWaitsQuery = `SELECT /* pmm-agent */
+  r.trx_id, r.trx_mysql_thread_id, r.trx_query, TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()),
+  b.trx_id, b.trx_mysql_thread_id, b.trx_query, TIMESTAMPDIFF(SECOND, b.trx_started, NOW()),
+  CONCAT('` + "`" + `', rl.OBJECT_SCHEMA, '` + "`.`" + `', rl.OBJECT_NAME, '` + "`" + `'), rl.INDEX_NAME, rl.LOCK_TYPE, rl.LOCK_MODE, bl.LOCK_MODE
+FROM performance_schema.data_lock_waits w
+JOIN information_schema.INNODB_TRX b ON b.trx_id = w.BLOCKING_ENGINE_TRANSACTION_ID
+JOIN information_schema.INNODB_TRX r ON r.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID
+JOIN performance_schema.data_locks bl ON bl.ENGINE_LOCK_ID = w.BLOCKING_ENGINE_LOCK_ID
+JOIN performance_schema.data_locks rl ON rl.ENGINE_LOCK_ID = w.REQUESTING_ENGINE_LOCK_ID
+ORDER BY r.trx_wait_started`
+
+	// MySQL 5.7; those tables were removed in 8.0.
+	mysqlLockWaits57Query = `SELECT /* pmm-agent */
+  r.trx_id, r.trx_mysql_thread_id, r.trx_query, TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()),
+  b.trx_id, b.trx_mysql_thread_id, b.trx_query, TIMESTAMPDIFF(SECOND, b.trx_started, NOW()),
+  rl.lock_table, rl.lock_index, rl.lock_type, rl.lock_mode, bl.lock_mode
+FROM information_schema.innodb_lock_waits w
+JOIN information_schema.innodb_trx b ON b.trx_id = w.blocking_trx_id
+JOIN information_schema.innodb_trx r ON r.trx_id = w.requesting_trx_id
+JOIN information_schema.innodb_locks bl ON bl.lock_id = w.blocking_lock_id
+JOIN information_schema.innodb_locks rl ON rl.lock_id = w.requested_lock_id
+ORDER BY r.trx_wait_started`
+)
+
+// MySQLLockWaitsActionParams represent MySQL lock waits Action params.
+type MySQLLockWaitsActionParams struct {
+	ID       string
+	DSN      string
+	TLSFiles *agentpb.TextFiles
+}
+
+type mysqlLockWaitsAction struct {
+	params MySQLLockWaitsActionParams
+}
+
+// NewMySQLLockWaitsAction creates MySQL lock waits Action.
+// This is an Action that returns InnoDB lock waits as a tree of blocking transactions.
+//
+// PMM Server can't request it yet: agentpb StartActionRequest has no lock waits params,
+// so the client has no case for it (nor for NewMySQLDeadlockAction).
+func NewMySQLLockWaitsAction(params MySQLLockWaitsActionParams) Action {
+	return &mysqlLockWaitsAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mysqlLockWaitsAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mysqlLockWaitsAction) Type() string {
+	return "mysql-lock-waits"
+}
+
+// Run runs an Action and returns output and error.
+func (a *mysqlLockWaitsAction) Run(ctx context.Context) ([]byte, error) {
+	db, err := mysqlOpen(a.params.DSN, a.params.TLSFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+	defer tlshelpers.DeregisterMySQLCerts()
+
+	waits, err := mysqlLockWaits(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := struct {
+		Roots []*mysqlLockNode `json:"roots"`
+		Waits []mysqlLockWait  `json:"waits"`
+	}{
+		Roots: buildMySQLLockTree(waits),
+		Waits: waits,
+	}
+	b, err := json.Marshal(res)
+	return b, errors.WithStack(err)
+}
+
+func (a *mysqlLockWaitsAction) sealed() {}
+
+// mysqlLockWait represents a single lock wait: a transaction waiting for a lock held by another one.
+type mysqlLockWait struct {
+	WaitingTrxID       string `json:"waiting_trx_id"`
+	WaitingThreadID    int64  `json:"waiting_thread_id"`
+	WaitingQuery       string `json:"waiting_query"`
+	WaitSeconds        int64  `json:"wait_seconds"`
+	BlockingTrxID      string `json:"blocking_trx_id"`
+	BlockingThreadID   int64  `json:"blocking_thread_id"`
+	BlockingQuery      string `json:"blocking_query"` // empty if blocking transaction is idle
+	BlockingTrxSeconds int64  `json:"blocking_trx_seconds"`
+	LockedTable        string `json:"locked_table"`
+	LockedIndex        string `json:"locked_index"`
+	LockType           string `json:"lock_type"`
+	WaitingLockMode    string `json:"waiting_lock_mode"`
+	BlockingLockMode   string `json:"blocking_lock_mode"`
+}
+
+// mysqlLockNode represents a transaction in the blocking tree.
+type mysqlLockNode struct {
+	TrxID      string `json:"trx_id"`
+	ThreadID   int64  `json:"thread_id"`
+	Query      string `json:"query"`
+	TrxSeconds int64  `json:"trx_seconds,omitempty"` // for roots only
+	// Wait is a lock wait for parent transaction; nil for roots.
+	Wait    *mysqlLockWait   `json:"wait,omitempty"`
+	Blocked []*mysqlLockNode `json:"blocked"`
+}
+
+// mysqlLockWaits returns current lock waits.
+func mysqlLockWaits(ctx context.Context, db *sql.DB) ([]mysqlLockWait, error) {
+	rows, err := db.QueryContext(ctx, mysqlLockWaitsQuery)
+	if err != nil {
+		var err57 error
+		if rows, err57 = db.QueryContext(ctx, mysqlLockWaits57Query); err57 != nil {
+			// return the error for the current version
+			return nil, errors.WithStack(err)
+		}
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []mysqlLockWait
+	for rows.Next() {
+		var w mysqlLockWait
+		var waitingQuery, blockingQuery, lockedIndex sql.NullString
+		if err = rows.Scan(&w.WaitingTrxID, &w.WaitingThreadID, &waitingQuery, &w.WaitSeconds,
+			&w.BlockingTrxID, &w.BlockingThreadID, &blockingQuery, &w.BlockingTrxSeconds,
+			&w.LockedTable, &lockedIndex, &w.LockType, &w.WaitingLockMode, &w.BlockingLockMode); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		w.WaitingQuery, w.BlockingQuery, w.LockedIndex = waitingQuery.String, blockingQuery.String, lockedIndex.String
+		res = append(res, w)
+	}
+	return res, errors.WithStack(rows.Err())
+}
+
+// buildMySQLLockTree returns trees of transactions rooted at transactions that block others, but do not wait themselves.
+// Transaction waiting for several others is included in all their subtrees.
+// Wait cycles (deadlocks that are not resolved yet) have no such transaction; they are rooted at the numerically lowest transaction ID,
+// and broken at the first repeated transaction.
+func buildMySQLLockTree(waits []mysqlLockWait) []*mysqlLockNode {
+	blocked := make(map[string][]int) // blocking trx ID -> waits indexes
+	waiting := make(map[string]bool)
+	var blocking []string // blocking trx IDs, in order of first wait
+	for i, w := range waits {
+		if _, ok := blocked[w.BlockingTrxID]; !ok {
+			blocking = append(blocking, w.BlockingTrxID)
+		}
+		blocked[w.BlockingTrxID] = append(blocked[w.BlockingTrxID], i)
+		waiting[w.WaitingTrxID] = true
+	}
+
+	reached := make(map[string]bool)
+	path := make(map[string]bool)
+	var build func(node *mysqlLockNode)
+	build = func(node *mysqlLockNode) {
+		reached[node.TrxID] = true
+		path[node.TrxID] = true
+		defer delete(path, node.TrxID)
+
+		node.Blocked = []*mysqlLockNode{}
+		for _, i := range blocked[node.TrxID] {
+			w := &waits[i]
+			child := &mysqlLockNode{
+				TrxID:    w.WaitingTrxID,
+				ThreadID: w.WaitingThreadID,
+				Query:    w.WaitingQuery,
+				Wait:     w,
+			}
+			if !path[child.TrxID] {
+				build(child)
+			}
+			node.Blocked = append(node.Blocked, child)
+		}
+	}
+	addRoot := func(trxID string) *mysqlLockNode {
+		w := waits[blocked[trxID][0]]
+		root := &mysqlLockNode{
+			TrxID:      w.BlockingTrxID,
+			ThreadID:   w.BlockingThreadID,
+			Query:      w.BlockingQuery,
+			TrxSeconds: w.BlockingTrxSeconds,
+		}
+		build(root)
+		return root
+	}
+
+	roots := []*mysqlLockNode{}
+	for _, id := range blocking {
+		if !waiting[id] {
+			roots = append(roots, addRoot(id))
+		}
+	}
+
+	cycled := append([]string(nil), blocking...)
+	sort.Slice(cycled, func(i, j int) bool { return lessMySQLTrxID(cycled[i], cycled[j]) })
+	for _, id := range cycled {
+		if !reached[id] {
+			roots = append(roots, addRoot(id))
+		}
+	}
+
+	return roots
+}
+
+// lessMySQLTrxID compares transaction IDs numerically; non-numeric IDs are compared as strings after numeric ones.
+func lessMySQLTrxID(a, b string) bool {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return an < bn
+	case aErr == nil || bErr == nil:
+		return aErr == nil
+	default:
+		return a < b
+	}
+}
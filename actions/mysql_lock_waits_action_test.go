@@ -0,0 +1,156 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestBuildMySQLLockTree(t *testing.T) {
+	t.Parallel()
+
+	wait := func(waiting, blocking string) mysqlLockWait {
+		return mysqlLockWait{WaitingTrxID: waiting, WaitingQuery: "q" + waiting, BlockingTrxID: blocking, BlockingQuery: "q" + blocking}
+	}
+
+	// flattens tree to "root(child(grandchild),child)" form
+	var flatten func(nodes []*mysqlLockNode) string
+	flatten = func(nodes []*mysqlLockNode) string {
+		var s string
+		for i, n := range nodes {
+			if i > 0 {
+				s += ","
+			}
+			s += n.TrxID
+			if len(n.Blocked) != 0 {
+				s += "(" + flatten(n.Blocked) + ")"
+			}
+		}
+		return s
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, []*mysqlLockNode{}, buildMySQLLockTree(nil))
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		t.Parallel()
+
+		waits := []mysqlLockWait{wait("3", "2"), wait("2", "1"), wait("4", "1")}
+		roots := buildMySQLLockTree(waits)
+		assert.Equal(t, "1(2(3),4)", flatten(roots))
+		require.Len(t, roots, 1)
+		assert.Equal(t, "q1", roots[0].Query)
+		assert.Nil(t, roots[0].Wait)
+		assert.Equal(t, "q2", roots[0].Blocked[0].Query)
+		assert.Equal(t, &waits[1], roots[0].Blocked[0].Wait)
+	})
+
+	t.Run("SeveralBlockers", func(t *testing.T) {
+		t.Parallel()
+
+		roots := buildMySQLLockTree([]mysqlLockWait{wait("3", "1"), wait("3", "2")})
+		assert.Equal(t, "1(3),2(3)", flatten(roots))
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		t.Parallel()
+
+		roots := buildMySQLLockTree([]mysqlLockWait{wait("5", "3"), wait("2", "1"), wait("3", "2"), wait("1", "3")})
+		assert.Equal(t, "1(2(3(5,1)))", flatten(roots))
+	})
+
+	t.Run("CycleNumericIDs", func(t *testing.T) {
+		t.Parallel()
+
+		// "10" < "9" as strings
+		roots := buildMySQLLockTree([]mysqlLockWait{wait("10", "9"), wait("9", "10")})
+		assert.Equal(t, "9(10(9))", flatten(roots))
+	})
+
+	t.Run("CycleWithTree", func(t *testing.T) {
+		t.Parallel()
+
+		roots := buildMySQLLockTree([]mysqlLockWait{wait("2", "1"), wait("4", "3"), wait("3", "4")})
+		assert.Equal(t, "1(2),3(4(3))", flatten(roots))
+	})
+}
+
+func TestMySQLLockWaits(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMySQLDSN(t)
+	db := tests.OpenTestMySQL(t)
+	defer db.Close() //nolint:errcheck
+
+	ctx := context.Background()
+	blocking, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer blocking.Rollback() //nolint:errcheck
+	_, err = blocking.Exec("UPDATE city SET Population = Population WHERE ID = 1")
+	require.NoError(t, err)
+
+	waiting, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer waiting.Rollback() //nolint:errcheck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = waiting.Exec("UPDATE city SET Population = Population WHERE ID = 1")
+	}()
+
+	a := NewMySQLLockWaitsAction(MySQLLockWaitsActionParams{
+		DSN: dsn,
+	})
+
+	var actual struct {
+		Roots []*mysqlLockNode
+		Waits []mysqlLockWait
+	}
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+		return len(actual.Waits) != 0
+	}, 3*time.Second, 50*time.Millisecond)
+
+	require.Len(t, actual.Roots, 1)
+	root := actual.Roots[0]
+	assert.Empty(t, root.Query) // idle in transaction
+	require.Len(t, root.Blocked, 1)
+	assert.Equal(t, "UPDATE city SET Population = Population WHERE ID = 1", root.Blocked[0].Query)
+	require.NotNil(t, root.Blocked[0].Wait)
+	assert.Equal(t, "`world`.`city`", root.Blocked[0].Wait.LockedTable)
+	assert.Equal(t, "PRIMARY", root.Blocked[0].Wait.LockedIndex)
+	assert.Equal(t, "RECORD", root.Blocked[0].Wait.LockType)
+
+	require.NoError(t, blocking.Rollback())
+	<-done
+}
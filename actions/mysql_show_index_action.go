@@ -17,6 +17,7 @@ package actions
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
 	"github.com/percona/pmm/api/agentpb"
@@ -57,13 +58,7 @@ func (a *mysqlShowIndexAction) Run(ctx context.Context) ([]byte, error) {
 	defer db.Close() //nolint:errcheck
 	defer tlshelpers.DeregisterMySQLCerts()
 
-	// use %#q to convert "table" to `"table"` and `table` to "`table`" to avoid SQL injections
-	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW /* pmm-agent */ INDEX IN %#q", a.params.Table))
-	if err != nil {
-		return nil, err
-	}
-
-	columns, dataRows, err := readRows(rows)
+	columns, dataRows, err := mysqlShowIndex(ctx, db, a.params.Table)
 	if err != nil {
 		return nil, err
 	}
@@ -71,3 +66,14 @@ func (a *mysqlShowIndexAction) Run(ctx context.Context) ([]byte, error) {
 }
 
 func (a *mysqlShowIndexAction) sealed() {}
+
+// mysqlShowIndex runs `SHOW INDEX` for given table and returns columns and data rows.
+func mysqlShowIndex(ctx context.Context, db *sql.DB, table string) ([]string, [][]interface{}, error) {
+	// use %#q to convert "table" to `"table"` and `table` to "`table`" to avoid SQL injections
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW /* pmm-agent */ INDEX IN %#q", table))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return readRows(rows)
+}
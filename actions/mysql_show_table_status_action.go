@@ -17,6 +17,7 @@ package actions
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/percona/pmm/api/agentpb"
 	"github.com/pkg/errors"
@@ -57,19 +58,28 @@ func (a *mysqlShowTableStatusAction) Run(ctx context.Context) ([]byte, error) {
 	defer db.Close() //nolint:errcheck
 	defer tlshelpers.DeregisterMySQLCerts()
 
-	rows, err := db.QueryContext(ctx, "SHOW /* pmm-agent */ TABLE STATUS WHERE Name = ?", a.params.Table)
+	columns, dataRows, err := mysqlShowTableStatus(ctx, db, a.params.Table)
 	if err != nil {
 		return nil, err
 	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *mysqlShowTableStatusAction) sealed() {}
+
+// mysqlShowTableStatus runs `SHOW TABLE STATUS` for given table and returns columns and data rows.
+func mysqlShowTableStatus(ctx context.Context, db *sql.DB, table string) ([]string, [][]interface{}, error) {
+	rows, err := db.QueryContext(ctx, "SHOW /* pmm-agent */ TABLE STATUS WHERE Name = ?", table)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	columns, dataRows, err := readRows(rows)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(dataRows) == 0 {
-		return nil, errors.Errorf("table %q not found", a.params.Table)
+		return nil, nil, errors.Errorf("table %q not found", table)
 	}
-	return jsonRows(columns, dataRows)
+	return columns, dataRows, nil
 }
-
-func (a *mysqlShowTableStatusAction) sealed() {}
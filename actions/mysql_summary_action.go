@@ -0,0 +1,515 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/tlshelpers"
+)
+
+// defaultMySQLSummarySampleInterval is a default interval between global status samples, the same as in pt-mysql-summary.
+const defaultMySQLSummarySampleInterval = 10 * time.Second
+
+// MySQLSummaryActionParams represent MySQL summary Action params.
+type MySQLSummaryActionParams struct {
+	ID       string
+	DSN      string
+	TLSFiles *agentpb.TextFiles
+	// SampleInterval is an interval between global status samples used for counter deltas.
+	// It is reduced to fit into the Action timeout.
+	SampleInterval time.Duration
+}
+
+type mysqlSummaryAction struct {
+	params MySQLSummaryActionParams
+}
+
+// NewMySQLSummaryAction creates MySQL summary Action.
+// This is an Action that collects the same information as pt-mysql-summary
+// and returns it as JSON document with summary data and text report.
+// The runner uses RunStream that sends only the text report, as the replaced tool did.
+func NewMySQLSummaryAction(params MySQLSummaryActionParams) Action {
+	if params.SampleInterval <= 0 {
+		params.SampleInterval = defaultMySQLSummarySampleInterval
+	}
+	return &mysqlSummaryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *mysqlSummaryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *mysqlSummaryAction) Type() string {
+	return "mysql-summary"
+}
+
+// Run runs an Action and returns output and error.
+// Output is a JSON document with summary data and text report.
+func (a *mysqlSummaryAction) Run(ctx context.Context) ([]byte, error) {
+	summary, report, err := a.summary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSummary(summary, report)
+}
+
+// RunStream runs an Action and passes output chunks to send.
+// Output is a text report only, the same as pt-mysql-summary output that PMM Server expects.
+func (a *mysqlSummaryAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	_, report, err := a.summary(ctx)
+	if err != nil {
+		return err
+	}
+	return sendSummaryReport(report, send)
+}
+
+// summary collects summary data and text report.
+func (a *mysqlSummaryAction) summary(ctx context.Context) (*mysqlSummary, string, error) {
+	db, err := mysqlOpen(a.params.DSN, a.params.TLSFiles)
+	if err != nil {
+		return nil, "", err
+	}
+	defer db.Close() //nolint:errcheck
+	defer tlshelpers.DeregisterMySQLCerts()
+
+	interval := a.params.SampleInterval
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline) / 3; d < interval {
+			interval = d
+		}
+	}
+
+	summary, err := getMySQLSummary(ctx, db, interval)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var report strings.Builder
+	if err = writeMySQLSummaryReport(&report, summary); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return summary, report.String(), nil
+}
+
+func (a *mysqlSummaryAction) sealed() {}
+
+// check interfaces
+var (
+	_ Action          = (*mysqlSummaryAction)(nil)
+	_ StreamingAction = (*mysqlSummaryAction)(nil)
+)
+
+// mysqlSummary represents MySQL instance summary.
+// Optional sections are empty if they are not applicable to the instance or can't be collected;
+// the reason of the latter is added to notes.
+type mysqlSummary struct {
+	Variables map[string]string `json:"variables"`
+	Status    map[string]string `json:"status"`
+	// StatusDeltas contains changes of numeric global status values during the sampling interval.
+	StatusDeltas  map[string]float64      `json:"status_deltas"`
+	SampleSeconds float64                 `json:"sample_seconds"`
+	Replication   *mysqlReplicationStatus `json:"replication,omitempty"`
+	InnoDB        mysqlInnoDBSummary      `json:"innodb"`
+	Plugins       []mysqlPlugin           `json:"plugins,omitempty"`
+	Users         []string                `json:"users,omitempty"`
+	Schemas       []mysqlSchemaSummary    `json:"schemas,omitempty"`
+	Notes         []string                `json:"notes,omitempty"`
+}
+
+// mysqlReplicationStatus represents binary log, GTID, and replica status.
+type mysqlReplicationStatus struct {
+	BinaryLogFile     string                   `json:"binary_log_file,omitempty"`
+	BinaryLogPosition int64                    `json:"binary_log_position,omitempty"`
+	GTIDMode          string                   `json:"gtid_mode,omitempty"`
+	GTIDExecuted      string                   `json:"gtid_executed,omitempty"`
+	Replicas          []map[string]interface{} `json:"replicas,omitempty"` // SHOW REPLICA STATUS rows, one per channel
+}
+
+// mysqlInnoDBSummary represents InnoDB metrics.
+type mysqlInnoDBSummary struct {
+	BufferPoolSize       int64   `json:"buffer_pool_size"`
+	BufferPoolPages      int64   `json:"buffer_pool_pages"`
+	BufferPoolFreePages  int64   `json:"buffer_pool_free_pages"`
+	BufferPoolDirtyPages int64   `json:"buffer_pool_dirty_pages"`
+	BufferPoolHitRatio   float64 `json:"buffer_pool_hit_ratio"`
+	LogFileSize          int64   `json:"log_file_size"`
+	FlushLogAtTrxCommit  string  `json:"flush_log_at_trx_commit"`
+	RowLockWaits         int64   `json:"row_lock_waits"`
+	HistoryListLength    int64   `json:"history_list_length"`
+}
+
+// mysqlPlugin represents information_schema.PLUGINS row.
+type mysqlPlugin struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Type    string `json:"type"`
+	Library string `json:"library"`
+}
+
+// mysqlSchemaSummary represents table sizes of a single schema.
+type mysqlSchemaSummary struct {
+	Name       string `json:"name"`
+	Tables     int64  `json:"tables"`
+	DataBytes  int64  `json:"data_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+}
+
+// mysqlNameValues runs a query returning name and value columns (like SHOW GLOBAL VARIABLES) and returns them as a map.
+func mysqlNameValues(ctx context.Context, db *sql.DB, query string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, dataRows, err := readRows(rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := make(map[string]string, len(dataRows))
+	for _, row := range dataRows {
+		if len(row) >= 2 {
+			res[metadataString(row[0])] = metadataString(row[1])
+		}
+	}
+	return res, nil
+}
+
+// mysqlQueryRows runs a query and returns columns and data rows.
+func mysqlQueryRows(ctx context.Context, db *sql.DB, query string) ([]string, [][]interface{}, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return readRows(rows)
+}
+
+// getMySQLSummary collects MySQL instance summary; global status is sampled twice with given interval.
+// Only global variables and status failures are fatal.
+func getMySQLSummary(ctx context.Context, db *sql.DB, interval time.Duration) (*mysqlSummary, error) {
+	summary := new(mysqlSummary)
+	note := func(section string, err error) {
+		summary.Notes = append(summary.Notes, fmt.Sprintf("%s: %s", section, err))
+	}
+
+	var err error
+	if summary.Variables, err = mysqlNameValues(ctx, db, "SHOW /* pmm-agent */ GLOBAL VARIABLES"); err != nil {
+		return nil, err
+	}
+
+	first, err := mysqlNameValues(ctx, db, "SHOW /* pmm-agent */ GLOBAL STATUS")
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	case <-time.After(interval):
+	}
+	if summary.Status, err = mysqlNameValues(ctx, db, "SHOW /* pmm-agent */ GLOBAL STATUS"); err != nil {
+		return nil, err
+	}
+	summary.SampleSeconds = time.Since(start).Seconds()
+	summary.StatusDeltas = mysqlStatusDeltas(first, summary.Status)
+
+	summary.Replication, err = getMySQLReplicationStatus(ctx, db, summary.Variables)
+	if err != nil {
+		note("replication", err)
+	}
+
+	summary.InnoDB = mysqlInnoDB(summary.Variables, summary.Status)
+	var historyLength sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT /* pmm-agent */ `COUNT` FROM information_schema.INNODB_METRICS "+
+		"WHERE `NAME` = 'trx_rseg_history_len'").Scan(&historyLength)
+	switch {
+	case err == nil:
+		summary.InnoDB.HistoryListLength = historyLength.Int64
+	case err != sql.ErrNoRows:
+		note("innodb metrics", err)
+	}
+
+	_, dataRows, err := mysqlQueryRows(ctx, db, "SELECT /* pmm-agent */ PLUGIN_NAME, PLUGIN_STATUS, PLUGIN_TYPE, PLUGIN_LIBRARY "+
+		"FROM information_schema.PLUGINS ORDER BY PLUGIN_NAME")
+	if err == nil {
+		for _, row := range dataRows {
+			summary.Plugins = append(summary.Plugins, mysqlPlugin{
+				Name:    metadataString(row[0]),
+				Status:  metadataString(row[1]),
+				Type:    metadataString(row[2]),
+				Library: metadataString(row[3]),
+			})
+		}
+	} else {
+		note("plugins", err)
+	}
+
+	_, dataRows, err = mysqlQueryRows(ctx, db, "SELECT /* pmm-agent */ CONCAT(QUOTE(User), '@', QUOTE(Host)) FROM mysql.user ORDER BY User, Host")
+	if err == nil {
+		for _, row := range dataRows {
+			summary.Users = append(summary.Users, metadataString(row[0]))
+		}
+	} else {
+		note("users", err)
+	}
+
+	_, dataRows, err = mysqlQueryRows(ctx, db, "SELECT /* pmm-agent */ TABLE_SCHEMA, COUNT(*), "+
+		"COALESCE(SUM(DATA_LENGTH), 0), COALESCE(SUM(INDEX_LENGTH), 0) FROM information_schema.TABLES "+
+		"WHERE TABLE_TYPE = 'BASE TABLE' GROUP BY TABLE_SCHEMA ORDER BY TABLE_SCHEMA")
+	if err == nil {
+		for _, row := range dataRows {
+			summary.Schemas = append(summary.Schemas, mysqlSchemaSummary{
+				Name:       metadataString(row[0]),
+				Tables:     int64(metadataNumber(row[1])),
+				DataBytes:  int64(metadataNumber(row[2])),
+				IndexBytes: int64(metadataNumber(row[3])),
+			})
+		}
+	} else {
+		note("schemas", err)
+	}
+
+	return summary, nil
+}
+
+// mysqlStatusDeltas returns non-zero changes of numeric global status values.
+func mysqlStatusDeltas(first, second map[string]string) map[string]float64 {
+	res := make(map[string]float64)
+	for name, v := range second {
+		prev, ok := first[name]
+		if !ok {
+			continue
+		}
+		if d := metadataNumber(v) - metadataNumber(prev); d != 0 {
+			res[name] = d
+		}
+	}
+	return res
+}
+
+// getMySQLReplicationStatus returns binary log and replicas status, or nil if the server is neither a source nor a replica.
+func getMySQLReplicationStatus(ctx context.Context, db *sql.DB, variables map[string]string) (*mysqlReplicationStatus, error) {
+	res := &mysqlReplicationStatus{
+		GTIDMode:     variables["gtid_mode"],
+		GTIDExecuted: strings.ReplaceAll(variables["gtid_executed"], "\n", ""),
+	}
+
+	// try new syntax first, then deprecated one for older versions
+	queryFirst := func(queries ...string) ([]string, [][]interface{}, error) {
+		var err error
+		for _, q := range queries {
+			var columns []string
+			var dataRows [][]interface{}
+			if columns, dataRows, err = mysqlQueryRows(ctx, db, q); err == nil {
+				return columns, dataRows, nil
+			}
+		}
+		return nil, nil, err
+	}
+
+	columns, dataRows, err := queryFirst("SHOW /* pmm-agent */ BINARY LOG STATUS", "SHOW /* pmm-agent */ MASTER STATUS")
+	if err != nil {
+		return nil, err
+	}
+	if len(dataRows) != 0 {
+		res.BinaryLogFile = metadataString(metadataValue(columns, dataRows[0], "File"))
+		res.BinaryLogPosition = int64(metadataNumber(metadataValue(columns, dataRows[0], "Position")))
+	}
+
+	if columns, dataRows, err = queryFirst("SHOW /* pmm-agent */ REPLICA STATUS", "SHOW /* pmm-agent */ SLAVE STATUS"); err != nil {
+		return nil, err
+	}
+	for _, row := range dataRows {
+		replica := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			replica[c] = row[i]
+		}
+		res.Replicas = append(res.Replicas, replica)
+	}
+
+	if res.BinaryLogFile == "" && len(res.Replicas) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// mysqlInnoDB returns InnoDB summary from global variables and status.
+func mysqlInnoDB(variables, status map[string]string) mysqlInnoDBSummary {
+	res := mysqlInnoDBSummary{
+		BufferPoolSize:       int64(metadataNumber(variables["innodb_buffer_pool_size"])),
+		BufferPoolPages:      int64(metadataNumber(status["Innodb_buffer_pool_pages_total"])),
+		BufferPoolFreePages:  int64(metadataNumber(status["Innodb_buffer_pool_pages_free"])),
+		BufferPoolDirtyPages: int64(metadataNumber(status["Innodb_buffer_pool_pages_dirty"])),
+		LogFileSize:          int64(metadataNumber(variables["innodb_log_file_size"])),
+		FlushLogAtTrxCommit:  variables["innodb_flush_log_at_trx_commit"],
+		RowLockWaits:         int64(metadataNumber(status["Innodb_row_lock_waits"])),
+	}
+
+	// MySQL 8.0.30+ uses innodb_redo_log_capacity instead of innodb_log_file_size and innodb_log_files_in_group
+	if capacity := variables["innodb_redo_log_capacity"]; capacity != "" {
+		res.LogFileSize = int64(metadataNumber(capacity))
+	}
+
+	// reads from disk to logical reads
+	if requests := metadataNumber(status["Innodb_buffer_pool_read_requests"]); requests > 0 {
+		res.BufferPoolHitRatio = 1 - metadataNumber(status["Innodb_buffer_pool_reads"])/requests
+	}
+	return res
+}
+
+// writeMySQLSummaryReport writes text report in pt-mysql-summary format.
+func writeMySQLSummaryReport(w io.Writer, s *mysqlSummary) error {
+	section := func(title string) { writeReportSection(w, title) }
+	value := func(name string, format string, args ...interface{}) { writeReportValue(w, name, format, args...) }
+	table := func(header string, rows func(tw io.Writer)) error {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, header)
+		rows(tw)
+		return tw.Flush()
+	}
+	variable := func(name string) string { return s.Variables[name] }
+
+	section("Report On Port " + variable("port"))
+	value("Hostname", "%s", variable("hostname"))
+	value("Version", "%s %s", variable("version"), variable("version_comment"))
+	value("Built On", "%s %s", variable("version_compile_os"), variable("version_compile_machine"))
+	value("Uptime", "%s", humanizeSeconds(int64(metadataNumber(s.Status["Uptime"]))))
+	value("Server ID", "%s", variable("server_id"))
+	value("Datadir", "%s", variable("datadir"))
+	value("Connections", "%s of %s max", s.Status["Threads_connected"], variable("max_connections"))
+	switch {
+	case s.Replication == nil:
+		value("Replication", "Is not a replica, binary log is not written")
+	case len(s.Replication.Replicas) == 0:
+		value("Replication", "Is not a replica, binary log %s:%d", s.Replication.BinaryLogFile, s.Replication.BinaryLogPosition)
+	default:
+		value("Replication", "Is a replica, %d channel(s)", len(s.Replication.Replicas))
+	}
+
+	section(fmt.Sprintf("Status Counters (Wait %.0f Seconds)", s.SampleSeconds))
+	names := make([]string, 0, len(s.StatusDeltas))
+	for name := range s.StatusDeltas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err := table("  Variable\tTotal\tPer Second\tPer Interval\t", func(tw io.Writer) {
+		for _, name := range names {
+			d := s.StatusDeltas[name]
+			perSecond := 0.0
+			if s.SampleSeconds > 0 {
+				perSecond = d / s.SampleSeconds
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%.2f\t%g\t\n", name, s.Status[name], perSecond, d)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if r := s.Replication; r != nil {
+		section("Replication")
+		value("Binary Log", "%s:%d", r.BinaryLogFile, r.BinaryLogPosition)
+		value("GTID Mode", "%s", r.GTIDMode)
+		value("GTID Executed", "%s", r.GTIDExecuted)
+		for _, replica := range r.Replicas {
+			source := metadataString(replica["Source_Host"])
+			if source == "" {
+				source = metadataString(replica["Master_Host"])
+			}
+			ioRunning, sqlRunning := replica["Replica_IO_Running"], replica["Replica_SQL_Running"]
+			if ioRunning == nil {
+				ioRunning, sqlRunning = replica["Slave_IO_Running"], replica["Slave_SQL_Running"]
+			}
+			lag := replica["Seconds_Behind_Source"]
+			if lag == nil {
+				lag = replica["Seconds_Behind_Master"]
+			}
+			value("Source "+metadataString(replica["Channel_Name"]), "%s, IO %s, SQL %s, %s seconds behind",
+				source, metadataString(ioRunning), metadataString(sqlRunning), metadataString(lag))
+		}
+	}
+
+	section("InnoDB")
+	value("Buffer Pool Size", "%s", humanizeBytes(s.InnoDB.BufferPoolSize))
+	value("Buffer Pool Pages", "%d total, %d free, %d dirty", s.InnoDB.BufferPoolPages, s.InnoDB.BufferPoolFreePages, s.InnoDB.BufferPoolDirtyPages)
+	value("Buffer Pool Hit Ratio", "%.2f%%", s.InnoDB.BufferPoolHitRatio*100)
+	value("Log File Size", "%s", humanizeBytes(s.InnoDB.LogFileSize))
+	value("Flush Log At Commit", "%s", s.InnoDB.FlushLogAtTrxCommit)
+	value("Row Lock Waits", "%d", s.InnoDB.RowLockWaits)
+	value("History List Length", "%d", s.InnoDB.HistoryListLength)
+
+	if len(s.Plugins) != 0 {
+		section("Plugins")
+		for _, p := range s.Plugins {
+			value(p.Name, "%s (%s)", p.Status, p.Type)
+		}
+	}
+
+	if len(s.Users) != 0 {
+		section("Users")
+		value("Users", "%d", len(s.Users))
+		for _, u := range s.Users {
+			fmt.Fprintf(w, "  %s\n", u)
+		}
+	}
+
+	if len(s.Schemas) != 0 {
+		section("Schema")
+		err = table("  Database\tTables\tData\tIndex\t", func(tw io.Writer) {
+			for _, schema := range s.Schemas {
+				fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t\n", schema.Name, schema.Tables, humanizeBytes(schema.DataBytes), humanizeBytes(schema.IndexBytes))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	section("Noteworthy Variables")
+	for _, name := range []string{
+		"binlog_format", "character_set_server", "collation_server", "innodb_file_per_table", "log_bin",
+		"max_allowed_packet", "read_only", "slow_query_log", "long_query_time", "sql_mode", "sync_binlog",
+		"table_open_cache", "thread_cache_size", "tmp_table_size", "transaction_isolation",
+	} {
+		if v, ok := s.Variables[name]; ok {
+			value(name, "%s", v)
+		}
+	}
+
+	if len(s.Notes) != 0 {
+		section("Notes")
+		for _, n := range s.Notes {
+			fmt.Fprintf(w, "  %s\n", n)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestMySQLSummary(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestMySQLDSN(t)
+
+	a := NewMySQLSummaryAction(MySQLSummaryActionParams{
+		DSN:            dsn,
+		SampleInterval: time.Second,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual struct {
+		Summary mysqlSummary
+		Report  string
+	}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	t.Logf("Report:\n%s", actual.Report)
+
+	assert.NotEmpty(t, actual.Summary.Variables["version"])
+	assert.NotEmpty(t, actual.Summary.Status["Uptime"])
+	assert.InDelta(t, 1, actual.Summary.SampleSeconds, 0.5)
+	assert.NotZero(t, actual.Summary.InnoDB.BufferPoolSize)
+	assert.Empty(t, actual.Summary.Notes)
+
+	var schemas []string
+	for _, s := range actual.Summary.Schemas {
+		schemas = append(schemas, s.Name)
+	}
+	assert.Contains(t, schemas, "world")
+	assert.Contains(t, actual.Report, "# Report On Port 3306 ")
+}
+
+func TestMySQLStatusDeltas(t *testing.T) {
+	t.Parallel()
+
+	first := map[string]string{"Questions": "100", "Uptime": "10", "Ssl_cipher": "", "Threads_connected": "5"}
+	second := map[string]string{"Questions": "150", "Uptime": "20", "Ssl_cipher": "", "Threads_connected": "5", "New_counter": "1"}
+	assert.Equal(t, map[string]float64{"Questions": 50, "Uptime": 10}, mysqlStatusDeltas(first, second))
+}
+
+func TestMySQLInnoDB(t *testing.T) {
+	t.Parallel()
+
+	variables := map[string]string{
+		"innodb_buffer_pool_size":        "134217728",
+		"innodb_log_file_size":           "50331648",
+		"innodb_flush_log_at_trx_commit": "1",
+	}
+	status := map[string]string{
+		"Innodb_buffer_pool_pages_total":   "8192",
+		"Innodb_buffer_pool_pages_free":    "4096",
+		"Innodb_buffer_pool_pages_dirty":   "10",
+		"Innodb_buffer_pool_read_requests": "1000",
+		"Innodb_buffer_pool_reads":         "10",
+		"Innodb_row_lock_waits":            "3",
+	}
+	expected := mysqlInnoDBSummary{
+		BufferPoolSize:       134217728,
+		BufferPoolPages:      8192,
+		BufferPoolFreePages:  4096,
+		BufferPoolDirtyPages: 10,
+		BufferPoolHitRatio:   0.99,
+		LogFileSize:          50331648,
+		FlushLogAtTrxCommit:  "1",
+		RowLockWaits:         3,
+	}
+	assert.Equal(t, expected, mysqlInnoDB(variables, status))
+
+	variables["innodb_redo_log_capacity"] = "104857600"
+	expected.LogFileSize = 104857600
+	assert.Equal(t, expected, mysqlInnoDB(variables, status))
+}
+
+func TestWriteMySQLSummaryReport(t *testing.T) {
+	t.Parallel()
+
+	s := &mysqlSummary{
+		Variables: map[string]string{
+			"port":            "3306",
+			"hostname":        "mysql1",
+			"version":         "8.0.28",
+			"version_comment": "MySQL Community Server - GPL",
+			"max_connections": "151",
+			"binlog_format":   "ROW",
+		},
+		Status:        map[string]string{"Uptime": "90061", "Threads_connected": "2", "Questions": "150"},
+		StatusDeltas:  map[string]float64{"Questions": 50},
+		SampleSeconds: 10,
+		Replication: &mysqlReplicationStatus{
+			BinaryLogFile:     "binlog.000002",
+			BinaryLogPosition: 157,
+			GTIDMode:          "ON",
+			Replicas: []map[string]interface{}{{
+				"Channel_Name":          "",
+				"Source_Host":           "mysql0",
+				"Replica_IO_Running":    "Yes",
+				"Replica_SQL_Running":   "Yes",
+				"Seconds_Behind_Source": int64(0),
+			}},
+		},
+		InnoDB: mysqlInnoDBSummary{BufferPoolSize: 128 << 20, BufferPoolHitRatio: 0.99},
+		Plugins: []mysqlPlugin{
+			{Name: "InnoDB", Status: "ACTIVE", Type: "STORAGE ENGINE"},
+		},
+		Users:   []string{"'root'@'localhost'"},
+		Schemas: []mysqlSchemaSummary{{Name: "world", Tables: 3, DataBytes: 1 << 20, IndexBytes: 1 << 10}},
+		Notes:   []string{"users: Error 1142: SELECT command denied"},
+	}
+
+	var report strings.Builder
+	err := writeMySQLSummaryReport(&report, s)
+	require.NoError(t, err)
+	actual := report.String()
+	t.Logf("Report:\n%s", actual)
+
+	for _, expected := range []string{
+		"# Report On Port 3306 #",
+		"                  Version | 8.0.28 MySQL Community Server - GPL\n",
+		"                   Uptime | 1 days, 1 hours, 1 minutes, 1 seconds\n",
+		"              Connections | 2 of 151 max\n",
+		"              Replication | Is a replica, 1 channel(s)\n",
+		"# Status Counters (Wait 10 Seconds) #",
+		"  Questions  150    5.00        50            \n",
+		"                  Source  | mysql0, IO Yes, SQL Yes, 0 seconds behind\n",
+		"         Buffer Pool Size | 128.00 MiB\n",
+		"    Buffer Pool Hit Ratio | 99.00%\n",
+		"                   InnoDB | ACTIVE (STORAGE ENGINE)\n",
+		"  'root'@'localhost'\n",
+		"  world     3       1.00 MiB  1.00 KiB  \n",
+		"            binlog_format | ROW\n",
+		"  users: Error 1142: SELECT command denied\n",
+	} {
+		assert.Contains(t, actual, expected)
+	}
+}
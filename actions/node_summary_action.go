@@ -0,0 +1,722 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// nodeSummarySysctls are kernel parameters relevant to databases.
+var nodeSummarySysctls = []string{
+	"fs.aio-max-nr",
+	"fs.file-max",
+	"kernel.numa_balancing",
+	"net.core.somaxconn",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.tcp_keepalive_time",
+	"net.ipv4.tcp_max_syn_backlog",
+	"vm.dirty_background_bytes",
+	"vm.dirty_background_ratio",
+	"vm.dirty_bytes",
+	"vm.dirty_ratio",
+	"vm.max_map_count",
+	"vm.nr_hugepages",
+	"vm.overcommit_memory",
+	"vm.overcommit_ratio",
+	"vm.swappiness",
+	"vm.zone_reclaim_mode",
+}
+
+// nodeSummaryFilesystems are filesystem types of /proc/mounts entries included into the summary.
+var nodeSummaryFilesystems = map[string]bool{
+	"btrfs":   true,
+	"ext2":    true,
+	"ext3":    true,
+	"ext4":    true,
+	"nfs":     true,
+	"nfs4":    true,
+	"overlay": true,
+	"tmpfs":   true,
+	"xfs":     true,
+	"zfs":     true,
+}
+
+// NodeSummaryActionParams represent node summary Action params.
+type NodeSummaryActionParams struct {
+	ID string
+	// Root is a directory containing proc, sys, and etc directories; "/" by default.
+	Root string
+}
+
+type nodeSummaryAction struct {
+	params NodeSummaryActionParams
+}
+
+// NewNodeSummaryAction creates node summary Action.
+// This is an Action that collects the same information as pt-summary by reading /proc, /sys, and cgroup files
+// and returns it as JSON document with summary data and text report.
+// The runner uses RunStream that sends only the text report, as the replaced tool did.
+func NewNodeSummaryAction(params NodeSummaryActionParams) Action {
+	if params.Root == "" {
+		params.Root = "/"
+	}
+	return &nodeSummaryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *nodeSummaryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *nodeSummaryAction) Type() string {
+	return "node-summary"
+}
+
+// Run runs an Action and returns output and error.
+// Output is a JSON document with summary data and text report.
+func (a *nodeSummaryAction) Run(ctx context.Context) ([]byte, error) {
+	summary, report, err := a.summary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSummary(summary, report)
+}
+
+// RunStream runs an Action and passes output chunks to send.
+// Output is a text report only, the same as pt-summary output that PMM Server expects.
+func (a *nodeSummaryAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	_, report, err := a.summary(ctx)
+	if err != nil {
+		return err
+	}
+	return sendSummaryReport(report, send)
+}
+
+// summary collects summary data and text report.
+func (a *nodeSummaryAction) summary(ctx context.Context) (*nodeSummary, string, error) {
+	summary, err := getNodeSummary(a.params.Root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var report strings.Builder
+	if err = writeNodeSummaryReport(&report, summary); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return summary, report.String(), nil
+}
+
+func (a *nodeSummaryAction) sealed() {}
+
+// check interfaces
+var (
+	_ Action          = (*nodeSummaryAction)(nil)
+	_ StreamingAction = (*nodeSummaryAction)(nil)
+)
+
+// nodeSummary represents node summary.
+// Sections are empty if files can't be read; the reason is added to notes.
+type nodeSummary struct {
+	Hostname      string                 `json:"hostname"`
+	OS            string                 `json:"os"`
+	Kernel        string                 `json:"kernel"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	LoadAverage   string                 `json:"load_average"`
+	CPU           nodeCPUSummary         `json:"cpu"`
+	Memory        map[string]int64       `json:"memory"` // /proc/meminfo values in bytes (or counts for HugePages_*)
+	NUMA          []nodeNUMANode         `json:"numa,omitempty"`
+	Filesystems   []nodeFilesystem       `json:"filesystems,omitempty"`
+	BlockDevices  []nodeBlockDevice      `json:"block_devices,omitempty"`
+	THP           map[string]string      `json:"transparent_hugepage,omitempty"`
+	Sysctl        map[string]string      `json:"sysctl"`
+	Network       []nodeNetworkInterface `json:"network,omitempty"`
+	Cgroup        *nodeCgroupLimits      `json:"cgroup,omitempty"`
+	Notes         []string               `json:"notes,omitempty"`
+}
+
+// nodeCPUSummary represents CPU topology.
+type nodeCPUSummary struct {
+	Model       string  `json:"model"`
+	Sockets     int     `json:"sockets"`
+	Cores       int     `json:"cores"`
+	Threads     int     `json:"threads"`
+	MHz         float64 `json:"mhz"`
+	Virtualized bool    `json:"virtualized"`
+}
+
+// nodeNUMANode represents NUMA node.
+type nodeNUMANode struct {
+	Node        int    `json:"node"`
+	CPUs        string `json:"cpus"`
+	MemoryBytes int64  `json:"memory_bytes"`
+}
+
+// nodeFilesystem represents mounted filesystem.
+type nodeFilesystem struct {
+	Device     string `json:"device"`
+	MountPoint string `json:"mount_point"`
+	Type       string `json:"type"`
+	Options    string `json:"options"`
+	SizeBytes  *int64 `json:"size_bytes,omitempty"`
+	FreeBytes  *int64 `json:"free_bytes,omitempty"`
+}
+
+// nodeBlockDevice represents block device queue settings.
+type nodeBlockDevice struct {
+	Name       string `json:"name"`
+	SizeBytes  int64  `json:"size_bytes"`
+	Rotational bool   `json:"rotational"`
+	Scheduler  string `json:"scheduler"`
+	QueueSize  int64  `json:"queue_size"`
+}
+
+// nodeNetworkInterface represents network interface.
+type nodeNetworkInterface struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	MAC       string `json:"mac"`
+	MTU       int64  `json:"mtu"`
+	SpeedMbps int64  `json:"speed_mbps"` // 0 if unknown
+}
+
+// nodeCgroupLimits represents cgroup limits of the pmm-agent process; zero values mean no limit.
+type nodeCgroupLimits struct {
+	Version     int     `json:"version"`
+	Path        string  `json:"path"`
+	CPUs        float64 `json:"cpus"`
+	MemoryBytes int64   `json:"memory_bytes"`
+	Pids        int64   `json:"pids"`
+}
+
+// nodeFS reads files relative to the root directory.
+type nodeFS string
+
+func (root nodeFS) path(name string) string {
+	return filepath.Join(string(root), name)
+}
+
+// read returns trimmed file content.
+func (root nodeFS) read(name string) (string, error) {
+	b, err := os.ReadFile(root.path(name))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readInt returns file content as integer.
+func (root nodeFS) readInt(name string) (int64, error) {
+	s, err := root.read(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, errors.WithStack(err)
+}
+
+// glob returns sorted base names of files matching pattern.
+func (root nodeFS) glob(pattern string) []string {
+	matches, _ := filepath.Glob(root.path(pattern))
+	res := make([]string, len(matches))
+	for i, m := range matches {
+		res[i] = filepath.Base(m)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getNodeSummary collects node summary from files under root directory.
+func getNodeSummary(root string) (*nodeSummary, error) {
+	if _, err := os.Stat(filepath.Join(root, "proc")); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fs := nodeFS(root)
+	summary := &nodeSummary{
+		Sysctl: make(map[string]string),
+	}
+	note := func(section string, err error) {
+		summary.Notes = append(summary.Notes, fmt.Sprintf("%s: %s", section, err))
+	}
+
+	var err error
+	if summary.Hostname, err = fs.read("proc/sys/kernel/hostname"); err != nil {
+		note("hostname", err)
+	}
+	if summary.Kernel, err = fs.read("proc/sys/kernel/osrelease"); err != nil {
+		note("kernel", err)
+	}
+	if summary.OS, err = nodeOSRelease(fs); err != nil {
+		note("os", err)
+	}
+	if uptime, err := fs.read("proc/uptime"); err == nil {
+		if fields := strings.Fields(uptime); len(fields) != 0 {
+			f, _ := strconv.ParseFloat(fields[0], 64)
+			summary.UptimeSeconds = int64(f)
+		}
+	} else {
+		note("uptime", err)
+	}
+	if loadavg, err := fs.read("proc/loadavg"); err == nil {
+		if fields := strings.Fields(loadavg); len(fields) >= 3 {
+			summary.LoadAverage = strings.Join(fields[:3], " ")
+		}
+	} else {
+		note("load average", err)
+	}
+
+	if summary.CPU, err = nodeCPU(fs); err != nil {
+		note("cpu", err)
+	}
+	if summary.Memory, err = nodeMemory(fs); err != nil {
+		note("memory", err)
+	}
+	summary.NUMA = nodeNUMA(fs)
+	if summary.Filesystems, err = nodeFilesystems(fs); err != nil {
+		note("filesystems", err)
+	}
+	summary.BlockDevices = nodeBlockDevices(fs)
+
+	for _, name := range []string{"enabled", "defrag"} {
+		if v, err := fs.read("sys/kernel/mm/transparent_hugepage/" + name); err == nil {
+			if summary.THP == nil {
+				summary.THP = make(map[string]string)
+			}
+			summary.THP[name] = nodeSelected(v)
+		}
+	}
+
+	for _, name := range nodeSummarySysctls {
+		if v, err := fs.read("proc/sys/" + strings.ReplaceAll(name, ".", "/")); err == nil {
+			summary.Sysctl[name] = strings.Join(strings.Fields(v), " ")
+		}
+	}
+
+	summary.Network = nodeNetwork(fs)
+	if summary.Cgroup, err = nodeCgroup(fs); err != nil {
+		note("cgroup", err)
+	}
+
+	return summary, nil
+}
+
+// nodeOSRelease returns PRETTY_NAME from os-release file.
+func nodeOSRelease(fs nodeFS) (string, error) {
+	s, err := fs.read("etc/os-release")
+	if err != nil {
+		if s, err = fs.read("usr/lib/os-release"); err != nil {
+			return "", err
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if v := strings.TrimPrefix(line, "PRETTY_NAME="); v != line {
+			return strings.Trim(v, `"'`), nil
+		}
+	}
+	return "", nil
+}
+
+// nodeCPU returns CPU topology from /proc/cpuinfo.
+func nodeCPU(fs nodeFS) (nodeCPUSummary, error) {
+	var res nodeCPUSummary
+	s, err := fs.read("proc/cpuinfo")
+	if err != nil {
+		return res, err
+	}
+
+	sockets := make(map[string]struct{})
+	cores := make(map[string]struct{})
+	for _, block := range strings.Split(s, "\n\n") {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(block, "\n") {
+			if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+				fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+		if _, ok := fields["processor"]; !ok {
+			continue
+		}
+
+		res.Threads++
+		if res.Model == "" {
+			res.Model = fields["model name"]
+		}
+		if res.MHz == 0 {
+			res.MHz, _ = strconv.ParseFloat(fields["cpu MHz"], 64)
+		}
+		for _, flag := range strings.Fields(fields["flags"]) {
+			if flag == "hypervisor" {
+				res.Virtualized = true
+			}
+		}
+		if id, ok := fields["physical id"]; ok {
+			sockets[id] = struct{}{}
+			cores[id+"/"+fields["core id"]] = struct{}{}
+		}
+	}
+
+	// some architectures do not report topology in cpuinfo
+	res.Sockets, res.Cores = len(sockets), len(cores)
+	if res.Sockets == 0 {
+		res.Sockets, res.Cores = 1, res.Threads
+	}
+	return res, nil
+}
+
+// nodeMemory returns /proc/meminfo values.
+func nodeMemory(fs nodeFS) (map[string]int64, error) {
+	f, err := os.Open(fs.path("proc/meminfo"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	res := make(map[string]int64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			v <<= 10
+		}
+		res[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return res, errors.WithStack(s.Err())
+}
+
+// nodeNUMA returns NUMA nodes from sysfs.
+func nodeNUMA(fs nodeFS) []nodeNUMANode {
+	var res []nodeNUMANode
+	for _, name := range fs.glob("sys/devices/system/node/node*") {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "node"))
+		if err != nil {
+			continue
+		}
+		node := nodeNUMANode{Node: n}
+		node.CPUs, _ = fs.read("sys/devices/system/node/" + name + "/cpulist")
+
+		// "Node 0 MemTotal:       16318412 kB"
+		meminfo, _ := fs.read("sys/devices/system/node/" + name + "/meminfo")
+		for _, line := range strings.Split(meminfo, "\n") {
+			if fields := strings.Fields(line); len(fields) >= 4 && fields[2] == "MemTotal:" {
+				kb, _ := strconv.ParseInt(fields[3], 10, 64)
+				node.MemoryBytes = kb << 10
+			}
+		}
+		res = append(res, node)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Node < res[j].Node })
+	return res
+}
+
+// nodeFilesystems returns mounted filesystems of interesting types with their sizes, if they are available.
+func nodeFilesystems(fs nodeFS) ([]nodeFilesystem, error) {
+	s, err := fs.read("proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []nodeFilesystem
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !nodeSummaryFilesystems[fields[2]] {
+			continue
+		}
+		mount := nodeFilesystem{
+			Device:     fields[0],
+			MountPoint: fields[1],
+			Type:       fields[2],
+			Options:    fields[3],
+		}
+
+		var stat unix.Statfs_t
+		if err := unix.Statfs(fs.path(mount.MountPoint), &stat); err == nil {
+			size := int64(stat.Blocks) * int64(stat.Bsize) //nolint:unconvert
+			free := int64(stat.Bavail) * int64(stat.Bsize) //nolint:unconvert
+			mount.SizeBytes, mount.FreeBytes = &size, &free
+		}
+		res = append(res, mount)
+	}
+	return res, nil
+}
+
+// nodeBlockDevices returns block devices queue settings from sysfs; virtual loop and ram devices are skipped.
+func nodeBlockDevices(fs nodeFS) []nodeBlockDevice {
+	var res []nodeBlockDevice
+	for _, name := range fs.glob("sys/block/*") {
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		dir := "sys/block/" + name + "/"
+		dev := nodeBlockDevice{Name: name}
+		if sectors, err := fs.readInt(dir + "size"); err == nil {
+			dev.SizeBytes = sectors * 512
+		}
+		rotational, _ := fs.readInt(dir + "queue/rotational")
+		dev.Rotational = rotational == 1
+		scheduler, _ := fs.read(dir + "queue/scheduler")
+		dev.Scheduler = nodeSelected(scheduler)
+		dev.QueueSize, _ = fs.readInt(dir + "queue/nr_requests")
+		res = append(res, dev)
+	}
+	return res
+}
+
+// nodeNetwork returns network interfaces from sysfs; loopback is skipped.
+func nodeNetwork(fs nodeFS) []nodeNetworkInterface {
+	var res []nodeNetworkInterface
+	for _, name := range fs.glob("sys/class/net/*") {
+		if name == "lo" {
+			continue
+		}
+		dir := "sys/class/net/" + name + "/"
+		iface := nodeNetworkInterface{Name: name}
+		iface.State, _ = fs.read(dir + "operstate")
+		iface.MAC, _ = fs.read(dir + "address")
+		iface.MTU, _ = fs.readInt(dir + "mtu")
+
+		// reading speed fails for interfaces that are down, and it is -1 for some virtual interfaces
+		if speed, err := fs.readInt(dir + "speed"); err == nil && speed > 0 {
+			iface.SpeedMbps = speed
+		}
+		res = append(res, iface)
+	}
+	return res
+}
+
+// nodeCgroup returns cgroup limits of the current process.
+func nodeCgroup(fs nodeFS) (*nodeCgroupLimits, error) {
+	s, err := fs.read("proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	// "hierarchy-ID:controller-list:cgroup-path"; controller list is empty for cgroup v2
+	paths := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+
+	// cgroup path of the process may be not visible inside a container; use the controller root then
+	dir := func(base, path string) string {
+		if _, err := os.Stat(fs.path(filepath.Join(base, path))); err == nil {
+			return filepath.Join(base, path)
+		}
+		return base
+	}
+
+	if _, err = os.Stat(fs.path("sys/fs/cgroup/cgroup.controllers")); err == nil {
+		res := &nodeCgroupLimits{Version: 2, Path: paths[""]}
+		d := dir("sys/fs/cgroup", res.Path)
+
+		// "max 100000" or "200000 100000"
+		if cpuMax, err := fs.read(d + "/cpu.max"); err == nil {
+			if fields := strings.Fields(cpuMax); len(fields) == 2 && fields[0] != "max" {
+				quota, _ := strconv.ParseFloat(fields[0], 64)
+				period, _ := strconv.ParseFloat(fields[1], 64)
+				if period > 0 {
+					res.CPUs = quota / period
+				}
+			}
+		}
+		res.MemoryBytes, _ = fs.readInt(d + "/memory.max")
+		res.Pids, _ = fs.readInt(d + "/pids.max")
+		return res, nil
+	}
+
+	res := &nodeCgroupLimits{Version: 1, Path: paths["memory"]}
+	cpu := dir("sys/fs/cgroup/cpu,cpuacct", paths["cpu"])
+	quota, err := fs.readInt(cpu + "/cpu.cfs_quota_us")
+	if err == nil && quota > 0 {
+		if period, _ := fs.readInt(cpu + "/cpu.cfs_period_us"); period > 0 {
+			res.CPUs = float64(quota) / float64(period)
+		}
+	}
+
+	// unlimited memory is reported as a huge page-aligned number
+	if limit, err := fs.readInt(dir("sys/fs/cgroup/memory", paths["memory"]) + "/memory.limit_in_bytes"); err == nil && limit < 1<<62 {
+		res.MemoryBytes = limit
+	}
+	res.Pids, _ = fs.readInt(dir("sys/fs/cgroup/pids", paths["pids"]) + "/pids.max")
+	return res, nil
+}
+
+// nodeSelected returns the selected value from sysfs list like "always [madvise] never".
+func nodeSelected(s string) string {
+	if i := strings.Index(s, "["); i >= 0 {
+		if j := strings.Index(s[i:], "]"); j > 0 {
+			return s[i+1 : i+j]
+		}
+	}
+	return s
+}
+
+// writeNodeSummaryReport writes text report in pt-summary format.
+func writeNodeSummaryReport(w io.Writer, s *nodeSummary) error {
+	section := func(title string) { writeReportSection(w, title) }
+	value := func(name string, format string, args ...interface{}) { writeReportValue(w, name, format, args...) }
+	table := func(header string, rows func(tw io.Writer)) error {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, header)
+		rows(tw)
+		return tw.Flush()
+	}
+	limit := func(v int64) string {
+		if v <= 0 {
+			return "unlimited"
+		}
+		return humanizeBytes(v)
+	}
+
+	section("System Summary Report")
+	value("Hostname", "%s", s.Hostname)
+	value("Uptime", "%s", humanizeSeconds(s.UptimeSeconds))
+	value("Load Average", "%s", s.LoadAverage)
+	value("Release", "%s", s.OS)
+	value("Kernel", "%s", s.Kernel)
+
+	section("Processor")
+	value("Processors", "physical = %d, cores = %d, virtual = %d, hyperthreading = %s",
+		s.CPU.Sockets, s.CPU.Cores, s.CPU.Threads, map[bool]string{true: "yes", false: "no"}[s.CPU.Threads > s.CPU.Cores])
+	value("Model", "%s", s.CPU.Model)
+	value("Speed", "%.0f MHz", s.CPU.MHz)
+	value("Virtualized", "%t", s.CPU.Virtualized)
+
+	section("Memory")
+	value("Total", "%s", humanizeBytes(s.Memory["MemTotal"]))
+	value("Free", "%s", humanizeBytes(s.Memory["MemFree"]))
+	value("Available", "%s", humanizeBytes(s.Memory["MemAvailable"]))
+	value("Buffers", "%s", humanizeBytes(s.Memory["Buffers"]))
+	value("Caches", "%s", humanizeBytes(s.Memory["Cached"]))
+	value("Dirty", "%s", humanizeBytes(s.Memory["Dirty"]))
+	value("Swap", "%s total, %s free", humanizeBytes(s.Memory["SwapTotal"]), humanizeBytes(s.Memory["SwapFree"]))
+	value("HugePages", "%d of %s", s.Memory["HugePages_Total"], humanizeBytes(s.Memory["Hugepagesize"]))
+	for _, node := range s.NUMA {
+		value(fmt.Sprintf("NUMA Node %d", node.Node), "%s, CPUs %s", humanizeBytes(node.MemoryBytes), node.CPUs)
+	}
+
+	if len(s.Filesystems) != 0 {
+		section("Mounted Filesystems")
+		err := table("  Filesystem\tSize\tFree\tType\tOptions\tMountpoint\t", func(tw io.Writer) {
+			for _, f := range s.Filesystems {
+				size, free := "-", "-"
+				if f.SizeBytes != nil {
+					size, free = humanizeBytes(*f.SizeBytes), humanizeBytes(*f.FreeBytes)
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t\n", f.Device, size, free, f.Type, f.Options, f.MountPoint)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.BlockDevices) != 0 {
+		section("Disk Schedulers And Queue Size")
+		for _, d := range s.BlockDevices {
+			kind := "SSD"
+			if d.Rotational {
+				kind = "HDD"
+			}
+			value(d.Name, "[%s] %d, %s, %s", d.Scheduler, d.QueueSize, kind, humanizeBytes(d.SizeBytes))
+		}
+	}
+
+	if len(s.Network) != 0 {
+		section("Network Devices")
+		err := table("  Device\tState\tSpeed\tMTU\tMAC\t", func(tw io.Writer) {
+			for _, iface := range s.Network {
+				speed := "-"
+				if iface.SpeedMbps > 0 {
+					speed = fmt.Sprintf("%d Mb/s", iface.SpeedMbps)
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\t%s\t\n", iface.Name, iface.State, speed, iface.MTU, iface.MAC)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.THP != nil {
+		section("Transparent Huge Pages")
+		value("Enabled", "%s", s.THP["enabled"])
+		value("Defrag", "%s", s.THP["defrag"])
+	}
+
+	if len(s.Sysctl) != 0 {
+		section("Kernel Parameters")
+		for _, name := range nodeSummarySysctls {
+			if v, ok := s.Sysctl[name]; ok {
+				value(name, "%s", v)
+			}
+		}
+	}
+
+	if c := s.Cgroup; c != nil {
+		section("Cgroup Limits")
+		value("Version", "%d", c.Version)
+		value("Path", "%s", c.Path)
+		if c.CPUs > 0 {
+			value("CPUs", "%g", c.CPUs)
+		} else {
+			value("CPUs", "unlimited")
+		}
+		value("Memory", "%s", limit(c.MemoryBytes))
+		if c.Pids > 0 {
+			value("Pids", "%d", c.Pids)
+		} else {
+			value("Pids", "unlimited")
+		}
+	}
+
+	if len(s.Notes) != 0 {
+		section("Notes")
+		for _, n := range s.Notes {
+			fmt.Fprintf(w, "  %s\n", n)
+		}
+	}
+
+	return nil
+}
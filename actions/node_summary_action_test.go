@@ -0,0 +1,185 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeSummary(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Host", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := getNodeSummary(filepath.Join("testdata", "node", "host"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "db1", s.Hostname)
+		assert.Equal(t, "Ubuntu 22.04.1 LTS", s.OS)
+		assert.Equal(t, "5.15.0-56-generic", s.Kernel)
+		assert.Equal(t, int64(90061), s.UptimeSeconds)
+		assert.Equal(t, "0.52 0.58 0.59", s.LoadAverage)
+		assert.Equal(t, nodeCPUSummary{
+			Model:   "Intel(R) Xeon(R) CPU E5-2680 v4 @ 2.40GHz",
+			Sockets: 2,
+			Cores:   4,
+			Threads: 8,
+			MHz:     2400,
+		}, s.CPU)
+		assert.Equal(t, int64(16318412<<10), s.Memory["MemTotal"])
+		assert.Equal(t, int64(0), s.Memory["HugePages_Total"])
+		assert.Equal(t, []nodeNUMANode{
+			{Node: 0, CPUs: "0-3", MemoryBytes: 8159206 << 10},
+			{Node: 1, CPUs: "4-7", MemoryBytes: 8159206 << 10},
+		}, s.NUMA)
+
+		require.Len(t, s.Filesystems, 3)
+		assert.Equal(t, "/", s.Filesystems[0].MountPoint)
+		assert.Equal(t, "ext4", s.Filesystems[0].Type)
+		assert.NotNil(t, s.Filesystems[0].SizeBytes, "fixture root directory exists")
+		assert.Equal(t, "tmpfs", s.Filesystems[1].Type)
+		assert.Equal(t, nodeFilesystem{
+			Device:     "/dev/nvme0n1p1",
+			MountPoint: "/var/lib/mysql",
+			Type:       "xfs",
+			Options:    "rw,noatime,attr2,inode64,noquota",
+		}, s.Filesystems[2])
+
+		assert.Equal(t, []nodeBlockDevice{
+			{Name: "nvme0n1", SizeBytes: 1953525168 * 512, Scheduler: "none", QueueSize: 1023},
+			{Name: "sda", SizeBytes: 976773168 * 512, Rotational: true, Scheduler: "bfq", QueueSize: 64},
+		}, s.BlockDevices)
+		assert.Equal(t, map[string]string{"enabled": "madvise", "defrag": "madvise"}, s.THP)
+		assert.Equal(t, "1", s.Sysctl["vm.swappiness"])
+		assert.Equal(t, "32768 60999", s.Sysctl["net.ipv4.ip_local_port_range"])
+		assert.NotContains(t, s.Sysctl, "vm.dirty_bytes")
+
+		assert.Equal(t, []nodeNetworkInterface{
+			{Name: "eth0", State: "up", MAC: "52:54:00:12:34:56", MTU: 9000, SpeedMbps: 10000},
+			{Name: "eth1", State: "down", MAC: "52:54:00:12:34:57", MTU: 1500},
+		}, s.Network)
+		assert.Equal(t, &nodeCgroupLimits{
+			Version:     2,
+			Path:        "/system.slice/pmm-agent.service",
+			CPUs:        2,
+			MemoryBytes: 2 << 30,
+		}, s.Cgroup)
+		assert.Empty(t, s.Notes)
+	})
+
+	t.Run("Container", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := getNodeSummary(filepath.Join("testdata", "node", "container"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "Oracle Linux Server 8.6", s.OS)
+		assert.Equal(t, nodeCPUSummary{Sockets: 1, Cores: 4, Threads: 4}, s.CPU)
+		require.Len(t, s.Filesystems, 1)
+		assert.Equal(t, "overlay", s.Filesystems[0].Type)
+		assert.Empty(t, s.BlockDevices)
+		assert.Empty(t, s.Network)
+		assert.Nil(t, s.THP)
+		assert.Equal(t, &nodeCgroupLimits{
+			Version:     1,
+			Path:        "/docker/f3c2a1b0d9e8",
+			CPUs:        1.5,
+			MemoryBytes: 1 << 30,
+		}, s.Cgroup)
+		assert.Empty(t, s.Notes)
+	})
+
+	t.Run("NoProc", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := getNodeSummary(filepath.Join("testdata", "node", "missing"))
+		require.Error(t, err)
+	})
+
+	t.Run("Action", func(t *testing.T) {
+		t.Parallel()
+
+		a := NewNodeSummaryAction(NodeSummaryActionParams{Root: filepath.Join("testdata", "node", "host")})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+
+		var actual struct {
+			Summary nodeSummary
+			Report  string
+		}
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+		t.Logf("Report:\n%s", actual.Report)
+
+		for _, expected := range []string{
+			"                 Hostname | db1\n",
+			"               Processors | physical = 2, cores = 4, virtual = 8, hyperthreading = yes\n",
+			"                    Total | 15.56 GiB\n",
+			"              NUMA Node 1 | 7.78 GiB, CPUs 4-7\n",
+			"                      sda | [bfq] 64, HDD, 465.76 GiB\n",
+			"  eth1    down   -           1500  52:54:00:12:34:57  \n",
+			"                  Enabled | madvise\n",
+			"            vm.swappiness | 1\n",
+			"                     CPUs | 2\n",
+			"                     Pids | unlimited\n",
+		} {
+			assert.Contains(t, actual.Report, expected)
+		}
+		// sizes of the fixture root depend on the host filesystem, so the column widths vary
+		assert.Regexp(t, `\n  /dev/nvme0n1p1 +- +- +xfs +rw,noatime,attr2,inode64,noquota +/var/lib/mysql +\n`, actual.Report)
+		assert.NotContains(t, actual.Report, "# Notes")
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		t.Parallel()
+
+		a := NewNodeSummaryAction(NodeSummaryActionParams{Root: filepath.Join("testdata", "node", "host")})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		var report []byte
+		err := Stream(a).RunStream(ctx, func(c Chunk) error {
+			report = append(report, c.Data...)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// only text report, without JSON envelope
+		assert.True(t, strings.HasPrefix(string(report), "# "), "%s", report)
+		assert.Contains(t, string(report), "                 Hostname | db1\n")
+	})
+}
+
+func TestNodeSelected(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "madvise", nodeSelected("always [madvise] never"))
+	assert.Equal(t, "none", nodeSelected("[none] mq-deadline"))
+	assert.Equal(t, "noop", nodeSelected("noop"))
+	assert.Equal(t, "", nodeSelected(""))
+}
@@ -0,0 +1,393 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+const (
+	// defaultPostgreSQLBloatLimit is a default maximal number of the largest relations to check.
+	defaultPostgreSQLBloatLimit = 100
+	// defaultPostgreSQLBloatTimeout is used when the context has no deadline;
+	// it matches the client's default Action timeout.
+	defaultPostgreSQLBloatTimeout = 10 * time.Second
+
+	bloatMethodEstimate    = "estimate"
+	bloatMethodPgstattuple = "pgstattuple"
+)
+
+// PostgreSQLBloatActionParams represent PostgreSQL table and index bloat Actions params.
+type PostgreSQLBloatActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+
+	// Schema limits relations to a single schema; all non-system schemas are checked if empty.
+	Schema string
+	// Limit is a maximal number of the largest relations to check.
+	Limit int
+	// Exact enables exact mode: relations are scanned with pgstattuple extension functions if it is installed.
+	// That can take a long time on large databases; it is bounded by the Action timeout
+	// (or defaultPostgreSQLBloatTimeout if the context has no deadline).
+	Exact bool
+}
+
+type postgresqlBloatAction struct {
+	params  PostgreSQLBloatActionParams
+	indexes bool
+}
+
+// NewPostgreSQLTableBloatAction creates PostgreSQL table bloat Action.
+// This is an Action that estimates wasted space in tables from pg_stats statistics, or measures it with pgstattuple.
+//
+// The client does not start bloat Actions yet as agentpb has no StartActionRequest params for them.
+// Once wired, they should be started with ConcurrentRunner.Start and client.getActionTimeout like other Actions:
+// the runner's context deadline becomes statement_timeout of the session.
+func NewPostgreSQLTableBloatAction(params PostgreSQLBloatActionParams) Action {
+	return newPostgreSQLBloatAction(params, false)
+}
+
+// NewPostgreSQLIndexBloatAction creates PostgreSQL index bloat Action.
+// This is an Action that estimates wasted space in B-tree indexes from pg_stats statistics, or measures it with pgstattuple.
+// See NewPostgreSQLTableBloatAction for timeout handling and the client wiring status.
+func NewPostgreSQLIndexBloatAction(params PostgreSQLBloatActionParams) Action {
+	return newPostgreSQLBloatAction(params, true)
+}
+
+func newPostgreSQLBloatAction(params PostgreSQLBloatActionParams, indexes bool) Action {
+	if params.Limit <= 0 {
+		params.Limit = defaultPostgreSQLBloatLimit
+	}
+	return &postgresqlBloatAction{
+		params:  params,
+		indexes: indexes,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlBloatAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlBloatAction) Type() string {
+	if a.indexes {
+		return "postgresql-index-bloat"
+	}
+	return "postgresql-table-bloat"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlBloatAction) Run(ctx context.Context) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPostgreSQLBloatTimeout)
+		defer cancel()
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	// use a single session to make statement_timeout work for all queries
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	// do not leave server-side queries running after the Action timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline).Milliseconds()
+		if timeout <= 0 {
+			return nil, errors.WithStack(context.DeadlineExceeded)
+		}
+		if _, err = conn.ExecContext(ctx, fmt.Sprintf("SET /* pmm-agent */ statement_timeout = %d", timeout)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var blockSize float64
+	if err = conn.QueryRowContext(ctx, "SELECT /* pmm-agent */ current_setting('block_size')::float8").Scan(&blockSize); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	query := postgresqlTableBloatQuery
+	if a.indexes {
+		query = postgresqlIndexBloatQuery
+	}
+	inputs, err := readBloatInputs(ctx, conn, query, a.params.Schema, a.params.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]bloatResult, len(inputs))
+	for i, in := range inputs {
+		results[i] = estimateBloat(in, blockSize)
+	}
+
+	if a.params.Exact {
+		var installed bool
+		err = conn.QueryRowContext(ctx, "SELECT /* pmm-agent */ EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pgstattuple')").Scan(&installed)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// otherwise, statistical estimates are returned; see method column
+		if installed {
+			for i, in := range inputs {
+				if results[i], err = measureBloat(ctx, conn, in, blockSize); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].WastedBytes > results[j].WastedBytes })
+
+	columns := []string{"schema", "table", "size_bytes", "wasted_bytes", "wasted_ratio", "method", "reliable"}
+	if a.indexes {
+		columns = []string{"schema", "table", "index", "size_bytes", "wasted_bytes", "wasted_ratio", "method", "reliable"}
+	}
+	dataRows := make([][]interface{}, len(results))
+	for i, r := range results {
+		row := []interface{}{r.Schema, r.Table}
+		if a.indexes {
+			row = append(row, r.Index)
+		}
+		dataRows[i] = append(row, r.SizeBytes, r.WastedBytes, r.WastedRatio, r.Method, r.Reliable)
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *postgresqlBloatAction) sealed() {}
+
+// bloatInput represents relation statistics used for bloat estimation.
+type bloatInput struct {
+	Schema           string
+	Table            string
+	Index            string // empty for tables
+	Pages            float64
+	Tuples           float64 // -1 if relation was never analyzed (PostgreSQL 14+)
+	FillFactor       float64
+	Columns          int
+	ColumnsWithStats int
+	DataWidth        float64 // average width of non-null column values sum
+	HasNulls         bool
+}
+
+// bloatResult represents relation bloat.
+type bloatResult struct {
+	Schema      string
+	Table       string
+	Index       string
+	SizeBytes   int64
+	WastedBytes int64
+	WastedRatio float64
+	Method      string
+	Reliable    bool // false if estimation is based on incomplete statistics
+}
+
+// Both queries return the largest relations first with columns of bloatInput.
+// Index expressions have no column statistics, so estimation for such indexes is not reliable.
+const (
+	postgresqlTableBloatQuery = `SELECT /* pmm-agent */ n.nspname, c.relname, '',
+  c.relpages::float8, c.reltuples::float8,
+  coalesce((SELECT option_value::float8 FROM pg_options_to_table(c.reloptions) WHERE option_name = 'fillfactor'), 100),
+  count(a.attnum), count(s.attname),
+  coalesce(sum((1 - coalesce(s.null_frac, 0)) * coalesce(s.avg_width, 0)), 0)::float8,
+  coalesce(max(s.null_frac), 0) > 0
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+LEFT JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = c.relname AND s.attname = a.attname AND NOT s.inherited
+WHERE c.relkind IN ('r', 'm') AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname !~ '^pg_toast'
+  AND ($1 = '' OR n.nspname = $1)
+GROUP BY c.oid, n.nspname
+ORDER BY c.relpages DESC
+LIMIT $2`
+
+	postgresqlIndexBloatQuery = `SELECT /* pmm-agent */ n.nspname, t.relname, c.relname,
+  c.relpages::float8, c.reltuples::float8,
+  coalesce((SELECT option_value::float8 FROM pg_options_to_table(c.reloptions) WHERE option_name = 'fillfactor'), 90),
+  i.indnatts, count(s.attname),
+  coalesce(sum((1 - coalesce(s.null_frac, 0)) * coalesce(s.avg_width, 0)), 0)::float8,
+  coalesce(max(s.null_frac), 0) > 0
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+JOIN pg_class t ON t.oid = i.indrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_am am ON am.oid = c.relam AND am.amname = 'btree'
+LEFT JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey) AND a.attnum > 0
+LEFT JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = t.relname AND s.attname = a.attname AND NOT s.inherited
+WHERE n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname !~ '^pg_toast'
+  AND ($1 = '' OR n.nspname = $1)
+GROUP BY c.oid, n.nspname, t.relname, i.indnatts
+ORDER BY c.relpages DESC
+LIMIT $2`
+)
+
+// readBloatInputs returns bloat estimation inputs using given query.
+func readBloatInputs(ctx context.Context, conn *sql.Conn, query, schema string, limit int) ([]bloatInput, error) {
+	rows, err := conn.QueryContext(ctx, query, schema, limit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []bloatInput
+	for rows.Next() {
+		var in bloatInput
+		err = rows.Scan(&in.Schema, &in.Table, &in.Index, &in.Pages, &in.Tuples, &in.FillFactor,
+			&in.Columns, &in.ColumnsWithStats, &in.DataWidth, &in.HasNulls)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, in)
+	}
+	return res, errors.WithStack(rows.Err())
+}
+
+// Sizes of PostgreSQL on-disk structures used for estimation.
+const (
+	pageHeaderSize      = 24 // PageHeaderData
+	itemIDSize          = 4  // ItemIdData, line pointer
+	heapTupleHeaderSize = 23 // HeapTupleHeaderData
+	indexTupleSize      = 8  // IndexTupleData
+	indexNullBitmapSize = 4  // IndexAttributeBitMapData
+	btreeSpecialSize    = 16 // BTPageOpaqueData
+	maxAlign            = 8
+)
+
+func alignUp(v float64) float64 {
+	return math.Ceil(v/maxAlign) * maxAlign
+}
+
+// estimateBloat returns estimated bloat: the difference between actual relation size
+// and the size of the relation with the same number of tuples of average width packed with fillfactor.
+func estimateBloat(in bloatInput, blockSize float64) bloatResult {
+	res := bloatResult{
+		Schema:    in.Schema,
+		Table:     in.Table,
+		Index:     in.Index,
+		SizeBytes: int64(in.Pages * blockSize),
+		Method:    bloatMethodEstimate,
+		Reliable:  in.Tuples >= 0 && in.Columns > 0 && in.ColumnsWithStats == in.Columns,
+	}
+
+	// the number of tuples is unknown for never analyzed relations
+	tuples := in.Tuples
+	if tuples < 0 {
+		return res
+	}
+
+	var tupleSize, pageSize float64
+	var extraPages float64
+	if in.Index == "" {
+		header := float64(heapTupleHeaderSize)
+		if in.HasNulls {
+			header += math.Ceil(float64(in.Columns) / 8)
+		}
+		tupleSize = alignUp(header) + alignUp(in.DataWidth) + itemIDSize
+		pageSize = blockSize - pageHeaderSize
+	} else {
+		header := float64(indexTupleSize)
+		if in.HasNulls {
+			header += indexNullBitmapSize
+		}
+		tupleSize = alignUp(header) + alignUp(in.DataWidth) + itemIDSize
+		pageSize = blockSize - pageHeaderSize - btreeSpecialSize
+		extraPages = 1 // metapage
+	}
+
+	var expectedPages float64
+	if tuples > 0 {
+		perPage := math.Max(math.Floor(pageSize*in.FillFactor/100/tupleSize), 1)
+		expectedPages = math.Ceil(tuples/perPage) + extraPages
+	}
+
+	if wasted := in.Pages - expectedPages; wasted > 0 {
+		res.WastedBytes = int64(wasted * blockSize)
+		res.WastedRatio = wasted / in.Pages
+	}
+	return res
+}
+
+// measureBloat returns bloat measured with pgstattuple extension functions.
+// For tables, it is the space of dead tuples and free space; for B-tree indexes, it is the free space in leaf pages
+// exceeding fillfactor, and empty and deleted pages.
+func measureBloat(ctx context.Context, conn *sql.Conn, in bloatInput, blockSize float64) (bloatResult, error) {
+	res := bloatResult{
+		Schema:   in.Schema,
+		Table:    in.Table,
+		Index:    in.Index,
+		Method:   bloatMethodPgstattuple,
+		Reliable: true,
+	}
+
+	var wasted float64
+	if in.Index == "" {
+		var deadBytes, freeBytes float64
+		err := conn.QueryRowContext(ctx, "SELECT /* pmm-agent */ table_len, dead_tuple_len, free_space "+
+			"FROM pgstattuple(format('%I.%I', $1::text, $2::text))", in.Schema, in.Table).Scan(&res.SizeBytes, &deadBytes, &freeBytes)
+		if err != nil {
+			return res, errors.Wrapf(err, "pgstattuple failed for table %s.%s", in.Schema, in.Table)
+		}
+		wasted = deadBytes + freeBytes
+	} else {
+		var leafPages, emptyPages, deletedPages float64
+		var density sql.NullFloat64
+		err := conn.QueryRowContext(ctx, "SELECT /* pmm-agent */ index_size, leaf_pages, empty_pages, deleted_pages, avg_leaf_density "+
+			"FROM pgstatindex(format('%I.%I', $1::text, $2::text))", in.Schema, in.Index).Scan(&res.SizeBytes, &leafPages, &emptyPages, &deletedPages, &density)
+		if err != nil {
+			return res, errors.Wrapf(err, "pgstatindex failed for index %s.%s", in.Schema, in.Index)
+		}
+		wasted = (emptyPages + deletedPages) * blockSize
+		// avg_leaf_density is NaN for indexes without leaf pages
+		if density.Valid && !math.IsNaN(density.Float64) && in.FillFactor > 0 {
+			wasted += math.Max(leafPages*blockSize*(1-density.Float64/in.FillFactor), 0)
+		}
+	}
+
+	res.WastedBytes = int64(wasted)
+	if res.SizeBytes > 0 {
+		res.WastedRatio = wasted / float64(res.SizeBytes)
+	}
+	return res, nil
+}
@@ -0,0 +1,187 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLBloat(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+	db := tests.OpenTestPostgreSQL(t)
+	defer db.Close() //nolint:errcheck
+
+	_, err := db.Exec("ANALYZE city")
+	require.NoError(t, err)
+
+	t.Run("Table", func(t *testing.T) {
+		a := NewPostgreSQLTableBloatAction(PostgreSQLBloatActionParams{
+			DSN:     dsn,
+			TempDir: os.TempDir(),
+			Schema:  "public",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		t.Logf("Full JSON:\n%s", b)
+
+		var actual [][]interface{}
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+		require.True(t, len(actual) > 1)
+		assert.Equal(t, []interface{}{"schema", "table", "size_bytes", "wasted_bytes", "wasted_ratio", "method", "reliable"}, actual[0])
+
+		var found bool
+		for _, row := range actual[1:] {
+			if row[1] == "city" {
+				found = true
+				assert.Equal(t, "public", row[0])
+				assert.Equal(t, bloatMethodEstimate, row[5])
+			}
+		}
+		assert.True(t, found)
+	})
+
+	t.Run("IndexExact", func(t *testing.T) {
+		a := NewPostgreSQLIndexBloatAction(PostgreSQLBloatActionParams{
+			DSN:     dsn,
+			TempDir: os.TempDir(),
+			Schema:  "public",
+			Limit:   1,
+			Exact:   true,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		t.Logf("Full JSON:\n%s", b)
+
+		var actual [][]interface{}
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+		require.Len(t, actual, 2)
+		assert.Equal(t, []interface{}{"schema", "table", "index", "size_bytes", "wasted_bytes", "wasted_ratio", "method", "reliable"}, actual[0])
+		assert.Contains(t, []interface{}{bloatMethodEstimate, bloatMethodPgstattuple}, actual[1][6])
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		a := NewPostgreSQLTableBloatAction(PostgreSQLBloatActionParams{
+			DSN:     dsn,
+			TempDir: os.TempDir(),
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		require.Error(t, err)
+	})
+}
+
+func TestEstimateBloat(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		in       bloatInput
+		expected bloatResult
+	}{{
+		name: "Table",
+		in: bloatInput{
+			Schema: "public", Table: "t",
+			Pages: 100, Tuples: 1000, FillFactor: 100,
+			Columns: 2, ColumnsWithStats: 2, DataWidth: 12,
+		},
+		// 44 bytes per tuple, 185 tuples per page, 6 pages expected
+		expected: bloatResult{
+			Schema: "public", Table: "t",
+			SizeBytes: 819200, WastedBytes: 770048, WastedRatio: 0.94,
+			Method: bloatMethodEstimate, Reliable: true,
+		},
+	}, {
+		name: "Index",
+		in: bloatInput{
+			Schema: "public", Table: "t", Index: "t_pkey",
+			Pages: 10, Tuples: 1000, FillFactor: 90,
+			Columns: 1, ColumnsWithStats: 1, DataWidth: 4,
+		},
+		// 20 bytes per tuple, 366 tuples per page, 3 leaf pages and metapage expected
+		expected: bloatResult{
+			Schema: "public", Table: "t", Index: "t_pkey",
+			SizeBytes: 81920, WastedBytes: 49152, WastedRatio: 0.6,
+			Method: bloatMethodEstimate, Reliable: true,
+		},
+	}, {
+		name: "NoBloat",
+		in: bloatInput{
+			Schema: "public", Table: "t", Index: "t_pkey",
+			Pages: 4, Tuples: 1000, FillFactor: 90,
+			Columns: 1, ColumnsWithStats: 1, DataWidth: 4,
+		},
+		expected: bloatResult{
+			Schema: "public", Table: "t", Index: "t_pkey",
+			SizeBytes: 32768,
+			Method:    bloatMethodEstimate, Reliable: true,
+		},
+	}, {
+		name: "NoStatistics",
+		in: bloatInput{
+			Schema: "public", Table: "t",
+			Pages: 100, Tuples: 1000, FillFactor: 100,
+			Columns: 2, ColumnsWithStats: 1, DataWidth: 4,
+		},
+		expected: bloatResult{
+			Schema: "public", Table: "t",
+			SizeBytes: 819200, WastedBytes: 778240, WastedRatio: 0.95,
+			Method: bloatMethodEstimate,
+		},
+	}, {
+		name: "NeverAnalyzed",
+		in: bloatInput{
+			Schema: "public", Table: "t",
+			Pages: 100, Tuples: -1, FillFactor: 100,
+			Columns: 2, ColumnsWithStats: 2, DataWidth: 12,
+		},
+		expected: bloatResult{
+			Schema: "public", Table: "t",
+			SizeBytes: 819200,
+			Method:    bloatMethodEstimate,
+		},
+	}} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := estimateBloat(tc.in, 8192)
+			assert.InDelta(t, tc.expected.WastedRatio, actual.WastedRatio, 0.0001)
+			actual.WastedRatio = tc.expected.WastedRatio
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
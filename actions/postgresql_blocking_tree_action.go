@@ -0,0 +1,126 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// postgresqlBlockingTreeQuery returns sessions blocking others and sessions waiting for them in depth-first order.
+// Roots are sessions that are not blocked themselves; blocking_pid is a parent session in the tree.
+// Sessions waiting in a cycle (a deadlock not yet detected by the server) have no root and are not returned.
+const postgresqlBlockingTreeQuery = `WITH RECURSIVE activity AS (
+  SELECT pid, pg_blocking_pids(pid) AS blocked_by, usename, datname, application_name, client_addr, state,
+    wait_event_type, wait_event, xact_start, query_start, query
+  FROM pg_stat_activity
+  WHERE pid <> pg_backend_pid()
+),
+tree AS (
+  SELECT pid AS root_pid, 0 AS level, pid, ARRAY[pid] AS path
+  FROM activity
+  WHERE cardinality(blocked_by) = 0 AND pid IN (SELECT unnest(blocked_by) FROM activity)
+  UNION ALL
+  SELECT t.root_pid, t.level + 1, a.pid, t.path || a.pid
+  FROM tree t
+  JOIN activity a ON t.pid = ANY(a.blocked_by)
+  WHERE a.pid <> ALL(t.path)
+)
+SELECT /* pmm-agent */
+  t.root_pid, t.level, t.pid,
+  CASE WHEN t.level > 0 THEN t.path[t.level] END AS blocking_pid,
+  array_to_string(a.blocked_by, ',') AS blocked_by,
+  a.usename, a.datname, a.application_name, host(a.client_addr) AS client_addr, a.state,
+  a.wait_event_type, a.wait_event,
+  l.locktype AS lock_type, l.mode AS lock_mode, l.relation::regclass::text AS lock_relation,
+  EXTRACT(EPOCH FROM clock_timestamp() - a.xact_start)::bigint AS xact_age_seconds,
+  EXTRACT(EPOCH FROM clock_timestamp() - a.query_start)::bigint AS query_age_seconds,
+  a.query
+FROM tree t
+JOIN activity a ON a.pid = t.pid
+LEFT JOIN LATERAL (
+  SELECT locktype, mode, relation FROM pg_locks WHERE pid = t.pid AND NOT granted LIMIT 1
+) l ON true
+ORDER BY t.path`
+
+// PostgreSQLBlockingTreeActionParams represent PostgreSQL blocking tree Action params.
+type PostgreSQLBlockingTreeActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+}
+
+type postgresqlBlockingTreeAction struct {
+	params PostgreSQLBlockingTreeActionParams
+}
+
+// NewPostgreSQLBlockingTreeAction creates PostgreSQL blocking tree Action.
+// This is an Action that returns sessions waiting for locks held by other sessions as a tree, one row per session.
+//
+// The client does not start it yet because there are no matching StartActionRequest params in agentpb;
+// NewPostgreSQLLongTransactionsAction is in the same state.
+func NewPostgreSQLBlockingTreeAction(params PostgreSQLBlockingTreeActionParams) Action {
+	return &postgresqlBlockingTreeAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlBlockingTreeAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlBlockingTreeAction) Type() string {
+	return "postgresql-blocking-tree"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlBlockingTreeAction) Run(ctx context.Context) ([]byte, error) {
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	rows, err := db.QueryContext(ctx, postgresqlBlockingTreeQuery)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	columns, dataRows, err := readRows(rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *postgresqlBlockingTreeAction) sealed() {}
@@ -0,0 +1,101 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLBlockingTree(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+	db := tests.OpenTestPostgreSQL(t)
+	defer db.Close() //nolint:errcheck
+
+	ctx := context.Background()
+	blocking, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer blocking.Rollback() //nolint:errcheck
+	var blockingPID float64
+	err = blocking.QueryRow("SELECT pg_backend_pid()").Scan(&blockingPID)
+	require.NoError(t, err)
+	_, err = blocking.Exec("UPDATE city SET population = population WHERE id = 1")
+	require.NoError(t, err)
+
+	waiting, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer waiting.Rollback() //nolint:errcheck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = waiting.Exec("UPDATE city SET population = population WHERE id = 1")
+	}()
+
+	a := NewPostgreSQLBlockingTreeAction(PostgreSQLBlockingTreeActionParams{
+		DSN:     dsn,
+		TempDir: os.TempDir(),
+	})
+
+	var rows []map[string]interface{}
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		var actual [][]interface{}
+		err = json.Unmarshal(b, &actual)
+		require.NoError(t, err)
+
+		// convert to maps for readability
+		rows = nil
+		for _, row := range actual[1:] {
+			m := make(map[string]interface{}, len(row))
+			for i, v := range row {
+				m[actual[0][i].(string)] = v
+			}
+			if m["root_pid"] == blockingPID {
+				rows = append(rows, m)
+			}
+		}
+		return len(rows) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, 0.0, rows[0]["level"])
+	assert.Equal(t, blockingPID, rows[0]["pid"])
+	assert.Nil(t, rows[0]["blocking_pid"])
+	assert.Equal(t, "idle in transaction", rows[0]["state"])
+
+	assert.Equal(t, 1.0, rows[1]["level"])
+	assert.Equal(t, blockingPID, rows[1]["blocking_pid"])
+	assert.Equal(t, "active", rows[1]["state"])
+	assert.Equal(t, "Lock", rows[1]["wait_event_type"])
+	assert.Equal(t, "transactionid", rows[1]["lock_type"])
+	assert.Equal(t, "UPDATE city SET population = population WHERE id = 1", rows[1]["query"])
+
+	require.NoError(t, blocking.Rollback())
+	<-done
+}
@@ -0,0 +1,144 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/agents/postgres/parser"
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// PostgreSQLCancelQueryActionParams represent PostgreSQL cancel query Action params.
+type PostgreSQLCancelQueryActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+	// PID is a process ID of the backend running the query.
+	PID int32
+	// Fingerprint is an expected QAN fingerprint of the running query.
+	Fingerprint string
+	// Terminate makes Action terminate the whole backend with pg_terminate_backend instead of pg_cancel_backend.
+	Terminate bool
+}
+
+type postgresqlCancelQueryAction struct {
+	params PostgreSQLCancelQueryActionParams
+}
+
+// NewPostgreSQLCancelQueryAction creates PostgreSQL cancel query Action.
+// This is an Action that cancels the query (or terminates the backend) with given PID
+// if it still runs the query with expected fingerprint.
+// It is not started by the client yet, see NewMySQLKillQueryAction.
+func NewPostgreSQLCancelQueryAction(params PostgreSQLCancelQueryActionParams) Action {
+	return &postgresqlCancelQueryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlCancelQueryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlCancelQueryAction) Type() string {
+	if a.params.Terminate {
+		return "postgresql-terminate-backend"
+	}
+	return "postgresql-cancel-query"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlCancelQueryAction) Run(ctx context.Context) ([]byte, error) {
+	res := &killQueryResult{
+		Target:      strconv.FormatInt(int64(a.params.PID), 10),
+		Fingerprint: a.params.Fingerprint,
+	}
+	var err error
+	res.Query, err = a.cancel(ctx)
+	return auditKillQuery(a, a.params.TempDir, res, err)
+}
+
+// cancel checks the backend and cancels its query; it returns the cancelled query.
+func (a *postgresqlCancelQueryAction) cancel(ctx context.Context) (string, error) {
+	if a.params.PID == 0 {
+		return "", errors.New("PID is required")
+	}
+	if a.params.Fingerprint == "" {
+		return "", errors.New("query fingerprint is required")
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	var own bool
+	var state, query sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT /* pmm-agent */ pid = pg_backend_pid(), state, query FROM pg_stat_activity WHERE pid = $1",
+		a.params.PID).Scan(&own, &state, &query)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", errors.Errorf("backend %d not found", a.params.PID)
+	case err != nil:
+		return "", errors.WithStack(err)
+	case own:
+		return "", errors.New("can't cancel own query")
+	case state.String != "active":
+		return "", errors.Errorf("backend %d is not running a query (state %q)", a.params.PID, state.String)
+	}
+
+	// truncated query texts (see track_activity_query_size) can't be parsed, so they are refused there
+	fingerprint, _, err := parser.Fingerprint(query.String)
+	if err != nil {
+		return "", err
+	}
+	if err = checkKillQueryFingerprint(a.params.Fingerprint, fingerprint); err != nil {
+		return "", err
+	}
+
+	f := "pg_cancel_backend"
+	if a.params.Terminate {
+		f = "pg_terminate_backend"
+	}
+	var ok bool
+	if err = db.QueryRowContext(ctx, "SELECT /* pmm-agent */ "+f+"($1)", a.params.PID).Scan(&ok); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !ok {
+		return "", errors.Errorf("%s(%d) failed", f, a.params.PID)
+	}
+	return query.String, nil
+}
+
+func (a *postgresqlCancelQueryAction) sealed() {}
@@ -0,0 +1,142 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLCancelQuery(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+	db := tests.OpenTestPostgreSQL(t)
+	defer db.Close() //nolint:errcheck
+
+	// startSleep runs a long query in a separate connection and returns its backend PID, and query error channel.
+	startSleep := func(t *testing.T) (int32, <-chan error) {
+		t.Helper()
+
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		var pid int32
+		err = conn.QueryRowContext(context.Background(), "SELECT pg_backend_pid()").Scan(&pid)
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		go func() {
+			defer conn.Close() //nolint:errcheck
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_sleep(5)")
+			errCh <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			var state string
+			err := db.QueryRow("SELECT state FROM pg_stat_activity WHERE pid = $1", pid).Scan(&state)
+			return err == nil && state == "active"
+		}, 3*time.Second, 10*time.Millisecond)
+
+		return pid, errCh
+	}
+
+	for _, terminate := range []bool{false, true} {
+		terminate := terminate
+		name := "Cancel"
+		if terminate {
+			name = "Terminate"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pid, errCh := startSleep(t)
+			a := NewPostgreSQLCancelQueryAction(PostgreSQLCancelQueryActionParams{
+				DSN:         dsn,
+				TempDir:     os.TempDir(),
+				PID:         pid,
+				Fingerprint: "SELECT pg_sleep($1)",
+				Terminate:   terminate,
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			b, err := a.Run(ctx)
+			require.NoError(t, err)
+
+			var actual killQueryResult
+			err = json.Unmarshal(b, &actual)
+			require.NoError(t, err)
+			assert.Equal(t, "SELECT pg_sleep(5)", actual.Query)
+
+			select {
+			case err = <-errCh:
+				assert.Error(t, err)
+			case <-time.After(3 * time.Second):
+				t.Fatal("query was not cancelled")
+			}
+		})
+	}
+
+	t.Run("FingerprintMismatch", func(t *testing.T) {
+		t.Parallel()
+
+		pid, errCh := startSleep(t)
+		a := NewPostgreSQLCancelQueryAction(PostgreSQLCancelQueryActionParams{
+			DSN:         dsn,
+			TempDir:     os.TempDir(),
+			PID:         pid,
+			Fingerprint: "SELECT * FROM city",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := a.Run(ctx)
+		assert.EqualError(t, err, `query fingerprint mismatch: expected "SELECT * FROM city", got "SELECT pg_sleep($1)"`)
+		assert.NoError(t, <-errCh)
+	})
+
+	t.Run("Idle", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+		var pid int32
+		err = conn.QueryRowContext(context.Background(), "SELECT pg_backend_pid()").Scan(&pid)
+		require.NoError(t, err)
+
+		a := NewPostgreSQLCancelQueryAction(PostgreSQLCancelQueryActionParams{
+			DSN:         dsn,
+			TempDir:     os.TempDir(),
+			PID:         pid,
+			Fingerprint: "SELECT pg_backend_pid()",
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err = a.Run(ctx)
+		assert.EqualError(t, err, `backend `+strconv.Itoa(int(pid))+` is not running a query (state "idle")`)
+	})
+}
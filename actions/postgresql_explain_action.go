@@ -0,0 +1,255 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// defaultPostgreSQLExplainTimeout is statement_timeout for EXPLAIN ANALYZE if not set.
+const defaultPostgreSQLExplainTimeout = 10 * time.Second
+
+// PostgreSQLExplainActionParams represent PostgreSQL EXPLAIN Action params.
+type PostgreSQLExplainActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	Query   string
+	TempDir string
+
+	// Analyze runs EXPLAIN (ANALYZE, BUFFERS) in a read-only transaction that is rolled back;
+	// DML queries are converted to SELECT for that.
+	Analyze bool
+	// Timeout is statement_timeout for EXPLAIN ANALYZE.
+	Timeout time.Duration
+}
+
+type postgresqlExplainAction struct {
+	id      string
+	dsn     string
+	files   *agentpb.TextFiles
+	query   string
+	tempDir string
+	analyze bool
+	timeout time.Duration
+}
+
+// NewPostgreSQLExplainAction creates PostgreSQL EXPLAIN Action.
+// This is an Action that can run `EXPLAIN (FORMAT JSON)` command on PostgreSQL service with given DSN.
+// Queries with $n placeholders (as in pg_stat_statements) are explained with a generic plan.
+//
+// The client does not start it yet: agentpb has no StartActionRequest params for PostgreSQL EXPLAIN,
+// so it should be wired into client.processChannelRequests together with the API change.
+func NewPostgreSQLExplainAction(params PostgreSQLExplainActionParams) Action {
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = defaultPostgreSQLExplainTimeout
+	}
+
+	return &postgresqlExplainAction{
+		id:      params.ID,
+		dsn:     params.DSN,
+		files:   params.Files,
+		query:   params.Query,
+		tempDir: params.TempDir,
+		analyze: params.Analyze,
+		timeout: timeout,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlExplainAction) ID() string {
+	return a.id
+}
+
+// Type returns an Action type.
+func (a *postgresqlExplainAction) Type() string {
+	return "postgresql-explain"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlExplainAction) Run(ctx context.Context) ([]byte, error) {
+	query := strings.TrimRight(strings.TrimSpace(a.query), "; \t\n")
+	// see postgresqlQuerySelectAction
+	if strings.Contains(query, ";") {
+		return nil, errors.New("query contains ';'")
+	}
+
+	isDMLQuery := isDMLQuery(query, dialectPostgreSQL)
+	placeholders := maxPlaceholder(query)
+	if a.analyze {
+		if placeholders > 0 {
+			return nil, errors.New("EXPLAIN ANALYZE is not possible for query with placeholders")
+		}
+
+		// read-only transaction does not allow DML
+		if isDMLQuery {
+			var err error
+			if query, err = dmlToSelect(query, dialectPostgreSQL); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dsn, err := templates.RenderDSN(a.dsn, a.files, filepath.Join(a.tempDir, strings.ToLower(a.Type()), a.id))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	// Explain a query in a transaction to be able to rollback any harm done by functions.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: a.analyze})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("SET /* pmm-agent */ LOCAL statement_timeout = %d", a.timeout.Milliseconds())); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var b []byte
+	switch {
+	case a.analyze:
+		b, err = explainPostgreSQL(ctx, tx, "FORMAT JSON, ANALYZE, BUFFERS", query)
+	case placeholders > 0:
+		b, err = explainPostgreSQLGeneric(ctx, tx, query, placeholders)
+	default:
+		b, err = explainPostgreSQL(ctx, tx, "FORMAT JSON", query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := explainResponse{
+		ExplainResult: b,
+		Query:         query,
+		IsDMLQuery:    isDMLQuery,
+	}
+	response.NormalizedPlan, _ = normalizePostgreSQLPlan(b)
+	if b, err = json.Marshal(response); err != nil {
+		return nil, errCannotEncodeExplainResponse
+	}
+	return b, nil
+}
+
+func (a *postgresqlExplainAction) sealed() {}
+
+// explainPostgreSQL runs EXPLAIN with given options and returns JSON plan.
+func explainPostgreSQL(ctx context.Context, tx *sql.Tx, options, query string) ([]byte, error) {
+	var b []byte
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("EXPLAIN /* pmm-agent */ (%s) %s", options, query)).Scan(&b)
+	return b, errors.WithStack(err)
+}
+
+// explainPostgreSQLGeneric returns JSON generic plan of the query with given number of $n placeholders.
+// PostgreSQL 16+ supports GENERIC_PLAN option directly; for older versions,
+// query is prepared and executed with NULL parameters with plan_cache_mode forcing generic plan (12+).
+func explainPostgreSQLGeneric(ctx context.Context, tx *sql.Tx, query string, placeholders int) ([]byte, error) {
+	var v string
+	if err := tx.QueryRowContext(ctx, "SHOW /* pmm-agent */ server_version_num").Scan(&v); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	switch {
+	case version >= 160000:
+		return explainPostgreSQL(ctx, tx, "FORMAT JSON, GENERIC_PLAN", query)
+	case version < 120000:
+		return nil, errors.New("generic plan for query with placeholders requires PostgreSQL 12 or later")
+	}
+
+	if _, err = tx.ExecContext(ctx, "SET /* pmm-agent */ LOCAL plan_cache_mode = force_generic_plan"); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// prepared statements are not transactional
+	const name = "pmm_agent_explain"
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("PREPARE %s AS %s", name, query)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer tx.ExecContext(context.Background(), "DEALLOCATE "+name) //nolint:errcheck
+
+	args := strings.TrimSuffix(strings.Repeat("NULL, ", placeholders), ", ")
+	return explainPostgreSQL(ctx, tx, "FORMAT JSON", fmt.Sprintf("EXECUTE %s(%s)", name, args))
+}
+
+// maxPlaceholder returns the maximal number n of $n placeholders in the query, or 0 if there are none.
+// Placeholders in string literals, quoted identifiers and comments are ignored.
+func maxPlaceholder(query string) int {
+	var res int
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return res
+			}
+			i += end + 1
+
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return res
+			}
+			i += end
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return res
+			}
+			i += end + 3
+
+		case c == '$' && (i == 0 || !isIdentChar(query[i-1])):
+			j := i + 1
+			for j < len(query) && '0' <= query[j] && query[j] <= '9' {
+				j++
+			}
+			if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n > res {
+				res = n
+			}
+			i = j - 1
+		}
+	}
+	return res
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
@@ -0,0 +1,160 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLExplain(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+	db := tests.OpenTestPostgreSQL(t)
+	defer db.Close() //nolint:errcheck
+
+	run := func(t *testing.T, params PostgreSQLExplainActionParams) (*explainResponse, error) {
+		t.Helper()
+
+		params.DSN = dsn
+		params.TempDir = os.TempDir()
+		a := NewPostgreSQLExplainAction(params)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.Logf("Full JSON:\n%s", b)
+
+		var er explainResponse
+		require.NoError(t, json.Unmarshal(b, &er))
+		return &er, nil
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		er, err := run(t, PostgreSQLExplainActionParams{Query: "SELECT * FROM city WHERE id = 1;"})
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM city WHERE id = 1", er.Query)
+		assert.False(t, er.IsDMLQuery)
+
+		var plan []map[string]interface{}
+		require.NoError(t, json.Unmarshal(er.ExplainResult, &plan))
+		require.Len(t, plan, 1)
+		assert.Contains(t, plan[0], "Plan")
+		assert.NotContains(t, plan[0], "Execution Time")
+
+		require.NotNil(t, er.NormalizedPlan)
+		assert.Nil(t, er.NormalizedPlan.ActualRows)
+	})
+
+	t.Run("DML", func(t *testing.T) {
+		er, err := run(t, PostgreSQLExplainActionParams{Query: "DELETE FROM city WHERE id = 1"})
+		require.NoError(t, err)
+		assert.True(t, er.IsDMLQuery)
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM city WHERE id = 1").Scan(&count))
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Analyze", func(t *testing.T) {
+		er, err := run(t, PostgreSQLExplainActionParams{Query: "SELECT * FROM city WHERE id = 1", Analyze: true})
+		require.NoError(t, err)
+
+		var plan []map[string]interface{}
+		require.NoError(t, json.Unmarshal(er.ExplainResult, &plan))
+		require.Len(t, plan, 1)
+		assert.Contains(t, plan[0], "Execution Time")
+
+		require.NotNil(t, er.NormalizedPlan)
+		assert.NotNil(t, er.NormalizedPlan.ActualRows)
+	})
+
+	t.Run("AnalyzeDML", func(t *testing.T) {
+		er, err := run(t, PostgreSQLExplainActionParams{Query: "UPDATE city SET name = 'Kabul' WHERE id = 1", Analyze: true})
+		require.NoError(t, err)
+		assert.True(t, er.IsDMLQuery)
+		assert.Equal(t, "SELECT name = 'Kabul' FROM city WHERE id = 1", er.Query)
+	})
+
+	t.Run("AnalyzeTimeout", func(t *testing.T) {
+		_, err := run(t, PostgreSQLExplainActionParams{
+			Query:   "SELECT pg_sleep(2)",
+			Analyze: true,
+			Timeout: 100 * time.Millisecond,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "canceling statement due to statement timeout")
+	})
+
+	t.Run("AnalyzeReadOnly", func(t *testing.T) {
+		_, err := run(t, PostgreSQLExplainActionParams{
+			Query:   "SELECT nextval(pg_get_serial_sequence('city', 'id'))",
+			Analyze: true,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "read-only transaction")
+	})
+
+	t.Run("Placeholders", func(t *testing.T) {
+		er, err := run(t, PostgreSQLExplainActionParams{Query: "SELECT * FROM city WHERE id = $1 AND name <> $2"})
+		require.NoError(t, err)
+
+		var plan []map[string]interface{}
+		require.NoError(t, json.Unmarshal(er.ExplainResult, &plan))
+		require.Len(t, plan, 1)
+		assert.Contains(t, plan[0], "Plan")
+	})
+
+	t.Run("AnalyzePlaceholders", func(t *testing.T) {
+		_, err := run(t, PostgreSQLExplainActionParams{Query: "SELECT * FROM city WHERE id = $1", Analyze: true})
+		require.EqualError(t, err, "EXPLAIN ANALYZE is not possible for query with placeholders")
+	})
+
+	t.Run("MultipleStatements", func(t *testing.T) {
+		_, err := run(t, PostgreSQLExplainActionParams{Query: "SELECT 1; DROP TABLE city"})
+		require.EqualError(t, err, "query contains ';'")
+	})
+}
+
+func TestMaxPlaceholder(t *testing.T) {
+	t.Parallel()
+
+	for query, expected := range map[string]int{
+		"SELECT 1":  0,
+		"SELECT $1": 1,
+		"SELECT * FROM t WHERE a = $2 AND b = $10":   10,
+		"SELECT '$5', \"$6\", $1":                    1,
+		"SELECT $1 -- $3\n":                          1,
+		"SELECT /* $3 */ $2":                         2,
+		"SELECT $$dollar$$":                          0,
+		"SELECT a$1 FROM t":                          0,
+		"SELECT * FROM t WHERE a = $1 AND b = 'open": 1,
+	} {
+		assert.Equal(t, expected, maxPlaceholder(query), "%q", query)
+	}
+}
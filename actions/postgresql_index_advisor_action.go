@@ -0,0 +1,280 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// PostgreSQLIndexAdvisorActionParams represent PostgreSQL index advisor Action params.
+type PostgreSQLIndexAdvisorActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	Query   string
+	TempDir string
+}
+
+type postgresqlIndexAdvisorAction struct {
+	params PostgreSQLIndexAdvisorActionParams
+}
+
+// NewPostgreSQLIndexAdvisorAction creates PostgreSQL index advisor Action.
+// This is an Action that explains given query, reads indexes and statistics of touched tables,
+// and returns ranked suggestions: missing composite indexes, duplicate and unused indexes.
+//
+// Like the MySQL index advisor, it is not started by the client until agentpb defines its params.
+func NewPostgreSQLIndexAdvisorAction(params PostgreSQLIndexAdvisorActionParams) Action {
+	return &postgresqlIndexAdvisorAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlIndexAdvisorAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlIndexAdvisorAction) Type() string {
+	return "postgresql-index-advisor"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlIndexAdvisorAction) Run(ctx context.Context) ([]byte, error) {
+	explain := NewPostgreSQLExplainAction(PostgreSQLExplainActionParams{
+		ID:      a.params.ID,
+		DSN:     a.params.DSN,
+		Files:   a.params.Files,
+		Query:   a.params.Query,
+		TempDir: a.params.TempDir,
+	})
+	b, err := explain.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var er explainResponse
+	if err = json.Unmarshal(b, &er); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if er.NormalizedPlan == nil {
+		return nil, errors.New("cannot parse explain output")
+	}
+
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	res := indexAdvice{
+		Query: er.Query,
+	}
+	tables := make(map[string]*tableMetadata)
+	walkPlan(er.NormalizedPlan, func(node *planNode) {
+		// CTE and function scans have no indexes
+		if node.Object == "" || tables[node.Object] != nil || node.NodeType == "CTE Scan" || node.NodeType == "Function Scan" {
+			return
+		}
+
+		table, err := postgresqlTableMetadata(ctx, db, node.Object)
+		if err != nil {
+			res.Notes = append(res.Notes, fmt.Sprintf("table %s is not analyzed: %s", node.Object, err))
+			return
+		}
+		tables[node.Object] = table
+	})
+
+	suggestions, notes := adviseIndexes(er.NormalizedPlan, tables, postgresqlIndexDialect{})
+	res.Suggestions = append([]indexSuggestion{}, suggestions...)
+	res.Notes = append(res.Notes, notes...)
+	return json.Marshal(res)
+}
+
+func (a *postgresqlIndexAdvisorAction) sealed() {}
+
+// postgresqlTableMetadata returns metadata of given table (with optional schema) from pg_class, pg_indexes,
+// pg_stats and pg_stat_user_indexes.
+func postgresqlTableMetadata(ctx context.Context, db *sql.DB, table string) (*tableMetadata, error) {
+	var schema, name string
+	var rows float64
+	err := db.QueryRowContext(ctx, "SELECT /* pmm-agent */ n.nspname, c.relname, c.reltuples "+
+		"FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.oid = to_regclass($1)", table).Scan(&schema, &name, &rows)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Errorf("table %q not found", table)
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	res := &tableMetadata{
+		Name:        table,
+		Rows:        rows,
+		Cardinality: make(map[string]float64),
+	}
+
+	columns, dataRows, err := postgresqlShowIndex(ctx, db, schema+"."+name)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range dataRows {
+		index, err := parsePostgreSQLIndexDef(metadataString(metadataValue(columns, row, "indexdef")))
+		if err != nil {
+			return nil, err
+		}
+		index.Name = metadataString(metadataValue(columns, row, "indexname"))
+		res.Indexes = append(res.Indexes, *index)
+	}
+
+	// negative n_distinct is a fraction of rows
+	statsRows, err := db.QueryContext(ctx, "SELECT /* pmm-agent */ attname, n_distinct "+
+		"FROM pg_stats WHERE schemaname = $1 AND tablename = $2", schema, name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer statsRows.Close() //nolint:errcheck
+	for statsRows.Next() {
+		var column string
+		var distinct float64
+		if err = statsRows.Scan(&column, &distinct); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if distinct < 0 {
+			distinct = -distinct * rows
+		}
+		res.Cardinality[column] = distinct
+	}
+	if err = statsRows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	unusedRows, err := db.QueryContext(ctx, "SELECT /* pmm-agent */ indexrelname "+
+		"FROM pg_stat_user_indexes WHERE schemaname = $1 AND relname = $2 AND idx_scan = 0", schema, name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer unusedRows.Close() //nolint:errcheck
+	unused := make(map[string]bool)
+	for unusedRows.Next() {
+		var index string
+		if err = unusedRows.Scan(&index); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		unused[index] = true
+	}
+	if err = unusedRows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := range res.Indexes {
+		res.Indexes[i].Unused = unused[res.Indexes[i].Name]
+	}
+
+	return res, nil
+}
+
+// parsePostgreSQLIndexDef returns index (without name) for pg_indexes.indexdef value like
+// `CREATE UNIQUE INDEX city_pkey ON public.city USING btree (id)`.
+func parsePostgreSQLIndexDef(def string) (*tableIndex, error) {
+	tokens, err := lexQuery(def, dialectPostgreSQL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+
+	p := &queryParser{tokens: tokens, dialect: dialectPostgreSQL}
+	if err = p.expect("CREATE"); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+	res := &tableIndex{
+		Unique: p.accept("UNIQUE"),
+	}
+	if _, err = p.untilKeyword("USING"); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+	if err = p.expect("USING"); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+	res.Type = p.peek().text
+	p.pos++
+	group, err := p.group()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+
+	for _, column := range splitList(group[1 : len(group)-1]) {
+		// column name with optional collation, operator class and ordering, or expression
+		name := renderTokens(column)
+		if isNameToken(column[0]) && (len(column) == 1 || column[1].kind == tokenWord) {
+			name = unquoteIdent(column[0])
+		}
+		res.Columns = append(res.Columns, name)
+	}
+
+	rest, err := p.untilKeyword()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse index definition %q", def)
+	}
+	res.Partial = containsKeyword(rest, "WHERE")
+	return res, nil
+}
+
+// postgresqlIndexDialect builds PostgreSQL index statements.
+type postgresqlIndexDialect struct{}
+
+func (postgresqlIndexDialect) createIndex(table string, columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pq.QuoteIdentifier(c)
+	}
+	return fmt.Sprintf("CREATE INDEX CONCURRENTLY ON %s (%s)", quotePostgreSQLName(table), strings.Join(quoted, ", "))
+}
+
+func (postgresqlIndexDialect) dropIndex(table, index string) string {
+	// index is in the same schema as table
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		index = table[:i] + "." + index
+	}
+	return fmt.Sprintf("DROP INDEX CONCURRENTLY %s", quotePostgreSQLName(index))
+}
+
+// quotePostgreSQLName returns quoted PostgreSQL name with optional schema.
+func quotePostgreSQLName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// check interfaces
+var (
+	_ indexDialect = postgresqlIndexDialect{}
+)
@@ -0,0 +1,57 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLIndexAdvisor(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+
+	t.Run("MissingIndex", func(t *testing.T) {
+		a := NewPostgreSQLIndexAdvisorAction(PostgreSQLIndexAdvisorActionParams{
+			DSN:     dsn,
+			Query:   "SELECT * FROM city WHERE population > 1000000 AND district = 'Noord-Holland'",
+			TempDir: os.TempDir(),
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := a.Run(ctx)
+		require.NoError(t, err)
+		t.Logf("Full JSON:\n%s", b)
+
+		var actual indexAdvice
+		require.NoError(t, json.Unmarshal(b, &actual))
+		require.NotEmpty(t, actual.Suggestions)
+		assert.Equal(t, indexSuggestionMissing, actual.Suggestions[0].Kind)
+		assert.Equal(t, "city", actual.Suggestions[0].Table)
+		assert.Equal(t, []string{"district", "population"}, actual.Suggestions[0].Columns)
+		assert.Equal(t, `CREATE INDEX CONCURRENTLY ON "city" ("district", "population")`, actual.Suggestions[0].Statement)
+	})
+}
@@ -0,0 +1,136 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// defaultPostgreSQLLongTransactionDuration is a default minimal duration of returned transactions.
+const defaultPostgreSQLLongTransactionDuration = time.Minute
+
+// postgresqlLongTransactionsQuery returns long-running and idle-in-transaction sessions and all replication slots.
+// Both may hold back xmin horizon, preventing vacuum from removing dead tuples;
+// holds_xmin_horizon is true for the oldest ones. Replication slots also retain WAL files.
+const postgresqlLongTransactionsQuery = `WITH horizon AS (
+  SELECT max(age(xmin)) AS xmin_age FROM (
+    SELECT backend_xmin AS xmin FROM pg_stat_activity
+    UNION ALL SELECT backend_xid FROM pg_stat_activity
+    UNION ALL SELECT xmin FROM pg_replication_slots
+    UNION ALL SELECT catalog_xmin FROM pg_replication_slots
+    UNION ALL SELECT transaction FROM pg_prepared_xacts
+  ) x
+  WHERE xmin IS NOT NULL
+),
+current_lsn AS (
+  SELECT CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END AS lsn
+),
+sessions AS (
+  SELECT 'session' AS kind, pid, NULL AS slot_name, usename, datname, application_name, host(client_addr) AS client_addr, state,
+    EXTRACT(EPOCH FROM clock_timestamp() - xact_start)::bigint AS xact_age_seconds,
+    EXTRACT(EPOCH FROM clock_timestamp() - state_change)::bigint AS state_age_seconds,
+    backend_xid::text AS xid, greatest(age(backend_xid), age(backend_xmin)) AS xmin_age,
+    NULL::bigint AS retained_wal_bytes, query
+  FROM pg_stat_activity
+  WHERE pid <> pg_backend_pid() AND xact_start IS NOT NULL
+    AND (state LIKE 'idle in transaction%' OR clock_timestamp() - xact_start >= make_interval(secs => $1))
+),
+slots AS (
+  SELECT 'replication_slot' AS kind, active_pid, slot_name::text, NULL, database::text, NULL, NULL,
+    CASE WHEN active THEN 'active' ELSE 'inactive' END,
+    NULL::bigint, NULL::bigint, NULL::text, greatest(age(xmin), age(catalog_xmin)),
+    pg_wal_lsn_diff((SELECT lsn FROM current_lsn), restart_lsn)::bigint, NULL
+  FROM pg_replication_slots
+)
+SELECT /* pmm-agent */ r.*, coalesce(r.xmin_age = (SELECT xmin_age FROM horizon), false) AS holds_xmin_horizon
+FROM (SELECT * FROM sessions UNION ALL SELECT * FROM slots) r
+ORDER BY r.kind = 'session' DESC, r.xact_age_seconds DESC, r.retained_wal_bytes DESC`
+
+// PostgreSQLLongTransactionsActionParams represent PostgreSQL long transactions Action params.
+type PostgreSQLLongTransactionsActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+
+	// MinDuration is a minimal duration of returned transactions; idle in transaction sessions are always returned.
+	MinDuration time.Duration
+}
+
+type postgresqlLongTransactionsAction struct {
+	params PostgreSQLLongTransactionsActionParams
+}
+
+// NewPostgreSQLLongTransactionsAction creates PostgreSQL long transactions Action.
+// This is an Action that returns long-running and idle-in-transaction sessions, and replication slots,
+// with their xmin horizon and WAL retention impact.
+// It is not started by the client yet, see NewPostgreSQLBlockingTreeAction.
+func NewPostgreSQLLongTransactionsAction(params PostgreSQLLongTransactionsActionParams) Action {
+	if params.MinDuration <= 0 {
+		params.MinDuration = defaultPostgreSQLLongTransactionDuration
+	}
+	return &postgresqlLongTransactionsAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlLongTransactionsAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlLongTransactionsAction) Type() string {
+	return "postgresql-long-transactions"
+}
+
+// Run runs an Action and returns output and error.
+func (a *postgresqlLongTransactionsAction) Run(ctx context.Context) ([]byte, error) {
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	rows, err := db.QueryContext(ctx, postgresqlLongTransactionsQuery, a.params.MinDuration.Seconds())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	columns, dataRows, err := readRows(rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *postgresqlLongTransactionsAction) sealed() {}
@@ -0,0 +1,83 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLLongTransactions(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+	db := tests.OpenTestPostgreSQL(t)
+	defer db.Close() //nolint:errcheck
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+	defer tx.Rollback() //nolint:errcheck
+	var pid float64
+	var xid string
+	err = tx.QueryRow("SELECT pg_backend_pid(), txid_current()::text").Scan(&pid, &xid)
+	require.NoError(t, err)
+
+	a := NewPostgreSQLLongTransactionsAction(PostgreSQLLongTransactionsActionParams{
+		DSN:         dsn,
+		TempDir:     os.TempDir(),
+		MinDuration: time.Hour,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual [][]interface{}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+
+	columns := actual[0]
+	assert.Equal(t, []interface{}{
+		"kind", "pid", "slot_name", "usename", "datname", "application_name", "client_addr", "state",
+		"xact_age_seconds", "state_age_seconds", "xid", "xmin_age", "retained_wal_bytes", "query", "holds_xmin_horizon",
+	}, columns)
+
+	// idle in transaction session is returned regardless of MinDuration
+	var found map[string]interface{}
+	for _, row := range actual[1:] {
+		m := make(map[string]interface{}, len(row))
+		for i, v := range row {
+			m[columns[i].(string)] = v
+		}
+		if m["pid"] == pid {
+			found = m
+		}
+	}
+	require.NotNil(t, found)
+	assert.Equal(t, "session", found["kind"])
+	assert.Equal(t, "idle in transaction", found["state"])
+	assert.Equal(t, xid, found["xid"])
+	assert.NotNil(t, found["xmin_age"])
+}
@@ -69,27 +69,36 @@ func (a *postgresqlShowIndexAction) Run(ctx context.Context) ([]byte, error) {
 	db := sql.OpenDB(connector)
 	defer db.Close() //nolint:errcheck
 
+	columns, dataRows, err := postgresqlShowIndex(ctx, db, a.params.Table)
+	if err != nil {
+		return nil, err
+	}
+	return jsonRows(columns, dataRows)
+}
+
+func (a *postgresqlShowIndexAction) sealed() {}
+
+// postgresqlShowIndex returns columns and data rows of pg_indexes for given table with optional schema.
+func postgresqlShowIndex(ctx context.Context, db *sql.DB, table string) ([]string, [][]interface{}, error) {
 	var namespaceQuery string
 	var args []interface{}
-	table := strings.Split(a.params.Table, ".")
-	switch len(table) {
+	parts := strings.Split(table, ".")
+	switch len(parts) {
 	case 2:
-		args = append(args, table[1], table[0])
+		args = append(args, parts[1], parts[0])
 		namespaceQuery = "AND schemaname = $2"
 	case 1:
-		args = append(args, table[0])
+		args = append(args, parts[0])
 	}
 	// TODO: Throw error if table doesn't exist.
 	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT /* pmm-agent */ * FROM pg_indexes WHERE tablename = $1 %s", namespaceQuery), args...)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 
 	columns, dataRows, err := readRows(rows)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
-	return jsonRows(columns, dataRows)
+	return columns, dataRows, nil
 }
-
-func (a *postgresqlShowIndexAction) sealed() {}
@@ -0,0 +1,485 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/utils/templates"
+)
+
+// PostgreSQLSummaryActionParams represent PostgreSQL summary Action params.
+type PostgreSQLSummaryActionParams struct {
+	ID      string
+	DSN     string
+	Files   *agentpb.TextFiles
+	TempDir string
+}
+
+type postgresqlSummaryAction struct {
+	params PostgreSQLSummaryActionParams
+}
+
+// NewPostgreSQLSummaryAction creates PostgreSQL summary Action.
+// This is an Action that collects the same information as pt-pg-summary using lib/pq
+// and returns it as JSON document with summary data and text report.
+// The runner uses RunStream that sends only the text report, as the replaced tool did.
+func NewPostgreSQLSummaryAction(params PostgreSQLSummaryActionParams) Action {
+	return &postgresqlSummaryAction{
+		params: params,
+	}
+}
+
+// ID returns an Action ID.
+func (a *postgresqlSummaryAction) ID() string {
+	return a.params.ID
+}
+
+// Type returns an Action type.
+func (a *postgresqlSummaryAction) Type() string {
+	return "postgresql-summary"
+}
+
+// Run runs an Action and returns output and error.
+// Output is a JSON document with summary data and text report.
+func (a *postgresqlSummaryAction) Run(ctx context.Context) ([]byte, error) {
+	summary, report, err := a.summary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSummary(summary, report)
+}
+
+// RunStream runs an Action and passes output chunks to send.
+// Output is a text report only, the same as pt-pg-summary output that PMM Server expects.
+func (a *postgresqlSummaryAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	_, report, err := a.summary(ctx)
+	if err != nil {
+		return err
+	}
+	return sendSummaryReport(report, send)
+}
+
+// summary collects summary data and text report.
+func (a *postgresqlSummaryAction) summary(ctx context.Context) (*postgresqlSummary, string, error) {
+	dsn, err := templates.RenderDSN(a.params.DSN, a.params.Files, filepath.Join(a.params.TempDir, strings.ToLower(a.Type()), a.params.ID))
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close() //nolint:errcheck
+
+	summary, err := getPostgreSQLSummary(ctx, db)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var report strings.Builder
+	if err = writePostgreSQLSummaryReport(&report, summary); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return summary, report.String(), nil
+}
+
+func (a *postgresqlSummaryAction) sealed() {}
+
+// check interfaces
+var (
+	_ Action          = (*postgresqlSummaryAction)(nil)
+	_ StreamingAction = (*postgresqlSummaryAction)(nil)
+)
+
+// postgresqlSummary represents PostgreSQL instance summary.
+// Optional sections are empty if they are not applicable to the instance or can't be collected;
+// the reason of the latter is added to notes.
+type postgresqlSummary struct {
+	Server       postgresqlServerSummary       `json:"server"`
+	Settings     []postgresqlSetting           `json:"settings"`
+	Databases    []postgresqlDatabaseSummary   `json:"databases"`
+	Connections  map[string]int64              `json:"connections"`
+	CacheHitRate float64                       `json:"cache_hit_ratio"`
+	Replicas     []postgresqlReplica           `json:"replicas,omitempty"`
+	WALReceiver  *postgresqlWALReceiver        `json:"wal_receiver,omitempty"`
+	Slots        []postgresqlReplicationSlot   `json:"replication_slots,omitempty"`
+	Tablespaces  []postgresqlTablespaceSummary `json:"tablespaces,omitempty"`
+	Extensions   []postgresqlExtension         `json:"extensions,omitempty"`
+	Notes        []string                      `json:"notes,omitempty"`
+}
+
+// postgresqlServerSummary represents general server information.
+type postgresqlServerSummary struct {
+	Version        string    `json:"version"`
+	VersionNum     int64     `json:"version_num"`
+	StartTime      time.Time `json:"start_time"`
+	UptimeSeconds  int64     `json:"uptime_seconds"`
+	InRecovery     bool      `json:"in_recovery"`
+	MaxConnections int64     `json:"max_connections"`
+}
+
+// postgresqlSetting represents pg_settings row changed from default value.
+type postgresqlSetting struct {
+	Name    string `json:"name"`
+	Setting string `json:"setting"`
+	Unit    string `json:"unit"`
+	Source  string `json:"source"`
+}
+
+// postgresqlDatabaseSummary represents pg_stat_database row.
+type postgresqlDatabaseSummary struct {
+	Name          string  `json:"name"`
+	SizeBytes     *int64  `json:"size_bytes"` // nil if there is no CONNECT privilege
+	Backends      int64   `json:"backends"`
+	XactCommit    int64   `json:"xact_commit"`
+	XactRollback  int64   `json:"xact_rollback"`
+	BlocksRead    int64   `json:"blks_read"`
+	BlocksHit     int64   `json:"blks_hit"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+	TempBytes     int64   `json:"temp_bytes"`
+	Deadlocks     int64   `json:"deadlocks"`
+}
+
+// postgresqlReplica represents pg_stat_replication row.
+type postgresqlReplica struct {
+	ApplicationName string `json:"application_name"`
+	ClientAddr      string `json:"client_addr"`
+	State           string `json:"state"`
+	SyncState       string `json:"sync_state"`
+	ReplayLagBytes  int64  `json:"replay_lag_bytes"`
+}
+
+// postgresqlWALReceiver represents standby status.
+type postgresqlWALReceiver struct {
+	Status           string `json:"status"`
+	ReplayLagSeconds int64  `json:"replay_lag_seconds"`
+}
+
+// postgresqlReplicationSlot represents pg_replication_slots row.
+type postgresqlReplicationSlot struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Active        bool   `json:"active"`
+	RetainedBytes int64  `json:"retained_bytes"`
+}
+
+// postgresqlTablespaceSummary represents pg_tablespace row.
+type postgresqlTablespaceSummary struct {
+	Name      string `json:"name"`
+	Location  string `json:"location"`
+	SizeBytes *int64 `json:"size_bytes"` // nil if there are no privileges
+}
+
+// postgresqlExtension represents pg_extension row of the current database.
+type postgresqlExtension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Queries for optional summary sections; they require PostgreSQL 10+.
+const (
+	postgresqlSummaryReplicasQuery = `SELECT /* pmm-agent */ coalesce(application_name, ''), coalesce(client_addr::text, ''),
+  coalesce(state, ''), coalesce(sync_state, ''), coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn), 0)::bigint
+FROM pg_stat_replication
+ORDER BY application_name`
+
+	postgresqlSummaryWALReceiverQuery = `SELECT /* pmm-agent */
+  coalesce((SELECT status FROM pg_stat_wal_receiver), ''),
+  coalesce(extract(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)::bigint`
+
+	postgresqlSummarySlotsQuery = `SELECT /* pmm-agent */ slot_name, slot_type, active,
+  coalesce(pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END, restart_lsn), 0)::bigint
+FROM pg_replication_slots
+ORDER BY slot_name`
+
+	postgresqlSummaryTablespacesQuery = `SELECT /* pmm-agent */ spcname, pg_tablespace_location(oid),
+  CASE WHEN has_tablespace_privilege(oid, 'CREATE') OR pg_has_role('pg_read_all_stats', 'USAGE') THEN pg_tablespace_size(oid) END
+FROM pg_tablespace
+ORDER BY spcname`
+)
+
+// getPostgreSQLSummary collects PostgreSQL instance summary.
+// Only server information and databases query failures are fatal.
+func getPostgreSQLSummary(ctx context.Context, db *sql.DB) (*postgresqlSummary, error) {
+	summary := &postgresqlSummary{
+		Connections: make(map[string]int64),
+	}
+
+	s := &summary.Server
+	err := db.QueryRowContext(ctx, "SELECT /* pmm-agent */ version(), current_setting('server_version_num')::bigint, "+
+		"pg_postmaster_start_time(), extract(epoch FROM now() - pg_postmaster_start_time())::bigint, "+
+		"pg_is_in_recovery(), current_setting('max_connections')::bigint").
+		Scan(&s.Version, &s.VersionNum, &s.StartTime, &s.UptimeSeconds, &s.InRecovery, &s.MaxConnections)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get server information")
+	}
+	s.StartTime = s.StartTime.UTC()
+
+	rows, err := db.QueryContext(ctx, "SELECT /* pmm-agent */ d.datname, "+
+		"CASE WHEN has_database_privilege(d.oid, 'CONNECT') THEN pg_database_size(d.oid) END, "+
+		"s.numbackends, s.xact_commit, s.xact_rollback, s.blks_read, s.blks_hit, s.temp_bytes, s.deadlocks "+
+		"FROM pg_database d JOIN pg_stat_database s ON s.datid = d.oid "+
+		"WHERE d.datallowconn ORDER BY d.datname")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get databases")
+	}
+	var blocksRead, blocksHit int64
+	for rows.Next() {
+		var d postgresqlDatabaseSummary
+		var size sql.NullInt64
+		if err = rows.Scan(&d.Name, &size, &d.Backends, &d.XactCommit, &d.XactRollback,
+			&d.BlocksRead, &d.BlocksHit, &d.TempBytes, &d.Deadlocks); err != nil {
+			rows.Close() //nolint:errcheck
+			return nil, errors.WithStack(err)
+		}
+		if size.Valid {
+			d.SizeBytes = &size.Int64
+		}
+		d.CacheHitRatio = cacheHitRatio(d.BlocksHit, d.BlocksRead)
+		blocksRead += d.BlocksRead
+		blocksHit += d.BlocksHit
+		summary.Databases = append(summary.Databases, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	summary.CacheHitRate = cacheHitRatio(blocksHit, blocksRead)
+
+	// optional sections
+	queryRows := func(section, query string, scan func(*sql.Rows) error) {
+		rows, err := db.QueryContext(ctx, query)
+		if err == nil {
+			defer rows.Close() //nolint:errcheck
+			for rows.Next() && err == nil {
+				err = scan(rows)
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+		}
+		if err != nil {
+			summary.Notes = append(summary.Notes, fmt.Sprintf("%s: %s", section, err))
+		}
+	}
+
+	queryRows("settings", "SELECT /* pmm-agent */ name, setting, coalesce(unit, ''), source "+
+		"FROM pg_settings WHERE source NOT IN ('default', 'override') ORDER BY name", func(rows *sql.Rows) error {
+		var setting postgresqlSetting
+		if err := rows.Scan(&setting.Name, &setting.Setting, &setting.Unit, &setting.Source); err != nil {
+			return err
+		}
+		summary.Settings = append(summary.Settings, setting)
+		return nil
+	})
+
+	queryRows("connections", "SELECT /* pmm-agent */ coalesce(state, 'background'), count(*) "+
+		"FROM pg_stat_activity GROUP BY 1", func(rows *sql.Rows) error {
+		var state string
+		var count int64
+		if err := rows.Scan(&state, &count); err != nil {
+			return err
+		}
+		summary.Connections[state] = count
+		return nil
+	})
+
+	if s.InRecovery {
+		queryRows("wal receiver", postgresqlSummaryWALReceiverQuery, func(rows *sql.Rows) error {
+			summary.WALReceiver = new(postgresqlWALReceiver)
+			return rows.Scan(&summary.WALReceiver.Status, &summary.WALReceiver.ReplayLagSeconds)
+		})
+	} else {
+		queryRows("replication", postgresqlSummaryReplicasQuery, func(rows *sql.Rows) error {
+			var r postgresqlReplica
+			err := rows.Scan(&r.ApplicationName, &r.ClientAddr, &r.State, &r.SyncState, &r.ReplayLagBytes)
+			summary.Replicas = append(summary.Replicas, r)
+			return err
+		})
+	}
+
+	queryRows("replication slots", postgresqlSummarySlotsQuery, func(rows *sql.Rows) error {
+		var slot postgresqlReplicationSlot
+		if err := rows.Scan(&slot.Name, &slot.Type, &slot.Active, &slot.RetainedBytes); err != nil {
+			return err
+		}
+		summary.Slots = append(summary.Slots, slot)
+		return nil
+	})
+
+	queryRows("tablespaces", postgresqlSummaryTablespacesQuery, func(rows *sql.Rows) error {
+		var ts postgresqlTablespaceSummary
+		var size sql.NullInt64
+		if err := rows.Scan(&ts.Name, &ts.Location, &size); err != nil {
+			return err
+		}
+		if size.Valid {
+			ts.SizeBytes = &size.Int64
+		}
+		summary.Tablespaces = append(summary.Tablespaces, ts)
+		return nil
+	})
+
+	queryRows("extensions", "SELECT /* pmm-agent */ extname, extversion FROM pg_extension ORDER BY extname", func(rows *sql.Rows) error {
+		var ext postgresqlExtension
+		if err := rows.Scan(&ext.Name, &ext.Version); err != nil {
+			return err
+		}
+		summary.Extensions = append(summary.Extensions, ext)
+		return nil
+	})
+
+	return summary, nil
+}
+
+// cacheHitRatio returns the ratio of buffer cache hits to all block reads, or 0 if there were no reads.
+func cacheHitRatio(hit, read int64) float64 {
+	if hit+read == 0 {
+		return 0
+	}
+	return float64(hit) / float64(hit+read)
+}
+
+// writePostgreSQLSummaryReport writes text report in pt-pg-summary format.
+func writePostgreSQLSummaryReport(w io.Writer, s *postgresqlSummary) error {
+	section := func(title string) { writeReportSection(w, title) }
+	value := func(name string, format string, args ...interface{}) { writeReportValue(w, name, format, args...) }
+	table := func(header string, rows func(tw io.Writer)) error {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, header)
+		rows(tw)
+		return tw.Flush()
+	}
+	size := func(b *int64) string {
+		if b == nil {
+			return "-"
+		}
+		return humanizeBytes(*b)
+	}
+
+	section("Server")
+	value("Version", "%s", s.Server.Version)
+	value("Started", "%s", s.Server.StartTime.Format(time.RFC3339))
+	value("Uptime", "%s", humanizeSeconds(s.Server.UptimeSeconds))
+	value("In Recovery", "%t", s.Server.InRecovery)
+	value("Cache Hit Ratio", "%.2f%%", s.CacheHitRate*100)
+
+	section("Connections")
+	value("Max Connections", "%d", s.Server.MaxConnections)
+	states := make([]string, 0, len(s.Connections))
+	for state := range s.Connections {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		value(state, "%d", s.Connections[state])
+	}
+
+	section("Databases")
+	err := table("  Database\tSize\tBackends\tCommits\tRollbacks\tCache Hit Ratio\tTemp Bytes\tDeadlocks\t", func(tw io.Writer) {
+		for _, d := range s.Databases {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%d\t%.2f%%\t%s\t%d\t\n", d.Name, size(d.SizeBytes), d.Backends,
+				d.XactCommit, d.XactRollback, d.CacheHitRatio*100, humanizeBytes(d.TempBytes), d.Deadlocks)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(s.Replicas) != 0 {
+		section("Replication")
+		err = table("  Application\tClient\tState\tSync State\tReplay Lag\t", func(tw io.Writer) {
+			for _, r := range s.Replicas {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t\n", r.ApplicationName, r.ClientAddr, r.State, r.SyncState, humanizeBytes(r.ReplayLagBytes))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.WALReceiver != nil {
+		section("Standby")
+		value("WAL Receiver", "%s", s.WALReceiver.Status)
+		value("Replay Lag", "%s", humanizeSeconds(s.WALReceiver.ReplayLagSeconds))
+	}
+
+	if len(s.Slots) != 0 {
+		section("Replication slots")
+		err = table("  Slot\tType\tActive\tRetained WAL\t", func(tw io.Writer) {
+			for _, slot := range s.Slots {
+				fmt.Fprintf(tw, "  %s\t%s\t%t\t%s\t\n", slot.Name, slot.Type, slot.Active, humanizeBytes(slot.RetainedBytes))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.Tablespaces) != 0 {
+		section("Tablespaces")
+		err = table("  Name\tLocation\tSize\t", func(tw io.Writer) {
+			for _, ts := range s.Tablespaces {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t\n", ts.Name, ts.Location, size(ts.SizeBytes))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.Extensions) != 0 {
+		section("Extensions")
+		for _, ext := range s.Extensions {
+			value(ext.Name, "%s", ext.Version)
+		}
+	}
+
+	if len(s.Settings) != 0 {
+		section("Settings")
+		for _, setting := range s.Settings {
+			v := setting.Setting
+			if setting.Unit != "" {
+				v += " " + setting.Unit
+			}
+			value(setting.Name, "%s (%s)", v, setting.Source)
+		}
+	}
+
+	if len(s.Notes) != 0 {
+		section("Notes")
+		for _, n := range s.Notes {
+			fmt.Fprintf(w, "  %s\n", n)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/utils/tests"
+)
+
+func TestPostgreSQLSummary(t *testing.T) {
+	t.Parallel()
+
+	dsn := tests.GetTestPostgreSQLDSN(t)
+
+	a := NewPostgreSQLSummaryAction(PostgreSQLSummaryActionParams{
+		DSN:     dsn,
+		TempDir: os.TempDir(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b, err := a.Run(ctx)
+	require.NoError(t, err)
+
+	var actual struct {
+		Summary postgresqlSummary
+		Report  string
+	}
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	t.Logf("Report:\n%s", actual.Report)
+
+	assert.Contains(t, actual.Summary.Server.Version, "PostgreSQL")
+	assert.False(t, actual.Summary.Server.InRecovery)
+	assert.NotZero(t, actual.Summary.Server.MaxConnections)
+	assert.Empty(t, actual.Summary.Notes)
+
+	var names []string
+	for _, d := range actual.Summary.Databases {
+		names = append(names, d.Name)
+	}
+	assert.Contains(t, names, "pmm-agent")
+	assert.Contains(t, actual.Report, "# Databases ")
+}
+
+func TestWritePostgreSQLSummaryReport(t *testing.T) {
+	t.Parallel()
+
+	s := &postgresqlSummary{
+		Server: postgresqlServerSummary{
+			Version:        "PostgreSQL 14.2",
+			VersionNum:     140002,
+			StartTime:      time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC),
+			UptimeSeconds:  3661,
+			MaxConnections: 100,
+		},
+		Settings: []postgresqlSetting{
+			{Name: "shared_buffers", Setting: "16384", Unit: "8kB", Source: "configuration file"},
+		},
+		Databases: []postgresqlDatabaseSummary{
+			{Name: "postgres", SizeBytes: pointer.ToInt64(8 << 20), Backends: 1, XactCommit: 10, BlocksHit: 99, BlocksRead: 1, CacheHitRatio: 0.99},
+			{Name: "secret", Backends: 0},
+		},
+		Connections:  map[string]int64{"active": 1, "idle": 2, "background": 5},
+		CacheHitRate: 0.99,
+		Replicas: []postgresqlReplica{
+			{ApplicationName: "walreceiver", ClientAddr: "10.0.0.2/32", State: "streaming", SyncState: "async", ReplayLagBytes: 2048},
+		},
+		Tablespaces: []postgresqlTablespaceSummary{
+			{Name: "pg_default", SizeBytes: pointer.ToInt64(1 << 30)},
+		},
+		Extensions: []postgresqlExtension{
+			{Name: "plpgsql", Version: "1.0"},
+		},
+		Notes: []string{"replication slots: pq: permission denied"},
+	}
+
+	var report strings.Builder
+	err := writePostgreSQLSummaryReport(&report, s)
+	require.NoError(t, err)
+	actual := report.String()
+	t.Logf("Report:\n%s", actual)
+
+	for _, expected := range []string{
+		"                  Version | PostgreSQL 14.2\n",
+		"                   Uptime | 0 days, 1 hours, 1 minutes, 1 seconds\n",
+		"          Cache Hit Ratio | 99.00%\n",
+		"               background | 5\n",
+		"  postgres  8.00 MiB  1         10       0          99.00%           0 B         0          \n",
+		"  secret    -         0         0        0          0.00%            0 B         0          \n",
+		"  walreceiver  10.0.0.2/32  streaming  async       2.00 KiB    \n",
+		"  pg_default            1.00 GiB  \n",
+		"                  plpgsql | 1.0\n",
+		"           shared_buffers | 16384 8kB (configuration file)\n",
+		"  replication slots: pq: permission denied\n",
+	} {
+		assert.Contains(t, actual, expected)
+	}
+	assert.NotContains(t, actual, "# Standby")
+	assert.NotContains(t, actual, "# Replication slots")
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0.0, cacheHitRatio(0, 0))
+	assert.Equal(t, 0.75, cacheHitRatio(3, 1))
+}
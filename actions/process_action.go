@@ -63,4 +63,64 @@ func (p *processAction) Run(ctx context.Context) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+// RunStream runs an Action and passes output chunks to send as they are written.
+func (p *processAction) RunStream(ctx context.Context, send func(Chunk) error) error {
+	cmd := exec.CommandContext(ctx, p.command, p.arg...) //nolint:gosec
+
+	// restrict process
+	cmd.Env = []string{} // do not inherit environment
+	cmd.Dir = "/"
+	pdeathsig.Set(cmd, unix.SIGKILL)
+
+	w := &chunkWriter{send: send}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	if e := w.flush(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// chunkWriter is an io.Writer that passes data to send in chunks of maxChunkSize.
+type chunkWriter struct {
+	send func(Chunk) error
+	buf  []byte
+}
+
+// Write implements io.Writer.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := maxChunkSize - len(w.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+
+		if len(w.buf) == maxChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush sends buffered data, if any.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.send(Chunk{Data: w.buf})
+	w.buf = nil
+	return err
+}
+
 func (*processAction) sealed() {}
+
+// check interfaces
+var (
+	_ StreamingAction = (*processAction)(nil)
+)
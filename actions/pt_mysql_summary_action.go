@@ -37,6 +37,9 @@ type ptMySQLSummaryAction struct {
 //
 // PTMySQL Summary Action, it's an abstract Action that can run an external commands.
 // This commands can be a shell script, script written on interpreted language, or binary file.
+//
+// Deprecated: the client runs NewMySQLSummaryAction that does not need pt-mysql-summary.
+// This Action is kept for existing users of the package and will be removed in a separate change.
 func NewPTMySQLSummaryAction(id string, cmd string, params *agentpb.StartActionRequest_PTMySQLSummaryParams) Action {
 	return &ptMySQLSummaryAction{
 		id:      id,
@@ -0,0 +1,457 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// queryDialect represents SQL dialect for query lexing and parsing.
+type queryDialect int
+
+const (
+	dialectMySQL queryDialect = iota
+	dialectPostgreSQL
+)
+
+// tokenKind represents a kind of query token.
+type tokenKind int
+
+const (
+	tokenWord        tokenKind = iota // keyword or unquoted identifier
+	tokenQuotedIdent                  // `identifier` (MySQL) or "identifier" (PostgreSQL)
+	tokenString                       // 'string' or "string" (MySQL), 'string', E'string' or $tag$string$tag$ (PostgreSQL)
+	tokenNumber                       // 42, 4.2e1, 0x2A
+	tokenVariable                     // @var, @@var, ? (MySQL) or $1 (PostgreSQL)
+	tokenHint                         // /*+ optimizer hints */
+	tokenPunct                        // operator or punctuation character
+)
+
+// token represents a single query token.
+type token struct {
+	kind  tokenKind
+	text  string
+	space bool // true if token is preceded by whitespace or comment
+}
+
+// is returns true if token is a keyword equal to one of given ones (case-insensitive).
+func (t token) is(keywords ...string) bool {
+	if t.kind != tokenWord {
+		return false
+	}
+	for _, kw := range keywords {
+		if strings.EqualFold(t.text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPunct returns true if token is a given punctuation character.
+func (t token) isPunct(c byte) bool {
+	return t.kind == tokenPunct && t.text[0] == c
+}
+
+// lexQuery is a hand-written lexer that splits query into tokens, skipping whitespace and comments.
+// For MySQL, content of version comments (/*!50100 ... */) is tokenized as MySQL server does.
+// For PostgreSQL, strings are standard conforming (backslash escapes are recognized only in E” strings).
+// On error, it returns tokens found before it.
+func lexQuery(query string, dialect queryDialect) ([]token, error) {
+	mysql := dialect == dialectMySQL
+	var res []token
+	var space, versionComment bool
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			space = true
+			continue
+
+		case mysql && (c == '#' || (strings.HasPrefix(query[i:], "--") && (i+2 == len(query) || query[i+2] <= ' '))),
+			!mysql && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+			space = true
+			continue
+
+		case !mysql && strings.HasPrefix(query[i:], "/*"):
+			// comments can be nested
+			depth := 1
+			for i += 2; depth > 0; {
+				switch {
+				case i >= len(query):
+					return res, errors.New("unterminated comment")
+				case strings.HasPrefix(query[i:], "/*"):
+					depth++
+					i += 2
+				case strings.HasPrefix(query[i:], "*/"):
+					depth--
+					i += 2
+				default:
+					i++
+				}
+			}
+			space = true
+			continue
+
+		case mysql && strings.HasPrefix(query[i:], "/*!"):
+			if versionComment {
+				return res, errors.New("nested version comment")
+			}
+			i += 3
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+			versionComment = true
+			space = true
+			continue
+
+		case versionComment && strings.HasPrefix(query[i:], "*/"):
+			i += 2
+			versionComment = false
+			space = true
+			continue
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return res, errors.New("unterminated comment")
+			}
+			i += 2 + end + 2
+			if query[start+2] != '+' {
+				space = true
+				continue
+			}
+			res = append(res, token{kind: tokenHint, text: query[start:i], space: space})
+
+		case c == '\'' || c == '"' || (mysql && c == '`'):
+			end := quoteEnd(query, i, mysql && c != '`')
+			if end < 0 {
+				return res, errors.Errorf("unterminated quoted %s", query[i:])
+			}
+			i = end
+			kind := tokenString
+			if c == '`' || (!mysql && c == '"') {
+				kind = tokenQuotedIdent
+			}
+			res = append(res, token{kind: kind, text: query[start:i], space: space})
+
+		case !mysql && (c == 'E' || c == 'e') && i+1 < len(query) && query[i+1] == '\'':
+			end := quoteEnd(query, i+1, true)
+			if end < 0 {
+				return res, errors.Errorf("unterminated quoted %s", query[i:])
+			}
+			i = end
+			res = append(res, token{kind: tokenString, text: query[start:i], space: space})
+
+		case !mysql && c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			for i++; i < len(query) && isDigit(query[i]); i++ {
+			}
+			res = append(res, token{kind: tokenVariable, text: query[start:i], space: space})
+
+		case !mysql && c == '$':
+			end := dollarQuoteEnd(query, i)
+			if end < 0 {
+				return res, errors.Errorf("unterminated quoted %s", query[i:])
+			}
+			i = end
+			kind := tokenString
+			if i == start+1 {
+				kind = tokenPunct
+			}
+			res = append(res, token{kind: kind, text: query[start:i], space: space})
+
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			for i++; i < len(query); i++ {
+				if isIdentChar(query[i]) || query[i] == '.' {
+					continue
+				}
+				// exponent sign
+				if (query[i] == '+' || query[i] == '-') && (query[i-1] == 'e' || query[i-1] == 'E') && isDigit(query[start]) {
+					continue
+				}
+				break
+			}
+			res = append(res, token{kind: tokenNumber, text: query[start:i], space: space})
+
+		case isIdentChar(c) || c >= 0x80:
+			for i < len(query) && (isIdentChar(query[i]) || query[i] >= 0x80) {
+				i++
+			}
+			res = append(res, token{kind: tokenWord, text: query[start:i], space: space})
+
+		case mysql && c == '@':
+			for i++; i < len(query) && query[i] == '@'; i++ {
+			}
+			if i < len(query) && (query[i] == '\'' || query[i] == '"' || query[i] == '`') {
+				if i = quoteEnd(query, i, query[i] != '`'); i < 0 {
+					return res, errors.Errorf("unterminated quoted %s", query[start:])
+				}
+			}
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '.' || query[i] >= 0x80) {
+				i++
+			}
+			res = append(res, token{kind: tokenVariable, text: query[start:i], space: space})
+
+		case mysql && c == '?':
+			i++
+			res = append(res, token{kind: tokenVariable, text: query[start:i], space: space})
+
+		default:
+			i++
+			res = append(res, token{kind: tokenPunct, text: query[start:i], space: space})
+		}
+
+		space = false
+	}
+
+	if versionComment {
+		return res, errors.New("unterminated comment")
+	}
+	return res, nil
+}
+
+// quoteEnd returns the position after the closing quote for a quoted string or identifier
+// starting at position i, or -1 if it is not terminated.
+// Doubled quotes are handled; backslash escapes are handled if backslash is true.
+func quoteEnd(query string, i int, backslash bool) int {
+	q := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case q:
+			if i+1 < len(query) && query[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// dollarQuoteEnd returns the position after the closing delimiter for a PostgreSQL dollar-quoted string
+// ($$string$$ or $tag$string$tag$) starting at position i, or -1 if it is not terminated.
+// If $ does not start a delimiter, the position after it is returned.
+func dollarQuoteEnd(query string, i int) int {
+	j := i + 1
+	for j < len(query) && query[j] != '$' && (isIdentChar(query[j]) || query[j] >= 0x80) {
+		j++
+	}
+	if j == len(query) || query[j] != '$' {
+		return i + 1
+	}
+
+	delim := query[i : j+1]
+	end := strings.Index(query[j+1:], delim)
+	if end < 0 {
+		return -1
+	}
+	return j + 1 + end + len(delim)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// queryBuilder builds a query from keywords and tokens.
+type queryBuilder struct {
+	strings.Builder
+}
+
+// keyword appends keyword (or any other text) separated by space.
+func (b *queryBuilder) keyword(s string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(s)
+}
+
+// tokens appends tokens separated by space, keeping original spacing between them.
+func (b *queryBuilder) tokens(tokens []token) {
+	for i, t := range tokens {
+		if b.Len() > 0 && (i == 0 || t.space) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+}
+
+// renderTokens returns query text for given tokens.
+func renderTokens(tokens []token) string {
+	var b queryBuilder
+	b.tokens(tokens)
+	return b.String()
+}
+
+// queryParser is a hand-written recursive descent parser of DML statement structure for MySQL and PostgreSQL.
+// It is not a full SQL grammar: it recognizes statement keywords and clause boundaries only.
+// Table references, expressions, conditions and subqueries are not parsed: they are returned
+// as token sequences with balanced parentheses, and are copied to the resulting query as is.
+// Constructs it does not recognize are reported as errors instead of being guessed.
+type queryParser struct {
+	tokens  []token
+	pos     int
+	dialect queryDialect
+}
+
+// eof returns true if all tokens are consumed.
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the current token, or zero token at the end.
+func (p *queryParser) peek() token {
+	return p.peekAt(p.pos)
+}
+
+// peekAt returns the token at given position, or zero token if there is none.
+func (p *queryParser) peekAt(pos int) token {
+	if pos >= len(p.tokens) {
+		return token{}
+	}
+	return p.tokens[pos]
+}
+
+// accept consumes the current token if it is one of given keywords.
+func (p *queryParser) accept(keywords ...string) bool {
+	if p.peek().is(keywords...) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptPunct consumes the current token if it is a given punctuation character.
+func (p *queryParser) acceptPunct(c byte) bool {
+	if p.peek().isPunct(c) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes given keyword or returns error.
+func (p *queryParser) expect(keyword string) error {
+	if !p.accept(keyword) {
+		return p.unexpected(keyword)
+	}
+	return nil
+}
+
+// unexpected returns error for the current token.
+func (p *queryParser) unexpected(expected string) error {
+	if p.eof() {
+		return errors.Errorf("expected %s, got end of query", expected)
+	}
+	return errors.Errorf("expected %s, got %q", expected, p.peek().text)
+}
+
+// until consumes and returns tokens until stop returns true for the current token
+// outside of parentheses, or until the end of the statement.
+func (p *queryParser) until(stop func() bool) ([]token, error) {
+	start := p.pos
+	var depth int
+	for ; !p.eof(); p.pos++ {
+		t := p.peek()
+		switch {
+		case t.isPunct(';'):
+			if depth != 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+			return p.tokens[start:p.pos], nil
+		case depth == 0 && stop():
+			return p.tokens[start:p.pos], nil
+		case t.isPunct('('):
+			depth++
+		case t.isPunct(')'):
+			if depth == 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+			depth--
+		}
+	}
+
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return p.tokens[start:p.pos], nil
+}
+
+// untilKeyword consumes and returns tokens until one of given keywords outside of parentheses.
+func (p *queryParser) untilKeyword(keywords ...string) ([]token, error) {
+	return p.until(func() bool { return p.peek().is(keywords...) })
+}
+
+// group consumes and returns parenthesized tokens, including parentheses.
+func (p *queryParser) group() ([]token, error) {
+	start := p.pos
+	if !p.acceptPunct('(') {
+		return nil, p.unexpected("(")
+	}
+	if _, err := p.until(func() bool { return p.peek().isPunct(')') }); err != nil {
+		return nil, err
+	}
+	if !p.acceptPunct(')') {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return p.tokens[start:p.pos], nil
+}
+
+// splitList splits tokens by commas outside of parentheses.
+func splitList(tokens []token) [][]token {
+	var res [][]token
+	var depth, start int
+	for i, t := range tokens {
+		switch {
+		case t.isPunct('('):
+			depth++
+		case t.isPunct(')'):
+			depth--
+		case t.isPunct(',') && depth == 0:
+			res = append(res, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, tokens[start:])
+}
+
+// containsKeyword returns true if tokens contain one of given keywords outside of parentheses.
+func containsKeyword(tokens []token, keywords ...string) bool {
+	var depth int
+	for _, t := range tokens {
+		switch {
+		case t.isPunct('('):
+			depth++
+		case t.isPunct(')'):
+			depth--
+		case depth == 0 && t.is(keywords...):
+			return true
+		}
+	}
+	return false
+}
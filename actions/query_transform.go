@@ -16,25 +16,32 @@
 package actions
 
 import (
-	"fmt"
-	"regexp"
-	"strings"
+	"github.com/pkg/errors"
 )
 
-//nolint:lll
-var (
-	dmlVerbs         = []string{"insert", "update", "delete", "replace"}
-	updateRe         = regexp.MustCompile(`(?i)^update\s+(?:low_priority|ignore)?\s*(.*?)\s+set\s+(.*?)(?:\s+where\s+(.*?))?(?:\s+limit\s*[0-9]+(?:\s*,\s*[0-9]+)?)?$`)
-	deleteRe         = regexp.MustCompile(`(?i)^delete\s+(.*?)\bfrom\s+(.*?)$`)
-	insertRe         = regexp.MustCompile(`(?i)^(?:insert(?:\s+ignore)?|replace)\s+.*?\binto\s+(.*?)\(([^\)]+)\)\s*values?\s*\((.*?)\)\s*(?:\slimit\s|on\s+duplicate\s+key.*)?\s*$`)
-	insertReNoFields = regexp.MustCompile(`(?i)^(?:insert(?:\s+ignore)?|replace)\s+.*?\binto\s+(.*?)\s*values?\s*\((.*?)\)\s*(?:\slimit\s|on\s+duplicate\s+key.*)?\s*$`)
-	insertSetRe      = regexp.MustCompile(`(?i)(?:insert(?:\s+ignore)?|replace)\s+(?:.*?\binto)\s+(.*?)\s*set\s+(.*?)\s*(?:\blimit\b|on\s+duplicate\s+key.*)?\s*$`)
-)
+var dmlVerbs = []string{"INSERT", "UPDATE", "DELETE", "REPLACE"}
+
+func isDMLQuery(query string, dialect queryDialect) bool {
+	// tokens before lexing error are enough
+	tokens, _ := lexQuery(query, dialect)
+	if len(tokens) == 0 {
+		return false
+	}
+	if !tokens[0].is("WITH") {
+		return tokens[0].is(dmlVerbs...)
+	}
 
-func isDMLQuery(query string) bool {
-	query = strings.ToLower(strings.TrimSpace(query))
-	for _, verb := range dmlVerbs {
-		if strings.HasPrefix(query, verb) {
+	// the first statement keyword after common table expressions
+	var depth int
+	for _, t := range tokens[1:] {
+		switch {
+		case t.isPunct('('):
+			depth++
+		case t.isPunct(')'):
+			depth--
+		case depth == 0 && t.is("SELECT", "TABLE", "VALUES"):
+			return false
+		case depth == 0 && t.is(dmlVerbs...):
 			return true
 		}
 	}
@@ -47,78 +54,659 @@ Newer MySQL versions can run explain on DML queries but higher persmissions
 are needed and the pmm user is a not privileged user.
 This function converts DML queries to the equivalent SELECT to make
 it able to explain DML queries on older MySQL versions and for unprivileged users.
+For PostgreSQL, it is used for EXPLAIN ANALYZE in a read-only transaction.
+
+The SELECT reads the same rows as the DML query:
+  - UPDATE: assignments FROM table references (and PostgreSQL's FROM list) with WHERE, ORDER BY and LIMIT;
+  - DELETE: deleted tables' columns FROM table references (and PostgreSQL's USING list) with WHERE, ORDER BY and LIMIT;
+  - INSERT/REPLACE ... VALUES/SET: rows with inserted values (for duplicate key check);
+  - INSERT/REPLACE ... SELECT/TABLE: the source query.
+
+MySQL's ON DUPLICATE KEY UPDATE and PostgreSQL's ON CONFLICT and RETURNING clauses are dropped.
+
+The conversion works on statement structure recognized by queryParser, not on a full SQL grammar;
+unrecognized statements are rejected with an error. PostgreSQL's WHERE CURRENT OF is rejected too:
+it refers to the current row of a cursor that exists only in the session that runs the original query,
+so there is no equivalent SELECT.
 */
-func dmlToSelect(query string) string {
-	query = strings.ReplaceAll(query, "\n", " ")
+func dmlToSelect(query string, dialect queryDialect) (string, error) {
+	tokens, err := lexQuery(query, dialect)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot convert query to SELECT")
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].isPunct(';') {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	p := &queryParser{tokens: tokens, dialect: dialect}
+	res, err := p.dmlToSelect()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot convert query to SELECT")
+	}
+	return res, nil
+}
+
+// dmlToSelect parses DML statement and returns the equivalent SELECT.
+func (p *queryParser) dmlToSelect() (string, error) {
+	var b queryBuilder
+	if p.peek().is("WITH") {
+		with, err := p.with()
+		if err != nil {
+			return "", err
+		}
+		b.tokens(with)
+	}
+
+	var err error
+	switch {
+	case p.accept("UPDATE"):
+		err = p.update(&b)
+	case p.accept("DELETE"):
+		err = p.delete(&b)
+	case p.accept("INSERT"), p.dialect == dialectMySQL && p.accept("REPLACE"):
+		err = p.insert(&b)
+	case p.dialect == dialectMySQL:
+		err = p.unexpected("INSERT, UPDATE, DELETE or REPLACE")
+	default:
+		err = p.unexpected("INSERT, UPDATE or DELETE")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !p.eof() {
+		return "", p.unexpected("end of query")
+	}
+	return b.String(), nil
+}
+
+// with parses common table expressions and returns their tokens.
+func (p *queryParser) with() ([]token, error) {
+	start := p.pos
+	if err := p.expect("WITH"); err != nil {
+		return nil, err
+	}
+	p.accept("RECURSIVE")
+
+	for {
+		if _, err := p.untilKeyword("AS"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		if p.dialect == dialectPostgreSQL {
+			p.accept("NOT")
+			p.accept("MATERIALIZED")
+		}
+		if _, err := p.group(); err != nil {
+			return nil, err
+		}
+		if !p.acceptPunct(',') {
+			return p.tokens[start:p.pos], nil
+		}
+	}
+}
+
+// returning consumes optional PostgreSQL RETURNING clause.
+// It does not change rows that are read by DML statement.
+func (p *queryParser) returning() error {
+	if p.dialect != dialectPostgreSQL || !p.accept("RETURNING") {
+		return nil
+	}
+	list, err := p.untilKeyword()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return p.unexpected("output expression")
+	}
+	return nil
+}
+
+// hints consumes and returns optimizer hints.
+func (p *queryParser) hints() []token {
+	start := p.pos
+	for p.peek().kind == tokenHint {
+		p.pos++
+	}
+	return p.tokens[start:p.pos]
+}
+
+// modifiers consumes given statement modifiers.
+func (p *queryParser) modifiers(modifiers ...string) {
+	for p.accept(modifiers...) {
+	}
+}
+
+// whereOrderLimit parses optional WHERE, ORDER BY and LIMIT clauses and appends them to the builder.
+func (p *queryParser) whereOrderLimit(b *queryBuilder) error {
+	if p.accept("WHERE") {
+		// cursor position can't be expressed as a condition, see dmlToSelect
+		if p.peek().is("CURRENT") && p.peekAt(p.pos+1).is("OF") {
+			return errors.New("WHERE CURRENT OF is not supported")
+		}
+		where, err := p.untilKeyword("ORDER", "LIMIT", "RETURNING")
+		if err != nil {
+			return err
+		}
+		if len(where) == 0 {
+			return p.unexpected("WHERE condition")
+		}
+		b.keyword("WHERE")
+		b.tokens(where)
+	}
+
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return err
+		}
+		order, err := p.untilKeyword("LIMIT", "RETURNING")
+		if err != nil {
+			return err
+		}
+		b.keyword("ORDER BY")
+		b.tokens(order)
+	}
+
+	if p.accept("LIMIT") {
+		limit, err := p.untilKeyword("RETURNING")
+		if err != nil {
+			return err
+		}
+		b.keyword("LIMIT")
+		b.tokens(limit)
+	}
+
+	return nil
+}
+
+// update parses UPDATE statement after UPDATE keyword.
+//
+//	UPDATE [LOW_PRIORITY] [IGNORE] table_references SET assignment_list
+//	  [WHERE where_condition] [ORDER BY ...] [LIMIT row_count]
+//
+// PostgreSQL:
+//
+//	UPDATE [ONLY] table_name [*] [[AS] alias] SET assignment_list
+//	  [FROM from_item [, ...]] [WHERE condition] [RETURNING ...]
+func (p *queryParser) update(b *queryBuilder) error {
+	hints := p.hints()
+	if p.dialect == dialectMySQL {
+		p.modifiers("LOW_PRIORITY", "IGNORE")
+	}
+
+	tables, err := p.untilKeyword("SET")
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return p.unexpected("table")
+	}
+	if err = p.expect("SET"); err != nil {
+		return err
+	}
+
+	// for MySQL, FROM and RETURNING are not valid there, but stop at them for a clear error
+	assignments, err := p.untilKeyword("WHERE", "ORDER", "LIMIT", "FROM", "RETURNING")
+	if err != nil {
+		return err
+	}
+	if len(assignments) == 0 {
+		return p.unexpected("assignment")
+	}
+
+	var from []token
+	if p.dialect == dialectPostgreSQL && p.accept("FROM") {
+		if from, err = p.untilKeyword("WHERE", "RETURNING"); err != nil {
+			return err
+		}
+		if len(from) == 0 {
+			return p.unexpected("table")
+		}
+	}
+
+	b.keyword("SELECT")
+	b.tokens(hints)
+	b.tokens(assignments)
+	b.keyword("FROM")
+	b.tokens(tables)
+	if len(from) != 0 {
+		b.WriteByte(',')
+		b.tokens(from)
+	}
+	if err = p.whereOrderLimit(b); err != nil {
+		return err
+	}
+	return p.returning()
+}
+
+// delete parses DELETE statement after DELETE keyword.
+//
+//	DELETE [LOW_PRIORITY] [QUICK] [IGNORE] FROM tbl_name [[AS] tbl_alias] [PARTITION (...)]
+//	  [WHERE where_condition] [ORDER BY ...] [LIMIT row_count]
+//	DELETE [LOW_PRIORITY] [QUICK] [IGNORE] tbl_name[.*] [, tbl_name[.*]] ...
+//	  FROM table_references [WHERE where_condition]
+//	DELETE [LOW_PRIORITY] [QUICK] [IGNORE] FROM tbl_name[.*] [, tbl_name[.*]] ...
+//	  USING table_references [WHERE where_condition]
+//
+// PostgreSQL:
+//
+//	DELETE FROM [ONLY] table_name [*] [[AS] alias]
+//	  [USING from_item [, ...]] [WHERE condition] [RETURNING ...]
+func (p *queryParser) delete(b *queryBuilder) error {
+	if p.dialect == dialectPostgreSQL {
+		return p.deletePostgreSQL(b)
+	}
+
+	hints := p.hints()
+	p.modifiers("LOW_PRIORITY", "QUICK", "IGNORE")
 
-	m := updateRe.FindStringSubmatch(query)
-	// > 2 because we need at least a table name and a list of fields
-	if len(m) > 2 {
-		return updateToSelect(m)
+	var targets, tables []token
+	var err error
+	if p.accept("FROM") {
+		if tables, err = p.untilKeyword("USING", "WHERE", "ORDER", "LIMIT"); err != nil {
+			return err
+		}
+		if p.accept("USING") {
+			targets = tables
+			if tables, err = p.untilKeyword("WHERE", "ORDER", "LIMIT"); err != nil {
+				return err
+			}
+		}
+	} else {
+		if targets, err = p.untilKeyword("FROM"); err != nil {
+			return err
+		}
+		if err = p.expect("FROM"); err != nil {
+			return err
+		}
+		if tables, err = p.untilKeyword("WHERE", "ORDER", "LIMIT"); err != nil {
+			return err
+		}
+	}
+	if len(tables) == 0 {
+		return p.unexpected("table")
 	}
 
-	m = deleteRe.FindStringSubmatch(query)
-	if len(m) > 1 {
-		return deleteToSelect(m)
+	b.keyword("SELECT")
+	b.tokens(hints)
+	switch {
+	case len(targets) != 0:
+		for i, target := range splitList(targets) {
+			if len(target) == 0 {
+				return errors.New("empty table name")
+			}
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.tokens(target)
+			if !target[len(target)-1].isPunct('*') {
+				b.WriteString(".*")
+			}
+		}
+	case len(splitList(tables)) > 1 || containsKeyword(tables, "JOIN", "STRAIGHT_JOIN"):
+		// deleted table is unknown
+		b.keyword("1")
+	default:
+		b.keyword("*")
 	}
+	b.keyword("FROM")
+	b.tokens(tables)
+	return p.whereOrderLimit(b)
+}
 
-	m = insertRe.FindStringSubmatch(query)
-	if len(m) > 2 {
-		return insertToSelect(m)
+// deletePostgreSQL parses PostgreSQL DELETE statement after DELETE keyword.
+// Unlike MySQL, USING list does not include the target table.
+func (p *queryParser) deletePostgreSQL(b *queryBuilder) error {
+	if err := p.expect("FROM"); err != nil {
+		return err
+	}
+	table, err := p.untilKeyword("USING", "WHERE", "RETURNING")
+	if err != nil {
+		return err
+	}
+	if len(table) == 0 {
+		return p.unexpected("table")
 	}
 
-	m = insertSetRe.FindStringSubmatch(query)
-	if len(m) > 2 {
-		return insertWithSetToSelect(m)
+	var using []token
+	if p.accept("USING") {
+		if using, err = p.untilKeyword("WHERE", "RETURNING"); err != nil {
+			return err
+		}
+		if len(using) == 0 {
+			return p.unexpected("table")
+		}
+	}
+
+	b.keyword("SELECT")
+	if len(using) == 0 {
+		b.keyword("*")
+	} else {
+		ref := tableReference(table)
+		if len(ref) == 0 {
+			return errors.New("empty table name")
+		}
+		b.tokens(ref)
+		b.WriteString(".*")
+	}
+	b.keyword("FROM")
+	b.tokens(table)
+	if len(using) != 0 {
+		b.WriteByte(',')
+		b.tokens(using)
 	}
+	if err = p.whereOrderLimit(b); err != nil {
+		return err
+	}
+	return p.returning()
+}
 
-	m = insertReNoFields.FindStringSubmatch(query)
-	if len(m) > 2 {
-		return insertToSelectNoFields(m)
+// tableReference returns tokens referencing PostgreSQL table in the select list:
+// alias if present, otherwise the (possibly qualified) table name.
+// It accepts tokens like `ONLY schema.table * AS alias`.
+func tableReference(table []token) []token {
+	if len(table) > 0 && table[0].is("ONLY") {
+		table = table[1:]
 	}
 
-	return ""
+	// name is a sequence of identifiers separated by dots
+	var end int
+	for ; end < len(table); end++ {
+		t := table[end]
+		if t.isPunct('.') {
+			continue
+		}
+		if (t.kind != tokenWord && t.kind != tokenQuotedIdent) || (end > 0 && !table[end-1].isPunct('.')) {
+			break
+		}
+	}
+	name, rest := table[:end], table[end:]
+
+	if len(rest) > 0 && rest[0].isPunct('*') {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0].is("AS") {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return rest[:1]
+	}
+	return name
 }
 
-func updateToSelect(matches []string) string {
-	matches = matches[1:]
-	matches[0], matches[1] = matches[1], matches[0]
-	format := []string{"SELECT %s", " FROM %s", " WHERE %s"}
-	result := ""
-	for i, match := range matches {
-		if match != "" {
-			result += fmt.Sprintf(format[i], match)
+// insert parses INSERT or REPLACE statement after INSERT or REPLACE keyword.
+//
+//	INSERT [LOW_PRIORITY | DELAYED | HIGH_PRIORITY] [IGNORE] [INTO] tbl_name [PARTITION (...)] [(col_name [, col_name] ...)]
+//	  { {VALUES | VALUE} (value_list) [, (value_list)] ... | VALUES ROW(value_list)[, ROW(value_list)][, ...] }
+//	  [AS row_alias[(col_alias [, col_alias] ...)]] [ON DUPLICATE KEY UPDATE assignment_list]
+//	INSERT ... [INTO] tbl_name [PARTITION (...)] SET assignment_list [AS ...] [ON DUPLICATE KEY UPDATE ...]
+//	INSERT ... [INTO] tbl_name [PARTITION (...)] [(col_name [, col_name] ...)]
+//	  { SELECT ... | TABLE table_name | VALUES row_constructor_list } [ON DUPLICATE KEY UPDATE ...]
+//
+// PostgreSQL:
+//
+//	INSERT INTO table_name [AS alias] [(column_name [, ...])] [OVERRIDING {SYSTEM | USER} VALUE]
+//	  { DEFAULT VALUES | VALUES (expression [, ...]) [, ...] | query }
+//	  [ON CONFLICT [conflict_target] conflict_action] [RETURNING ...]
+func (p *queryParser) insert(b *queryBuilder) error {
+	mysql := p.dialect == dialectMySQL
+	if mysql {
+		p.hints()
+		p.modifiers("LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE")
+		p.accept("INTO")
+	} else if err := p.expect("INTO"); err != nil {
+		return err
+	}
+
+	table, err := p.until(func() bool {
+		t := p.peek()
+		if mysql {
+			return t.isPunct('(') || t.is("PARTITION", "VALUES", "VALUE", "SET", "SELECT", "TABLE", "WITH")
+		}
+		return t.isPunct('(') || t.is("OVERRIDING", "DEFAULT", "VALUES", "SELECT", "TABLE", "WITH")
+	})
+	if err != nil {
+		return err
+	}
+	if len(table) == 0 {
+		return p.unexpected("table")
+	}
+	if mysql && p.accept("PARTITION") {
+		partition, err := p.group()
+		if err != nil {
+			return err
+		}
+		table = p.tokens[p.pos-len(partition)-len(table)-1 : p.pos]
+	}
+
+	var columns [][]token
+	if p.peek().isPunct('(') && !p.peekAt(p.pos+1).is("SELECT", "WITH") {
+		group, err := p.group()
+		if err != nil {
+			return err
+		}
+		if group = group[1 : len(group)-1]; len(group) != 0 {
+			columns = splitList(group)
+		}
+	}
+
+	if !mysql && p.accept("OVERRIDING") {
+		p.modifiers("SYSTEM", "USER")
+		if err = p.expect("VALUE"); err != nil {
+			return err
+		}
+	}
+
+	var conditions [][]token
+	switch {
+	case !mysql && p.peek().is("DEFAULT") && p.peekAt(p.pos+1).is("VALUES"):
+		// default values are unknown
+		p.pos += 2
+
+	case p.peek().is("VALUES", "VALUE") && (p.peekAt(p.pos+1).isPunct('(') || p.peekAt(p.pos+1).is("ROW")):
+		p.pos++
+		var rows [][]token
+		for {
+			p.accept("ROW")
+			row, err := p.group()
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row[1:len(row)-1])
+			if !p.acceptPunct(',') {
+				break
+			}
+		}
+		if conditions, err = valuesConditions(columns, rows); err != nil {
+			return err
+		}
+
+	case mysql && p.accept("SET"):
+		assignments, err := p.until(p.atInsertTail)
+		if err != nil {
+			return err
+		}
+		if len(assignments) == 0 {
+			return p.unexpected("assignment")
+		}
+		var condition []token
+		for i, assignment := range splitList(assignments) {
+			eq := -1
+			for j, t := range assignment {
+				if t.isPunct('=') {
+					eq = j
+					break
+				}
+			}
+			if eq < 1 || eq == len(assignment)-1 {
+				return errors.Errorf("invalid assignment %q", renderTokens(assignment))
+			}
+			if i != 0 {
+				condition = append(condition, token{kind: tokenWord, text: "AND", space: true})
+			}
+			condition = append(condition, equal(assignment[:eq], assignment[eq+1:])...)
+		}
+		conditions = [][]token{condition}
+
+	case p.accept("TABLE"):
+		source, err := p.until(p.atInsertTail)
+		if err != nil {
+			return err
+		}
+		if len(source) == 0 {
+			return p.unexpected("table")
+		}
+		if err = p.insertTail(); err != nil {
+			return err
+		}
+		b.keyword("SELECT * FROM")
+		b.tokens(source)
+		return nil
+
+	default:
+		source, err := p.until(p.atInsertTail)
+		if err != nil {
+			return err
+		}
+		if len(source) == 0 {
+			return p.unexpected("VALUES, SET, SELECT or TABLE")
+		}
+		if !containsKeyword(source, "SELECT", "TABLE", "VALUES") && !source[0].isPunct('(') {
+			return errors.Errorf("unexpected %q", source[0].text)
+		}
+		if err = p.insertTail(); err != nil {
+			return err
+		}
+		if b.Len() != 0 && source[0].is("WITH") {
+			return errors.New("nested WITH")
+		}
+		b.tokens(source)
+		return nil
+	}
+
+	// row alias
+	if mysql && p.accept("AS") {
+		if _, err = p.until(p.atInsertTail); err != nil {
+			return err
 		}
 	}
-	return result
+	if err = p.insertTail(); err != nil {
+		return err
+	}
+
+	b.keyword("SELECT * FROM")
+	b.tokens(table)
+	if conditions == nil {
+		b.keyword("LIMIT 1")
+		return nil
+	}
+
+	b.keyword("WHERE")
+	for i, condition := range conditions {
+		if i != 0 {
+			b.keyword("OR")
+		}
+		if len(conditions) > 1 {
+			b.keyword("(" + renderTokens(condition) + ")")
+			continue
+		}
+		b.tokens(condition)
+	}
+	return nil
 }
 
-func deleteToSelect(matches []string) string {
-	if strings.Contains(matches[2], "join") {
-		return fmt.Sprintf("SELECT 1 FROM %s", matches[2])
+// atInsertTail returns true if the current token starts ON DUPLICATE KEY UPDATE clause (MySQL),
+// or ON CONFLICT or RETURNING clause (PostgreSQL).
+func (p *queryParser) atInsertTail() bool {
+	if p.dialect == dialectPostgreSQL {
+		return (p.peek().is("ON") && p.peekAt(p.pos+1).is("CONFLICT")) || p.peek().is("RETURNING")
 	}
-	return fmt.Sprintf("SELECT * FROM %s", matches[2])
+	return p.peek().is("ON") && p.peekAt(p.pos+1).is("DUPLICATE") &&
+		p.peekAt(p.pos+2).is("KEY") && p.peekAt(p.pos+3).is("UPDATE")
 }
 
-func insertToSelect(matches []string) string {
-	fields := strings.Split(matches[2], ",")
-	values := strings.Split(matches[3], ",")
-	if len(fields) == len(values) {
-		query := fmt.Sprintf("SELECT * FROM %s WHERE ", matches[1])
-		sep := ""
-		for i := 0; i < len(fields); i++ {
-			query += fmt.Sprintf(`%s%s=%s`, sep, strings.TrimSpace(fields[i]), values[i])
-			sep = " and "
+// insertTail consumes optional ON DUPLICATE KEY UPDATE clause (MySQL),
+// or ON CONFLICT and RETURNING clauses (PostgreSQL).
+// They do not change rows that are read by INSERT.
+func (p *queryParser) insertTail() error {
+	if p.dialect == dialectPostgreSQL {
+		if p.peek().is("ON") && p.peekAt(p.pos+1).is("CONFLICT") {
+			p.pos += 2
+			conflict, err := p.untilKeyword("RETURNING")
+			if err != nil {
+				return err
+			}
+			if !containsKeyword(conflict, "DO") {
+				return p.unexpected("DO")
+			}
 		}
-		return query
+		return p.returning()
+	}
+
+	if !p.atInsertTail() {
+		return nil
+	}
+	p.pos += 4
+	assignments, err := p.untilKeyword()
+	if err != nil {
+		return err
 	}
-	return fmt.Sprintf("SELECT * FROM %s LIMIT 1", matches[1])
+	if len(assignments) == 0 {
+		return p.unexpected("assignment")
+	}
+	return nil
 }
 
-func insertToSelectNoFields(matches []string) string {
-	return fmt.Sprintf("SELECT * FROM %s LIMIT 1", matches[1])
+// valuesConditions returns conditions matching inserted rows, one per row,
+// or nil if columns are unknown.
+func valuesConditions(columns, rows [][]token) ([][]token, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	res := make([][]token, 0, len(rows))
+	for _, row := range rows {
+		values := splitList(row)
+		if len(values) != len(columns) {
+			return nil, nil
+		}
+
+		var condition []token
+		for i, value := range values {
+			if len(value) == 0 || len(columns[i]) == 0 {
+				return nil, errors.New("empty column or value")
+			}
+			// default value is unknown
+			if len(value) == 1 && value[0].is("DEFAULT") {
+				continue
+			}
+			if len(condition) != 0 {
+				condition = append(condition, token{kind: tokenWord, text: "AND", space: true})
+			}
+			condition = append(condition, equal(columns[i], value)...)
+		}
+		if len(condition) == 0 {
+			return nil, nil
+		}
+		res = append(res, condition)
+	}
+	return res, nil
 }
 
-func insertWithSetToSelect(matches []string) string {
-	return fmt.Sprintf("SELECT * FROM %s WHERE %s", matches[1], strings.ReplaceAll(matches[2], ",", " AND "))
+// equal returns `column = value` condition tokens; complex values are parenthesized.
+func equal(column []token, value []token) []token {
+	res := make([]token, 0, len(column)+len(value)+3)
+	res = append(res, column...)
+	res[0].space = true
+	res = append(res, token{kind: tokenPunct, text: "=", space: true})
+	if len(value) == 1 {
+		return append(res, token{kind: value[0].kind, text: value[0].text, space: true})
+	}
+
+	res = append(res, token{kind: tokenPunct, text: "(", space: true})
+	res = append(res, value...)
+	res[len(res)-len(value)].space = false
+	return append(res, token{kind: tokenPunct, text: ")"})
 }
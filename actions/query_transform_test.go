@@ -16,60 +16,179 @@
 package actions
 
 import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDMLToSelect(t *testing.T) {
-	q := dmlToSelect(`update ignore tabla set nombre = "carlos" where id = 0 limit 2`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla WHERE id = 0`, q)
-
-	q = dmlToSelect(`update ignore tabla set nombre = "carlos" where id = 0`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla WHERE id = 0`, q)
-
-	q = dmlToSelect(`update ignore tabla set nombre = "carlos" limit 1`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla`, q)
-
-	q = dmlToSelect(`update tabla set nombre = "carlos" where id = 0 limit 2`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla WHERE id = 0`, q)
-
-	q = dmlToSelect(`update tabla set nombre = "carlos" where id = 0`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla WHERE id = 0`, q)
-
-	q = dmlToSelect(`update tabla set nombre = "carlos" limit 1`)
-	assert.Equal(t, `SELECT nombre = "carlos" FROM tabla`, q)
-
-	q = dmlToSelect(`delete from tabla`)
-	assert.Equal(t, `SELECT * FROM tabla`, q)
-
-	q = dmlToSelect(`delete from tabla join tabla2 on tabla.id = tabla2.tabla2_id`)
-	assert.Equal(t, `SELECT 1 FROM tabla join tabla2 on tabla.id = tabla2.tabla2_id`, q)
-
-	q = dmlToSelect(`insert into tabla (f1, f2, f3) values (1,2,3)`)
-	assert.Equal(t, `SELECT * FROM tabla  WHERE f1=1 and f2=2 and f3=3`, q)
-
-	q = dmlToSelect(`insert into tabla (f1, f2, f3) values (1,2)`)
-	assert.Equal(t, `SELECT * FROM tabla  LIMIT 1`, q)
-
-	q = dmlToSelect(`insert into tabla set f1="A1", f2="A2"`)
-	assert.Equal(t, `SELECT * FROM tabla WHERE f1="A1" AND  f2="A2"`, q)
-
-	q = dmlToSelect(`replace into tabla set f1="A1", f2="A2"`)
-	assert.Equal(t, `SELECT * FROM tabla WHERE f1="A1" AND  f2="A2"`, q)
-
-	q = dmlToSelect("insert into `tabla-1` values(12)")
-	assert.Equal(t, "SELECT * FROM `tabla-1` LIMIT 1", q)
-
-	q = dmlToSelect(`UPDATE
+	for query, expected := range map[string]string{
+		`update ignore tabla set nombre = "carlos" where id = 0 limit 2`: `SELECT nombre = "carlos" FROM tabla WHERE id = 0 LIMIT 2`,
+		`update ignore tabla set nombre = "carlos" where id = 0`:         `SELECT nombre = "carlos" FROM tabla WHERE id = 0`,
+		`update ignore tabla set nombre = "carlos" limit 1`:              `SELECT nombre = "carlos" FROM tabla LIMIT 1`,
+		`update tabla set nombre = "carlos" where id = 0 limit 2`:        `SELECT nombre = "carlos" FROM tabla WHERE id = 0 LIMIT 2`,
+		`update tabla set nombre = "carlos" where id = 0`:                `SELECT nombre = "carlos" FROM tabla WHERE id = 0`,
+		`update tabla set nombre = "carlos" limit 1`:                     `SELECT nombre = "carlos" FROM tabla LIMIT 1`,
+
+		`delete from tabla`: `SELECT * FROM tabla`,
+		`delete from tabla join tabla2 on tabla.id = tabla2.tabla2_id`: `SELECT 1 FROM tabla join tabla2 on tabla.id = tabla2.tabla2_id`,
+
+		`insert into tabla (f1, f2, f3) values (1,2,3)`: `SELECT * FROM tabla WHERE f1 = 1 AND f2 = 2 AND f3 = 3`,
+		`insert into tabla (f1, f2, f3) values (1,2)`:   `SELECT * FROM tabla LIMIT 1`,
+		`insert into tabla set f1="A1", f2="A2"`:        `SELECT * FROM tabla WHERE f1 = "A1" AND f2 = "A2"`,
+		`replace into tabla set f1="A1", f2="A2"`:       `SELECT * FROM tabla WHERE f1 = "A1" AND f2 = "A2"`,
+		"insert into `tabla-1` values(12)":              "SELECT * FROM `tabla-1` LIMIT 1",
+
+		`UPDATE
   employees2
 SET
   first_name = 'Joe',
   emp_no = 10
 WHERE
-  emp_no = 3`)
-	assert.Equal(t, "SELECT first_name = 'Joe',   emp_no = 10 FROM employees2 WHERE emp_no = 3", q)
+  emp_no = 3`: "SELECT first_name = 'Joe', emp_no = 10 FROM employees2 WHERE emp_no = 3",
+		`UPDATE employees2 SET first_name = 'Joe', emp_no = 10 WHERE emp_no = 3`: "SELECT first_name = 'Joe', emp_no = 10 FROM employees2 WHERE emp_no = 3",
+	} {
+		actual, err := dmlToSelect(query, dialectMySQL)
+		require.NoError(t, err, "%s", query)
+		assert.Equal(t, expected, actual, "%s", query)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			`SELECT 1`:                     `cannot convert query to SELECT: expected INSERT, UPDATE, DELETE or REPLACE, got "SELECT"`,
+			`UPDATE t SET a = 1; DELETE t`: `cannot convert query to SELECT: expected end of query, got ";"`,
+			`UPDATE t SET a = 'b`:          "cannot convert query to SELECT: unterminated quoted 'b",
+			`DELETE FROM t WHERE (a = 1`:   `cannot convert query to SELECT: unbalanced parentheses`,
+			`UPDATE t WHERE a = 1`:         `cannot convert query to SELECT: expected SET, got end of query`,
+			`INSERT INTO t SET a`:          `cannot convert query to SELECT: invalid assignment "a"`,
+		} {
+			_, err := dmlToSelect(query, dialectMySQL)
+			assert.EqualError(t, err, expected, "%s", query)
+		}
+	})
+
+	t.Run("PostgreSQLErrors", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			`REPLACE INTO t VALUES (1)`:                  `cannot convert query to SELECT: expected INSERT, UPDATE or DELETE, got "REPLACE"`,
+			`DELETE t`:                                   `cannot convert query to SELECT: expected FROM, got "t"`,
+			`UPDATE t SET a = $$b`:                       "cannot convert query to SELECT: unterminated quoted $$b",
+			`DELETE FROM t WHERE CURRENT OF c`:           `cannot convert query to SELECT: WHERE CURRENT OF is not supported`,
+			`INSERT INTO t VALUES (1) ON CONFLICT (a)`:   `cannot convert query to SELECT: expected DO, got end of query`,
+			`UPDATE t SET a = 1 FROM WHERE a = 2`:        `cannot convert query to SELECT: expected table, got "WHERE"`,
+			`INSERT INTO t VALUES (1) RETURNING`:         `cannot convert query to SELECT: expected output expression, got end of query`,
+			`DELETE FROM t /* unterminated /* nested */`: `cannot convert query to SELECT: unterminated comment`,
+		} {
+			_, err := dmlToSelect(query, dialectPostgreSQL)
+			assert.EqualError(t, err, expected, "%s", query)
+		}
+	})
+
+	for dialect, dir := range map[queryDialect]string{
+		dialectMySQL:      "mysql",
+		dialectPostgreSQL: "postgres",
+	} {
+		dialect, dir := dialect, dir
+		t.Run("Corpus/"+dir, func(t *testing.T) {
+			cases := readDMLCorpus(t, filepath.Join("..", "testqueries", dir, "dml_to_select.sql"))
+			require.NotEmpty(t, cases)
+
+			for _, c := range cases {
+				actual, err := dmlToSelect(c.query, dialect)
+				if c.expected == "ERROR" {
+					assert.Error(t, err, "%s\n=> %s", c.query, actual)
+					continue
+				}
+
+				require.NoError(t, err, "%s", c.query)
+				assert.Equal(t, c.expected, actual, "%s", c.query)
+
+				// result should be a valid read-only query
+				assert.True(t, isDMLQuery(c.query, dialect), "%s", c.query)
+				assert.False(t, isDMLQuery(actual, dialect), "%s", actual)
+				_, err = lexQuery(actual, dialect)
+				assert.NoError(t, err, "%s", actual)
+			}
+		})
+	}
+}
+
+func TestIsDMLQuery(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"SELECT 1":                                  false,
+		"  insert into t values (1)":                true,
+		"UPDATE t SET a = 1":                        true,
+		"delete from t":                             true,
+		"REPLACE t VALUES (1)":                      true,
+		"/* update */ SELECT 'delete'":              false,
+		"/* comment */ DELETE FROM t":               true,
+		"WITH c AS (SELECT 1) SELECT * FROM c":      false,
+		"WITH c AS (DELETE FROM t) SELECT * FROM c": false,
+		"WITH c AS (SELECT 1) UPDATE t SET a = 1":   true,
+		"WITH RECURSIVE c AS (SELECT 1) DELETE t":   true,
+		"UPDATE t SET a = 'unterminated":            true,
+		"updated_at":                                false,
+		"":                                          false,
+	} {
+		assert.Equal(t, expected, isDMLQuery(query, dialectMySQL), "%q", query)
+	}
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		for query, expected := range map[string]bool{
+			"SELECT 'it\\' -- DELETE'":                              false,
+			"SELECT $$ DELETE $$":                                   false,
+			"SELECT $tag$ $$ UPDATE $tag$":                          false,
+			`SELECT "update" FROM t`:                                false,
+			"# DELETE FROM t":                                       false,
+			"WITH c AS MATERIALIZED (SELECT 1) DELETE FROM t":       true,
+			"/* /* nested */ SELECT */ UPDATE t SET a = 1":          true,
+			"INSERT INTO t VALUES (1) ON CONFLICT DO NOTHING":       true,
+			"WITH c AS NOT MATERIALIZED (SELECT 1) SELECT * FROM c": false,
+		} {
+			assert.Equal(t, expected, isDMLQuery(query, dialectPostgreSQL), "%q", query)
+		}
+	})
+}
+
+type dmlCorpusCase struct {
+	query    string
+	expected string
+}
 
-	q = dmlToSelect(`UPDATE employees2 SET first_name = 'Joe', emp_no = 10 WHERE emp_no = 3`)
-	assert.Equal(t, "SELECT first_name = 'Joe', emp_no = 10 FROM employees2 WHERE emp_no = 3", q)
+// readDMLCorpus reads test cases from testqueries corpus file.
+func readDMLCorpus(t *testing.T, path string) []dmlCorpusCase {
+	t.Helper()
+
+	f, err := os.Open(path) //nolint:gosec
+	require.NoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	var res []dmlCorpusCase
+	var query []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "-- => "):
+			require.NotEmpty(t, query, "expected result without query: %s", line)
+			res = append(res, dmlCorpusCase{
+				query:    strings.Join(query, "\n"),
+				expected: strings.TrimPrefix(line, "-- => "),
+			})
+			query = nil
+
+		case len(query) == 0 && (strings.TrimSpace(line) == "" || strings.HasPrefix(line, "--")):
+			// skip comments and empty lines between queries
+
+		default:
+			query = append(query, line)
+		}
+	}
+	require.NoError(t, s.Err())
+	require.Empty(t, query, "query without expected result")
+	return res
 }
@@ -0,0 +1,82 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Helpers for text reports of summary Actions in pt-*-summary tools format.
+
+// marshalSummary returns JSON document with summary data and text report.
+func marshalSummary(summary interface{}, report string) ([]byte, error) {
+	res := struct {
+		Summary interface{} `json:"summary"`
+		Report  string      `json:"report"`
+	}{
+		Summary: summary,
+		Report:  report,
+	}
+	b, err := json.Marshal(res)
+	return b, errors.WithStack(err)
+}
+
+// sendSummaryReport passes text report to send in chunks.
+func sendSummaryReport(report string, send func(Chunk) error) error {
+	w := &chunkWriter{send: send}
+	if _, err := io.WriteString(w, report); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// writeReportSection writes section header line.
+func writeReportSection(w io.Writer, title string) {
+	n := 100 - len(title)
+	if n < 3 {
+		n = 3
+	}
+	fmt.Fprintf(w, "# %s %s\n", title, strings.Repeat("#", n))
+}
+
+// writeReportValue writes right-aligned name and formatted value.
+func writeReportValue(w io.Writer, name string, format string, args ...interface{}) {
+	fmt.Fprintf(w, "%25s | %s\n", name, fmt.Sprintf(format, args...))
+}
+
+// humanizeBytes returns size in the largest binary unit with value of at least 1.
+func humanizeBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// humanizeSeconds returns duration as days, hours, minutes, and seconds like pt-*-summary tools do.
+func humanizeSeconds(s int64) string {
+	return fmt.Sprintf("%d days, %d hours, %d minutes, %d seconds", s/86400, s%86400/3600, s%3600/60, s%60)
+}
@@ -0,0 +1,56 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryReport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Section", func(t *testing.T) {
+		var w strings.Builder
+		writeReportSection(&w, "Databases")
+		assert.Equal(t, "# Databases "+strings.Repeat("#", 91)+"\n", w.String())
+	})
+
+	t.Run("Value", func(t *testing.T) {
+		var w strings.Builder
+		writeReportValue(&w, "Version", "%s (%d)", "14.2", 140002)
+		assert.Equal(t, "                  Version | 14.2 (140002)\n", w.String())
+	})
+
+	t.Run("HumanizeBytes", func(t *testing.T) {
+		for b, expected := range map[int64]string{
+			0:       "0 B",
+			1023:    "1023 B",
+			1024:    "1.00 KiB",
+			1 << 20: "1.00 MiB",
+			5 << 40: "5.00 TiB",
+		} {
+			assert.Equal(t, expected, humanizeBytes(b))
+		}
+	})
+
+	t.Run("HumanizeSeconds", func(t *testing.T) {
+		assert.Equal(t, "0 days, 0 hours, 0 minutes, 0 seconds", humanizeSeconds(0))
+		assert.Equal(t, "1 days, 1 hours, 1 minutes, 1 seconds", humanizeSeconds(90061))
+	})
+}
@@ -0,0 +1,165 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxStateAge is a maximal age of QAN agents state snapshots used on start.
+// Older state would attribute too much to the first bucket after restart.
+const MaxStateAge = 10 * time.Minute
+
+// ErrSnapshotExpired is returned by Restore when the snapshot is older than allowed.
+var ErrSnapshotExpired = errors.New("snapshot expired")
+
+// snapshot is a serialized form of Cache.
+type snapshot struct {
+	Type  string          `json:"type"`
+	Saved time.Time       `json:"saved"`
+	Items []*snapshotItem `json:"items"`
+}
+
+// snapshotItem is a serialized form of cacheItem.
+type snapshotItem struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+	Added time.Time       `json:"added"`
+}
+
+// Snapshot writes all current items with their timestamps to the file at the given path.
+// The file is replaced atomically.
+func (c *Cache) Snapshot(path string) error {
+	c.rw.RLock()
+	s := &snapshot{
+		Type:  c.typ.String(),
+		Saved: time.Now(),
+		Items: make([]*snapshotItem, 0, len(c.items)),
+	}
+	var err error
+	for e := c.itemsList.Front(); e != nil && err == nil; e = e.Next() {
+		item := e.Value.(*cacheItem)
+		si := &snapshotItem{Added: item.added}
+		if si.Key, err = json.Marshal(item.key); err == nil {
+			si.Value, err = json.Marshal(item.value)
+		}
+		s.Items = append(s.Items, si)
+	}
+	c.rw.RUnlock()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache item")
+	}
+	return WriteSnapshotFile(path, s)
+}
+
+// Restore replaces all items with ones from the snapshot file at the given path
+// written by Snapshot. Snapshots older than maxAge are not used.
+// Items keep their original timestamps, so retain and size limits are applied as if there was no restart.
+func (c *Cache) Restore(path string, maxAge time.Duration) error {
+	var s snapshot
+	if err := ReadSnapshotFile(path, &s); err != nil {
+		return err
+	}
+	if s.Type != c.typ.String() {
+		return fmt.Errorf("%w: snapshot contains %s, cache contains %v", ErrWrongType, s.Type, c.typ)
+	}
+	if time.Since(s.Saved) > maxAge {
+		return ErrSnapshotExpired
+	}
+
+	items := make(map[interface{}]*list.Element, len(s.Items))
+	itemsList := list.New()
+	now := time.Now()
+	for _, si := range s.Items {
+		if now.Sub(si.Added) > c.retain {
+			continue
+		}
+
+		key := reflect.New(c.typ.Key())
+		if err := json.Unmarshal(si.Key, key.Interface()); err != nil {
+			return errors.Wrap(err, "failed to unmarshal cache item key")
+		}
+		value := reflect.New(c.typ.Elem())
+		if err := json.Unmarshal(si.Value, value.Interface()); err != nil {
+			return errors.Wrap(err, "failed to unmarshal cache item value")
+		}
+
+		k := key.Elem().Interface()
+		if e, ok := items[k]; ok {
+			itemsList.Remove(e)
+		}
+		items[k] = itemsList.PushBack(&cacheItem{k, value.Elem().Interface(), si.Added})
+	}
+
+	// items are stored oldest first
+	for uint(len(items)) > c.sizeLimit {
+		delete(items, itemsList.Remove(itemsList.Front()).(*cacheItem).key)
+	}
+
+	c.rw.Lock()
+	defer c.rw.Unlock()
+	c.items = items
+	c.itemsList = itemsList
+	return nil
+}
+
+// WriteSnapshotFile atomically writes JSON representation of v to the file at the given path,
+// creating parent directories if needed.
+// It may be used by agents that keep their state outside of Cache.
+func WriteSnapshotFile(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal snapshot")
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+
+	if _, err = f.Write(b); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.WithStack(err)
+	}
+	if err = f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), path))
+}
+
+// ReadSnapshotFile reads JSON representation of v from the file at the given path written by WriteSnapshotFile.
+// Returned error wraps os.ErrNotExist if there is no such file.
+func ReadSnapshotFile(path string, v interface{}) error {
+	b, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.Wrap(json.Unmarshal(b, v), "failed to unmarshal snapshot")
+}
@@ -0,0 +1,127 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type snapshotType struct {
+	Query string
+	Calls int64
+	Tags  []string
+}
+
+func TestSnapshot(t *testing.T) {
+	set := map[int64]*snapshotType{
+		1: {Query: "SELECT 1", Calls: 10},
+		2: {Query: "SELECT 2", Calls: 20, Tags: []string{"t1"}},
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent_id", "state.json")
+
+		c, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, c.Set(set))
+		require.NoError(t, c.Snapshot(path))
+
+		restored, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, restored.Restore(path, time.Minute))
+
+		actual := make(map[int64]*snapshotType)
+		require.NoError(t, restored.Get(actual))
+		assert.Equal(t, set, actual)
+		assert.Equal(t, c.Stats().Oldest.UnixNano(), restored.Stats().Oldest.UnixNano())
+		assert.Equal(t, c.Stats().Newest.UnixNano(), restored.Stats().Newest.UnixNano())
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		err = c.Restore(filepath.Join(t.TempDir(), "state.json"), time.Minute)
+		assert.True(t, errors.Is(err, fs.ErrNotExist), "%+v", err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "state.json")
+
+		c, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, c.Set(set))
+		require.NoError(t, c.Snapshot(path))
+
+		time.Sleep(100 * time.Millisecond)
+		restored, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		assert.Equal(t, ErrSnapshotExpired, restored.Restore(path, 50*time.Millisecond))
+		assert.Zero(t, restored.Len())
+	})
+
+	t.Run("RetainAndSizeLimit", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "state.json")
+
+		c, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, c.Set(map[int64]*snapshotType{1: {}}))
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, c.Set(map[int64]*snapshotType{2: {}, 3: {}, 4: {}}))
+		require.NoError(t, c.Snapshot(path))
+
+		// item 1 is too old, then one of items 2-4 is over the size limit
+		restored, err := New(make(map[int64]*snapshotType), 100*time.Millisecond, 2, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, restored.Restore(path, time.Minute))
+		actual := make(map[int64]*snapshotType)
+		require.NoError(t, restored.Get(actual))
+		assert.Len(t, actual, 2)
+		assert.NotContains(t, actual, int64(1))
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "state.json")
+
+		c, err := New(make(map[int64]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, c.Set(set))
+		require.NoError(t, c.Snapshot(path))
+
+		restored, err := New(make(map[string]*snapshotType), time.Minute, 100, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+		err = restored.Restore(path, time.Minute)
+		assert.True(t, errors.Is(err, ErrWrongType), "%+v", err)
+
+		files, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, files, 1, "temporary files should be removed")
+	})
+}
@@ -0,0 +1,287 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package currentop samples MongoDB operations with $currentOp and feeds finished ones to QAN aggregator.
+// It works without the profiler and on mongos, but only operations that run longer than
+// the sampling interval are reliably seen, and their durations are rounded down to it.
+package currentop
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/percona/pmm-agent/agents/mongodb/internal/profiler/aggregator"
+	"github.com/percona/pmm-agent/agents/mongodb/internal/profiler/sender"
+)
+
+const (
+	// DefaultInterval is the default sampling interval.
+	DefaultInterval = time.Second
+
+	mgoTimeoutDialInfo      = 5 * time.Second
+	mgoTimeoutSessionSocket = 5 * time.Second
+)
+
+// New creates new Sampler.
+func New(mongoDSN string, logger *logrus.Entry, w sender.Writer, agentID string, interval time.Duration) *Sampler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &Sampler{
+		mongoDSN: mongoDSN,
+		logger:   logger,
+		w:        w,
+		agentID:  agentID,
+		interval: interval,
+	}
+}
+
+// Sampler periodically runs $currentOp and tracks operations until they finish.
+type Sampler struct {
+	// dependencies
+	mongoDSN string
+	w        sender.Writer
+	logger   *logrus.Entry
+	agentID  string
+	interval time.Duration
+
+	// internal deps
+	client     *mongo.Client
+	aggregator *aggregator.Aggregator
+	sender     *sender.Sender
+
+	// state
+	m        sync.Mutex      // Lock() to protect internal consistency of the service
+	running  bool            // Is this service running?
+	doneChan chan struct{}   // close(doneChan) to notify goroutines that they should shutdown
+	wg       *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// Start starts sampler but doesn't wait until it exits.
+func (s *Sampler) Start() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.running {
+		return nil
+	}
+
+	appName := fmt.Sprintf("QAN-mongodb-currentop-%s", s.agentID)
+	client, err := createSession(s.mongoDSN, appName)
+	if err != nil {
+		return err
+	}
+	s.client = client
+
+	// create aggregator which collects documents and aggregates them into qan report
+	s.aggregator = aggregator.New(time.Now(), s.agentID, s.logger)
+	reportChan := s.aggregator.Start()
+
+	// create sender which sends qan reports and start it
+	s.sender = sender.New(reportChan, s.w, s.logger)
+	if err = s.sender.Start(); err != nil {
+		s.aggregator.Stop()
+		s.client.Disconnect(context.TODO()) //nolint:errcheck
+		return err
+	}
+
+	s.doneChan = make(chan struct{})
+	s.wg = &sync.WaitGroup{}
+	s.wg.Add(1)
+
+	ctx := context.Background()
+	labels := pprof.Labels("component", "mongodb.currentop")
+	go pprof.Do(ctx, labels, func(ctx context.Context) {
+		defer s.wg.Done()
+		s.run(ctx, appName)
+	})
+
+	s.running = true
+	return nil
+}
+
+// Stop stops running sampler, waits until it stops.
+func (s *Sampler) Stop() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.running {
+		return nil
+	}
+
+	// notify goroutine to close and wait for it to exit
+	close(s.doneChan)
+	s.wg.Wait()
+
+	// stop aggregator and sender; do it after goroutine is closed
+	s.aggregator.Stop()
+	s.sender.Stop()
+
+	// close the session; do it after goroutine is closed
+	s.client.Disconnect(context.TODO()) //nolint:errcheck
+
+	s.running = false
+	return nil
+}
+
+func (s *Sampler) run(ctx context.Context, appName string) {
+	t := newTracker()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		ops, err := s.sample(ctx, appName)
+		if err != nil {
+			s.logger.Warnf("Failed to run $currentOp: %s.", err)
+		} else {
+			for _, doc := range t.update(ops, time.Now()) {
+				if err = s.aggregator.Add(ctx, doc); err != nil {
+					s.logger.Warnf("Couldn't add document to aggregator: %s.", err)
+				}
+			}
+		}
+
+		select {
+		case <-s.doneChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample returns currently running user operations.
+func (s *Sampler) sample(ctx context.Context, appName string) ([]currentOp, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.interval+mgoTimeoutSessionSocket)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$currentOp", Value: bson.D{{Key: "allUsers", Value: true}, {Key: "idleConnections", Value: false}}}},
+		{{Key: "$match", Value: bson.D{
+			{Key: "active", Value: true},
+			{Key: "op", Value: bson.D{{Key: "$in", Value: bson.A{"query", "getmore", "insert", "update", "remove", "command"}}}},
+			{Key: "appName", Value: bson.D{{Key: "$ne", Value: appName}}},
+		}}},
+	}
+	cursor, err := s.client.Database("admin").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []currentOp
+	if err = cursor.All(ctx, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// currentOp represents a single $currentOp document.
+type currentOp struct {
+	Shard              string      `bson:"shard"` // mongos only
+	OpID               interface{} `bson:"opid"`
+	Op                 string      `bson:"op"`
+	Ns                 string      `bson:"ns"`
+	Command            bson.D      `bson:"command"`
+	OriginatingCommand bson.D      `bson:"originatingCommand"`
+	MicrosecsRunning   int64       `bson:"microsecs_running"`
+	Client             string      `bson:"client"`
+	EffectiveUsers     []struct {
+		User string `bson:"user"`
+		DB   string `bson:"db"`
+	} `bson:"effectiveUsers"`
+}
+
+// trackedOp is an operation seen by the last sample.
+type trackedOp struct {
+	op       currentOp
+	lastSeen time.Time
+}
+
+// tracker tracks running operations between samples.
+type tracker struct {
+	ops map[string]*trackedOp
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		ops: make(map[string]*trackedOp),
+	}
+}
+
+// update takes operations from the new sample, and returns operations that finished since the previous one.
+func (t *tracker) update(ops []currentOp, now time.Time) []proto.SystemProfile {
+	seen := make(map[string]*trackedOp, len(ops))
+	for _, op := range ops {
+		// replication and internal operations
+		if op.Ns == "" || strings.HasPrefix(op.Ns, "local.") || len(op.Command) == 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%v", op.Shard, op.OpID)
+		seen[key] = &trackedOp{
+			op:       op,
+			lastSeen: now,
+		}
+	}
+
+	var res []proto.SystemProfile
+	for key, op := range t.ops {
+		if seen[key] == nil {
+			res = append(res, op.systemProfile())
+		}
+	}
+
+	t.ops = seen
+	return res
+}
+
+// systemProfile converts operation to system.profile document as if it was finished when it was last seen.
+func (op *trackedOp) systemProfile() proto.SystemProfile {
+	doc := proto.SystemProfile{
+		Client:             op.op.Client,
+		Millis:             int(op.op.MicrosecsRunning / 1000),
+		Ns:                 op.op.Ns,
+		Op:                 op.op.Op,
+		Command:            op.op.Command,
+		OriginatingCommand: op.op.OriginatingCommand,
+		Ts:                 op.lastSeen,
+	}
+	if len(op.op.EffectiveUsers) != 0 {
+		doc.User = op.op.EffectiveUsers[0].User + "@" + op.op.EffectiveUsers[0].DB
+	}
+	return doc
+}
+
+func createSession(dsn string, appName string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mgoTimeoutDialInfo)
+	defer cancel()
+	opts := options.Client().
+		ApplyURI(dsn).
+		SetDirect(true).
+		SetReadPreference(readpref.Nearest()).
+		SetSocketTimeout(mgoTimeoutSessionSocket).
+		SetAppName(appName)
+
+	return mongo.Connect(ctx, opts)
+}
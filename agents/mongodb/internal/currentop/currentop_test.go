@@ -0,0 +1,70 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package currentop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTracker(t *testing.T) {
+	find := func(opID int32, microsecs int64) currentOp {
+		return currentOp{
+			OpID:             opID,
+			Op:               "query",
+			Ns:               "shop.orders",
+			Command:          bson.D{{Key: "find", Value: "orders"}, {Key: "filter", Value: bson.D{{Key: "status", Value: "A"}}}},
+			MicrosecsRunning: microsecs,
+			Client:           "10.0.0.5:53514",
+			EffectiveUsers: []struct {
+				User string `bson:"user"`
+				DB   string `bson:"db"`
+			}{{User: "app", DB: "admin"}},
+		}
+	}
+	oplog := currentOp{
+		OpID:    int32(3),
+		Op:      "getmore",
+		Ns:      "local.oplog.rs",
+		Command: bson.D{{Key: "getMore", Value: int64(42)}},
+	}
+
+	tr := newTracker()
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	assert.Empty(t, tr.update([]currentOp{find(1, 500_000), find(2, 100_000), oplog}, now))
+	assert.Empty(t, tr.update([]currentOp{find(1, 1_500_000), find(2, 1_100_000), oplog}, now.Add(time.Second)))
+
+	// the second operation finished
+	docs := tr.update([]currentOp{find(1, 2_500_000), oplog}, now.Add(2*time.Second))
+	require.Len(t, docs, 1)
+	assert.Equal(t, 1100, docs[0].Millis)
+	assert.Equal(t, now.Add(time.Second), docs[0].Ts)
+	assert.Equal(t, "query", docs[0].Op)
+	assert.Equal(t, "shop.orders", docs[0].Ns)
+	assert.Equal(t, "app@admin", docs[0].User)
+	assert.Equal(t, "10.0.0.5:53514", docs[0].Client)
+
+	// the first one finished too; oplog tailing is never reported
+	docs = tr.update(nil, now.Add(3*time.Second))
+	require.Len(t, docs, 1)
+	assert.Equal(t, 2500, docs[0].Millis)
+	assert.Empty(t, tr.update(nil, now.Add(4*time.Second)))
+}
@@ -0,0 +1,302 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongolog tails mongod structured (JSON) log and feeds slow query entries to QAN aggregator.
+// It requires MongoDB 4.4+; slow queries are logged according to slowms and slowOpSampleRate settings
+// even if the profiler is disabled.
+package mongolog
+
+import (
+	"context"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/pmm-agent/agents/mongodb/internal/profiler/aggregator"
+	"github.com/percona/pmm-agent/agents/mongodb/internal/profiler/sender"
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+	"github.com/percona/pmm-agent/utils/backoff"
+)
+
+const (
+	// slowQueryMsg is a message of slow query log entries (id 51803).
+	slowQueryMsg = "Slow query"
+
+	backoffMinDelay = 1 * time.Second
+	backoffMaxDelay = 30 * time.Second
+)
+
+// New creates new Tailer.
+func New(logFile string, logger *logrus.Entry, w sender.Writer, agentID string) *Tailer {
+	return &Tailer{
+		logFile: logFile,
+		logger:  logger,
+		w:       w,
+		agentID: agentID,
+	}
+}
+
+// Tailer reads slow query entries from mongod log file.
+type Tailer struct {
+	// dependencies
+	logFile string
+	w       sender.Writer
+	logger  *logrus.Entry
+	agentID string
+
+	// internal deps
+	readerM    sync.Mutex // protects reader that is replaced by run on read errors
+	reader     *parser.ContinuousFileReader
+	aggregator *aggregator.Aggregator
+	sender     *sender.Sender
+
+	// state
+	m       sync.Mutex      // Lock() to protect internal consistency of the service
+	running bool            // Is this service running?
+	stop    chan struct{}   // closed to notify goroutines they should shutdown
+	wg      *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// Start starts tailer but doesn't wait until it exits.
+func (t *Tailer) Start() error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.running {
+		return nil
+	}
+
+	if t.logFile == "" {
+		return errors.New("mongod log file is not set")
+	}
+	reader, err := t.openReader()
+	if err != nil {
+		return err
+	}
+	t.reader = reader
+
+	// create aggregator which collects documents and aggregates them into qan report
+	t.aggregator = aggregator.New(time.Now(), t.agentID, t.logger)
+	reportChan := t.aggregator.Start()
+
+	// create sender which sends qan reports and start it
+	t.sender = sender.New(reportChan, t.w, t.logger)
+	if err = t.sender.Start(); err != nil {
+		t.aggregator.Stop()
+		t.reader.Close() //nolint:errcheck
+		return err
+	}
+
+	t.stop = make(chan struct{})
+	t.wg = &sync.WaitGroup{}
+	t.wg.Add(1)
+
+	ctx := context.Background()
+	labels := pprof.Labels("component", "mongodb.mongolog")
+	go pprof.Do(ctx, labels, func(ctx context.Context) {
+		defer t.wg.Done()
+		t.run(ctx)
+	})
+
+	t.running = true
+	return nil
+}
+
+// Stop stops running tailer, waits until it stops.
+func (t *Tailer) Stop() error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if !t.running {
+		return nil
+	}
+
+	// closing reader lets goroutine exit
+	close(t.stop)
+	t.readerM.Lock()
+	err := t.reader.Close()
+	t.readerM.Unlock()
+	t.wg.Wait()
+
+	// stop aggregator and sender; do it after goroutine is closed
+	t.aggregator.Stop()
+	t.sender.Stop()
+
+	t.running = false
+	return err
+}
+
+// openReader opens log file for reading new entries.
+func (t *Tailer) openReader() (*parser.ContinuousFileReader, error) {
+	rl := t.logger.WithField("component", "mongolog/reader").WithField("file", t.logFile)
+	reader, err := parser.NewContinuousFileReader(t.logFile, rl)
+	return reader, errors.WithStack(err)
+}
+
+// run reads log file until Stop is called.
+// On read errors, the file is reopened with backoff; entries written in between are lost.
+func (t *Tailer) run(ctx context.Context) {
+	b := backoff.New(backoffMinDelay, backoffMaxDelay)
+	for {
+		t.readerM.Lock()
+		reader := t.reader
+		t.readerM.Unlock()
+
+		started := time.Now()
+		err := t.tail(ctx, reader)
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+
+		// reader also returns io.EOF if it can't reopen rotated file
+		t.logger.Errorf("Failed to read %s: %s. Reopening.", t.logFile, err)
+		if time.Since(started) > backoffMaxDelay {
+			b.Reset()
+		}
+
+		for {
+			timer := time.NewTimer(b.Delay())
+			select {
+			case <-t.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			reader, err = t.openReader()
+			if err != nil {
+				t.logger.Warnf("Failed to reopen %s: %s.", t.logFile, err)
+				continue
+			}
+
+			// Stop closes the current reader; check that it was not called to avoid leaking the new one
+			t.readerM.Lock()
+			select {
+			case <-t.stop:
+				t.readerM.Unlock()
+				_ = reader.Close()
+				return
+			default:
+				t.reader = reader
+				t.readerM.Unlock()
+			}
+			break
+		}
+	}
+}
+
+// tail reads entries until the reader returns an error.
+func (t *Tailer) tail(ctx context.Context, reader *parser.ContinuousFileReader) error {
+	for {
+		line, err := reader.NextLine()
+		if line != "" {
+			doc, parseErr := parseLine(line)
+			switch {
+			case parseErr != nil:
+				t.logger.Debugf("Failed to parse log line: %s.", parseErr)
+			case doc != nil:
+				if err := t.aggregator.Add(ctx, *doc); err != nil {
+					t.logger.Warnf("Couldn't add document to aggregator: %s.", err)
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// logEntry represents a single structured log entry.
+type logEntry struct {
+	T    time.Time `bson:"t"`
+	Msg  string    `bson:"msg"`
+	Attr struct {
+		Type               string `bson:"type"`
+		Ns                 string `bson:"ns"`
+		Command            bson.D `bson:"command"`
+		OriginatingCommand bson.D `bson:"originatingCommand"`
+		KeysExamined       int    `bson:"keysExamined"`
+		DocsExamined       int    `bson:"docsExamined"`
+		NReturned          int    `bson:"nreturned"`
+		Reslen             int    `bson:"reslen"`
+		DurationMillis     int    `bson:"durationMillis"`
+		Remote             string `bson:"remote"`
+	} `bson:"attr"`
+}
+
+// parseLine converts slow query log entry to system.profile document.
+// It returns nil document for other entries.
+func parseLine(line string) (*proto.SystemProfile, error) {
+	// fast path for the most entries
+	if !strings.Contains(line, `"msg":"`+slowQueryMsg+`"`) {
+		return nil, nil
+	}
+
+	var e logEntry
+	if err := bson.UnmarshalExtJSON([]byte(line), false, &e); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if e.Msg != slowQueryMsg || e.Attr.Ns == "" || len(e.Attr.Command) == 0 {
+		return nil, nil
+	}
+
+	// the profiler uses collection namespace for commands
+	ns := e.Attr.Ns
+	if db := strings.TrimSuffix(ns, ".$cmd"); db != ns {
+		if collection, ok := e.Attr.Command[0].Value.(string); ok {
+			ns = db + "." + collection
+		}
+	}
+
+	return &proto.SystemProfile{
+		Client:             e.Attr.Remote,
+		DocsExamined:       e.Attr.DocsExamined,
+		KeysExamined:       e.Attr.KeysExamined,
+		Millis:             e.Attr.DurationMillis,
+		Nreturned:          e.Attr.NReturned,
+		Ns:                 ns,
+		Op:                 profilerOp(e.Attr.Type, e.Attr.Command),
+		Command:            e.Attr.Command,
+		OriginatingCommand: e.Attr.OriginatingCommand,
+		ResponseLength:     e.Attr.Reslen,
+		Ts:                 e.T,
+	}, nil
+}
+
+// profilerOp returns system.profile op value for log entry, so fingerprints are the same as for the profiler.
+func profilerOp(typ string, command bson.D) string {
+	if typ != "command" {
+		// update and remove
+		return typ
+	}
+
+	switch command[0].Key {
+	case "find":
+		return "query"
+	case "insert":
+		return "insert"
+	case "getMore":
+		return "getmore"
+	default:
+		return "command"
+	}
+}
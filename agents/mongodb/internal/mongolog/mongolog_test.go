@@ -0,0 +1,106 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongolog
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/fingerprinter"
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/agents/mongodb/internal/report"
+)
+
+func TestParseLine(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "mongod.log"))
+	require.NoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	var docs []*proto.SystemProfile
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		doc, err := parseLine(s.Text())
+		require.NoError(t, err)
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	require.NoError(t, s.Err())
+	require.Len(t, docs, 3)
+
+	find := docs[0]
+	assert.Equal(t, time.Date(2022, 5, 10, 12, 0, 2, 456000000, time.UTC), find.Ts.UTC())
+	assert.Equal(t, "query", find.Op)
+	assert.Equal(t, "shop.orders", find.Ns)
+	assert.Equal(t, 150, find.Millis)
+	assert.Equal(t, 1000, find.DocsExamined)
+	assert.Equal(t, 12, find.Nreturned)
+	assert.Equal(t, 2345, find.ResponseLength)
+	assert.Equal(t, "10.0.0.5:53514", find.Client)
+
+	assert.Equal(t, "update", docs[1].Op)
+	assert.Equal(t, "insert", docs[2].Op)
+
+	// fingerprints should be the same as for the profiler
+	fp := fingerprinter.NewFingerprinter(fingerprinter.DefaultKeyFilters())
+	for i, expected := range []string{
+		"FIND orders created,qty,status",
+		"UPDATE orders _id",
+		"INSERT orders",
+	} {
+		actual, err := fp.Fingerprint(*docs[i])
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual.Fingerprint, "%d", i)
+	}
+
+	doc, err := parseLine(`{"t":{"$date":"2022-05-10T12:00:04.000+00:00"},"msg":"Slow query","attr":`)
+	assert.Error(t, err)
+	assert.Nil(t, doc)
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(*report.Report) error { return nil }
+
+func TestTailerReopen(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "mongod.log")
+	require.NoError(t, os.WriteFile(logFile, nil, 0o600))
+
+	tailer := New(logFile, logrus.WithField("test", t.Name()), nopWriter{}, "agent_id")
+	require.NoError(t, tailer.Start())
+	defer tailer.Stop() //nolint:errcheck
+
+	// simulate read error
+	tailer.readerM.Lock()
+	reader := tailer.reader
+	tailer.readerM.Unlock()
+	require.NoError(t, reader.Close())
+
+	assert.Eventually(t, func() bool {
+		tailer.readerM.Lock()
+		defer tailer.readerM.Unlock()
+		return tailer.reader != reader
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.NoError(t, tailer.Stop())
+}
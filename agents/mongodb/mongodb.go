@@ -13,18 +13,22 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package mongodb runs built-in QAN Agent for MongoDB profiler.
+// Package mongodb runs built-in QAN Agent for MongoDB profiler, $currentOp or mongod log.
 package mongodb
 
 import (
 	"context"
+	"time"
 
 	"github.com/percona/pmm/api/inventorypb"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 
 	"github.com/percona/pmm-agent/agents"
+	"github.com/percona/pmm-agent/agents/mongodb/internal/currentop"
+	"github.com/percona/pmm-agent/agents/mongodb/internal/mongolog"
 	"github.com/percona/pmm-agent/agents/mongodb/internal/profiler"
 	"github.com/percona/pmm-agent/agents/mongodb/internal/report"
 )
@@ -35,13 +39,26 @@ type MongoDB struct {
 	l       *logrus.Entry
 	changes chan agents.Change
 
-	mongoDSN string
+	mongoDSN          string
+	source            string
+	logFile           string
+	currentOpInterval time.Duration
 }
 
+// Sources of QAN data.
+const (
+	SourceProfiler  = "profiler"  // system.profile collections
+	SourceCurrentOp = "currentop" // $currentOp sampling
+	SourceLog       = "log"       // slow query entries from mongod log
+)
+
 // Params represent Agent parameters.
 type Params struct {
-	DSN     string
-	AgentID string
+	DSN               string
+	AgentID           string
+	Source            string        // SourceProfiler if empty
+	LogFile           string        // for SourceLog
+	CurrentOpInterval time.Duration // for SourceCurrentOp; currentop.DefaultInterval if zero
 }
 
 // New creates new MongoDB QAN service.
@@ -52,13 +69,31 @@ func New(params *Params, l *logrus.Entry) (*MongoDB, error) {
 		return nil, err
 	}
 
+	switch params.Source {
+	case "", SourceProfiler, SourceCurrentOp:
+	case SourceLog:
+		if params.LogFile == "" {
+			return nil, errors.New("mongod log file is not set")
+		}
+	default:
+		return nil, errors.Errorf("unknown source %q", params.Source)
+	}
+
 	return newMongo(params.DSN, l, params), nil
 }
 
 func newMongo(mongoDSN string, l *logrus.Entry, params *Params) *MongoDB {
+	source := params.Source
+	if source == "" {
+		source = SourceProfiler
+	}
+
 	return &MongoDB{
-		agentID:  params.AgentID,
-		mongoDSN: mongoDSN,
+		agentID:           params.AgentID,
+		mongoDSN:          mongoDSN,
+		source:            source,
+		logFile:           params.LogFile,
+		currentOpInterval: params.CurrentOpInterval,
 
 		l:       l,
 		changes: make(chan agents.Change, 10),
@@ -78,9 +113,16 @@ func (m *MongoDB) Run(ctx context.Context) {
 
 	m.changes <- agents.Change{Status: inventorypb.AgentStatus_STARTING}
 
-	prof = profiler.New(m.mongoDSN, m.l, m, m.agentID)
+	switch m.source {
+	case SourceCurrentOp:
+		prof = currentop.New(m.mongoDSN, m.l, m, m.agentID, m.currentOpInterval)
+	case SourceLog:
+		prof = mongolog.New(m.logFile, m.l, m, m.agentID)
+	default:
+		prof = profiler.New(m.mongoDSN, m.l, m, m.agentID)
+	}
 	if err := prof.Start(); err != nil {
-		m.l.Errorf("can't run profiler, reason: %v", err)
+		m.l.Errorf("can't run %s source, reason: %v", m.source, err)
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_STOPPING}
 		return
 	}
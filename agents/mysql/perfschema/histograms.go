@@ -0,0 +1,133 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perfschema
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/reform.v1"
+
+	"github.com/percona/pmm-agent/agents/cache"
+)
+
+// histogramBucket is a single bucket of digest's latency histogram.
+type histogramBucket struct {
+	High  uint64 // in picoseconds
+	Count uint64
+}
+
+// digestHistogram contains non-empty buckets of digest's latency histogram by bucket number.
+type digestHistogram struct {
+	Buckets map[uint32]histogramBucket
+}
+
+type histogramMap map[string]*digestHistogram
+
+// histogramCache is a wrapper for cache.Cache to use only with histogramMap type
+type histogramCache struct {
+	cache *cache.Cache
+}
+
+func (c *histogramCache) Set(src histogramMap) error {
+	return c.cache.Set(src)
+}
+
+func (c *histogramCache) Get(dest histogramMap) error {
+	return c.cache.Get(dest)
+}
+
+func newHistogramCache(typ histogramMap, retain time.Duration, sizeLimit uint, l *logrus.Entry) (*histogramCache, error) {
+	c, err := cache.New(typ, retain, sizeLimit, l)
+	return &histogramCache{c}, err
+}
+
+func getHistograms(q *reform.Querier) (histogramMap, error) {
+	rows, err := q.SelectRows(eventsStatementsHistogramByDigestView, "WHERE DIGEST IS NOT NULL AND COUNT_BUCKET > 0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query events_statements_histogram_by_digest")
+	}
+	defer rows.Close() //nolint:errcheck
+
+	res := make(histogramMap)
+	for {
+		var esh eventsStatementsHistogramByDigest
+		if err = q.NextRow(&esh, rows); err != nil {
+			break
+		}
+
+		// rows for different schemas are merged like summaries are
+		h := res[*esh.Digest]
+		if h == nil {
+			h = &digestHistogram{Buckets: make(map[uint32]histogramBucket)}
+			res[*esh.Digest] = h
+		}
+		b := h.Buckets[esh.BucketNumber]
+		b.High = esh.BucketTimerHigh
+		b.Count += esh.CountBucket
+		h.Buckets[esh.BucketNumber] = b
+	}
+	if err != reform.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to fetch events_statements_histogram_by_digest")
+	}
+	return res, nil
+}
+
+// histogramP99 returns 99th percentile of query time in seconds for queries executed between prev and current histograms,
+// using the upper bound of the bucket.
+// It returns false if there were no queries.
+func histogramP99(current, prev *digestHistogram) (float32, bool) {
+	if current == nil {
+		return 0, false
+	}
+	if prev == nil {
+		prev = &digestHistogram{}
+	}
+
+	// treat histogram as new if it was truncated
+	for n, p := range prev.Buckets {
+		if current.Buckets[n].Count < p.Count {
+			prev = &digestHistogram{}
+			break
+		}
+	}
+
+	numbers := make([]uint32, 0, len(current.Buckets))
+	var total uint64
+	for n, c := range current.Buckets {
+		numbers = append(numbers, n)
+		total += c.Count - prev.Buckets[n].Count
+	}
+	if total == 0 {
+		return 0, false
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	var count uint64
+	for _, n := range numbers {
+		c := current.Buckets[n]
+		count += c.Count - prev.Buckets[n].Count
+		if float64(count) >= 0.99*float64(total) {
+			// convert picoseconds to seconds
+			return float32(c.High) / 1000000000000, true
+		}
+	}
+
+	// unreachable, the last bucket always matches
+	return 0, false
+}
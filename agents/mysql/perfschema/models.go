@@ -62,6 +62,19 @@ type eventsStatementsSummaryByDigestExamples struct {
 	CurrentSchema *string `reform:"SCHEMA_NAME"`
 }
 
+// eventsStatementsHistogramByDigest represents a row in
+// performance_schema.events_statements_histogram_by_digest table (MySQL 8.0+).
+//
+//reform:performance_schema.events_statements_histogram_by_digest
+type eventsStatementsHistogramByDigest struct {
+	SchemaName      *string `reform:"SCHEMA_NAME"`
+	Digest          *string `reform:"DIGEST"`
+	BucketNumber    uint32  `reform:"BUCKET_NUMBER"`
+	BucketTimerLow  uint64  `reform:"BUCKET_TIMER_LOW"`
+	BucketTimerHigh uint64  `reform:"BUCKET_TIMER_HIGH"`
+	CountBucket     uint64  `reform:"COUNT_BUCKET"`
+}
+
 // eventsStatementsHistory represents a row in performance_schema.events_statements_history table.
 //
 //reform:performance_schema.events_statements_history
@@ -302,6 +302,107 @@ var (
 	_ fmt.Stringer  = (*eventsStatementsSummaryByDigestExamples)(nil)
 )
 
+type eventsStatementsHistogramByDigestViewType struct {
+	s parse.StructInfo
+	z []interface{}
+}
+
+// Schema returns a schema name in SQL database ("performance_schema").
+func (v *eventsStatementsHistogramByDigestViewType) Schema() string {
+	return v.s.SQLSchema
+}
+
+// Name returns a view or table name in SQL database ("events_statements_histogram_by_digest").
+func (v *eventsStatementsHistogramByDigestViewType) Name() string {
+	return v.s.SQLName
+}
+
+// Columns returns a new slice of column names for that view or table in SQL database.
+func (v *eventsStatementsHistogramByDigestViewType) Columns() []string {
+	return []string{
+		"SCHEMA_NAME",
+		"DIGEST",
+		"BUCKET_NUMBER",
+		"BUCKET_TIMER_LOW",
+		"BUCKET_TIMER_HIGH",
+		"COUNT_BUCKET",
+	}
+}
+
+// NewStruct makes a new struct for that view or table.
+func (v *eventsStatementsHistogramByDigestViewType) NewStruct() reform.Struct {
+	return new(eventsStatementsHistogramByDigest)
+}
+
+// eventsStatementsHistogramByDigestView represents events_statements_histogram_by_digest view or table in SQL database.
+var eventsStatementsHistogramByDigestView = &eventsStatementsHistogramByDigestViewType{
+	s: parse.StructInfo{
+		Type:      "eventsStatementsHistogramByDigest",
+		SQLSchema: "performance_schema",
+		SQLName:   "events_statements_histogram_by_digest",
+		Fields: []parse.FieldInfo{
+			{Name: "SchemaName", Type: "*string", Column: "SCHEMA_NAME"},
+			{Name: "Digest", Type: "*string", Column: "DIGEST"},
+			{Name: "BucketNumber", Type: "uint32", Column: "BUCKET_NUMBER"},
+			{Name: "BucketTimerLow", Type: "uint64", Column: "BUCKET_TIMER_LOW"},
+			{Name: "BucketTimerHigh", Type: "uint64", Column: "BUCKET_TIMER_HIGH"},
+			{Name: "CountBucket", Type: "uint64", Column: "COUNT_BUCKET"},
+		},
+		PKFieldIndex: -1,
+	},
+	z: new(eventsStatementsHistogramByDigest).Values(),
+}
+
+// String returns a string representation of this struct or record.
+func (s eventsStatementsHistogramByDigest) String() string {
+	res := make([]string, 6)
+	res[0] = "SchemaName: " + reform.Inspect(s.SchemaName, true)
+	res[1] = "Digest: " + reform.Inspect(s.Digest, true)
+	res[2] = "BucketNumber: " + reform.Inspect(s.BucketNumber, true)
+	res[3] = "BucketTimerLow: " + reform.Inspect(s.BucketTimerLow, true)
+	res[4] = "BucketTimerHigh: " + reform.Inspect(s.BucketTimerHigh, true)
+	res[5] = "CountBucket: " + reform.Inspect(s.CountBucket, true)
+	return strings.Join(res, ", ")
+}
+
+// Values returns a slice of struct or record field values.
+// Returned interface{} values are never untyped nils.
+func (s *eventsStatementsHistogramByDigest) Values() []interface{} {
+	return []interface{}{
+		s.SchemaName,
+		s.Digest,
+		s.BucketNumber,
+		s.BucketTimerLow,
+		s.BucketTimerHigh,
+		s.CountBucket,
+	}
+}
+
+// Pointers returns a slice of pointers to struct or record fields.
+// Returned interface{} values are never untyped nils.
+func (s *eventsStatementsHistogramByDigest) Pointers() []interface{} {
+	return []interface{}{
+		&s.SchemaName,
+		&s.Digest,
+		&s.BucketNumber,
+		&s.BucketTimerLow,
+		&s.BucketTimerHigh,
+		&s.CountBucket,
+	}
+}
+
+// View returns View object for that struct.
+func (s *eventsStatementsHistogramByDigest) View() reform.View {
+	return eventsStatementsHistogramByDigestView
+}
+
+// check interfaces
+var (
+	_ reform.View   = eventsStatementsHistogramByDigestView
+	_ reform.Struct = (*eventsStatementsHistogramByDigest)(nil)
+	_ fmt.Stringer  = (*eventsStatementsHistogramByDigest)(nil)
+)
+
 type eventsStatementsHistoryViewType struct {
 	s parse.StructInfo
 	z []interface{}
@@ -558,6 +659,7 @@ var (
 func init() {
 	parse.AssertUpToDate(&eventsStatementsSummaryByDigestView.s, new(eventsStatementsSummaryByDigest))
 	parse.AssertUpToDate(&eventsStatementsSummaryByDigestExamplesView.s, new(eventsStatementsSummaryByDigestExamples))
+	parse.AssertUpToDate(&eventsStatementsHistogramByDigestView.s, new(eventsStatementsHistogramByDigest))
 	parse.AssertUpToDate(&eventsStatementsHistoryView.s, new(eventsStatementsHistory))
 	parse.AssertUpToDate(&setupConsumersView.s, new(setupConsumers))
 	parse.AssertUpToDate(&setupInstrumentsView.s, new(setupInstruments))
@@ -20,7 +20,9 @@ import (
 	"context"
 	"database/sql"
 	"io"
+	"io/fs"
 	"math"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -37,6 +39,7 @@ import (
 
 	"github.com/percona/pmm-agent/agents"
 	"github.com/percona/pmm-agent/agents/cache"
+	"github.com/percona/pmm-agent/agents/waits"
 	"github.com/percona/pmm-agent/tlshelpers"
 	"github.com/percona/pmm-agent/utils/truncate"
 	"github.com/percona/pmm-agent/utils/version"
@@ -87,10 +90,13 @@ type PerfSchema struct {
 	dbCloser             io.Closer
 	agentID              string
 	disableQueryExamples bool
+	stateFile            string
 	l                    *logrus.Entry
 	changes              chan agents.Change
 	historyCache         *historyCache
 	summaryCache         *summaryCache
+	histogramCache       *histogramCache
+	waits                *waits.Profile
 	versionsCache        *versionsCache
 }
 
@@ -101,6 +107,7 @@ type Params struct {
 	DisableQueryExamples bool
 	TextFiles            *agentpb.TextFiles
 	TLSSkipVerify        bool
+	StateFile            string // summaries are persisted there between restarts if set
 }
 
 // newPerfSchemaParams holds all required parameters to instantiate a new PerfSchema
@@ -109,6 +116,7 @@ type newPerfSchemaParams struct {
 	DBCloser             io.Closer
 	AgentID              string
 	DisableQueryExamples bool
+	StateFile            string
 	LogEntry             *logrus.Entry
 }
 
@@ -139,6 +147,7 @@ func New(params *Params, l *logrus.Entry) (*PerfSchema, error) {
 		DBCloser:             sqlDB,
 		AgentID:              params.AgentID,
 		DisableQueryExamples: params.DisableQueryExamples,
+		StateFile:            params.StateFile,
 		LogEntry:             l,
 	}
 	return newPerfSchema(newParams)
@@ -155,15 +164,23 @@ func newPerfSchema(params *newPerfSchemaParams) (*PerfSchema, error) {
 		return nil, errors.Wrap(err, "cannot create cache")
 	}
 
+	histogramCache, err := newHistogramCache(histogramMap{}, retainSummaries, summariesCacheSize, params.LogEntry)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create cache")
+	}
+
 	return &PerfSchema{
 		q:                    params.Querier,
 		dbCloser:             params.DBCloser,
 		agentID:              params.AgentID,
 		disableQueryExamples: params.DisableQueryExamples,
+		stateFile:            params.StateFile,
 		l:                    params.LogEntry,
 		changes:              make(chan agents.Change, 10),
 		historyCache:         historyCache,
 		summaryCache:         summaryCache,
+		histogramCache:       histogramCache,
+		waits:                waits.NewProfile(sampleWaits, waitSecondsDesc),
 		versionsCache:        &versionsCache{items: make(map[string]*mySQLVersion)},
 	}, nil
 }
@@ -171,18 +188,24 @@ func newPerfSchema(params *newPerfSchemaParams) (*PerfSchema, error) {
 // Run extracts performance data and sends it to the channel until ctx is canceled.
 func (m *PerfSchema) Run(ctx context.Context) {
 	defer func() {
+		m.saveState()
 		m.dbCloser.Close() //nolint:errcheck
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_DONE}
 		close(m.changes)
 	}()
 
-	// add current summaries to cache so they are not send as new on first iteration with incorrect timestamps
+	// add current summaries to cache so they are not send as new on first iteration with incorrect timestamps,
+	// unless summaries from the previous run were restored
 	var running bool
 	var err error
 	m.changes <- agents.Change{Status: inventorypb.AgentStatus_STARTING}
 
+	restored := m.restoreState()
 	if s, err := getSummaries(m.q); err == nil {
-		if err = m.summaryCache.Set(s); err == nil {
+		if !restored {
+			err = m.summaryCache.Set(s)
+		}
+		if err == nil {
 			m.l.Debugf("Got %d initial summaries.", len(s))
 			running = true
 			m.changes <- agents.Change{Status: inventorypb.AgentStatus_RUNNING}
@@ -231,6 +254,9 @@ func (m *PerfSchema) Run(ctx context.Context) {
 
 			lengthS := uint32(math.Round(wait.Seconds())) // round 59.9s/60.1s to 60s
 			buckets, err := m.getNewBuckets(start, lengthS)
+			if dropped := m.waits.Rotate(); dropped != 0 {
+				m.l.Warnf("Dropped %d wait samples over the limit of %d queries and events.", dropped, waits.KeysLimit)
+			}
 
 			start = time.Now()
 			wait = start.Truncate(querySummaries).Add(querySummaries).Sub(start)
@@ -254,13 +280,75 @@ func (m *PerfSchema) Run(ctx context.Context) {
 	}
 }
 
+// restoreState loads summaries saved by the previous run into the cache.
+// It returns true if they were loaded.
+func (m *PerfSchema) restoreState() bool {
+	if m.stateFile == "" {
+		return false
+	}
+
+	err := m.summaryCache.cache.Restore(m.stateFile, cache.MaxStateAge)
+
+	// remove it to avoid using the same state twice if we crash
+	_ = os.Remove(m.stateFile)
+
+	switch {
+	case err == nil:
+		m.l.Infof("Restored %d summaries from %s.", m.summaryCache.cache.Len(), m.stateFile)
+		return m.summaryCache.cache.Len() != 0
+	case errors.Is(err, fs.ErrNotExist):
+		return false
+	default:
+		m.l.Warnf("Failed to restore summaries from %s: %s.", m.stateFile, err)
+		return false
+	}
+}
+
+// saveState saves cached summaries for the next run.
+func (m *PerfSchema) saveState() {
+	if m.stateFile == "" {
+		return
+	}
+
+	if err := m.summaryCache.cache.Snapshot(m.stateFile); err != nil {
+		m.l.Warnf("Failed to save summaries to %s: %s.", m.stateFile, err)
+		return
+	}
+	m.l.Debugf("Saved %d summaries to %s.", m.summaryCache.cache.Len(), m.stateFile)
+}
+
+// runHistoryCacheRefresher refreshes history cache every refreshHistory,
+// and samples waits of running statements every sampleWaits if setup_consumers allow that.
 func (m *PerfSchema) runHistoryCacheRefresher(ctx context.Context) {
-	t := time.NewTicker(refreshHistory)
+	t := time.NewTicker(sampleWaits)
 	defer t.Stop()
 
-	for {
-		if err := m.refreshHistoryCache(); err != nil {
-			m.l.Error(err)
+	refreshEvery := int(refreshHistory / sampleWaits)
+	var waitsEnabled, stagesEnabled bool
+	for i := 0; ; i++ {
+		if i%refreshEvery == 0 {
+			if err := m.refreshHistoryCache(); err != nil {
+				m.l.Error(err)
+			}
+
+			// consumers can be changed at runtime
+			w, s, err := getWaitConsumers(m.q)
+			if err != nil {
+				m.l.Error(err)
+			}
+			if w != waitsEnabled || s != stagesEnabled {
+				m.l.Infof("Waits sampling: %t, stages sampling: %t.", w, s)
+			}
+			waitsEnabled, stagesEnabled = w, s
+		}
+
+		if waitsEnabled {
+			keys, err := getWaits(ctx, m.q, stagesEnabled)
+			if err != nil {
+				m.l.Debug(err)
+			} else {
+				m.waits.Add(keys)
+			}
 		}
 
 		select {
@@ -315,6 +403,8 @@ func (m *PerfSchema) getNewBuckets(periodStart time.Time, periodLengthSecs uint3
 	}
 	m.l.Debugf("summaryCache: %s", m.summaryCache.cache.Stats())
 
+	currentHistograms, prevHistograms := m.getHistograms()
+
 	// add agent_id, timestamps, and examples from history cache
 	history := make(historyMap)
 	if err = m.historyCache.Get(history); err != nil {
@@ -325,6 +415,14 @@ func (m *PerfSchema) getNewBuckets(periodStart time.Time, periodLengthSecs uint3
 		b.Common.PeriodStartUnixSecs = startS
 		b.Common.PeriodLengthSecs = periodLengthSecs
 
+		// histograms of known queries are not available after restart until the next interval
+		prevHistogram := prevHistograms[b.Common.Queryid]
+		if prevHistogram != nil || prev[b.Common.Queryid] == nil {
+			if p99, ok := histogramP99(currentHistograms[b.Common.Queryid], prevHistogram); ok {
+				b.Common.MQueryTimeP99 = p99
+			}
+		}
+
 		if esh := history[b.Common.Queryid]; esh != nil {
 			// TODO test if we really need that
 			// If we don't need it, we can avoid polling events_statements_history completely
@@ -350,6 +448,36 @@ func (m *PerfSchema) getNewBuckets(periodStart time.Time, periodLengthSecs uint3
 	return buckets, nil
 }
 
+// getHistograms returns the current state of events_statements_histogram_by_digest table and the previous cached state.
+// Both are empty if histograms are not available.
+func (m *PerfSchema) getHistograms() (current, prev histogramMap) {
+	// available since MySQL 8.0, but not in MariaDB
+	mysqlVer := m.mySQLVersion()
+	if mysqlVer.version < 8 || mysqlVer.vendor == "mariadb" {
+		return nil, nil
+	}
+
+	current, err := getHistograms(m.q)
+	if err != nil {
+		// histograms are optional
+		m.l.Warn(err)
+		return nil, nil
+	}
+
+	prev = make(histogramMap)
+	if err = m.histogramCache.Get(prev); err != nil {
+		m.l.Warn(err)
+		return nil, nil
+	}
+
+	// merge prev and current in cache
+	if err = m.histogramCache.Set(current); err != nil {
+		m.l.Warn(err)
+	}
+	m.l.Debugf("histogramCache: %s", m.histogramCache.cache.Stats())
+	return current, prev
+}
+
 // inc returns increment from prev to current, or 0, if there was a wrap-around.
 func inc(current, prev uint64) float32 {
 	if current <= prev {
@@ -461,8 +589,10 @@ func (m *PerfSchema) Describe(ch chan<- *prometheus.Desc) {
 func (m *PerfSchema) Collect(ch chan<- prometheus.Metric) {
 	historyStats := m.historyCache.cache.Stats()
 	summaryStats := m.summaryCache.cache.Stats()
+	histogramStats := m.histogramCache.cache.Stats()
 	historyMetrics := cache.MetricsFromStats(historyStats, m.agentID, "history")
 	summaryMetrics := cache.MetricsFromStats(summaryStats, m.agentID, "summary")
+	histogramMetrics := cache.MetricsFromStats(histogramStats, m.agentID, "histogram")
 
 	for _, metric := range historyMetrics {
 		ch <- metric
@@ -470,6 +600,11 @@ func (m *PerfSchema) Collect(ch chan<- prometheus.Metric) {
 	for _, metric := range summaryMetrics {
 		ch <- metric
 	}
+	for _, metric := range histogramMetrics {
+		ch <- metric
+	}
+
+	m.waits.Collect(ch, m.agentID)
 }
 
 // check interfaces
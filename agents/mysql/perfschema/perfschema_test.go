@@ -165,6 +165,46 @@ func TestPerfSchemaMakeBuckets(t *testing.T) {
 	})
 }
 
+func TestHistogramP99(t *testing.T) {
+	histogram := func(counts map[uint32]uint64) *digestHistogram {
+		h := &digestHistogram{Buckets: make(map[uint32]histogramBucket)}
+		for n, c := range counts {
+			h.Buckets[n] = histogramBucket{High: uint64(n+1) * 1000000000, Count: c} // n+1 ms
+		}
+		return h
+	}
+
+	t.Run("New", func(t *testing.T) {
+		p99, ok := histogramP99(histogram(map[uint32]uint64{0: 98, 5: 1, 9: 1}), nil)
+		require.True(t, ok)
+		assert.InDelta(t, 0.006, p99, 0.0000001)
+	})
+
+	t.Run("Normal", func(t *testing.T) {
+		prev := histogram(map[uint32]uint64{0: 1000, 9: 1})
+		current := histogram(map[uint32]uint64{0: 1010, 9: 2}) // +10 fast, +1 slow
+		p99, ok := histogramP99(current, prev)
+		require.True(t, ok)
+		assert.InDelta(t, 0.01, p99, 0.0000001)
+	})
+
+	t.Run("Same", func(t *testing.T) {
+		h := histogram(map[uint32]uint64{0: 10})
+		_, ok := histogramP99(h, h)
+		assert.False(t, ok)
+		_, ok = histogramP99(nil, h)
+		assert.False(t, ok)
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		prev := histogram(map[uint32]uint64{0: 1000, 9: 1})
+		current := histogram(map[uint32]uint64{3: 5})
+		p99, ok := histogramP99(current, prev)
+		require.True(t, ok)
+		assert.InDelta(t, 0.004, p99, 0.0000001)
+	})
+}
+
 type setupParams struct {
 	db                   *reform.DB
 	disableQueryExamples bool
@@ -234,6 +274,45 @@ func filter(mb []*agentpb.MetricsBucket) []*agentpb.MetricsBucket {
 	return res
 }
 
+func TestWaits(t *testing.T) {
+	t.Run("Class", func(t *testing.T) {
+		for event, expected := range map[string]string{
+			"":                                      waitClassCPU,
+			"wait/io/table/sql/handler":             waitClassIO,
+			"wait/io/file/innodb/innodb_data_file":  waitClassIO,
+			"wait/lock/table/sql/handler":           waitClassLock,
+			"wait/lock/metadata/sql/mdl":            waitClassLock,
+			"wait/synch/mutex/innodb/trx_sys_mutex": waitClassLock,
+			"idle":                                  waitClassOther,
+		} {
+			assert.Equal(t, expected, waitClass(event), "%q", event)
+		}
+	})
+
+	t.Run("Consumers", func(t *testing.T) {
+		consumers := []*setupConsumers{
+			{Name: "global_instrumentation", Enabled: "YES"},
+			{Name: "thread_instrumentation", Enabled: "YES"},
+			{Name: "events_statements_current", Enabled: "YES"},
+			{Name: "events_waits_current", Enabled: "NO"},
+			{Name: "events_stages_current", Enabled: "YES"},
+		}
+		waits, stages := waitConsumers(consumers)
+		assert.False(t, waits)
+		assert.False(t, stages)
+
+		consumers[3].Enabled = "YES"
+		waits, stages = waitConsumers(consumers)
+		assert.True(t, waits)
+		assert.True(t, stages)
+
+		consumers[4].Enabled = "NO"
+		waits, stages = waitConsumers(consumers)
+		assert.True(t, waits)
+		assert.False(t, stages)
+	})
+}
+
 func TestPerfSchema(t *testing.T) {
 	sqlDB := tests.OpenTestMySQL(t)
 	defer sqlDB.Close() //nolint:errcheck
@@ -0,0 +1,138 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perfschema
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/reform.v1"
+
+	"github.com/percona/pmm-agent/agents/waits"
+)
+
+const (
+	prometheusNamespace = "pmm_agent"
+	prometheusSubsystem = "perfschema"
+
+	sampleWaits = time.Second
+
+	// wait classes
+	waitClassCPU   = "CPU"
+	waitClassIO    = "IO"
+	waitClassLock  = "Lock"
+	waitClassOther = "Other"
+)
+
+var waitSecondsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "wait_seconds"),
+	"Estimated time spent by query in wait event or stage during the last QAN interval "+
+		"(sampled from events_waits_current and events_stages_current).",
+	[]string{"agent_id", "queryid", "wait_class", "wait_event"},
+	nil)
+
+// waitConsumers returns true if setup_consumers allow sampling waits and stages of current statements.
+// Stages are optional: without them, time outside of waits is reported as CPU without stage name.
+func waitConsumers(consumers []*setupConsumers) (waitsEnabled, stagesEnabled bool) {
+	enabled := make(map[string]bool, len(consumers))
+	for _, c := range consumers {
+		enabled[c.Name] = c.Enabled == "YES"
+	}
+
+	base := enabled["global_instrumentation"] && enabled["thread_instrumentation"] && enabled["events_statements_current"]
+	waitsEnabled = base && enabled["events_waits_current"]
+	stagesEnabled = waitsEnabled && enabled["events_stages_current"]
+	return
+}
+
+// getWaitConsumers checks setup_consumers for waits sampling.
+func getWaitConsumers(q *reform.Querier) (waitsEnabled, stagesEnabled bool, err error) {
+	structs, err := q.SelectAllFrom(setupConsumersView, "")
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to query setup_consumers")
+	}
+
+	consumers := make([]*setupConsumers, len(structs))
+	for i, s := range structs {
+		consumers[i] = s.(*setupConsumers)
+	}
+	waitsEnabled, stagesEnabled = waitConsumers(consumers)
+	return waitsEnabled, stagesEnabled, nil
+}
+
+// waitClass returns wait class for wait event name (empty if thread is not waiting).
+func waitClass(waitEvent string) string {
+	switch {
+	case waitEvent == "":
+		return waitClassCPU
+	case strings.HasPrefix(waitEvent, "wait/io/"):
+		return waitClassIO
+	case strings.HasPrefix(waitEvent, "wait/lock/"), strings.HasPrefix(waitEvent, "wait/synch/"):
+		return waitClassLock
+	default:
+		return waitClassOther
+	}
+}
+
+// getWaits returns digests and wait events (or stages, if not waiting) of running statements.
+func getWaits(ctx context.Context, q *reform.Querier, stages bool) ([]waits.Key, error) {
+	stageColumn, stageJoin := "''", ""
+	if stages {
+		stageColumn = "COALESCE(st.EVENT_NAME, '')"
+		stageJoin = "LEFT JOIN performance_schema.events_stages_current st " +
+			"ON st.THREAD_ID = s.THREAD_ID AND st.END_EVENT_ID IS NULL "
+	}
+
+	// skip our own thread
+	rows, err := q.WithContext(ctx).Query(fmt.Sprintf(
+		"SELECT /* %s */ s.DIGEST, COALESCE(w.EVENT_NAME, ''), %s "+
+			"FROM performance_schema.events_statements_current s "+
+			"LEFT JOIN performance_schema.events_waits_current w ON w.THREAD_ID = s.THREAD_ID AND w.END_EVENT_ID IS NULL "+
+			"%s"+
+			"WHERE s.END_EVENT_ID IS NULL AND s.DIGEST IS NOT NULL "+
+			"AND s.THREAD_ID <> (SELECT THREAD_ID FROM performance_schema.threads WHERE PROCESSLIST_ID = CONNECTION_ID())",
+		queryTag, stageColumn, stageJoin))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query events_waits_current")
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []waits.Key
+	for rows.Next() {
+		var digest, waitEvent, stage string
+		if err = rows.Scan(&digest, &waitEvent, &stage); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		k := waits.Key{
+			QueryID: digest,
+			Class:   waitClass(waitEvent),
+			Event:   waitEvent,
+		}
+		if k.Class == waitClassCPU {
+			k.Event = stage
+			if k.Event == "" {
+				k.Event = waitClassCPU
+			}
+		}
+		res = append(res, k)
+	}
+	return res, errors.WithStack(rows.Err())
+}
@@ -0,0 +1,126 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowlog
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+)
+
+// ReplayParams represent Replay parameters.
+type ReplayParams struct {
+	AgentID              string
+	DisableQueryExamples bool
+	OutlierTime          float64
+	Location             *time.Location // for timestamps without time zone; local if nil
+}
+
+// Replay reads the whole slow log from the given reader and aggregates events into one-minute buckets
+// by events' own timestamps, using the same pipeline as SlowLog agent.
+// Buckets of each minute are passed to send in order of appearance; Replay stops on the first send error.
+// Events without timestamp (MySQL writes it only when it changes) are aggregated into the current minute.
+// Reader is closed when Replay returns.
+func Replay(ctx context.Context, reader parser.Reader, params *ReplayParams, send func([]*agentpb.MetricsBucket) error, l *logrus.Entry) error {
+	opts := log.Options{
+		DefaultLocation: params.Location,
+		FilterAdminCommand: map[string]bool{
+			"Binlog Dump":      true,
+			"Binlog Dump GTID": true,
+		},
+	}
+	if l.Logger.GetLevel() == logrus.TraceLevel {
+		opts.Debug = true
+		opts.Debugf = l.WithField("component", "slowlog/parser").Tracef
+	}
+
+	p := parser.NewSlowLogParser(reader, opts)
+	go p.Run()
+	defer func() {
+		// stop parser if we return early, and let it exit
+		_ = reader.Close()
+		for p.Parse() != nil {
+		}
+	}()
+
+	aggregator := event.NewAggregator(true, 0, params.OutlierTime)
+	var start time.Time
+	var events int
+	lengthS := uint32(aggregateInterval.Seconds())
+
+	flush := func() error {
+		if events == 0 {
+			return nil
+		}
+		if start.IsZero() {
+			return errors.New("slow log events have no timestamps")
+		}
+
+		res := aggregator.Finalize()
+		buckets := makeBuckets(params.AgentID, res, start, lengthS, params.DisableQueryExamples)
+		l.Debugf("Made %d buckets out of %d classes and %d events in %s+%d interval.",
+			len(buckets), len(res.Class), events, start.Format("2006-01-02 15:04:05"), lengthS)
+
+		aggregator = event.NewAggregator(true, 0, params.OutlierTime)
+		events = 0
+		return send(buckets)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		e := p.Parse()
+		if e == nil {
+			break
+		}
+
+		if !e.Ts.IsZero() {
+			minute := e.Ts.Truncate(aggregateInterval)
+			switch {
+			case start.IsZero():
+				// events before the first timestamp go to the first minute
+				start = minute
+			case !minute.Equal(start):
+				if err := flush(); err != nil {
+					return err
+				}
+				start = minute
+			}
+		}
+
+		l.Tracef("Parsed slowlog event: %+v.", e)
+		fingerprint := query.Fingerprint(e.Query)
+		digest := query.Id(fingerprint)
+		aggregator.AddEvent(e, digest, e.User, e.Host, e.Db, e.Server, fingerprint)
+		events++
+	}
+
+	if err := p.Err(); err != io.EOF {
+		return errors.Wrap(err, "failed to parse slow log")
+	}
+	return flush()
+}
@@ -0,0 +1,83 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowlog
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+)
+
+func TestReplay(t *testing.T) {
+	params := &ReplayParams{
+		AgentID:  "/agent_id/replay",
+		Location: time.UTC,
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		r, err := parser.NewSimpleFileReader(filepath.Join("parser", "testdata", "slow006.log"))
+		require.NoError(t, err)
+
+		var actual [][]*agentpb.MetricsBucket
+		err = Replay(context.Background(), r, params, func(buckets []*agentpb.MetricsBucket) error {
+			actual = append(actual, buckets)
+			return nil
+		}, logrus.WithField("test", t.Name()))
+		require.NoError(t, err)
+
+		// 11:48:27, 11:48:57 (twice) and 11:49:05, 11:49:07, 11:49:30
+		require.Len(t, actual, 2)
+		for i, expected := range []struct {
+			start   time.Time
+			queries float32
+		}{
+			{time.Date(2007, 12, 18, 11, 48, 0, 0, time.UTC), 3},
+			{time.Date(2007, 12, 18, 11, 49, 0, 0, time.UTC), 3},
+		} {
+			var queries float32
+			for _, b := range actual[i] {
+				assert.Equal(t, "/agent_id/replay", b.Common.AgentId)
+				assert.Equal(t, uint32(expected.start.Unix()), b.Common.PeriodStartUnixSecs)
+				assert.Equal(t, uint32(60), b.Common.PeriodLengthSecs)
+				queries += b.Common.NumQueries
+			}
+			assert.Equal(t, expected.queries, queries, "%d", i)
+		}
+	})
+
+	t.Run("SendError", func(t *testing.T) {
+		r, err := parser.NewSimpleFileReader(filepath.Join("parser", "testdata", "slow006.log"))
+		require.NoError(t, err)
+
+		var calls int
+		sendErr := errors.New("send error")
+		err = Replay(context.Background(), r, params, func([]*agentpb.MetricsBucket) error {
+			calls++
+			return sendErr
+		}, logrus.WithField("test", t.Name()))
+		assert.Equal(t, sendErr, err)
+		assert.Equal(t, 1, calls)
+	})
+}
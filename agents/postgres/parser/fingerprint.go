@@ -0,0 +1,46 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+
+	pgquery "github.com/pganalyze/pg_query_go"
+	"github.com/pkg/errors"
+)
+
+// Fingerprint returns normalized query with constants replaced by placeholders ($1, $2, …),
+// and query ID that is the same for queries that differ only in constants.
+func Fingerprint(query string) (fingerprint, queryID string, err error) {
+	if extractTablesRecover {
+		defer func() {
+			if r := recover(); r != nil {
+				// preserve stack
+				err = errors.WithStack(fmt.Errorf("panic: %v", r))
+			}
+		}()
+	}
+
+	if fingerprint, err = pgquery.Normalize(query); err != nil {
+		err = errors.Wrap(err, "error on normalizing sql query")
+		return
+	}
+	if queryID, err = pgquery.FastFingerprint(query); err != nil {
+		err = errors.Wrap(err, "error on fingerprinting sql query")
+		return
+	}
+	return
+}
@@ -110,3 +110,18 @@ func BenchmarkExtractTables(b *testing.B) {
 		})
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	fingerprint1, queryID1, err := Fingerprint("SELECT * FROM city WHERE id = 42 AND name = 'Kyiv'")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM city WHERE id = $1 AND name = $2", fingerprint1)
+	assert.NotEmpty(t, queryID1)
+
+	fingerprint2, queryID2, err := Fingerprint("SELECT * FROM city WHERE id = 1 AND name = 'Lviv'")
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint1, fingerprint2)
+	assert.Equal(t, queryID1, queryID2)
+
+	_, _, err = Fingerprint("SELECT * FROM")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pglog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/sirupsen/logrus"
+
+	"github.com/percona/pmm-agent/agents/postgres/parser"
+	"github.com/percona/pmm-agent/utils/truncate"
+)
+
+// histogramBounds are upper bounds of latency histogram ranges in milliseconds;
+// they are the same as pg_stat_monitor's default ones.
+var histogramBounds = []float64{3, 10, 31, 100, 316, 1000, 3162, 10000, 31622, 100000}
+
+// tablesCacheSize limits the number of fingerprints with extracted table names.
+const tablesCacheSize = 5000
+
+// classKey identifies a single bucket.
+type classKey struct {
+	queryID  string
+	user     string
+	database string
+	host     string
+}
+
+// class contains aggregated data for a single bucket.
+type class struct {
+	fingerprint string
+	tables      []string
+	times       []float64
+	example     *entry // the slowest one
+}
+
+// aggregator aggregates duration entries by fingerprint, user, database and client host.
+type aggregator struct {
+	classes map[classKey]*class
+	tables  map[string][]string // by fingerprint, to avoid parsing the same query every minute
+	l       *logrus.Entry
+}
+
+func newAggregator(l *logrus.Entry) *aggregator {
+	return &aggregator{
+		classes: make(map[classKey]*class),
+		tables:  make(map[string][]string),
+		l:       l,
+	}
+}
+
+// add adds entry to the current interval.
+func (a *aggregator) add(e *entry) {
+	fingerprint, queryID, err := parser.Fingerprint(e.query)
+	if err != nil {
+		// keep data for queries our parser can't handle, but don't try to merge them
+		a.l.Debugf("Failed to fingerprint query: %s.", err)
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(e.query))
+		fingerprint, queryID = e.query, fmt.Sprintf("%016x", h.Sum64())
+	}
+
+	key := classKey{
+		queryID:  queryID,
+		user:     e.user,
+		database: e.database,
+		host:     e.host,
+	}
+	c := a.classes[key]
+	if c == nil {
+		c = &class{
+			fingerprint: fingerprint,
+		}
+		a.classes[key] = c
+	}
+
+	c.times = append(c.times, e.duration)
+	if c.example == nil || e.duration > c.example.duration {
+		c.example = e
+	}
+}
+
+// makeBuckets returns buckets for the current interval and resets it.
+func (a *aggregator) makeBuckets(agentID string, agentType inventorypb.AgentType, periodStart time.Time, periodLengthSecs uint32,
+	disableQueryExamples bool,
+) []*agentpb.MetricsBucket {
+	res := make([]*agentpb.MetricsBucket, 0, len(a.classes))
+	for key, c := range a.classes {
+		tables, ok := a.tables[c.fingerprint]
+		if !ok {
+			var err error
+			if tables, err = parser.ExtractTables(c.fingerprint); err != nil {
+				a.l.Debugf("Can't extract table names from query %s: %v.", c.fingerprint, err)
+			}
+			if len(a.tables) >= tablesCacheSize {
+				a.tables = make(map[string][]string)
+			}
+			a.tables[c.fingerprint] = tables
+		}
+
+		fingerprint, isTruncated := truncate.Query(c.fingerprint)
+		mb := &agentpb.MetricsBucket{
+			Common: &agentpb.MetricsBucket_Common{
+				Queryid:             key.queryID,
+				Fingerprint:         fingerprint,
+				Database:            key.database,
+				Username:            key.user,
+				ClientHost:          key.host,
+				Tables:              tables,
+				AgentId:             agentID,
+				AgentType:           agentType,
+				PeriodStartUnixSecs: uint32(periodStart.Unix()),
+				PeriodLengthSecs:    periodLengthSecs,
+				IsTruncated:         isTruncated,
+			},
+			Postgresql: &agentpb.MetricsBucket_PostgreSQL{
+				ApplicationName: c.example.app,
+				QueryPlan:       c.example.plan,
+				HistogramItems:  makeHistogram(c.times),
+			},
+		}
+
+		sort.Float64s(c.times)
+		var sum float64
+		for _, t := range c.times {
+			sum += t
+		}
+		n := len(c.times)
+		mb.Common.NumQueries = float32(n)
+		mb.Common.MQueryTimeCnt = float32(n)
+		mb.Common.MQueryTimeSum = float32(sum)
+		mb.Common.MQueryTimeMin = float32(c.times[0])
+		mb.Common.MQueryTimeMax = float32(c.times[n-1])
+		mb.Common.MQueryTimeP99 = float32(c.times[int(math.Ceil(0.99*float64(n)))-1])
+
+		if !disableQueryExamples {
+			example, truncated := truncate.Query(c.example.query)
+			if truncated {
+				mb.Common.IsTruncated = truncated
+			}
+			mb.Common.Example = example
+			mb.Common.ExampleFormat = agentpb.ExampleFormat_EXAMPLE
+			mb.Common.ExampleType = agentpb.ExampleType_SLOWEST
+		}
+
+		res = append(res, mb)
+	}
+
+	a.classes = make(map[classKey]*class)
+	return res
+}
+
+// makeHistogram returns latency histogram for given query times in seconds.
+func makeHistogram(times []float64) []*agentpb.HistogramItem {
+	res := make([]*agentpb.HistogramItem, len(histogramBounds))
+	var lower float64
+	for i, upper := range histogramBounds {
+		res[i] = &agentpb.HistogramItem{
+			Range: fmt.Sprintf("(%.0f - %.0f)", lower, upper),
+		}
+		lower = upper
+	}
+
+	for _, t := range times {
+		ms := t * 1000
+		i := sort.SearchFloat64s(histogramBounds, ms)
+		if i == len(histogramBounds) {
+			// the last range is open-ended
+			i--
+		}
+		res[i].Frequency++
+	}
+
+	return res
+}
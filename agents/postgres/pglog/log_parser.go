@@ -0,0 +1,276 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pglog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+)
+
+// Supported values of log_destination.
+const (
+	FormatStderr = "stderr"
+	FormatCSVLog = "csvlog"
+)
+
+// csvlog columns, see https://www.postgresql.org/docs/current/runtime-config-logging.html#RUNTIME-CONFIG-LOGGING-CSVLOG
+const (
+	csvUserName        = 1
+	csvDatabaseName    = 2
+	csvConnectionFrom  = 4
+	csvErrorSeverity   = 11
+	csvMessage         = 13
+	csvApplicationName = 22
+	csvMinFields       = 23
+)
+
+var (
+	// duration entry logged by log_min_duration_statement, log_min_duration_sample or auto_explain
+	durationRE = regexp.MustCompile(`(?s)^duration: ([0-9.]+) ms(?:  (statement|execute [^:\n]+|plan):\s?(.*))?$`)
+
+	// log_line_prefix escapes we understand: user=%u db=%d host=%h app=%a and %u@%d
+	prefixUserRE   = regexp.MustCompile(`(?:^|[\s,\[])user=([^\s,\]]*)`)
+	prefixDBRE     = regexp.MustCompile(`(?:^|[\s,\[])db=([^\s,\]]*)`)
+	prefixHostRE   = regexp.MustCompile(`(?:^|[\s,\[])host=([^\s,\]]*)`)
+	prefixAppRE    = regexp.MustCompile(`(?:^|[\s,\[])app=([^\s,\]]*)`)
+	prefixUserDBRE = regexp.MustCompile(`(?:^|\s)([^\s@\[\]]+)@([^\s@\[\]]+)\s*$`)
+
+	// first line of text plan node: "Seq Scan on t  (cost=…)" or "Result  (actual time=…)"
+	planNodeRE = regexp.MustCompile(`  \((?:cost=|actual time=|actual rows=|never executed)`)
+)
+
+// entry represents a single statement with duration from PostgreSQL log.
+type entry struct {
+	user     string
+	database string
+	host     string
+	app      string
+	duration float64 // in seconds
+	query    string
+	plan     string // auto_explain output, if any
+}
+
+// logParser reads PostgreSQL log records and extracts duration entries from them.
+//
+// A record is complete only when the first line of the next one is read, so the last record is returned
+// only when the next one is written or the reader returns an error.
+type logParser struct {
+	r      parser.Reader
+	format string
+
+	pending string // first line of the next stderr record
+	err     error  // reader error to return after the last record
+}
+
+func newLogParser(r parser.Reader, format string) (*logParser, error) {
+	switch format {
+	case FormatStderr, FormatCSVLog:
+	default:
+		return nil, errors.Errorf("unsupported log format %q", format)
+	}
+
+	return &logParser{
+		r:      r,
+		format: format,
+	}, nil
+}
+
+// next returns the next duration entry.
+// Records that are not duration entries or can't be parsed are skipped.
+// Reader error (io.EOF if reader was closed) is returned after the last entry.
+func (p *logParser) next() (*entry, error) {
+	for {
+		rec, err := p.record()
+		if err != nil {
+			return nil, err
+		}
+
+		var e *entry
+		switch p.format {
+		case FormatCSVLog:
+			e = parseCSVRecord(rec)
+		default:
+			e = parseStderrRecord(rec)
+		}
+		if e != nil {
+			return e, nil
+		}
+	}
+}
+
+// record returns the next full log record.
+func (p *logParser) record() (string, error) {
+	rec := p.pending
+	p.pending = ""
+
+	for p.err == nil {
+		line, err := p.r.NextLine()
+		p.err = err
+		if line == "" {
+			continue
+		}
+
+		if p.format == FormatCSVLog {
+			// quoted values may contain newlines; record is complete when quotes are balanced
+			rec += line
+			if strings.Count(rec, `"`)%2 == 0 {
+				return rec, nil
+			}
+			continue
+		}
+
+		// stderr continuation lines start with tab
+		if rec == "" || strings.HasPrefix(line, "\t") {
+			rec += line
+			continue
+		}
+		p.pending = line
+		return rec, nil
+	}
+
+	if rec != "" {
+		return rec, nil
+	}
+	return "", p.err
+}
+
+// parseStderrRecord parses stderr log record, or returns nil if it is not a duration entry.
+func parseStderrRecord(rec string) *entry {
+	lines := strings.Split(strings.TrimRight(rec, "\n"), "\n")
+	i := strings.Index(lines[0], "LOG:  duration: ")
+	if i < 0 {
+		return nil
+	}
+
+	prefix := lines[0][:i]
+	lines[0] = lines[0][i+len("LOG:  "):]
+	for j := 1; j < len(lines); j++ {
+		lines[j] = strings.TrimPrefix(lines[j], "\t")
+	}
+
+	e := parseMessage(strings.Join(lines, "\n"))
+	if e == nil {
+		return nil
+	}
+
+	e.user = findSubmatch(prefixUserRE, prefix)
+	e.database = findSubmatch(prefixDBRE, prefix)
+	e.host = findSubmatch(prefixHostRE, prefix)
+	e.app = findSubmatch(prefixAppRE, prefix)
+	if e.user == "" && e.database == "" {
+		if m := prefixUserDBRE.FindStringSubmatch(prefix); m != nil {
+			e.user, e.database = m[1], m[2]
+		}
+	}
+	return e
+}
+
+// parseCSVRecord parses csvlog record, or returns nil if it is not a duration entry.
+func parseCSVRecord(rec string) *entry {
+	r := csv.NewReader(strings.NewReader(rec))
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	if err != nil || len(fields) < csvMinFields || fields[csvErrorSeverity] != "LOG" {
+		return nil
+	}
+
+	e := parseMessage(fields[csvMessage])
+	if e == nil {
+		return nil
+	}
+
+	e.user = fields[csvUserName]
+	e.database = fields[csvDatabaseName]
+	e.host = fields[csvConnectionFrom]
+	if host, _, err := net.SplitHostPort(e.host); err == nil {
+		e.host = host
+	}
+	e.app = fields[csvApplicationName]
+	return e
+}
+
+// parseMessage parses duration message, or returns nil if it doesn't contain a statement.
+func parseMessage(msg string) *entry {
+	m := durationRE.FindStringSubmatch(msg)
+	if m == nil || m[2] == "" {
+		// log_duration without statement text
+		return nil
+	}
+
+	ms, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return nil
+	}
+
+	e := &entry{
+		duration: ms / 1000,
+	}
+	if m[2] == "plan" {
+		e.query, e.plan = parsePlan(m[3])
+	} else {
+		e.query = strings.TrimSpace(m[3])
+	}
+
+	if e.query == "" {
+		return nil
+	}
+	return e
+}
+
+// parsePlan extracts query text and plan from auto_explain output in text or JSON format.
+func parsePlan(s string) (query, plan string) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "{") {
+		var p struct {
+			QueryText string `json:"Query Text"`
+		}
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			return "", ""
+		}
+		return strings.TrimSpace(p.QueryText), s
+	}
+
+	if !strings.HasPrefix(s, "Query Text: ") {
+		// YAML and XML formats are not supported
+		return "", ""
+	}
+	s = strings.TrimPrefix(s, "Query Text: ")
+
+	// query text may span several lines, so find the first plan node
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if planNodeRE.MatchString(lines[i]) {
+			return strings.TrimSpace(strings.Join(lines[:i], "\n")), strings.Join(lines[i:], "\n")
+		}
+	}
+	return strings.TrimSpace(lines[0]), strings.Join(lines[1:], "\n")
+}
+
+func findSubmatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,212 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pglog runs built-in QAN Agent for PostgreSQL server log.
+//
+// It is an alternative to pg_stat_statements and pg_stat_monitor for servers where extensions can't be installed.
+// Statements are logged by log_min_duration_statement (or log_min_duration_sample) and auto_explain.
+// Please note that statements that are logged by both are counted twice.
+package pglog
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/percona/pmm-agent/agents"
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+	"github.com/percona/pmm-agent/utils/backoff"
+)
+
+const (
+	backoffMinDelay   = 1 * time.Second
+	backoffMaxDelay   = 30 * time.Second
+	aggregateInterval = time.Minute
+)
+
+// PGLogQAN extracts performance data from PostgreSQL server log.
+type PGLogQAN struct {
+	params  *Params
+	l       *logrus.Entry
+	changes chan agents.Change
+}
+
+// Params represent Agent parameters.
+type Params struct {
+	LogFilePath          string // path to the current log file (or a symlink to it)
+	Format               string // FormatStderr (default) or FormatCSVLog
+	AgentID              string
+	AgentType            inventorypb.AgentType // type of the Agent the log is used instead of, for buckets
+	DisableQueryExamples bool
+}
+
+// New creates new PGLogQAN QAN service.
+func New(params *Params, l *logrus.Entry) (*PGLogQAN, error) {
+	if params.LogFilePath == "" {
+		return nil, errors.New("log file is not set")
+	}
+	if params.Format == "" {
+		params.Format = FormatStderr
+	}
+	if _, err := newLogParser(nil, params.Format); err != nil {
+		return nil, err
+	}
+
+	return &PGLogQAN{
+		params:  params,
+		l:       l,
+		changes: make(chan agents.Change, 10),
+	}, nil
+}
+
+// Run extracts performance data and sends it to the channel until ctx is canceled.
+func (m *PGLogQAN) Run(ctx context.Context) {
+	defer func() {
+		m.changes <- agents.Change{Status: inventorypb.AgentStatus_DONE}
+		close(m.changes)
+	}()
+
+	b := backoff.New(backoffMinDelay, backoffMaxDelay)
+	for {
+		m.changes <- agents.Change{Status: inventorypb.AgentStatus_STARTING}
+
+		started := time.Now()
+		err := m.processFile(ctx)
+		if ctx.Err() != nil {
+			m.changes <- agents.Change{Status: inventorypb.AgentStatus_STOPPING}
+			m.l.Infof("Context canceled.")
+			return
+		}
+
+		m.l.Errorf("Failed to process %s: %v.", m.params.LogFilePath, err)
+		m.changes <- agents.Change{Status: inventorypb.AgentStatus_WAITING}
+
+		if time.Since(started) > backoffMaxDelay {
+			b.Reset()
+		}
+		t := time.NewTimer(b.Delay())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			m.changes <- agents.Change{Status: inventorypb.AgentStatus_STOPPING}
+			m.l.Infof("Context canceled.")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// processFile extracts performance data from the log file and sends it to the channel until ctx is canceled
+// or the file can't be read anymore.
+func (m *PGLogQAN) processFile(ctx context.Context) error {
+	rl := m.l.WithField("component", "pglog/reader").WithField("file", m.params.LogFilePath)
+	reader, err := parser.NewContinuousFileReader(m.params.LogFilePath, rl)
+	if err != nil {
+		return err
+	}
+
+	p, err := newLogParser(reader, m.params.Format)
+	if err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	// send entries to the channel, close it when parser is done
+	entries := make(chan *entry, 1000)
+	parserDone := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		for {
+			e, err := p.next()
+			if err != nil {
+				parserDone <- err
+				return
+			}
+			entries <- e
+		}
+	}()
+
+	m.changes <- agents.Change{Status: inventorypb.AgentStatus_RUNNING}
+
+	aggregator := newAggregator(m.l)
+	ctxDone := ctx.Done()
+
+	// aggregate every minute at 00 seconds
+	start := time.Now()
+	wait := start.Truncate(aggregateInterval).Add(aggregateInterval).Sub(start)
+	m.l.Debugf("Scheduling next aggregation in %s at %s.", wait, start.Add(wait).Format("15:04:05"))
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctxDone:
+			err = reader.Close() // that will let parser to stop
+			m.l.Infof("Context done with %s. Reader closed with %v.", ctx.Err(), err)
+			ctxDone = nil
+
+		case e, ok := <-entries:
+			if !ok {
+				// parser is done; reader returns io.EOF when it is closed by us or by itself
+				err = <-parserDone
+				if err == io.EOF && ctx.Err() == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+
+			m.l.Tracef("Parsed log entry: %+v.", e)
+			aggregator.add(e)
+
+		case <-t.C:
+			lengthS := uint32(math.Round(wait.Seconds())) // round 59.9s/60.1s to 60s
+			buckets := aggregator.makeBuckets(m.params.AgentID, m.params.AgentType, start, lengthS, m.params.DisableQueryExamples)
+			m.l.Debugf("Made %d buckets in %s+%d interval.", len(buckets), start.Format("15:04:05"), lengthS)
+
+			start = time.Now()
+			wait = start.Truncate(aggregateInterval).Add(aggregateInterval).Sub(start)
+			m.l.Debugf("Scheduling next aggregation in %s at %s.", wait, start.Add(wait).Format("15:04:05"))
+			t.Reset(wait)
+
+			m.changes <- agents.Change{MetricsBucket: buckets}
+		}
+	}
+}
+
+// Changes returns channel that should be read until it is closed.
+func (m *PGLogQAN) Changes() <-chan agents.Change {
+	return m.changes
+}
+
+// Describe implements prometheus.Collector.
+func (m *PGLogQAN) Describe(ch chan<- *prometheus.Desc) {
+	// This method is needed to satisfy interface.
+}
+
+// Collect implement prometheus.Collector.
+func (m *PGLogQAN) Collect(ch chan<- prometheus.Metric) {
+	// This method is needed to satisfy interface.
+}
+
+// check interfaces
+var (
+	_ prometheus.Collector = (*PGLogQAN)(nil)
+)
@@ -0,0 +1,177 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pglog
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+)
+
+func parseFile(t *testing.T, file, format string) []*entry {
+	t.Helper()
+
+	r, err := parser.NewSimpleFileReader(filepath.Join("testdata", file))
+	require.NoError(t, err)
+	defer r.Close() //nolint:errcheck
+
+	p, err := newLogParser(r, format)
+	require.NoError(t, err)
+
+	var res []*entry
+	for {
+		e, err := p.next()
+		if err == io.EOF {
+			return res
+		}
+		require.NoError(t, err)
+		res = append(res, e)
+	}
+}
+
+func TestLogParser(t *testing.T) {
+	t.Run("Stderr", func(t *testing.T) {
+		expected := []*entry{{
+			user:     "alice",
+			database: "shop",
+			host:     "10.0.0.1",
+			app:      "psql",
+			duration: 0.0125,
+			query:    "SELECT * FROM orders WHERE id = 42",
+		}, {
+			user:     "bob",
+			database: "shop",
+			host:     "10.0.0.2",
+			app:      "app",
+			duration: 0.00025,
+			query:    "SELECT name\n\tFROM customers\n\tWHERE id = $1",
+		}, {
+			user:     "alice",
+			database: "shop",
+			host:     "10.0.0.1",
+			app:      "psql",
+			duration: 1.5,
+			query:    "SELECT * FROM orders\n  WHERE id = 7",
+			plan:     "Index Scan using orders_pkey on orders  (cost=0.29..8.30 rows=1 width=40)\n  Index Cond: (id = 7)",
+		}, {
+			user:     "carol",
+			database: "warehouse",
+			duration: 0.003,
+			query:    "UPDATE stock SET qty = qty - 1 WHERE item = 'apple'",
+		}}
+		assert.Equal(t, expected, parseFile(t, "stderr.log", FormatStderr))
+	})
+
+	t.Run("CSVLog", func(t *testing.T) {
+		expected := []*entry{{
+			user:     "alice",
+			database: "shop",
+			host:     "10.0.0.1",
+			app:      "psql",
+			duration: 0.0125,
+			query:    "SELECT * FROM orders WHERE id = 42",
+		}, {
+			user:     "bob",
+			database: "shop",
+			host:     "[local]",
+			app:      "app",
+			duration: 0.00025,
+			query:    "SELECT name\nFROM customers\nWHERE id = $1",
+		}, {
+			user:     "alice",
+			database: "shop",
+			host:     "10.0.0.1",
+			app:      "psql",
+			duration: 1.5,
+			query:    "SELECT * FROM orders WHERE id = 7",
+			plan:     "{\n  \"Query Text\": \"SELECT * FROM orders WHERE id = 7\",\n  \"Plan\": {\n    \"Node Type\": \"Index Scan\",\n    \"Relation Name\": \"orders\"\n  }\n}",
+		}}
+		assert.Equal(t, expected, parseFile(t, "csvlog.csv", FormatCSVLog))
+	})
+
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		_, err := New(&Params{LogFilePath: "postgresql.json", Format: "jsonlog"}, logrus.WithField("test", t.Name()))
+		assert.EqualError(t, err, `unsupported log format "jsonlog"`)
+	})
+}
+
+func TestAggregator(t *testing.T) {
+	a := newAggregator(logrus.WithField("test", t.Name()))
+	for _, e := range parseFile(t, "stderr.log", FormatStderr) {
+		a.add(e)
+	}
+	a.add(&entry{user: "alice", database: "shop", host: "10.0.0.1", duration: 0.02, query: "SELECT * FROM orders WHERE id = 1"})
+
+	start := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	buckets := a.makeBuckets("/agent_id/pglog", inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT, start, 60, false)
+	require.Len(t, buckets, 3)
+
+	var orders *agentpb.MetricsBucket
+	for _, b := range buckets {
+		assert.Equal(t, "/agent_id/pglog", b.Common.AgentId)
+		assert.Equal(t, inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT, b.Common.AgentType)
+		assert.Equal(t, uint32(start.Unix()), b.Common.PeriodStartUnixSecs)
+		assert.Equal(t, uint32(60), b.Common.PeriodLengthSecs)
+		assert.NotEmpty(t, b.Common.Queryid)
+		if b.Common.Fingerprint == "SELECT * FROM orders WHERE id = $1" {
+			orders = b
+		}
+	}
+
+	require.NotNil(t, orders)
+	assert.Equal(t, []string{"orders"}, orders.Common.Tables)
+	assert.Equal(t, "alice", orders.Common.Username)
+	assert.Equal(t, "shop", orders.Common.Database)
+	assert.Equal(t, "10.0.0.1", orders.Common.ClientHost)
+	assert.Equal(t, float32(3), orders.Common.NumQueries)
+	assert.InDelta(t, 1.5325, orders.Common.MQueryTimeSum, 0.0001)
+	assert.InDelta(t, 0.0125, orders.Common.MQueryTimeMin, 0.0001)
+	assert.InDelta(t, 1.5, orders.Common.MQueryTimeMax, 0.0001)
+	assert.InDelta(t, 1.5, orders.Common.MQueryTimeP99, 0.0001)
+	assert.Equal(t, "SELECT * FROM orders\n  WHERE id = 7", orders.Common.Example)
+	assert.Equal(t, agentpb.ExampleType_SLOWEST, orders.Common.ExampleType)
+	assert.Contains(t, orders.Postgresql.QueryPlan, "Index Scan using orders_pkey")
+
+	frequencies := make(map[string]uint32)
+	for _, h := range orders.Postgresql.HistogramItems {
+		if h.Frequency != 0 {
+			frequencies[h.Range] = h.Frequency
+		}
+	}
+	assert.Equal(t, map[string]uint32{"(10 - 31)": 2, "(1000 - 3162)": 1}, frequencies)
+
+	assert.Empty(t, a.makeBuckets("/agent_id/pglog", inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT, start.Add(time.Minute), 60, false))
+}
+
+func TestNew(t *testing.T) {
+	l := logrus.WithField("test", t.Name())
+
+	_, err := New(&Params{Format: FormatStderr}, l)
+	assert.EqualError(t, err, "log file is not set")
+
+	m, err := New(&Params{LogFilePath: "postgresql.log"}, l)
+	require.NoError(t, err)
+	assert.Equal(t, FormatStderr, m.params.Format)
+}
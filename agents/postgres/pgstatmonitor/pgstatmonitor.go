@@ -21,7 +21,10 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/AlekSi/pointer"
@@ -38,19 +41,25 @@ import (
 	"gopkg.in/reform.v1/dialects/postgresql"
 
 	"github.com/percona/pmm-agent/agents"
+	"github.com/percona/pmm-agent/agents/cache"
+	"github.com/percona/pmm-agent/agents/waits"
 	"github.com/percona/pmm-agent/utils/version"
 )
 
-const defaultWaitTime = 60 * time.Second
+const (
+	defaultWaitTime = 60 * time.Second
+)
 
 // PGStatMonitorQAN QAN services connects to PostgreSQL and extracts stats.
 type PGStatMonitorQAN struct {
 	q            *reform.Querier
 	dbCloser     io.Closer
 	agentID      string
+	stateFile    string
 	l            *logrus.Entry
 	changes      chan agents.Change
 	monitorCache *statMonitorCache
+	waits        *waits.Profile
 
 	// By default, query shows the actual parameter instead of the placeholder.
 	// It is quite useful when users want to use that query and try to run that
@@ -68,6 +77,7 @@ type Params struct {
 	DisableQueryExamples bool
 	TextFiles            *agentpb.TextFiles
 	AgentID              string
+	StateFile            string // stat monitor is persisted there between restarts if set
 }
 
 type (
@@ -119,7 +129,7 @@ func New(params *Params, l *logrus.Entry) (*PGStatMonitorQAN, error) {
 	// TODO register reformL metrics https://jira.percona.com/browse/PMM-4087
 	q := reform.NewDB(sqlDB, postgresql.Dialect, reformL).WithTag(queryTag)
 
-	return newPgStatMonitorQAN(q, sqlDB, params.AgentID, params.DisableQueryExamples, l)
+	return newPgStatMonitorQAN(q, sqlDB, params.AgentID, params.StateFile, params.DisableQueryExamples, l)
 }
 
 func isPropertyValueInt(property string) bool {
@@ -154,7 +164,7 @@ func areSettingsTextValues(q *reform.Querier) (bool, error) {
 	return false, nil
 }
 
-func newPgStatMonitorQAN(q *reform.Querier, dbCloser io.Closer, agentID string, disableQueryExamples bool, l *logrus.Entry) (*PGStatMonitorQAN, error) {
+func newPgStatMonitorQAN(q *reform.Querier, dbCloser io.Closer, agentID, stateFile string, disableQueryExamples bool, l *logrus.Entry) (*PGStatMonitorQAN, error) {
 	var settings []reform.Struct
 
 	settingsValuesAreText, err := areSettingsTextValues(q)
@@ -213,6 +223,7 @@ func newPgStatMonitorQAN(q *reform.Querier, dbCloser io.Closer, agentID string,
 		l:                    l,
 		changes:              make(chan agents.Change, 10),
 		monitorCache:         newStatMonitorCache(l),
+		waits:                waits.NewProfile(waitsSampleInterval, waitSecondsDesc),
 		pgsmNormalizedQuery:  normalizedQuery,
 		waitTime:             waitTime,
 		disableQueryExamples: disableQueryExamples,
@@ -271,16 +282,21 @@ func getPGMonitorVersion(q *reform.Querier) (pgStatMonitorVersion, pgStatMonitor
 // Run extracts stats data and sends it to the channel until ctx is canceled.
 func (m *PGStatMonitorQAN) Run(ctx context.Context) {
 	defer func() {
+		m.saveState()
 		m.dbCloser.Close() //nolint:errcheck
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_DONE}
 		close(m.changes)
 	}()
 
-	// add current stat monitor to cache so they are not send as new on first iteration with incorrect timestamps
+	// add current stat monitor to cache so they are not send as new on first iteration with incorrect timestamps,
+	// unless stat monitor from the previous run was restored
 	var running bool
 	m.changes <- agents.Change{Status: inventorypb.AgentStatus_STARTING}
+	restored := m.restoreState()
 	if current, _, err := m.monitorCache.getStatMonitorExtended(ctx, m.q, m.pgsmNormalizedQuery); err == nil {
-		m.monitorCache.refresh(current)
+		if !restored {
+			m.monitorCache.refresh(current)
+		}
 		m.l.Debugf("Got %d initial stat monitor.", len(current))
 		running = true
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_RUNNING}
@@ -289,6 +305,15 @@ func (m *PGStatMonitorQAN) Run(ctx context.Context) {
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_WAITING}
 	}
 
+	// wait for the sampler to stop before closing the database
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.runWaitSampler(ctx)
+	}()
+
 	// query pg_stat_monitor every waitTime seconds
 	start := time.Now()
 	m.l.Debugf("Scheduling next collection in %s at %s.", m.waitTime, start.Add(m.waitTime).Format("15:04:05"))
@@ -309,6 +334,9 @@ func (m *PGStatMonitorQAN) Run(ctx context.Context) {
 
 			lengthS := uint32(m.waitTime.Seconds())
 			buckets, err := m.getNewBuckets(ctx, lengthS)
+			if dropped := m.waits.Rotate(); dropped != 0 {
+				m.l.Warnf("Dropped %d wait event samples over the limit of %d queries and events.", dropped, waits.KeysLimit)
+			}
 
 			start = time.Now()
 			m.l.Debugf("Scheduling next collection in %s at %s.", m.waitTime, start.Add(m.waitTime).Format("15:04:05"))
@@ -331,6 +359,43 @@ func (m *PGStatMonitorQAN) Run(ctx context.Context) {
 	}
 }
 
+// restoreState loads stat monitor saved by the previous run into the cache.
+// It returns true if it was loaded.
+func (m *PGStatMonitorQAN) restoreState() bool {
+	if m.stateFile == "" {
+		return false
+	}
+
+	err := m.monitorCache.restore(m.stateFile, cache.MaxStateAge)
+
+	// remove it to avoid using the same state twice if we crash
+	_ = os.Remove(m.stateFile)
+
+	switch {
+	case err == nil:
+		m.l.Infof("Restored stat monitor from %s: %s.", m.stateFile, m.monitorCache.stats())
+		return m.monitorCache.stats().current != 0
+	case errors.Is(err, fs.ErrNotExist):
+		return false
+	default:
+		m.l.Warnf("Failed to restore stat monitor from %s: %s.", m.stateFile, err)
+		return false
+	}
+}
+
+// saveState saves cached stat monitor for the next run.
+func (m *PGStatMonitorQAN) saveState() {
+	if m.stateFile == "" {
+		return
+	}
+
+	if err := m.monitorCache.snapshot(m.stateFile); err != nil {
+		m.l.Warnf("Failed to save stat monitor to %s: %s.", m.stateFile, err)
+		return
+	}
+	m.l.Debugf("Saved stat monitor to %s: %s.", m.stateFile, m.monitorCache.stats())
+}
+
 func (m *PGStatMonitorQAN) getNewBuckets(ctx context.Context, periodLengthSecs uint32) ([]*agentpb.MetricsBucket, error) {
 	current, prev, err := m.monitorCache.getStatMonitorExtended(ctx, m.q, m.pgsmNormalizedQuery)
 	if err != nil {
@@ -538,7 +603,7 @@ func (m *PGStatMonitorQAN) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implement prometheus.Collector.
 func (m *PGStatMonitorQAN) Collect(ch chan<- prometheus.Metric) {
-	// This method is needed to satisfy interface.
+	m.waits.Collect(ch, m.agentID)
 }
 
 // check interfaces
@@ -27,6 +27,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"gopkg.in/reform.v1"
 
+	"github.com/percona/pmm-agent/agents/cache"
 	"github.com/percona/pmm-agent/utils/truncate"
 )
 
@@ -229,6 +230,38 @@ func (ssc *statMonitorCache) refresh(current map[time.Time]map[string]*pgStatMon
 	ssc.items = current
 }
 
+// statMonitorSnapshot is a serialized form of statMonitorCache.
+type statMonitorSnapshot struct {
+	Saved time.Time                                       `json:"saved"`
+	Items map[time.Time]map[string]*pgStatMonitorExtended `json:"items"`
+}
+
+// snapshot writes cache items to the file at the given path.
+func (ssc *statMonitorCache) snapshot(path string) error {
+	ssc.rw.RLock()
+	defer ssc.rw.RUnlock()
+
+	return cache.WriteSnapshotFile(path, &statMonitorSnapshot{
+		Saved: time.Now(),
+		Items: ssc.items,
+	})
+}
+
+// restore replaces cache items with ones from the file at the given path written by snapshot.
+// Snapshots older than maxAge are not used.
+func (ssc *statMonitorCache) restore(path string, maxAge time.Duration) error {
+	var s statMonitorSnapshot
+	if err := cache.ReadSnapshotFile(path, &s); err != nil {
+		return err
+	}
+	if time.Since(s.Saved) > maxAge {
+		return cache.ErrSnapshotExpired
+	}
+
+	ssc.refresh(s.Items)
+	return nil
+}
+
 func queryDatabases(q *reform.Querier) map[int64]string {
 	structs, err := q.SelectAllFrom(pgStatDatabaseView, "")
 	if err != nil {
@@ -0,0 +1,78 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgstatmonitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ver "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/percona/pmm-agent/agents/waits"
+)
+
+const (
+	prometheusNamespace = "pmm_agent"
+	prometheusSubsystem = "pgstatmonitor"
+
+	waitsSampleInterval = 500 * time.Millisecond
+)
+
+// v20 is the first pg_stat_monitor version that uses PostgreSQL query IDs;
+// earlier versions use their own hashes that don't match pg_stat_activity.query_id.
+var v20 = ver.Must(ver.NewVersion("2.0.0"))
+
+var waitSecondsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "wait_seconds"),
+	"Estimated time spent by query in wait event during the last QAN interval (sampled from pg_stat_activity).",
+	[]string{"agent_id", "queryid", "wait_event_type", "wait_event"},
+	nil)
+
+// runWaitSampler samples pg_stat_activity until ctx is canceled.
+func (m *PGStatMonitorQAN) runWaitSampler(ctx context.Context) {
+	check := func(ctx context.Context) error {
+		q := m.q.WithContext(ctx)
+		pgVersion, err := getPGVersion(q)
+		if err != nil {
+			return err
+		}
+		// pg_stat_activity.query_id is available since PostgreSQL 14
+		if pgVersion < 14 {
+			return errors.Wrapf(waits.ErrNotSupported, "PostgreSQL %v doesn't expose query_id in pg_stat_activity", pgVersion)
+		}
+
+		var v string
+		if err = q.QueryRow(fmt.Sprintf("SELECT /* %s */ pg_stat_monitor_version()", queryTag)).Scan(&v); err != nil {
+			return errors.Wrap(err, "failed to get pg_stat_monitor version from DB")
+		}
+		pgsmVersion, err := ver.NewVersion(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse pg_stat_monitor version")
+		}
+		if pgsmVersion.Core().LessThan(v20) {
+			return errors.Wrapf(waits.ErrNotSupported, "pg_stat_monitor %s query IDs don't match pg_stat_activity", v)
+		}
+		return nil
+	}
+	sample := func(ctx context.Context) ([]waits.Key, error) {
+		return waits.SamplePostgreSQL(ctx, m.q, queryTag)
+	}
+
+	m.waits.Run(ctx, check, sample, m.l)
+}
@@ -21,9 +21,12 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"io/fs"
 	"math"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq" // register SQL driver
@@ -38,6 +41,7 @@ import (
 
 	"github.com/percona/pmm-agent/agents"
 	"github.com/percona/pmm-agent/agents/cache"
+	"github.com/percona/pmm-agent/agents/waits"
 	"github.com/percona/pmm-agent/utils/truncate"
 	"github.com/percona/pmm-agent/utils/version"
 )
@@ -55,9 +59,11 @@ type PGStatStatementsQAN struct {
 	q               *reform.Querier
 	dbCloser        io.Closer
 	agentID         string
+	stateFile       string
 	l               *logrus.Entry
 	changes         chan agents.Change
 	statementsCache *statementsCache
+	waits           *waits.Profile
 }
 
 // Params represent Agent parameters.
@@ -65,6 +71,7 @@ type Params struct {
 	DSN       string
 	AgentID   string
 	TextFiles *agentpb.TextFiles
+	StateFile string // stat statements are persisted there between restarts if set
 }
 
 const queryTag = "pmm-agent:pgstatstatements"
@@ -82,10 +89,10 @@ func New(params *Params, l *logrus.Entry) (*PGStatStatementsQAN, error) {
 	reformL := sqlmetrics.NewReform("postgres", params.AgentID, l.Tracef)
 	// TODO register reformL metrics https://jira.percona.com/browse/PMM-4087
 	q := reform.NewDB(sqlDB, postgresql.Dialect, reformL).WithTag(queryTag)
-	return newPgStatStatementsQAN(q, sqlDB, params.AgentID, l)
+	return newPgStatStatementsQAN(q, sqlDB, params.AgentID, params.StateFile, l)
 }
 
-func newPgStatStatementsQAN(q *reform.Querier, dbCloser io.Closer, agentID string, l *logrus.Entry) (*PGStatStatementsQAN, error) {
+func newPgStatStatementsQAN(q *reform.Querier, dbCloser io.Closer, agentID, stateFile string, l *logrus.Entry) (*PGStatStatementsQAN, error) {
 	statementCache, err := newStatementsCache(statementsMap{}, retainStatStatements, statStatementsCacheSize, l)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create cache")
@@ -95,9 +102,11 @@ func newPgStatStatementsQAN(q *reform.Querier, dbCloser io.Closer, agentID strin
 		q:               q,
 		dbCloser:        dbCloser,
 		agentID:         agentID,
+		stateFile:       stateFile,
 		l:               l,
 		changes:         make(chan agents.Change, 10),
 		statementsCache: statementCache,
+		waits:           waits.NewProfile(waitsSampleInterval, waitSecondsDesc),
 	}, nil
 }
 
@@ -129,18 +138,24 @@ func rowsByVersion(q *reform.Querier, tail string) (*sql.Rows, error) {
 // Run extracts stats data and sends it to the channel until ctx is canceled.
 func (m *PGStatStatementsQAN) Run(ctx context.Context) {
 	defer func() {
+		m.saveState()
 		m.dbCloser.Close() //nolint:errcheck
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_DONE}
 		close(m.changes)
 	}()
 
-	// add current stat statements to cache, so they are not send as new on first iteration with incorrect timestamps
+	// add current stat statements to cache, so they are not send as new on first iteration with incorrect timestamps,
+	// unless stat statements from the previous run were restored
 	var running bool
 	var err error
 	m.changes <- agents.Change{Status: inventorypb.AgentStatus_STARTING}
 
+	restored := m.restoreState()
 	if current, _, err := m.getStatStatementsExtended(ctx, m.q); err == nil {
-		if err = m.statementsCache.Set(current); err == nil {
+		if !restored {
+			err = m.statementsCache.Set(current)
+		}
+		if err == nil {
 			m.l.Debugf("Got %d initial stat statements.", len(current))
 			running = true
 			m.changes <- agents.Change{Status: inventorypb.AgentStatus_RUNNING}
@@ -152,6 +167,15 @@ func (m *PGStatStatementsQAN) Run(ctx context.Context) {
 		m.changes <- agents.Change{Status: inventorypb.AgentStatus_WAITING}
 	}
 
+	// wait for the sampler to stop before closing the database
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.runWaitSampler(ctx)
+	}()
+
 	// query pg_stat_statements every minute at 00 seconds
 	start := time.Now()
 	wait := start.Truncate(queryStatStatements).Add(queryStatStatements).Sub(start)
@@ -173,6 +197,9 @@ func (m *PGStatStatementsQAN) Run(ctx context.Context) {
 
 			lengthS := uint32(math.Round(wait.Seconds())) // round 59.9s/60.1s to 60s
 			buckets, err := m.getNewBuckets(ctx, start, lengthS)
+			if dropped := m.waits.Rotate(); dropped != 0 {
+				m.l.Warnf("Dropped %d wait event samples over the limit of %d queries and events.", dropped, waits.KeysLimit)
+			}
 
 			start = time.Now()
 			wait = start.Truncate(queryStatStatements).Add(queryStatStatements).Sub(start)
@@ -196,6 +223,43 @@ func (m *PGStatStatementsQAN) Run(ctx context.Context) {
 	}
 }
 
+// restoreState loads stat statements saved by the previous run into the cache.
+// It returns true if they were loaded.
+func (m *PGStatStatementsQAN) restoreState() bool {
+	if m.stateFile == "" {
+		return false
+	}
+
+	err := m.statementsCache.cache.Restore(m.stateFile, cache.MaxStateAge)
+
+	// remove it to avoid using the same state twice if we crash
+	_ = os.Remove(m.stateFile)
+
+	switch {
+	case err == nil:
+		m.l.Infof("Restored %d stat statements from %s.", m.statementsCache.cache.Len(), m.stateFile)
+		return m.statementsCache.cache.Len() != 0
+	case errors.Is(err, fs.ErrNotExist):
+		return false
+	default:
+		m.l.Warnf("Failed to restore stat statements from %s: %s.", m.stateFile, err)
+		return false
+	}
+}
+
+// saveState saves cached stat statements for the next run.
+func (m *PGStatStatementsQAN) saveState() {
+	if m.stateFile == "" {
+		return
+	}
+
+	if err := m.statementsCache.cache.Snapshot(m.stateFile); err != nil {
+		m.l.Warnf("Failed to save stat statements to %s: %s.", m.stateFile, err)
+		return
+	}
+	m.l.Debugf("Saved %d stat statements to %s.", m.statementsCache.cache.Len(), m.stateFile)
+}
+
 // getStatStatementsExtended returns the current state of pg_stat_statements table with extended information (database, username, tables)
 // and the previous cashed state.
 func (m *PGStatStatementsQAN) getStatStatementsExtended(ctx context.Context, q *reform.Querier) (current, prev statementsMap, err error) {
@@ -394,6 +458,8 @@ func (m *PGStatStatementsQAN) Collect(ch chan<- prometheus.Metric) {
 	for _, metric := range metrics {
 		ch <- metric
 	}
+
+	m.waits.Collect(ch, m.agentID)
 }
 
 // check interfaces
@@ -0,0 +1,59 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgstatstatements
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/percona/pmm-agent/agents/waits"
+)
+
+const (
+	prometheusNamespace = "pmm_agent"
+	prometheusSubsystem = "pgstatstatements"
+
+	waitsSampleInterval = 500 * time.Millisecond
+)
+
+var waitSecondsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "wait_seconds"),
+	"Estimated time spent by query in wait event during the last QAN interval (sampled from pg_stat_activity).",
+	[]string{"agent_id", "queryid", "wait_event_type", "wait_event"},
+	nil)
+
+// runWaitSampler samples pg_stat_activity until ctx is canceled.
+func (m *PGStatStatementsQAN) runWaitSampler(ctx context.Context) {
+	check := func(ctx context.Context) error {
+		pgVersion, err := getPGVersion(m.q.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		// pg_stat_activity.query_id is available since PostgreSQL 14
+		if pgVersion < 14 {
+			return errors.Wrapf(waits.ErrNotSupported, "PostgreSQL %v doesn't expose query_id in pg_stat_activity", pgVersion)
+		}
+		return nil
+	}
+	sample := func(ctx context.Context) ([]waits.Key, error) {
+		return waits.SamplePostgreSQL(ctx, m.q, queryTag)
+	}
+
+	m.waits.Run(ctx, check, sample, m.l)
+}
@@ -0,0 +1,58 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/config"
+)
+
+// LocalState returns SetState request for built-in QAN Agents configured locally.
+// It is used to run those Agents without PMM Server; their data is written only to local QAN sinks.
+func LocalState(agents []config.LocalAgent) (*agentpb.SetStateRequest, error) {
+	res := &agentpb.SetStateRequest{
+		BuiltinAgents: make(map[string]*agentpb.SetStateRequest_BuiltinAgent, len(agents)),
+	}
+	for i, agent := range agents {
+		if agent.ID == "" {
+			return nil, errors.Errorf("local Agent #%d: id is not set", i+1)
+		}
+		if _, ok := res.BuiltinAgents[agent.ID]; ok {
+			return nil, errors.Errorf("local Agent %q: duplicate id", agent.ID)
+		}
+
+		agentType := inventorypb.AgentType(inventorypb.AgentType_value[agent.Type])
+		if !strings.HasPrefix(agent.Type, "QAN_") || agentType == inventorypb.AgentType_AGENT_TYPE_INVALID {
+			return nil, errors.Errorf("local Agent %q: unexpected type %q, only QAN Agents are supported", agent.ID, agent.Type)
+		}
+		if agent.DSN == "" {
+			return nil, errors.Errorf("local Agent %q: dsn is not set", agent.ID)
+		}
+
+		res.BuiltinAgents[agent.ID] = &agentpb.SetStateRequest_BuiltinAgent{
+			Type:                 agentType,
+			Dsn:                  agent.DSN,
+			DisableQueryExamples: agent.DisableQueryExamples,
+			MaxQueryLogSize:      agent.MaxQueryLogSize,
+		}
+	}
+	return res, nil
+}
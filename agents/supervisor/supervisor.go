@@ -19,6 +19,7 @@ package supervisor
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"runtime/pprof"
@@ -39,10 +40,13 @@ import (
 	"github.com/percona/pmm-agent/agents/mysql/perfschema"
 	"github.com/percona/pmm-agent/agents/mysql/slowlog"
 	"github.com/percona/pmm-agent/agents/noop"
+	"github.com/percona/pmm-agent/agents/postgres/pglog"
 	"github.com/percona/pmm-agent/agents/postgres/pgstatmonitor"
 	"github.com/percona/pmm-agent/agents/postgres/pgstatstatements"
 	"github.com/percona/pmm-agent/agents/process"
 	"github.com/percona/pmm-agent/config"
+	"github.com/percona/pmm-agent/qansink"
+	"github.com/percona/pmm-agent/utils/redact"
 	"github.com/percona/pmm-agent/utils/templates"
 )
 
@@ -54,6 +58,11 @@ type Supervisor struct {
 	portsRegistry *portsRegistry
 	changes       chan *agentpb.StateChangedRequest
 	qanRequests   chan *agentpb.QANCollectRequest
+	qanSinks      *qansink.Sinks
+	mongoDBQAN    *config.MongoDBQAN
+	postgreSQLQAN *config.PostgreSQLQAN
+	redactor      *redact.Redactor
+	noQANState    bool
 	l             *logrus.Entry
 
 	rw             sync.RWMutex
@@ -82,19 +91,52 @@ type builtinAgentInfo struct {
 	collect        func(chan<- prometheus.Metric) // agent's func to provide Prometheus metrics
 }
 
+// Params represent Supervisor parameters.
+type Params struct {
+	Paths  *config.Paths
+	Ports  *config.Ports
+	Server *config.Server
+
+	// QANSinks are local QAN data sinks; may be nil.
+	// Caller should close them after QANRequests() channel is closed.
+	QANSinks *qansink.Sinks
+	// MongoDBQAN and PostgreSQLQAN are per-Agent QAN source settings; may be nil.
+	MongoDBQAN    *config.MongoDBQAN
+	PostgreSQLQAN *config.PostgreSQLQAN
+	// Redactor redacts query examples before QAN data is written to sinks or sent; may be nil.
+	Redactor *redact.Redactor
+	// DisableQANState disables persisting of built-in QAN Agents state between restarts.
+	DisableQANState bool
+}
+
 // NewSupervisor creates new Supervisor object.
 //
 // Supervisor is gracefully stopped when context passed to NewSupervisor is canceled.
 // Changes of Agent statuses are reported via Changes() channel which must be read until it is closed.
-// QAN data is sent to QANRequests() channel which must be read until it is closed.
-func NewSupervisor(ctx context.Context, paths *config.Paths, ports *config.Ports, server *config.Server) *Supervisor {
+// QAN data is sent to QANRequests() channel which must be read until it is closed,
+// and written to local sinks.
+func NewSupervisor(ctx context.Context, params *Params) *Supervisor {
+	mongoDBQAN := params.MongoDBQAN
+	if mongoDBQAN == nil {
+		mongoDBQAN = new(config.MongoDBQAN)
+	}
+	postgreSQLQAN := params.PostgreSQLQAN
+	if postgreSQLQAN == nil {
+		postgreSQLQAN = new(config.PostgreSQLQAN)
+	}
+
 	supervisor := &Supervisor{
 		ctx:           ctx,
-		paths:         paths,
-		serverCfg:     server,
-		portsRegistry: newPortsRegistry(ports.Min, ports.Max, nil),
+		paths:         params.Paths,
+		serverCfg:     params.Server,
+		portsRegistry: newPortsRegistry(params.Ports.Min, params.Ports.Max, nil),
 		changes:       make(chan *agentpb.StateChangedRequest, 10),
 		qanRequests:   make(chan *agentpb.QANCollectRequest, 10),
+		qanSinks:      params.QANSinks,
+		mongoDBQAN:    mongoDBQAN,
+		postgreSQLQAN: postgreSQLQAN,
+		redactor:      params.Redactor,
+		noQANState:    params.DisableQANState,
 		l:             logrus.WithField("component", "supervisor"),
 
 		agentProcesses: make(map[string]*agentProcessInfo),
@@ -270,6 +312,7 @@ func (s *Supervisor) setBuiltinAgents(builtinAgents map[string]*agentpb.SetState
 		<-agent.done
 
 		delete(s.builtinAgents, agentID)
+		s.removeQANStateFile(agentID)
 	}
 
 	// restart
@@ -411,13 +454,22 @@ func (s *Supervisor) startBuiltin(agentID string, builtinAgent *agentpb.SetState
 			DisableQueryExamples: builtinAgent.DisableQueryExamples,
 			TextFiles:            builtinAgent.GetTextFiles(),
 			TLSSkipVerify:        builtinAgent.TlsSkipVerify,
+			StateFile:            s.qanStateFile(agentID),
 		}
 		agent, err = perfschema.New(params, l)
 
 	case inventorypb.AgentType_QAN_MONGODB_PROFILER_AGENT:
+		// settings are configured per Agent, so each Agent reads its own mongod log
+		settings := s.mongoDBQAN.Agents[agentID]
+		if err = s.checkMongoDBLogFile(agentID, settings); err != nil {
+			break
+		}
 		params := &mongodb.Params{
-			DSN:     dsn,
-			AgentID: agentID,
+			DSN:               dsn,
+			AgentID:           agentID,
+			Source:            settings.Source,
+			LogFile:           settings.LogFile,
+			CurrentOpInterval: settings.CurrentOpInterval,
 		}
 		agent, err = mongodb.New(params, l)
 
@@ -434,19 +486,30 @@ func (s *Supervisor) startBuiltin(agentID string, builtinAgent *agentpb.SetState
 		agent, err = slowlog.New(params, l)
 
 	case inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT:
+		// server log is used instead of extension where it can't be installed
+		if agent, err = s.newPostgreSQLLogAgent(agentID, builtinAgent, l); err != nil || agent != nil {
+			break
+		}
+
 		params := &pgstatstatements.Params{
 			DSN:       dsn,
 			AgentID:   agentID,
 			TextFiles: builtinAgent.GetTextFiles(),
+			StateFile: s.qanStateFile(agentID),
 		}
 		agent, err = pgstatstatements.New(params, l)
 
 	case inventorypb.AgentType_QAN_POSTGRESQL_PGSTATMONITOR_AGENT:
+		if agent, err = s.newPostgreSQLLogAgent(agentID, builtinAgent, l); err != nil || agent != nil {
+			break
+		}
+
 		params := &pgstatmonitor.Params{
 			DSN:                  dsn,
 			AgentID:              agentID,
 			TextFiles:            builtinAgent.GetTextFiles(),
 			DisableQueryExamples: builtinAgent.DisableQueryExamples,
+			StateFile:            s.qanStateFile(agentID),
 		}
 		agent, err = pgstatmonitor.New(params, l)
 
@@ -475,10 +538,15 @@ func (s *Supervisor) startBuiltin(agentID string, builtinAgent *agentpb.SetState
 				}
 			}
 			if change.MetricsBucket != nil {
-				l.Infof("Sending %d buckets.", len(change.MetricsBucket))
-				s.qanRequests <- &agentpb.QANCollectRequest{
+				s.redactor.Buckets(change.MetricsBucket)
+				req := &agentpb.QANCollectRequest{
 					MetricsBucket: change.MetricsBucket,
 				}
+				s.qanSinks.Write(req)
+				if s.qanSinks.SendToServer() {
+					l.Infof("Sending %d buckets.", len(change.MetricsBucket))
+					s.qanRequests <- req
+				}
 			}
 		}
 		close(done)
@@ -494,6 +562,75 @@ func (s *Supervisor) startBuiltin(agentID string, builtinAgent *agentpb.SetState
 	return nil
 }
 
+// checkMongoDBLogFile returns error if the mongod log file of the given MongoDB QAN Agent is also used by another Agent.
+func (s *Supervisor) checkMongoDBLogFile(agentID string, settings config.MongoDBQANAgent) error {
+	if settings.Source != mongodb.SourceLog {
+		return nil
+	}
+	for id, other := range s.mongoDBQAN.Agents {
+		if id != agentID && other.Source == mongodb.SourceLog && filepath.Clean(other.LogFile) == filepath.Clean(settings.LogFile) {
+			return errors.Errorf("log file %s is also used by Agent %s", settings.LogFile, id)
+		}
+	}
+	return nil
+}
+
+// newPostgreSQLLogAgent returns server log QAN Agent that should be used instead of the given
+// PostgreSQL QAN Agent, or nil if the Agent should use its extension.
+// Settings are configured per Agent, so each Agent reads its own server log.
+func (s *Supervisor) newPostgreSQLLogAgent(agentID string, builtinAgent *agentpb.SetStateRequest_BuiltinAgent,
+	l *logrus.Entry,
+) (agents.BuiltinAgent, error) {
+	settings := s.postgreSQLQAN.Agents[agentID]
+	switch settings.Source {
+	case "", "extension":
+		return nil, nil //nolint:nilnil
+	case "log":
+		// handled below
+	default:
+		return nil, errors.Errorf("unexpected PostgreSQL QAN source %q", settings.Source)
+	}
+
+	for id, other := range s.postgreSQLQAN.Agents {
+		if id != agentID && other.Source == "log" && filepath.Clean(other.LogFile) == filepath.Clean(settings.LogFile) {
+			return nil, errors.Errorf("log file %s is also used by Agent %s", settings.LogFile, id)
+		}
+	}
+
+	params := &pglog.Params{
+		LogFilePath:          settings.LogFile,
+		Format:               settings.LogFormat,
+		AgentID:              agentID,
+		AgentType:            builtinAgent.Type,
+		DisableQueryExamples: builtinAgent.DisableQueryExamples,
+	}
+	return pglog.New(params, l)
+}
+
+// qanStateFile returns path to the file where built-in QAN Agent persists its state between restarts,
+// or empty string if that is disabled.
+// It is outside of Agent's temporary directory as that directory is recreated on start.
+func (s *Supervisor) qanStateFile(agentID string) string {
+	if s.noQANState {
+		return ""
+	}
+	return qanStateFilePath(s.paths.TempDir, agentID)
+}
+
+// qanStateFilePath returns path to built-in QAN Agent's state file in given temporary directory.
+func qanStateFilePath(tempDir, agentID string) string {
+	return filepath.Join(tempDir, "qan-state", agentID+".json")
+}
+
+// removeQANStateFile removes state file of the removed built-in Agent, if any.
+// It is done even if persisting is disabled now to remove a file left from the previous runs.
+func (s *Supervisor) removeQANStateFile(agentID string) {
+	path := qanStateFilePath(s.paths.TempDir, agentID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		s.l.Warnf("Failed to remove QAN state file %s: %s.", path, err)
+	}
+}
+
 // "_" at the begginging is reserved for possible extensions
 var textFileRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`) //nolint:gochecknoglobals
 
@@ -25,9 +25,11 @@ import (
 	"github.com/percona/pmm/api/agentlocalpb"
 	"github.com/percona/pmm/api/agentpb"
 	"github.com/percona/pmm/api/inventorypb"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/percona/pmm-agent/agents/postgres/pglog"
 	"github.com/percona/pmm-agent/agents/process"
 	"github.com/percona/pmm-agent/config"
 )
@@ -50,7 +52,11 @@ func TestSupervisor(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	tempDir, err := os.MkdirTemp("", "pmm-agent-")
 	require.NoError(t, err)
-	s := NewSupervisor(ctx, &config.Paths{TempDir: tempDir}, &config.Ports{Min: 65000, Max: 65099}, &config.Server{Address: "localhost:443"})
+	s := NewSupervisor(ctx, &Params{
+		Paths:  &config.Paths{TempDir: tempDir},
+		Ports:  &config.Ports{Min: 65000, Max: 65099},
+		Server: &config.Server{Address: "localhost:443"},
+	})
 
 	t.Run("Start13", func(t *testing.T) {
 		expectedList := []*agentlocalpb.AgentInfo{}
@@ -253,6 +259,47 @@ func TestSupervisor(t *testing.T) {
 	})
 }
 
+func TestQANStateFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RemovedAgent", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		tempDir := t.TempDir()
+		s := NewSupervisor(ctx, &Params{Paths: &config.Paths{TempDir: tempDir}, Ports: &config.Ports{}, Server: &config.Server{}})
+
+		path := s.qanStateFile("noop1")
+		assert.Equal(t, filepath.Join(tempDir, "qan-state", "noop1.json"), path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+		require.NoError(t, os.WriteFile(path, []byte("{}"), 0o600))
+
+		s.SetState(&agentpb.SetStateRequest{
+			BuiltinAgents: map[string]*agentpb.SetStateRequest_BuiltinAgent{
+				"noop1": {Type: type_TEST_NOOP, Dsn: "1"},
+			},
+		})
+		assertChanges(t, s, &agentpb.StateChangedRequest{AgentId: "noop1", Status: inventorypb.AgentStatus_STARTING})
+		assertChanges(t, s, &agentpb.StateChangedRequest{AgentId: "noop1", Status: inventorypb.AgentStatus_RUNNING})
+		assert.FileExists(t, path)
+
+		s.SetState(&agentpb.SetStateRequest{})
+		assertChanges(t, s, &agentpb.StateChangedRequest{AgentId: "noop1", Status: inventorypb.AgentStatus_STOPPING})
+		assertChanges(t, s, &agentpb.StateChangedRequest{AgentId: "noop1", Status: inventorypb.AgentStatus_DONE})
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := NewSupervisor(ctx, &Params{Paths: &config.Paths{TempDir: t.TempDir()}, Ports: &config.Ports{}, Server: &config.Server{}, DisableQANState: true})
+		assert.Empty(t, s.qanStateFile("noop1"))
+	})
+}
+
 func TestFilter(t *testing.T) {
 	t.Parallel()
 
@@ -273,6 +320,130 @@ func TestFilter(t *testing.T) {
 	assert.Equal(t, []string{"toStop"}, toStop)
 }
 
+func TestLocalState(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Normal", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := LocalState([]config.LocalAgent{{
+			ID:              "/agent_id/local-perfschema",
+			Type:            "QAN_MYSQL_PERFSCHEMA_AGENT",
+			DSN:             "root:root-password@tcp(127.0.0.1:3306)/",
+			MaxQueryLogSize: 1024,
+		}, {
+			ID:                   "/agent_id/local-profiler",
+			Type:                 "QAN_MONGODB_PROFILER_AGENT",
+			DSN:                  "mongodb://127.0.0.1:27017",
+			DisableQueryExamples: true,
+		}})
+		require.NoError(t, err)
+		expected := &agentpb.SetStateRequest{
+			BuiltinAgents: map[string]*agentpb.SetStateRequest_BuiltinAgent{
+				"/agent_id/local-perfschema": {
+					Type:            inventorypb.AgentType_QAN_MYSQL_PERFSCHEMA_AGENT,
+					Dsn:             "root:root-password@tcp(127.0.0.1:3306)/",
+					MaxQueryLogSize: 1024,
+				},
+				"/agent_id/local-profiler": {
+					Type:                 inventorypb.AgentType_QAN_MONGODB_PROFILER_AGENT,
+					Dsn:                  "mongodb://127.0.0.1:27017",
+					DisableQueryExamples: true,
+				},
+			},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for expected, agents := range map[string][]config.LocalAgent{
+			`local Agent #1: id is not set`: {{Type: "QAN_MYSQL_SLOWLOG_AGENT", DSN: "dsn"}},
+			`local Agent "a": duplicate id`: {
+				{ID: "a", Type: "QAN_MYSQL_SLOWLOG_AGENT", DSN: "dsn"},
+				{ID: "a", Type: "QAN_MYSQL_SLOWLOG_AGENT", DSN: "dsn"},
+			},
+			`local Agent "a": unexpected type "MYSQLD_EXPORTER", only QAN Agents are supported`: {
+				{ID: "a", Type: "MYSQLD_EXPORTER", DSN: "dsn"},
+			},
+			`local Agent "a": unexpected type "QAN_FOO_AGENT", only QAN Agents are supported`: {
+				{ID: "a", Type: "QAN_FOO_AGENT", DSN: "dsn"},
+			},
+			`local Agent "a": dsn is not set`: {{ID: "a", Type: "QAN_MYSQL_SLOWLOG_AGENT"}},
+		} {
+			_, err := LocalState(agents)
+			assert.EqualError(t, err, expected)
+		}
+	})
+}
+
+func TestNewPostgreSQLLogAgent(t *testing.T) {
+	t.Parallel()
+
+	s := &Supervisor{
+		postgreSQLQAN: &config.PostgreSQLQAN{
+			Agents: map[string]config.PostgreSQLQANAgent{
+				"/agent_id/pgss1":  {Source: "log", LogFile: "/var/log/postgresql/pg1.log"},
+				"/agent_id/pgss2":  {Source: "log", LogFile: "/var/log/postgresql/pg2.log", LogFormat: "csvlog"},
+				"/agent_id/pgsm":   {Source: "log", LogFile: "/var/log/postgresql/pg3.log"},
+				"/agent_id/ext":    {Source: "extension", LogFile: "/var/log/postgresql/pg1.log"},
+				"/agent_id/dup1":   {Source: "log", LogFile: "/var/log/postgresql/dup.log"},
+				"/agent_id/dup2":   {Source: "log", LogFile: "/var/log/postgresql/../postgresql/dup.log"},
+				"/agent_id/wrong":  {Source: "pgstatements"},
+				"/agent_id/nofile": {Source: "log"},
+			},
+		},
+	}
+	l := logrus.WithField("test", t.Name())
+	pgss := &agentpb.SetStateRequest_BuiltinAgent{Type: inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT}
+	pgsm := &agentpb.SetStateRequest_BuiltinAgent{Type: inventorypb.AgentType_QAN_POSTGRESQL_PGSTATMONITOR_AGENT}
+
+	for _, id := range []string{"/agent_id/pgss1", "/agent_id/pgss2"} {
+		agent, err := s.newPostgreSQLLogAgent(id, pgss, l)
+		require.NoError(t, err)
+		assert.IsType(t, new(pglog.PGLogQAN), agent)
+	}
+
+	agent, err := s.newPostgreSQLLogAgent("/agent_id/pgsm", pgsm, l)
+	require.NoError(t, err)
+	assert.IsType(t, new(pglog.PGLogQAN), agent)
+
+	for _, id := range []string{"/agent_id/ext", "/agent_id/other"} {
+		agent, err = s.newPostgreSQLLogAgent(id, pgss, l)
+		require.NoError(t, err)
+		assert.Nil(t, agent)
+	}
+
+	_, err = s.newPostgreSQLLogAgent("/agent_id/dup1", pgss, l)
+	assert.EqualError(t, err, "log file /var/log/postgresql/dup.log is also used by Agent /agent_id/dup2")
+	_, err = s.newPostgreSQLLogAgent("/agent_id/wrong", pgss, l)
+	assert.EqualError(t, err, `unexpected PostgreSQL QAN source "pgstatements"`)
+	_, err = s.newPostgreSQLLogAgent("/agent_id/nofile", pgss, l)
+	assert.EqualError(t, err, "log file is not set")
+}
+
+func TestCheckMongoDBLogFile(t *testing.T) {
+	t.Parallel()
+
+	s := &Supervisor{
+		mongoDBQAN: &config.MongoDBQAN{
+			Agents: map[string]config.MongoDBQANAgent{
+				"/agent_id/log1":      {Source: "log", LogFile: "/var/log/mongodb/mongod1.log"},
+				"/agent_id/log2":      {Source: "log", LogFile: "/var/log/mongodb/mongod2.log"},
+				"/agent_id/dup":       {Source: "log", LogFile: "/var/log/mongodb/mongod2.log"},
+				"/agent_id/currentop": {Source: "currentop", LogFile: "/var/log/mongodb/mongod1.log"},
+			},
+		},
+	}
+
+	for _, id := range []string{"/agent_id/log1", "/agent_id/currentop", "/agent_id/other"} {
+		assert.NoError(t, s.checkMongoDBLogFile(id, s.mongoDBQAN.Agents[id]), "%s", id)
+	}
+	err := s.checkMongoDBLogFile("/agent_id/log2", s.mongoDBQAN.Agents["/agent_id/log2"])
+	assert.EqualError(t, err, "log file /var/log/mongodb/mongod2.log is also used by Agent /agent_id/dup")
+}
+
 func TestSupervisorProcessParams(t *testing.T) {
 	setup := func(t *testing.T) (*Supervisor, func()) {
 		temp, err := os.MkdirTemp("", "pmm-agent-")
@@ -284,7 +455,7 @@ func TestSupervisorProcessParams(t *testing.T) {
 			TempDir:        temp,
 		}
 
-		s := NewSupervisor(ctx, paths, &config.Ports{}, &config.Server{}) //nolint:varnamelen
+		s := NewSupervisor(ctx, &Params{Paths: paths, Ports: &config.Ports{}, Server: &config.Server{}}) //nolint:varnamelen
 
 		teardown := func() {
 			cancel()
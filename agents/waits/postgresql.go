@@ -0,0 +1,55 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package waits
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/reform.v1"
+)
+
+// waitEventCPU is a wait event type and name for active PostgreSQL backends that don't wait.
+const waitEventCPU = "CPU"
+
+// SamplePostgreSQL returns query IDs and wait events of active client backends from pg_stat_activity.
+// pg_stat_activity.query_id is available since PostgreSQL 14 (with compute_query_id enabled).
+func SamplePostgreSQL(ctx context.Context, q *reform.Querier, queryTag string) ([]Key, error) {
+	rows, err := q.WithContext(ctx).Query(fmt.Sprintf(
+		"SELECT /* %s */ query_id, COALESCE(wait_event_type, ''), COALESCE(wait_event, '') FROM pg_stat_activity "+
+			"WHERE state = 'active' AND backend_type = 'client backend' AND query_id IS NOT NULL AND query_id <> 0 "+
+			"AND pid <> pg_backend_pid()", queryTag))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var res []Key
+	for rows.Next() {
+		var queryID int64
+		var k Key
+		if err = rows.Scan(&queryID, &k.Class, &k.Event); err != nil {
+			return nil, err
+		}
+		k.QueryID = strconv.FormatInt(queryID, 10)
+		if k.Class == "" {
+			k.Class, k.Event = waitEventCPU, waitEventCPU
+		}
+		res = append(res, k)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,76 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package waits
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotSupported should be wrapped by check functions if wait events can't be sampled.
+var ErrNotSupported = errors.New("not supported")
+
+// checkRetryInterval is a delay before the next check if the previous one failed, for example, if the database was down.
+var checkRetryInterval = 10 * time.Second
+
+// Run calls sample every sample duration and adds results to the profile until ctx is canceled.
+//
+// Before that, check is called to ensure that sampling is supported; it is retried every checkRetryInterval on errors.
+// Sampling is disabled if it returns an error wrapping ErrNotSupported.
+func (p *Profile) Run(ctx context.Context, check func(context.Context) error, sample func(context.Context) ([]Key, error), l *logrus.Entry) {
+	for {
+		err := check(ctx)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrNotSupported) {
+			l.Infof("Wait events sampler is disabled: %s.", err)
+			return
+		}
+
+		l.Warnf("Wait events sampler is not started: %s. Retrying in %s.", err, checkRetryInterval)
+		t := time.NewTimer(checkRetryInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	l.Debugf("Wait events sampler is started.")
+	t := time.NewTicker(p.sampleDuration)
+	defer t.Stop()
+
+	for {
+		keys, err := sample(ctx)
+		if err != nil {
+			l.Debugf("Failed to sample wait events: %s.", err)
+		} else {
+			p.Add(keys)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// nothing, continue loop
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package waits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileRun(t *testing.T) {
+	defer func(d time.Duration) { checkRetryInterval = d }(checkRetryInterval)
+	checkRetryInterval = time.Millisecond
+
+	l := logrus.WithField("test", t.Name())
+	key := Key{QueryID: "42", Class: "CPU", Event: "CPU"}
+
+	t.Run("Retry", func(t *testing.T) {
+		p := NewProfile(time.Millisecond, testDesc)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var checks int
+		check := func(context.Context) error {
+			checks++
+			if checks < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		sample := func(context.Context) ([]Key, error) {
+			cancel()
+			return []Key{key}, nil
+		}
+
+		p.Run(ctx, check, sample, l)
+		assert.Equal(t, 3, checks)
+		p.Rotate()
+		assert.Equal(t, map[Key]time.Duration{key: time.Millisecond}, p.Waits())
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		p := NewProfile(time.Millisecond, testDesc)
+		check := func(context.Context) error {
+			return errors.Wrap(ErrNotSupported, "too old")
+		}
+		sample := func(context.Context) ([]Key, error) {
+			t.Fatal("sample should not be called")
+			return nil, nil
+		}
+
+		p.Run(context.Background(), check, sample, l)
+		p.Rotate()
+		assert.Empty(t, p.Waits())
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		p := NewProfile(time.Millisecond, testDesc)
+		ctx, cancel := context.WithCancel(context.Background())
+		check := func(context.Context) error {
+			cancel()
+			return errors.New("connection refused")
+		}
+		sample := func(context.Context) ([]Key, error) {
+			t.Fatal("sample should not be called")
+			return nil, nil
+		}
+
+		p.Run(ctx, check, sample, l)
+	})
+}
@@ -0,0 +1,106 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package waits aggregates sampled wait events of running queries for QAN Agents.
+package waits
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KeysLimit is a maximal number of distinct keys in a single interval.
+const KeysLimit = 1000
+
+// Key identifies aggregated wait samples.
+type Key struct {
+	QueryID string // query ID or digest, as reported to QAN
+	Class   string // wait class or wait event type
+	Event   string // wait event name
+}
+
+// Profile aggregates samples by query and wait event for the current interval,
+// and keeps results of the last completed interval.
+type Profile struct {
+	sampleDuration time.Duration
+	desc           *prometheus.Desc
+
+	m        sync.Mutex
+	current  map[Key]int
+	last     map[Key]int
+	droppedN int
+}
+
+// NewProfile creates new Profile for samples taken every sampleDuration.
+// desc is used for wait metrics; it should have agent ID, query ID, class and event variable labels.
+func NewProfile(sampleDuration time.Duration, desc *prometheus.Desc) *Profile {
+	return &Profile{
+		sampleDuration: sampleDuration,
+		desc:           desc,
+		current:        make(map[Key]int),
+	}
+}
+
+// SampleDuration returns duration of a single sample.
+func (p *Profile) SampleDuration() time.Duration {
+	return p.sampleDuration
+}
+
+// Add adds a single sample of running queries.
+func (p *Profile) Add(keys []Key) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, k := range keys {
+		if _, ok := p.current[k]; !ok && len(p.current) >= KeysLimit {
+			p.droppedN++
+			continue
+		}
+		p.current[k]++
+	}
+}
+
+// Rotate finishes the current interval and returns the number of dropped samples.
+func (p *Profile) Rotate() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.last = p.current
+	p.current = make(map[Key]int, len(p.last))
+	dropped := p.droppedN
+	p.droppedN = 0
+	return dropped
+}
+
+// Waits returns estimated wait times of the last completed interval.
+func (p *Profile) Waits() map[Key]time.Duration {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	res := make(map[Key]time.Duration, len(p.last))
+	for k, n := range p.last {
+		res[k] = time.Duration(n) * p.sampleDuration
+	}
+	return res
+}
+
+// Collect sends wait metrics of the last completed interval.
+func (p *Profile) Collect(ch chan<- prometheus.Metric, agentID string) {
+	for k, d := range p.Waits() {
+		ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, d.Seconds(), agentID, k.QueryID, k.Class, k.Event)
+	}
+}
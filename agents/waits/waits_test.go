@@ -0,0 +1,69 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package waits
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDesc = prometheus.NewDesc("test_wait_seconds", "Test.", []string{"agent_id", "queryid", "class", "event"}, nil)
+
+func TestProfile(t *testing.T) {
+	p := NewProfile(500*time.Millisecond, testDesc)
+	io := Key{QueryID: "42", Class: "IO", Event: "DataFileRead"}
+	cpu := Key{QueryID: "42", Class: "CPU", Event: "CPU"}
+	lock := Key{QueryID: "-7", Class: "Lock", Event: "transactionid"}
+
+	p.Add([]Key{io, cpu})
+	p.Add([]Key{io, lock})
+	assert.Empty(t, p.Waits(), "nothing before the first rotation")
+
+	assert.Equal(t, 0, p.Rotate())
+	assert.Equal(t, map[Key]time.Duration{
+		io:   time.Second,
+		cpu:  500 * time.Millisecond,
+		lock: 500 * time.Millisecond,
+	}, p.Waits())
+
+	ch := make(chan prometheus.Metric, 10)
+	p.Collect(ch, "agent_id")
+	close(ch)
+	assert.Len(t, ch, 3)
+
+	p.Add([]Key{cpu})
+	assert.Equal(t, 0, p.Rotate())
+	assert.Equal(t, map[Key]time.Duration{cpu: 500 * time.Millisecond}, p.Waits())
+
+	t.Run("Limit", func(t *testing.T) {
+		p := NewProfile(time.Second, testDesc)
+		keys := make([]Key, KeysLimit+2)
+		for i := range keys {
+			keys[i] = Key{QueryID: fmt.Sprint(i), Class: "CPU", Event: "CPU"}
+		}
+		p.Add(keys)
+		p.Add(keys[:1])
+		assert.Equal(t, 2, p.Rotate())
+		waits := p.Waits()
+		require.Len(t, waits, KeysLimit)
+		assert.Equal(t, 2*time.Second, waits[keys[0]])
+	})
+}
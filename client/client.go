@@ -18,13 +18,15 @@ package client
 
 import (
 	"context"
-	"fmt"
 	"net"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/percona/pmm/api/agentpb"
 	"github.com/percona/pmm/utils/tlsconfig"
 	"github.com/percona/pmm/version"
@@ -40,9 +42,11 @@ import (
 
 	"github.com/percona/pmm-agent/actions" // TODO https://jira.percona.com/browse/PMM-7206
 	"github.com/percona/pmm-agent/client/channel"
+	"github.com/percona/pmm-agent/client/spool"
 	"github.com/percona/pmm-agent/config"
 	"github.com/percona/pmm-agent/jobs"
 	"github.com/percona/pmm-agent/utils/backoff"
+	"github.com/percona/pmm-agent/utils/redact"
 )
 
 const (
@@ -51,6 +55,7 @@ const (
 	backoffMaxDelay      = 15 * time.Second
 	clockDriftWarning    = 5 * time.Second
 	defaultActionTimeout = 10 * time.Second // default timeout for compatibility with an older server
+	spoolReplayInterval  = time.Minute      // to pick up QAN data spooled by `pmm-agent qan-replay`
 )
 
 // Client represents pmm-agent's connection to nginx/pmm-managed.
@@ -59,6 +64,7 @@ type Client struct {
 	supervisor        supervisor
 	connectionChecker connectionChecker
 	softwareVersioner softwareVersioner
+	redactor          *redact.Redactor
 
 	l       *logrus.Entry
 	backoff *backoff.Backoff
@@ -67,27 +73,49 @@ type Client struct {
 	// for unit tests only
 	dialTimeout time.Duration
 
-	actionsRunner *actions.ConcurrentRunner
-	jobsRunner    *jobs.Runner
+	jobsRunner *jobs.Runner
+	spool      *spool.Spool
 
-	rw      sync.RWMutex
-	md      *agentpb.ServerConnectMetadata
-	channel *channel.Channel
+	rw            sync.RWMutex
+	md            *agentpb.ServerConnectMetadata
+	channel       *channel.Channel
+	actionsRunner *actions.ConcurrentRunner // set by Run
 }
 
 // New creates new client.
 //
+// Output of all Actions is redacted by redactor (that may be nil).
 // Caller should call Run.
-func New(cfg *config.Config, supervisor supervisor, connectionChecker connectionChecker, sv softwareVersioner) *Client {
+func New(cfg *config.Config, supervisor supervisor, connectionChecker connectionChecker, sv softwareVersioner, redactor *redact.Redactor) *Client {
 	return &Client{
 		cfg:               cfg,
 		supervisor:        supervisor,
 		connectionChecker: connectionChecker,
 		softwareVersioner: sv,
+		redactor:          redactor,
 		l:                 logrus.WithField("component", "client"),
 		backoff:           backoff.New(backoffMinDelay, backoffMaxDelay),
 		done:              make(chan struct{}),
 		dialTimeout:       dialTimeout,
+		spool:             spool.New(SpoolParams(cfg), logrus.WithField("component", "spool")),
+	}
+}
+
+// SpoolParams returns spool parameters for given configuration.
+func SpoolParams(cfg *config.Config) *spool.Params {
+	if cfg == nil || cfg.Spool.Disable {
+		return &spool.Params{}
+	}
+
+	dir := cfg.Spool.Dir
+	if dir == "" && cfg.Paths.TempDir != "" {
+		dir = filepath.Join(cfg.Paths.TempDir, "qan-spool")
+	}
+
+	return &spool.Params{
+		Dir:     dir,
+		MaxSize: cfg.Spool.MaxSize,
+		MaxAge:  cfg.Spool.MaxAge,
 	}
 }
 
@@ -101,7 +129,15 @@ func New(cfg *config.Config, supervisor supervisor, connectionChecker connection
 func (c *Client) Run(ctx context.Context) error {
 	c.l.Info("Starting...")
 
-	c.actionsRunner = actions.NewConcurrentRunner(ctx)
+	// actions runner is also used by Collect that may be called at any time
+	actionsRunner := actions.NewConcurrentRunner(ctx, actions.Limits{
+		MaxRunning:           c.cfg.Actions.MaxRunning,
+		MaxRunningPerService: c.cfg.Actions.MaxRunningPerService,
+		MaxQueued:            c.cfg.Actions.MaxQueued,
+	}, c.redactor)
+	c.rw.Lock()
+	c.actionsRunner = actionsRunner
+	c.rw.Unlock()
 	c.jobsRunner = jobs.NewRunner()
 
 	// do nothing until ctx is canceled if config misses critical info
@@ -174,10 +210,37 @@ func (c *Client) Run(ctx context.Context) error {
 	//    When Run is left, caller stops supervisor, and that allows processSupervisorRequests to exit.
 	//
 	// Done() channel is closed when all three goroutines exited.
+	//
+	// QAN data that was spooled while the channel was down is replayed in a separate goroutine.
+	// It periodically checks the spool for data added by other processes, and exits when the channel is closed;
+	// Done() channel also waits for it.
 
 	// TODO Make 2 and 3 behave more like 1 - that seems to be simpler.
 	// https://jira.percona.com/browse/PMM-4245
 
+	replayDone := make(chan struct{})
+	go func() {
+		defer close(replayDone)
+
+		channelClosed := make(chan struct{})
+		go func() {
+			_ = c.channel.Wait()
+			close(channelClosed)
+		}()
+
+		t := time.NewTicker(spoolReplayInterval)
+		defer t.Stop()
+		for {
+			c.replaySpool()
+
+			select {
+			case <-t.C:
+			case <-channelClosed:
+				return
+			}
+		}
+	}()
+
 	oneDone := make(chan struct{}, 5)
 	go func() {
 		c.jobsRunner.Run(ctx)
@@ -206,6 +269,7 @@ func (c *Client) Run(ctx context.Context) error {
 		<-oneDone
 		<-oneDone
 		<-oneDone
+		<-replayDone
 		c.l.Info("Done.")
 		close(c.done)
 	}()
@@ -219,10 +283,13 @@ func (c *Client) Done() <-chan struct{} {
 
 func (c *Client) processActionResults() {
 	for result := range c.actionsRunner.Results() {
+		if result.Partial {
+			c.l.Debugf("Sending chunk of Action %s output: %d bytes, %.0f%% done.", result.ID, len(result.Output), result.Progress*100)
+		}
 		resp, err := c.channel.SendAndWaitResponse(&agentpb.ActionResultRequest{
 			ActionId: result.ID,
 			Output:   result.Output,
-			Done:     true,
+			Done:     !result.Partial,
 			Error:    result.Error,
 		})
 		if err != nil {
@@ -274,7 +341,11 @@ func (c *Client) processSupervisorRequests() {
 				continue
 			}
 			if resp == nil {
-				c.l.Warn("Failed to send QanCollect request.")
+				// channel is closed, keep data until the next connection
+				c.l.Warn("Failed to send QanCollect request, spooling it.")
+				if err = c.spool.Push(collect); err != nil {
+					c.l.Errorf("Failed to spool QanCollect request: %s.", err)
+				}
 			}
 		}
 		c.l.Debugf("Supervisor QANRequests() channel drained.")
@@ -283,6 +354,28 @@ func (c *Client) processSupervisorRequests() {
 	wg.Wait()
 }
 
+// replaySpool sends spooled QAN data to the channel in order until the spool is empty or the channel is closed.
+func (c *Client) replaySpool() {
+	n, err := c.spool.Replay(func(collect *agentpb.QANCollectRequest) error {
+		resp, err := c.channel.SendAndWaitResponse(collect)
+		if err != nil {
+			// server rejected that request; sending it again will not help
+			c.l.Error(err)
+			return nil
+		}
+		if resp == nil {
+			return errors.New("channel is closed")
+		}
+		return nil
+	})
+	if n > 0 {
+		c.l.Infof("Replayed %d spooled QanCollect requests.", n)
+	}
+	if err != nil {
+		c.l.Warnf("Failed to replay spooled QanCollect requests: %s.", err)
+	}
+}
+
 func (c *Client) processChannelRequests(ctx context.Context) {
 	for req := range c.channel.Requests() {
 		var responsePayload agentpb.AgentResponsePayload
@@ -385,16 +478,39 @@ func (c *Client) processChannelRequests(ctx context.Context) {
 				})
 
 			case *agentpb.StartActionRequest_PtSummaryParams:
-				action = actions.NewProcessAction(p.ActionId, c.cfg.Paths.PTSummary, []string{})
+				action = actions.NewNodeSummaryAction(actions.NodeSummaryActionParams{
+					ID: p.ActionId,
+				})
 
 			case *agentpb.StartActionRequest_PtPgSummaryParams:
-				action = actions.NewProcessAction(p.ActionId, c.cfg.Paths.PTPgSummary, argListFromPgParams(params.PtPgSummaryParams))
+				action = actions.NewPostgreSQLSummaryAction(actions.PostgreSQLSummaryActionParams{
+					ID:      p.ActionId,
+					DSN:     dsnFromPgParams(params.PtPgSummaryParams),
+					TempDir: c.cfg.Paths.TempDir,
+				})
 
 			case *agentpb.StartActionRequest_PtMysqlSummaryParams:
-				action = actions.NewPTMySQLSummaryAction(p.ActionId, c.cfg.Paths.PTMySqlSummary, params.PtMysqlSummaryParams)
+				action = actions.NewMySQLSummaryAction(actions.MySQLSummaryActionParams{
+					ID:  p.ActionId,
+					DSN: dsnFromMySQLParams(params.PtMysqlSummaryParams),
+				})
 
 			case *agentpb.StartActionRequest_PtMongodbSummaryParams:
-				action = actions.NewProcessAction(p.ActionId, c.cfg.Paths.PTMongoDBSummary, argListFromMongoDBParams(params.PtMongodbSummaryParams))
+				action = actions.NewMongoDBSummaryAction(actions.MongoDBSummaryActionParams{
+					ID:      p.ActionId,
+					DSN:     dsnFromMongoDBParams(params.PtMongodbSummaryParams),
+					TempDir: c.cfg.Paths.TempDir,
+				})
+
+			// Those Actions are implemented, but not started there until agentpb gets their params:
+			//   - NewPostgreSQLExplainAction;
+			//   - NewMySQLIndexAdvisorAction, NewPostgreSQLIndexAdvisorAction;
+			//   - NewPostgreSQLTableBloatAction, NewPostgreSQLIndexBloatAction;
+			//   - NewMySQLKillQueryAction, NewPostgreSQLCancelQueryAction, NewMongoDBKillOpAction
+			//     (pass Paths.TempDir for their audit log);
+			//   - NewMySQLLockWaitsAction, NewMySQLDeadlockAction;
+			//   - NewPostgreSQLBlockingTreeAction, NewPostgreSQLLongTransactionsAction;
+			//   - NewMongoDBShowIndexAction, NewMongoDBIndexStatsAction, NewMongoDBCollectionStatsAction.
 
 			default:
 				c.l.Errorf("Unhandled StartAction request: %v.", req)
@@ -403,7 +519,7 @@ func (c *Client) processChannelRequests(ctx context.Context) {
 				break outerSwitch
 			}
 
-			c.actionsRunner.Start(action, c.getActionTimeout(p))
+			c.actionsRunner.Start(action, actionService(p), c.getActionTimeout(p))
 			responsePayload = &agentpb.StartActionResponse{}
 
 		case *agentpb.StopActionRequest:
@@ -756,6 +872,7 @@ func (c *Client) Describe(chan<- *prometheus.Desc) {
 func (c *Client) Collect(ch chan<- prometheus.Metric) {
 	c.rw.RLock()
 	channel := c.channel
+	actionsRunner := c.actionsRunner
 	c.rw.RUnlock()
 
 	desc := prometheus.NewDesc("pmm_agent_connected", "Has value 1 if two-way communication channel is established.", nil, nil)
@@ -765,60 +882,107 @@ func (c *Client) Collect(ch chan<- prometheus.Metric) {
 	} else {
 		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 0)
 	}
+	c.spool.Collect(ch)
 	c.supervisor.Collect(ch)
+	if actionsRunner != nil {
+		actionsRunner.Collect(ch)
+	}
 }
 
-// argListFromPgParams creates an array of strings from the pointer to the parameters for pt-pg-sumamry
-func argListFromPgParams(pParams *agentpb.StartActionRequest_PTPgSummaryParams) []string {
-	var args []string
-
-	if pParams.Host != "" {
-		args = append(args, "--host", pParams.Host)
+// actionService returns DSN of the service Action connects to, or empty string.
+func actionService(req *agentpb.StartActionRequest) string {
+	m := req.ProtoReflect()
+	fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("params"))
+	if fd == nil || fd.Message() == nil {
+		return ""
 	}
 
-	if pParams.Port > 0 && pParams.Port <= 65535 {
-		args = append(args, "--port", strconv.Itoa(int(pParams.Port)))
+	params := m.Get(fd).Message()
+	if dsn := params.Descriptor().Fields().ByName("dsn"); dsn != nil {
+		return params.Get(dsn).String()
 	}
+	return ""
+}
 
-	if pParams.Username != "" {
-		args = append(args, "--username", pParams.Username)
-	}
+// dsnFromMySQLParams creates MySQL DSN from the pt-mysql-summary parameters.
+// Socket takes precedence over host and port.
+func dsnFromMySQLParams(pParams *agentpb.StartActionRequest_PTMySQLSummaryParams) string {
+	cfg := mysql.NewConfig()
+	cfg.User = pParams.Username
+	cfg.Passwd = pParams.Password
 
-	pswd := strings.TrimSpace(pParams.Password)
-	if pswd != "" {
-		args = append(args, "--password", pswd)
+	if pParams.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = pParams.Socket
+		return cfg.FormatDSN()
 	}
 
-	return args
+	host := pParams.Host
+	if host == "" {
+		host = "localhost"
+	}
+	port := 3306
+	if pParams.Port > 0 && pParams.Port <= 65535 {
+		port = int(pParams.Port)
+	}
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	return cfg.FormatDSN()
 }
 
-// argListFromMongoDBParams creates an array of strings from the pointer to the parameters for pt-mongodb-sumamry
-func argListFromMongoDBParams(pParams *agentpb.StartActionRequest_PTMongoDBSummaryParams) []string {
-	var args []string
+// dsnFromPgParams creates PostgreSQL connection URI from the pt-pg-summary parameters.
+// Parameters have no SSL mode, so the driver's default (or PGSSLMODE environment variable) is used.
+func dsnFromPgParams(pParams *agentpb.StartActionRequest_PTPgSummaryParams) string {
+	host := pParams.Host
+	if host == "" {
+		host = "localhost"
+	}
 
-	// Only adds the arguments are valid
+	if pParams.Port > 0 && pParams.Port <= 65535 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(pParams.Port)))
+	}
 
-	if pParams.Username != "" {
-		args = append(args, "--username", pParams.Username)
+	u := &url.URL{
+		Scheme: "postgres",
+		Host:   host,
+		Path:   "/postgres",
 	}
 
-	if pParams.Password != "" {
-		// TODO change this line when pt-mongodb-summary is updated
-		args = append(args, fmt.Sprintf("--password=%s", pParams.Password))
+	pswd := strings.TrimSpace(pParams.Password)
+	switch {
+	case pswd != "":
+		u.User = url.UserPassword(pParams.Username, pswd)
+	case pParams.Username != "":
+		u.User = url.User(pParams.Username)
 	}
 
-	if pParams.Host != "" {
-		var hostPortStr string = pParams.Host
+	return u.String()
+}
+
+// dsnFromMongoDBParams creates MongoDB connection URI from the pt-mongodb-summary parameters.
+// Direct connection is used to get the summary of the given instance even if it is a replica set member.
+func dsnFromMongoDBParams(pParams *agentpb.StartActionRequest_PTMongoDBSummaryParams) string {
+	host := pParams.Host
+	if host == "" {
+		host = "localhost"
+	}
 
-		// If valid port attaches ':' and the port number after address
-		if pParams.Port > 0 && pParams.Port <= 65535 {
-			hostPortStr += ":" + strconv.Itoa(int(pParams.Port))
-		}
+	// If valid port attaches ':' and the port number after address
+	if pParams.Port > 0 && pParams.Port <= 65535 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(pParams.Port)))
+	}
 
-		args = append(args, hostPortStr)
+	u := &url.URL{
+		Scheme:   "mongodb",
+		Host:     host,
+		Path:     "/",
+		RawQuery: "directConnection=true",
+	}
+	if pParams.Username != "" {
+		u.User = url.UserPassword(pParams.Username, pParams.Password)
 	}
 
-	return args
+	return u.String()
 }
 
 // check interface
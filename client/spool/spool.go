@@ -0,0 +1,501 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spool implements bounded on-disk queue for QAN data that can't be sent to PMM Server.
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultMaxSize = 100 * 1024 * 1024 // 100 MiB
+	defaultMaxAge  = 24 * time.Hour
+
+	fileExt = ".qan"
+	tempExt = ".tmp"
+
+	prometheusNamespace = "pmm_agent"
+	prometheusSubsystem = "qan_spool"
+)
+
+// ErrDisabled is returned by Push when spool is disabled.
+var ErrDisabled = errors.New("spool is disabled")
+
+// Params represent Spool parameters.
+type Params struct {
+	// Dir is a directory for spooled requests. Spool is disabled if empty.
+	Dir string
+	// MaxSize is a maximal total size of spooled requests in bytes; the oldest requests are dropped above it.
+	// Zero means default value.
+	MaxSize int64
+	// MaxAge is a maximal age of spooled request; older requests are dropped.
+	// Zero means default value.
+	MaxAge time.Duration
+}
+
+// entry describes a single spooled QAN collect request stored in a separate file.
+type entry struct {
+	name    string
+	created time.Time
+	buckets int
+	size    int64
+}
+
+// Spool is a bounded crash-safe FIFO queue of QAN collect requests stored on disk.
+//
+// Each request is stored in a separate file. File is written to the temporary location, synced, and then renamed,
+// so partially written requests are never visible after a crash.
+//
+// All exported methods are thread-safe.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	l       *logrus.Entry
+
+	mSpooled, mReplayed, mDropped prometheus.Counter
+
+	replayM sync.Mutex // serializes Replay calls
+
+	m       sync.Mutex
+	loaded  bool
+	entries []*entry
+	size    int64
+	last    int64 // last used file timestamp to keep names unique and ordered
+	dropped int   // buckets dropped by this Spool instance
+}
+
+// New creates new Spool. Directory is created and existing files are loaded lazily on the first use.
+func New(params *Params, l *logrus.Entry) *Spool {
+	s := &Spool{
+		dir:     params.Dir,
+		maxSize: params.MaxSize,
+		maxAge:  params.MaxAge,
+		l:       l,
+
+		mSpooled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "spooled_buckets_total",
+			Help:      "A total number of QAN buckets written to the spool.",
+		}),
+		mReplayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "replayed_buckets_total",
+			Help:      "A total number of QAN buckets sent to PMM Server from the spool.",
+		}),
+		mDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "dropped_buckets_total",
+			Help:      "A total number of QAN buckets dropped from the spool due to size or age limits.",
+		}),
+	}
+
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+	if s.maxAge <= 0 {
+		s.maxAge = defaultMaxAge
+	}
+
+	return s
+}
+
+// Push stores QAN collect request at the end of the queue, dropping the oldest requests if limits are exceeded.
+func (s *Spool) Push(req *agentpb.QANCollectRequest) error {
+	if s.dir == "" {
+		return ErrDisabled
+	}
+
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err = s.load(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ts := now.UnixNano()
+	if ts <= s.last {
+		ts = s.last + 1
+	}
+	s.last = ts
+
+	e := &entry{
+		name:    fmt.Sprintf("%020d-%d%s", ts, len(req.MetricsBucket), fileExt),
+		created: time.Unix(0, ts),
+		buckets: len(req.MetricsBucket),
+		size:    int64(len(b)),
+	}
+	if err = writeFile(filepath.Join(s.dir, e.name), b); err != nil {
+		return err
+	}
+
+	s.entries = append(s.entries, e)
+	s.size += e.size
+	s.mSpooled.Add(float64(e.buckets))
+
+	s.trim(now)
+	return nil
+}
+
+// Replay calls send for spooled requests from the oldest to the newest.
+// Requests added to the spool directory by other processes since the last call are picked up too.
+// Request is removed from the spool when send returns nil.
+// Replay stops and returns the error returned by send; that request is kept for the next Replay call.
+// It returns the number of successfully sent requests.
+func (s *Spool) Replay(send func(*agentpb.QANCollectRequest) error) (int, error) {
+	if s.dir == "" {
+		return 0, nil
+	}
+
+	// only one Replay should remove requests from the front of the queue at any time
+	s.replayM.Lock()
+	defer s.replayM.Unlock()
+
+	s.m.Lock()
+	err := s.scan()
+	s.m.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for {
+		e, req, err := s.front()
+		if err != nil {
+			return n, err
+		}
+		if e == nil {
+			return n, nil
+		}
+
+		if err = send(req); err != nil {
+			return n, err
+		}
+
+		s.m.Lock()
+		s.remove(e)
+		s.m.Unlock()
+
+		s.mReplayed.Add(float64(e.buckets))
+		n++
+	}
+}
+
+// Dropped returns the number of buckets dropped due to size or age limits or corruption
+// since this Spool was created.
+func (s *Spool) Dropped() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.dropped
+}
+
+// Len returns the current number of spooled requests.
+func (s *Spool) Len() int {
+	if s.dir == "" {
+		return 0
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.load(); err != nil {
+		s.l.Error(err)
+	}
+	return len(s.entries)
+}
+
+// front returns the oldest non-expired request, or nils if spool is empty.
+// Unreadable requests are dropped.
+func (s *Spool) front() (*entry, *agentpb.QANCollectRequest, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, nil, err
+	}
+
+	s.trim(time.Now())
+
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		b, err := os.ReadFile(filepath.Join(s.dir, e.name)) //nolint:gosec
+		if err == nil {
+			req := new(agentpb.QANCollectRequest)
+			if err = proto.Unmarshal(b, req); err == nil {
+				return e, req, nil
+			}
+		}
+
+		s.l.Warnf("Dropping unreadable spooled request %s: %s.", e.name, err)
+		s.drop(e)
+	}
+
+	return nil, nil, nil
+}
+
+// load creates spool directory and loads information about existing spooled requests once.
+// Must be called with s.m held.
+func (s *Spool) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := s.scan(); err != nil {
+		return err
+	}
+
+	if len(s.entries) > 0 {
+		s.l.Infof("Loaded %d spooled requests (%d bytes) from %s.", len(s.entries), s.size, s.dir)
+	}
+
+	s.loaded = true
+	return nil
+}
+
+// scan adds information about spooled requests that are present in the spool directory, but not in memory.
+// On the first call, it also removes partially written files left after a crash.
+// Must be called with s.m held.
+func (s *Spool) scan() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		if s.loaded || !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		return nil // will be created by load
+	}
+
+	known := make(map[string]struct{}, len(s.entries))
+	for _, e := range s.entries {
+		known[e.name] = struct{}{}
+	}
+
+	var added int
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		name := f.Name()
+		path := filepath.Join(s.dir, name)
+
+		if strings.HasSuffix(name, tempExt) {
+			// remove partially written files left after a crash;
+			// later, they may be written by other processes right now
+			if !s.loaded {
+				s.l.Debugf("Removing partially written spooled request %s.", name)
+				_ = os.Remove(path)
+			}
+			continue
+		}
+
+		if _, ok := known[name]; ok {
+			continue
+		}
+
+		e, err := parseName(name)
+		if err != nil {
+			s.l.Warnf("Skipping unexpected file %s in spool directory: %s.", name, err)
+			continue
+		}
+
+		fi, err := f.Info()
+		if err != nil {
+			s.l.Warnf("Skipping spooled request %s: %s.", name, err)
+			continue
+		}
+		e.size = fi.Size()
+
+		s.entries = append(s.entries, e)
+		s.size += e.size
+		if ts := e.created.UnixNano(); ts > s.last {
+			s.last = ts
+		}
+		added++
+	}
+
+	if added > 0 {
+		sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+		if s.loaded {
+			s.l.Infof("Found %d new spooled requests in %s.", added, s.dir)
+		}
+	}
+	return nil
+}
+
+// trim drops expired requests and the oldest requests above size limit.
+// Must be called with s.m held.
+func (s *Spool) trim(now time.Time) {
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		if now.Sub(e.created) <= s.maxAge && s.size <= s.maxSize {
+			return
+		}
+
+		s.l.Warnf("Dropping spooled request %s with %d buckets due to spool limits.", e.name, e.buckets)
+		s.drop(e)
+	}
+}
+
+// drop removes request from the spool and accounts its buckets as dropped.
+// Must be called with s.m held.
+func (s *Spool) drop(e *entry) {
+	s.remove(e)
+	s.mDropped.Add(float64(e.buckets))
+	s.dropped += e.buckets
+}
+
+// remove removes request from the spool.
+// Must be called with s.m held.
+func (s *Spool) remove(e *entry) {
+	if err := os.Remove(filepath.Join(s.dir, e.name)); err != nil && !os.IsNotExist(err) {
+		s.l.Errorf("Failed to remove spooled request: %s.", err)
+	}
+
+	for i, se := range s.entries {
+		if se == e {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			s.size -= e.size
+			return
+		}
+	}
+}
+
+// parseName parses file name in the "<unix nano timestamp>-<number of buckets>.qan" format.
+func parseName(name string) (*entry, error) {
+	if !strings.HasSuffix(name, fileExt) {
+		return nil, errors.Errorf("unexpected extension")
+	}
+
+	parts := strings.Split(strings.TrimSuffix(name, fileExt), "-")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("unexpected name format")
+	}
+
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buckets, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &entry{
+		name:    name,
+		created: time.Unix(0, ts),
+		buckets: buckets,
+	}, nil
+}
+
+// writeFile atomically writes data to the file with given path.
+func writeFile(path string, b []byte) error {
+	temp := path + tempExt
+	f, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640) //nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		_ = os.Remove(temp)
+		return errors.WithStack(err)
+	}
+
+	// sync directory to persist rename; ignore errors as not all platforms support that
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (s *Spool) Describe(ch chan<- *prometheus.Desc) {
+	s.mSpooled.Describe(ch)
+	s.mReplayed.Describe(ch)
+	s.mDropped.Describe(ch)
+	ch <- mSizeDesc
+	ch <- mRequestsDesc
+}
+
+// Collect implement prometheus.Collector.
+func (s *Spool) Collect(ch chan<- prometheus.Metric) {
+	s.mSpooled.Collect(ch)
+	s.mReplayed.Collect(ch)
+	s.mDropped.Collect(ch)
+
+	s.m.Lock()
+	size, requests := s.size, len(s.entries)
+	s.m.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(mSizeDesc, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(mRequestsDesc, prometheus.GaugeValue, float64(requests))
+}
+
+var (
+	mSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "size_bytes"),
+		"Current total size of spooled QAN requests in bytes.",
+		nil,
+		nil)
+	mRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "requests"),
+		"Current number of spooled QAN requests.",
+		nil,
+		nil)
+)
+
+// check interfaces
+var (
+	_ prometheus.Collector = (*Spool)(nil)
+)
@@ -0,0 +1,170 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func request(queryID string) *agentpb.QANCollectRequest {
+	return &agentpb.QANCollectRequest{
+		MetricsBucket: []*agentpb.MetricsBucket{{
+			Common: &agentpb.MetricsBucket_Common{
+				Queryid: queryID,
+			},
+		}},
+	}
+}
+
+func queryIDs(t *testing.T, s *Spool) []string {
+	var res []string
+	_, err := s.Replay(func(req *agentpb.QANCollectRequest) error {
+		res = append(res, req.MetricsBucket[0].Common.Queryid)
+		return nil
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func TestSpool(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		s := New(&Params{}, logrus.WithField("test", t.Name()))
+		assert.Equal(t, ErrDisabled, s.Push(request("1")))
+		assert.Zero(t, s.Len())
+		n, err := s.Replay(func(*agentpb.QANCollectRequest) error { panic("not reached") })
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		t.Parallel()
+
+		s := New(&Params{Dir: t.TempDir()}, logrus.WithField("test", t.Name()))
+		for _, id := range []string{"1", "2", "3"} {
+			require.NoError(t, s.Push(request(id)))
+		}
+		assert.Equal(t, 3, s.Len())
+		assert.Equal(t, []string{"1", "2", "3"}, queryIDs(t, s))
+		assert.Zero(t, s.Len())
+	})
+
+	t.Run("SendError", func(t *testing.T) {
+		t.Parallel()
+
+		s := New(&Params{Dir: t.TempDir()}, logrus.WithField("test", t.Name()))
+		for _, id := range []string{"1", "2", "3"} {
+			require.NoError(t, s.Push(request(id)))
+		}
+
+		var sent int
+		n, err := s.Replay(func(req *agentpb.QANCollectRequest) error {
+			if sent == 1 {
+				return errors.New("channel is closed")
+			}
+			sent++
+			return nil
+		})
+		assert.EqualError(t, err, "channel is closed")
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []string{"2", "3"}, queryIDs(t, s))
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		s := New(&Params{Dir: dir}, logrus.WithField("test", t.Name()))
+		for _, id := range []string{"1", "2"} {
+			require.NoError(t, s.Push(request(id)))
+		}
+
+		// simulate a crash in the middle of writing
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "99999999999999999999-1.qan.tmp"), []byte("garbage"), 0o600))
+
+		s = New(&Params{Dir: dir}, logrus.WithField("test", t.Name()))
+		require.NoError(t, s.Push(request("3")))
+		assert.Equal(t, []string{"1", "2", "3"}, queryIDs(t, s))
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("OtherProcess", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		s := New(&Params{Dir: dir}, logrus.WithField("test", t.Name()))
+		require.NoError(t, s.Push(request("1")))
+		assert.Equal(t, 1, s.Len())
+
+		other := New(&Params{Dir: dir}, logrus.WithField("test", t.Name()))
+		require.NoError(t, other.Push(request("2")))
+		require.NoError(t, other.Push(request("3")))
+
+		assert.Equal(t, []string{"1", "2", "3"}, queryIDs(t, s))
+	})
+
+	t.Run("MaxSize", func(t *testing.T) {
+		t.Parallel()
+
+		size := int64(proto.Size(request("1")))
+		s := New(&Params{Dir: t.TempDir(), MaxSize: 2 * size}, logrus.WithField("test", t.Name()))
+		for _, id := range []string{"1", "2", "3", "4"} {
+			require.NoError(t, s.Push(request(id)))
+		}
+		assert.Equal(t, []string{"3", "4"}, queryIDs(t, s))
+		assert.Equal(t, 2*len(request("1").MetricsBucket), s.Dropped())
+	})
+
+	t.Run("MaxAge", func(t *testing.T) {
+		t.Parallel()
+
+		s := New(&Params{Dir: t.TempDir(), MaxAge: 100 * time.Millisecond}, logrus.WithField("test", t.Name()))
+		require.NoError(t, s.Push(request("1")))
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, s.Push(request("2")))
+		assert.Equal(t, []string{"2"}, queryIDs(t, s))
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		s := New(&Params{Dir: dir}, logrus.WithField("test", t.Name()))
+		require.NoError(t, s.Push(request("1")))
+		require.NoError(t, s.Push(request("2")))
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, files[0].Name()), []byte("garbage"), 0o600))
+
+		assert.Equal(t, []string{"2"}, queryIDs(t, s))
+	})
+}
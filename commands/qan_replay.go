@@ -0,0 +1,174 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/percona/pmm-agent/agents/mysql/slowlog"
+	"github.com/percona/pmm-agent/agents/mysql/slowlog/parser"
+	"github.com/percona/pmm-agent/client"
+	"github.com/percona/pmm-agent/client/spool"
+	"github.com/percona/pmm-agent/config"
+	"github.com/percona/pmm-agent/utils/redact"
+	"github.com/percona/pmm-agent/utils/truncate"
+)
+
+// QANReplaySlowLog implements `pmm-agent qan-replay slowlog` command.
+func QANReplaySlowLog() {
+	l := logrus.WithField("component", "qan-replay")
+	cfg, _, err := config.Get(l)
+	if _, ok := err.(config.ErrConfigFileDoesNotExist); ok {
+		err = nil
+	}
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %s.\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGINT)
+	defer cancel()
+
+	p := &cfg.QANReplay
+	params := &slowlog.ReplayParams{
+		AgentID:              p.AgentID,
+		DisableQueryExamples: p.DisableQueryExamples,
+		OutlierTime:          p.OutlierTime,
+	}
+	if p.TimeZone != "" {
+		if params.Location, err = time.LoadLocation(p.TimeZone); err != nil {
+			fmt.Printf("Failed to load time zone: %s.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	redactor, err := redact.New(&cfg.QueryExamples)
+	if err != nil {
+		fmt.Printf("Failed to configure query examples redaction: %s.\n", err)
+		os.Exit(1)
+	}
+
+	// PMM Server accepts buckets only for known Agents, and pmm-agent sends spooled data on its behalf
+	var s *spool.Spool
+	if p.Upload {
+		if p.AgentID == "" {
+			fmt.Printf("--agent-id is required for --upload.\n")
+			os.Exit(1)
+		}
+		spoolParams := client.SpoolParams(cfg)
+		if spoolParams.Dir == "" {
+			fmt.Printf("Can't upload: spool is disabled.\n")
+			os.Exit(1)
+		}
+		s = spool.New(spoolParams, l.WithField("component", "spool"))
+	}
+
+	reader, err := parser.NewSimpleFileReader(p.File)
+	if err != nil {
+		fmt.Printf("Failed to open slow log: %s.\n", err)
+		os.Exit(1)
+	}
+
+	top := make(topQueries)
+	var buckets int
+	err = slowlog.Replay(ctx, reader, params, func(mb []*agentpb.MetricsBucket) error {
+		redactor.Buckets(mb)
+		top.add(mb)
+		buckets += len(mb)
+		if s == nil {
+			return nil
+		}
+		return s.Push(&agentpb.QANCollectRequest{MetricsBucket: mb})
+	}, l)
+	if err != nil {
+		fmt.Printf("Failed to replay slow log: %s.\n", err)
+		os.Exit(1)
+	}
+
+	top.print(os.Stdout, p.Top)
+
+	if s != nil {
+		// the oldest requests are dropped above spool limits, that is likely for large slow logs
+		if dropped := s.Dropped(); dropped != 0 {
+			fmt.Printf("\n%d of %d buckets are spooled, %d buckets are dropped due to spool limits; "+
+				"increase them with --spool-max-size and --spool-max-age and replay again.\n", buckets-dropped, buckets, dropped)
+			os.Exit(1)
+		}
+		fmt.Printf("\n%d buckets are spooled; running pmm-agent will send them to PMM Server.\n", buckets)
+	}
+}
+
+// topQuery contains totals for a single query.
+type topQuery struct {
+	queryID     string
+	fingerprint string
+	count       float64
+	time        float64
+}
+
+// topQueries accumulates totals for all queries by query ID.
+type topQueries map[string]*topQuery
+
+func (t topQueries) add(buckets []*agentpb.MetricsBucket) {
+	for _, b := range buckets {
+		q := t[b.Common.Queryid]
+		if q == nil {
+			q = &topQuery{
+				queryID:     b.Common.Queryid,
+				fingerprint: b.Common.Fingerprint,
+			}
+			t[q.queryID] = q
+		}
+		q.count += float64(b.Common.NumQueries)
+		q.time += float64(b.Common.MQueryTimeSum)
+	}
+}
+
+// print writes a table of n queries with the largest total time.
+func (t topQueries) print(w io.Writer, n int) {
+	queries := make([]*topQuery, 0, len(t))
+	for _, q := range t {
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].time != queries[j].time {
+			return queries[i].time > queries[j].time
+		}
+		return queries[i].queryID < queries[j].queryID
+	})
+	if n > 0 && len(queries) > n {
+		queries = queries[:n]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Rank\tQuery ID\tCount\tTotal time, s\tAverage time, s\tFingerprint")
+	for i, q := range queries {
+		fingerprint := truncate.String(q.fingerprint, 80)
+		fmt.Fprintf(tw, "%d\t%s\t%.0f\t%.3f\t%.6f\t%s\n", i+1, q.queryID, q.count, q.time, q.time/q.count, fingerprint)
+	}
+	_ = tw.Flush()
+}
@@ -28,6 +28,8 @@ import (
 	"github.com/percona/pmm-agent/client"
 	"github.com/percona/pmm-agent/config"
 	"github.com/percona/pmm-agent/connectionchecker"
+	"github.com/percona/pmm-agent/qansink"
+	"github.com/percona/pmm-agent/utils/redact"
 	"github.com/percona/pmm-agent/versioner"
 )
 
@@ -73,12 +75,38 @@ func run(ctx context.Context, cfg *config.Config, configFilepath string) {
 	// It should be created separately.
 	// TODO https://jira.percona.com/browse/PMM-7206
 
-	supervisor := supervisor.NewSupervisor(ctx, &cfg.Paths, &cfg.Ports, &cfg.Server)
+	qanSinks, err := qansink.New(cfg.QANSink, logrus.WithField("component", "qan-sink"))
+	if err != nil {
+		logrus.WithField("component", "main").Fatalf("Failed to create QAN sinks: %s.", err)
+	}
+	defer qanSinks.Close() //nolint:errcheck
+
+	redactor, err := redact.New(&cfg.QueryExamples)
+	if err != nil {
+		logrus.WithField("component", "main").Fatalf("Failed to configure query examples redaction: %s.", err)
+	}
+
+	supervisor := supervisor.NewSupervisor(ctx, &supervisor.Params{
+		Paths:           &cfg.Paths,
+		Ports:           &cfg.Ports,
+		Server:          &cfg.Server,
+		QANSinks:        qanSinks,
+		MongoDBQAN:      &cfg.MongoDBQAN,
+		PostgreSQLQAN:   &cfg.PostgreSQLQAN,
+		Redactor:        redactor,
+		DisableQANState: cfg.QANStateDisable,
+	})
 	connectionChecker := connectionchecker.New(&cfg.Paths)
 	v := versioner.New(&versioner.RealExecFunctions{})
-	client := client.New(cfg, supervisor, connectionChecker, v)
+	client := client.New(cfg, supervisor, connectionChecker, v, redactor)
 	localServer := agentlocal.NewServer(cfg, supervisor, client, configFilepath)
 
+	// Without PMM Server, locally configured built-in QAN Agents are started, and their data is written to local QAN sinks.
+	// Client does nothing in that case, so supervisor's channels are read here.
+	if (cfg.ID == "" || cfg.Server.Address == "") && len(cfg.LocalAgents) != 0 {
+		runLocalAgents(cfg, supervisor, qanSinks)
+	}
+
 	go func() {
 		_ = client.Run(ctx)
 		cancel()
@@ -89,3 +117,30 @@ func run(ctx context.Context, cfg *config.Config, configFilepath string) {
 
 	<-client.Done()
 }
+
+// runLocalAgents starts locally configured built-in QAN Agents and reads supervisor's channels until they are closed.
+func runLocalAgents(cfg *config.Config, s *supervisor.Supervisor, qanSinks *qansink.Sinks) {
+	l := logrus.WithField("component", "local-agents")
+
+	state, err := supervisor.LocalState(cfg.LocalAgents)
+	if err != nil {
+		l.Fatalf("Failed to configure local Agents: %s.", err)
+	}
+	if !qanSinks.HasLocal() {
+		l.Fatalf("Local Agents require stdout or file QAN sink (--qan-sink).")
+	}
+
+	go func() {
+		for change := range s.Changes() {
+			l.Infof("Agent %s: %s.", change.AgentId, change.Status)
+		}
+	}()
+	go func() {
+		for req := range s.QANRequests() {
+			l.Warnf("PMM Server is not configured, dropping %d buckets.", len(req.MetricsBucket))
+		}
+	}()
+
+	l.Infof("Starting %d local Agents.", len(state.BuiltinAgents))
+	s.SetState(state)
+}
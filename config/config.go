@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/percona/pmm/utils/nodeinfo"
 	"github.com/percona/pmm/version"
@@ -97,6 +98,9 @@ type Paths struct {
 
 	TempDir string `yaml:"tempdir"`
 
+	// pt-*summary tool paths are deprecated and not used since summary Actions collect data natively.
+	// They are still parsed and resolved, so existing configuration files and command lines keep working,
+	// and will be removed together with their flags and YAML keys in PMM 3.0.
 	PTSummary        string `yaml:"pt_summary"`
 	PTPgSummary      string `yaml:"pt_pg_summary"`
 	PTMySqlSummary   string `yaml:"pt_mysql_summary"`
@@ -111,6 +115,72 @@ type Ports struct {
 	Max uint16 `yaml:"max"`
 }
 
+// Spool represents on-disk spool configuration for QAN data that can't be sent to PMM Server.
+type Spool struct {
+	Dir     string        `yaml:"dir,omitempty"`      // defaults to qan-spool in Paths.TempDir
+	MaxSize int64         `yaml:"max-size,omitempty"` // in bytes
+	MaxAge  time.Duration `yaml:"max-age,omitempty"`
+	Disable bool          `yaml:"disable,omitempty"`
+}
+
+// Actions represents limits of concurrently running Actions.
+type Actions struct {
+	MaxRunning           int `yaml:"max-running,omitempty"`             // negative value means no limit
+	MaxRunningPerService int `yaml:"max-running-per-service,omitempty"` // negative value means no limit
+	MaxQueued            int `yaml:"max-queued,omitempty"`              // negative value means no limit
+}
+
+// LocalAgent represents built-in QAN Agent that is started without PMM Server.
+type LocalAgent struct {
+	ID                   string `yaml:"id"`
+	Type                 string `yaml:"type"` // inventory Agent type, for example QAN_MYSQL_PERFSCHEMA_AGENT
+	DSN                  string `yaml:"dsn"`
+	DisableQueryExamples bool   `yaml:"disable-query-examples,omitempty"`
+	MaxQueryLogSize      int64  `yaml:"max-query-log-size,omitempty"`
+}
+
+// MongoDBQAN represents configuration of MongoDB QAN Agents.
+type MongoDBQAN struct {
+	// Agents contains settings of MongoDB QAN Agents by Agent ID; configuration file only.
+	// Agents without settings use the profiler.
+	Agents map[string]MongoDBQANAgent `yaml:"agents,omitempty"`
+}
+
+// MongoDBQANAgent represents configuration of a single MongoDB QAN Agent.
+type MongoDBQANAgent struct {
+	Source            string        `yaml:"source,omitempty"`             // profiler (default), currentop or log
+	LogFile           string        `yaml:"log-file,omitempty"`           // mongod log file for log source
+	CurrentOpInterval time.Duration `yaml:"currentop-interval,omitempty"` // sampling interval for currentop source
+}
+
+// PostgreSQLQAN represents configuration of PostgreSQL QAN Agents.
+type PostgreSQLQAN struct {
+	// Agents contains settings of pg_stat_statements and pg_stat_monitor QAN Agents by Agent ID;
+	// configuration file only. Agents without settings use their extensions.
+	Agents map[string]PostgreSQLQANAgent `yaml:"agents,omitempty"`
+}
+
+// PostgreSQLQANAgent represents configuration of a single PostgreSQL QAN Agent.
+type PostgreSQLQANAgent struct {
+	Source    string `yaml:"source,omitempty"`     // extension (default) or log
+	LogFile   string `yaml:"log-file,omitempty"`   // server log file for log source
+	LogFormat string `yaml:"log-format,omitempty"` // stderr (default) or csvlog for log source
+}
+
+// QueryExamples represents redaction of query examples and Action outputs before they leave pmm-agent.
+type QueryExamples struct {
+	MaskLiterals bool         `yaml:"mask-literals,omitempty"`
+	MaskColumns  []string     `yaml:"mask-columns,omitempty"`  // column name patterns with * wildcards
+	AllowSchemas []string     `yaml:"allow-schemas,omitempty"` // examples from those schemas/databases are not redacted
+	Rules        []RedactRule `yaml:"rules,omitempty"`         // configuration file only
+}
+
+// RedactRule represents a regular expression redaction rule.
+type RedactRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"` // may contain $1-style references to submatches
+}
+
 // Setup contains `pmm-agent setup` flag and argument values.
 // It is never stored in configuration file.
 type Setup struct {
@@ -133,6 +203,18 @@ type Setup struct {
 	SkipRegistration bool
 }
 
+// QANReplay contains `pmm-agent qan-replay` flag and argument values.
+// It is never stored in configuration file.
+type QANReplay struct {
+	File                 string
+	AgentID              string
+	TimeZone             string
+	OutlierTime          float64
+	Top                  int
+	Upload               bool
+	DisableQueryExamples bool
+}
+
 // Config represents pmm-agent's configuration.
 //
 //nolint:maligned
@@ -146,12 +228,24 @@ type Config struct {
 	Server Server `yaml:"server"`
 	Paths  Paths  `yaml:"paths"`
 	Ports  Ports  `yaml:"ports"`
+	Spool  Spool  `yaml:"spool,omitempty"`
+
+	Actions Actions `yaml:"actions,omitempty"`
+
+	QANSink         string        `yaml:"qan-sink,omitempty"`          // comma-separated list, see qansink.New
+	QANStateDisable bool          `yaml:"qan-state-disable,omitempty"` // do not persist built-in QAN Agents state
+	LocalAgents     []LocalAgent  `yaml:"local-agents,omitempty"`      // configuration file only, used without PMM Server
+	MongoDBQAN      MongoDBQAN    `yaml:"mongodb-qan,omitempty"`
+	PostgreSQLQAN   PostgreSQLQAN `yaml:"postgresql-qan,omitempty"`
+
+	QueryExamples QueryExamples `yaml:"query-examples,omitempty"`
 
 	LogLevel string `yaml:"log-level"`
 	Debug    bool   `yaml:"debug"`
 	Trace    bool   `yaml:"trace"`
 
-	Setup Setup `yaml:"-"`
+	Setup     Setup     `yaml:"-"`
+	QANReplay QANReplay `yaml:"-"`
 }
 
 // ErrConfigFileDoesNotExist error is returned from Get method if configuration file is expected,
@@ -193,6 +287,16 @@ func get(args []string, l *logrus.Entry) (cfg *Config, configFileF string, err e
 		if cfg.Ports.Max == 0 {
 			cfg.Ports.Max = 51999
 		}
+		// zero means default limit, negative means no limit (see actions.Limits)
+		if cfg.Actions.MaxRunning == 0 {
+			cfg.Actions.MaxRunning = 10
+		}
+		if cfg.Actions.MaxRunningPerService == 0 {
+			cfg.Actions.MaxRunningPerService = 2
+		}
+		if cfg.Actions.MaxQueued == 0 {
+			cfg.Actions.MaxQueued = 100
+		}
 		for sp, v := range map[*string]string{
 			&cfg.Paths.NodeExporter:     "node_exporter",
 			&cfg.Paths.MySQLdExporter:   "mysqld_exporter",
@@ -351,13 +455,13 @@ func Application(cfg *Config) (*kingpin.Application, *string) {
 		Envar("PMM_AGENT_PATHS_PROXYSQL_EXPORTER").StringVar(&cfg.Paths.ProxySQLExporter)
 	app.Flag("paths-azure_exporter", "Path to azure_exporter to use [PMM_AGENT_PATHS_AZURE_EXPORTER]").
 		Envar("PMM_AGENT_PATHS_AZURE_EXPORTER").StringVar(&cfg.Paths.AzureExporter)
-	app.Flag("paths-pt-summary", "Path to pt summary to use [PMM_AGENT_PATHS_PT_SUMMARY]").
+	app.Flag("paths-pt-summary", "Deprecated, not used; will be removed in PMM 3.0 [PMM_AGENT_PATHS_PT_SUMMARY]").
 		Envar("PMM_AGENT_PATHS_PT_SUMMARY").StringVar(&cfg.Paths.PTSummary)
-	app.Flag("paths-pt-pg-summary", "Path to pt-pg-summary to use [PMM_AGENT_PATHS_PT_PG_SUMMARY]").
+	app.Flag("paths-pt-pg-summary", "Deprecated, not used; will be removed in PMM 3.0 [PMM_AGENT_PATHS_PT_PG_SUMMARY]").
 		Envar("PMM_AGENT_PATHS_PT_PG_SUMMARY").StringVar(&cfg.Paths.PTPgSummary)
-	app.Flag("paths-pt-mongodb-summary", "Path to pt mongodb summary to use [PMM_AGENT_PATHS_PT_MONGODB_SUMMARY]").
+	app.Flag("paths-pt-mongodb-summary", "Deprecated, not used; will be removed in PMM 3.0 [PMM_AGENT_PATHS_PT_MONGODB_SUMMARY]").
 		Envar("PMM_AGENT_PATHS_PT_MONGODB_SUMMARY").StringVar(&cfg.Paths.PTMongoDBSummary)
-	app.Flag("paths-pt-mysql-summary", "Path to pt my sql summary to use [PMM_AGENT_PATHS_PT_MYSQL_SUMMARY]").
+	app.Flag("paths-pt-mysql-summary", "Deprecated, not used; will be removed in PMM 3.0 [PMM_AGENT_PATHS_PT_MYSQL_SUMMARY]").
 		Envar("PMM_AGENT_PATHS_PT_MYSQL_SUMMARY").StringVar(&cfg.Paths.PTMySqlSummary)
 	app.Flag("paths-tempdir", "Temporary directory for exporters [PMM_AGENT_PATHS_TEMPDIR]").
 		Envar("PMM_AGENT_PATHS_TEMPDIR").StringVar(&cfg.Paths.TempDir)
@@ -368,6 +472,41 @@ func Application(cfg *Config) (*kingpin.Application, *string) {
 	app.Flag("ports-max", "Maximal allowed port number for listening sockets [PMM_AGENT_PORTS_MAX]").
 		Envar("PMM_AGENT_PORTS_MAX").Uint16Var(&cfg.Ports.Max)
 
+	app.Flag("actions-max-running", "Maximal number of concurrently running Actions, -1 for no limit [PMM_AGENT_ACTIONS_MAX_RUNNING]").
+		Envar("PMM_AGENT_ACTIONS_MAX_RUNNING").IntVar(&cfg.Actions.MaxRunning)
+	app.Flag("actions-max-running-per-service", "Maximal number of concurrently running Actions for the same service, -1 for no limit "+
+		"[PMM_AGENT_ACTIONS_MAX_RUNNING_PER_SERVICE]").
+		Envar("PMM_AGENT_ACTIONS_MAX_RUNNING_PER_SERVICE").IntVar(&cfg.Actions.MaxRunningPerService)
+	app.Flag("actions-max-queued", "Maximal number of Actions waiting for start; others are rejected; -1 for no limit "+
+		"[PMM_AGENT_ACTIONS_MAX_QUEUED]").
+		Envar("PMM_AGENT_ACTIONS_MAX_QUEUED").IntVar(&cfg.Actions.MaxQueued)
+
+	app.Flag("spool-dir", "Directory for QAN data that can't be sent to PMM Server [PMM_AGENT_SPOOL_DIR]").
+		Envar("PMM_AGENT_SPOOL_DIR").StringVar(&cfg.Spool.Dir)
+	app.Flag("spool-max-size", "Maximal size of spooled QAN data in bytes [PMM_AGENT_SPOOL_MAX_SIZE]").
+		Envar("PMM_AGENT_SPOOL_MAX_SIZE").Int64Var(&cfg.Spool.MaxSize)
+	app.Flag("spool-max-age", "Maximal age of spooled QAN data [PMM_AGENT_SPOOL_MAX_AGE]").
+		Envar("PMM_AGENT_SPOOL_MAX_AGE").DurationVar(&cfg.Spool.MaxAge)
+	app.Flag("spool-disable", "Disable spooling of QAN data that can't be sent to PMM Server [PMM_AGENT_SPOOL_DISABLE]").
+		Envar("PMM_AGENT_SPOOL_DISABLE").BoolVar(&cfg.Spool.Disable)
+	app.Flag("qan-state-disable", "Do not persist built-in QAN Agents state between restarts [PMM_AGENT_QAN_STATE_DISABLE]").
+		Envar("PMM_AGENT_QAN_STATE_DISABLE").BoolVar(&cfg.QANStateDisable)
+
+	app.Flag("qan-sink", "Comma-separated list of QAN data destinations: server (default), stdout, "+
+		"file:///path/to/dir[?max-size=<bytes>&max-files=<n>&format=ndjson|columnar] [PMM_AGENT_QAN_SINK]").
+		Envar("PMM_AGENT_QAN_SINK").StringVar(&cfg.QANSink)
+
+	app.Flag("query-examples-mask-literals", "Replace literals in query examples with ? "+
+		"[PMM_AGENT_QUERY_EXAMPLES_MASK_LITERALS]").
+		Envar("PMM_AGENT_QUERY_EXAMPLES_MASK_LITERALS").BoolVar(&cfg.QueryExamples.MaskLiterals)
+	app.Flag("query-examples-mask-column", "Mask values of columns matching pattern (with * wildcards) "+
+		"in query examples and results; may be repeated [PMM_AGENT_QUERY_EXAMPLES_MASK_COLUMN]").
+		Envar("PMM_AGENT_QUERY_EXAMPLES_MASK_COLUMN").StringsVar(&cfg.QueryExamples.MaskColumns)
+	app.Flag("query-examples-allow-schema", "Do not redact query examples from schema/database; may be repeated "+
+		"[PMM_AGENT_QUERY_EXAMPLES_ALLOW_SCHEMA]").
+		Envar("PMM_AGENT_QUERY_EXAMPLES_ALLOW_SCHEMA").StringsVar(&cfg.QueryExamples.AllowSchemas)
+	// no flag for Rules - they are set in configuration file
+
 	app.Flag("log-level", "Set logging level [PMM_AGENT_LOG_LEVEL]").
 		Envar("PMM_AGENT_LOG_LEVEL").EnumVar(&cfg.LogLevel, "debug", "info", "warn", "error", "fatal")
 	app.Flag("debug", "Enable debug output [PMM_AGENT_DEBUG]").
@@ -450,6 +589,19 @@ func Application(cfg *Config) (*kingpin.Application, *string) {
 	setupCmd.Flag("agent-password", "Custom password for /metrics endpoint [PMM_AGENT_SETUP_NODE_PASSWORD]").
 		Envar("PMM_AGENT_SETUP_NODE_PASSWORD").StringVar(&cfg.Setup.AgentPassword)
 
+	qanReplayCmd := app.Command("qan-replay", "Make QAN data from historic sources")
+	slowlogCmd := qanReplayCmd.Command("slowlog", "Make QAN data from MySQL slow log file")
+	slowlogCmd.Arg("file", "Slow log file").Required().StringVar(&cfg.QANReplay.File)
+	slowlogCmd.Flag("top", "Number of top queries by total time to print").Default("10").IntVar(&cfg.QANReplay.Top)
+	slowlogCmd.Flag("upload", "Spool QAN data for sending to PMM Server by running pmm-agent").BoolVar(&cfg.QANReplay.Upload)
+	slowlogCmd.Flag("agent-id", "ID of MySQL slow log QAN Agent on PMM Server to use for QAN data").
+		PlaceHolder("</agent_id/...>").StringVar(&cfg.QANReplay.AgentID)
+	slowlogCmd.Flag("time-zone", "Time zone of slow log timestamps without offset (default is local)").
+		StringVar(&cfg.QANReplay.TimeZone)
+	slowlogCmd.Flag("outlier-time", "Value of Percona Server slow_query_log_always_write_time variable for outliers detection").
+		Float64Var(&cfg.QANReplay.OutlierTime)
+	slowlogCmd.Flag("disable-query-examples", "Do not include query examples").BoolVar(&cfg.QANReplay.DisableQueryExamples)
+
 	return app, configFileF
 }
 
@@ -115,6 +115,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 		}
 		assert.Equal(t, expected, actual)
 		assert.Empty(t, configFilepath)
@@ -163,6 +168,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 		}
 		assert.Equal(t, expected, actual)
 		assert.Equal(t, name, configFilepath)
@@ -213,6 +223,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 			LogLevel: "info",
 			Debug:    true,
 		}
@@ -271,6 +286,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 			Debug: true,
 		}
 		assert.Equal(t, expected, actual)
@@ -328,6 +348,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 			Debug: true,
 		}
 		assert.Equal(t, expected, actual)
@@ -382,6 +407,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 			Debug: true,
 		}
 		assert.Equal(t, expected, actual)
@@ -422,6 +452,11 @@ func TestGet(t *testing.T) {
 				Min: 42000,
 				Max: 51999,
 			},
+			Actions: Actions{
+				MaxRunning:           10,
+				MaxRunningPerService: 2,
+				MaxQueued:            100,
+			},
 			Debug: true,
 		}
 		assert.Equal(t, expected, actual)
@@ -71,6 +71,9 @@ func main() {
 	case "setup":
 		config.ConfigureLogger(&cfg)
 		commands.Setup()
+	case "qan-replay slowlog":
+		config.ConfigureLogger(&cfg)
+		commands.QANReplaySlowLog()
 	default:
 		// not reachable due to default kingpin's termination handler; keep it just in case
 		kingpin.Fatalf("Unexpected command %q.", cmd)
@@ -101,7 +101,7 @@ func TestImports(t *testing.T) {
 
 	constraints := make(map[string]constraint)
 
-	agentsUsingCache := []string{"/perfschema", "/pgstatstatements"}
+	agentsUsingCache := []string{"/perfschema", "/pgstatmonitor", "/pgstatstatements"}
 
 	// agents code should be independent
 	for _, a := range []string{
@@ -109,10 +109,12 @@ func TestImports(t *testing.T) {
 		"github.com/percona/pmm-agent/agents/mysql/perfschema",
 		"github.com/percona/pmm-agent/agents/mysql/slowlog",
 		"github.com/percona/pmm-agent/agents/noop",
+		"github.com/percona/pmm-agent/agents/postgres/pglog",
 		"github.com/percona/pmm-agent/agents/postgres/pgstatmonitor",
 		"github.com/percona/pmm-agent/agents/postgres/pgstatstatements",
 		"github.com/percona/pmm-agent/agents/process",
 		"github.com/percona/pmm-agent/agents/cache",
+		"github.com/percona/pmm-agent/agents/waits",
 	} {
 		c := constraint{
 			denyPrefixes: []string{
@@ -130,10 +132,21 @@ func TestImports(t *testing.T) {
 			}
 		}
 
-		// allows agents to use cache
+		// reuses slowlog's file reader and pgstatstatements' parser
+		if strings.HasSuffix(a, "/pglog") {
+			c.allowPrefixes = []string{
+				"github.com/percona/pmm-agent/agents/mysql/slowlog/parser",
+				"github.com/percona/pmm-agent/agents/postgres/parser",
+			}
+		}
+
+		// allows agents to use cache and wait events sampling
 		for _, cachedAgent := range agentsUsingCache {
 			if strings.HasSuffix(a, cachedAgent) {
-				c.allowPrefixes = append(c.allowPrefixes, "github.com/percona/pmm-agent/agents/cache")
+				c.allowPrefixes = append(c.allowPrefixes,
+					"github.com/percona/pmm-agent/agents/cache",
+					"github.com/percona/pmm-agent/agents/waits",
+				)
 			}
 		}
 
@@ -0,0 +1,89 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qansink
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// columnarBlock is a group of metrics buckets stored by columns, similar to Parquet row group.
+type columnarBlock struct {
+	Rows int `json:"rows"`
+	// Columns contains values of metrics buckets fields, one value per row (null if field is not set).
+	// Nested fields are flattened with dot-separated names like "common.queryid".
+	Columns map[string][]json.RawMessage `json:"columns"`
+}
+
+// marshalColumnar returns metrics buckets of the given request as a single line of columnar JSON.
+// It returns nil for a request without buckets.
+func marshalColumnar(req *agentpb.QANCollectRequest) ([]byte, error) {
+	if len(req.MetricsBucket) == 0 {
+		return nil, nil
+	}
+
+	block := &columnarBlock{
+		Rows:    len(req.MetricsBucket),
+		Columns: make(map[string][]json.RawMessage),
+	}
+	opts := protojson.MarshalOptions{EmitUnpopulated: true}
+	for row, b := range req.MetricsBucket {
+		data, err := opts.Marshal(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err = flattenColumns(block, row, "", data); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := json.Marshal(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return append(res, '\n'), nil
+}
+
+// flattenColumns adds fields of JSON object data to block's columns for the given row.
+func flattenColumns(block *columnarBlock, row int, prefix string, data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for name, value := range fields {
+		name = prefix + name
+		if bytes.HasPrefix(value, []byte("{")) {
+			if err := flattenColumns(block, row, name+".", value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		column := block.Columns[name]
+		if column == nil {
+			// nil values are encoded as null
+			column = make([]json.RawMessage, block.Rows)
+			block.Columns[name] = column
+		}
+		column[row] = value
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qansink
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxFileSize = 100 * 1024 * 1024
+	defaultMaxFiles    = 10
+
+	filePrefix         = "qan-"
+	ndjsonFileSuffix   = ".ndjson"
+	columnarFileSuffix = ".columnar.jsonl"
+)
+
+// FileSink writes newline-delimited JSON files to the directory.
+// Current file is rotated when it becomes larger than max-size; only max-files newest files are kept.
+//
+// In ndjson format (default), each line is a single metrics bucket.
+// In columnar format, each line is a block of all metrics buckets of a single request stored by columns,
+// similar to Parquet row groups (but it is not Apache Parquet format).
+type FileSink struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	suffix   string
+	marshal  func(*agentpb.QANCollectRequest) ([]byte, error)
+	l        *logrus.Entry
+
+	m    sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink creates new FileSink for file:///path/to/dir?max-size=104857600&max-files=10&format=ndjson URL.
+// Zero max-files disables removal of old files. Format is ndjson (default) or columnar.
+func NewFileSink(u *url.URL, l *logrus.Entry) (*FileSink, error) {
+	if u.Path == "" {
+		return nil, errors.Errorf("QAN sink %q: directory is not set", u)
+	}
+
+	s := &FileSink{
+		dir:      filepath.Clean(u.Path),
+		maxSize:  defaultMaxFileSize,
+		maxFiles: defaultMaxFiles,
+		suffix:   ndjsonFileSuffix,
+		marshal:  marshalBuckets,
+		l:        l,
+	}
+
+	q := u.Query()
+	if v := q.Get("max-size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, errors.Errorf("QAN sink %q: invalid max-size %q", u, v)
+		}
+		s.maxSize = size
+	}
+	if v := q.Get("max-files"); v != "" {
+		files, err := strconv.Atoi(v)
+		if err != nil || files < 0 {
+			return nil, errors.Errorf("QAN sink %q: invalid max-files %q", u, v)
+		}
+		s.maxFiles = files
+	}
+	switch v := q.Get("format"); v {
+	case "", "ndjson":
+	case "columnar":
+		s.suffix = columnarFileSuffix
+		s.marshal = marshalColumnar
+	default:
+		return nil, errors.Errorf("QAN sink %q: invalid format %q", u, v)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return s, nil
+}
+
+// Write implements Sink.
+func (s *FileSink) Write(req *agentpb.QANCollectRequest) error {
+	b, err := s.marshal(req)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.f != nil && s.size+int64(len(b)) > s.maxSize {
+		if err = s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err = s.openFile(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return errors.WithStack(err)
+}
+
+// Close implements Sink.
+func (s *FileSink) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+// openFile creates a new current file and removes the oldest ones.
+// Must be called with s.m held.
+func (s *FileSink) openFile() error {
+	name := filepath.Join(s.dir, filePrefix+time.Now().UTC().Format("20060102T150405.000000000Z")+s.suffix)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640) //nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.l.Debugf("Writing to %s.", name)
+	s.f = f
+	s.size = 0
+
+	s.removeOld()
+	return nil
+}
+
+// closeFile closes the current file.
+// Must be called with s.m held.
+func (s *FileSink) closeFile() error {
+	err := s.f.Close()
+	s.f = nil
+	return errors.WithStack(err)
+}
+
+// removeOld removes the oldest files over the limit.
+// Must be called with s.m held.
+func (s *FileSink) removeOld() {
+	if s.maxFiles == 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.l.Warnf("Failed to read directory: %s.", err)
+		return
+	}
+
+	var names []string
+	for _, e := range entries {
+		if name := e.Name(); !e.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, s.suffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names) // names sort by creation time
+
+	for len(names) > s.maxFiles {
+		path := filepath.Join(s.dir, names[0])
+		if err = os.Remove(path); err != nil {
+			s.l.Warnf("Failed to remove %s: %s.", path, err)
+		}
+		names = names[1:]
+	}
+}
+
+// check interfaces
+var (
+	_ Sink = (*FileSink)(nil)
+)
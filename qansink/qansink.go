@@ -0,0 +1,150 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package qansink provides destinations for QAN data produced by built-in Agents
+// other than PMM Server: newline-delimited JSON files (with buckets stored by rows or by columns)
+// and standard output.
+package qansink
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// Server is a sink name for sending QAN data to PMM Server.
+	Server = "server"
+	// Stdout is a sink name for writing QAN data to standard output.
+	Stdout = "stdout"
+)
+
+// Sink writes QAN data somewhere.
+type Sink interface {
+	// Write writes all metrics buckets of the given request.
+	Write(req *agentpb.QANCollectRequest) error
+	io.Closer
+}
+
+// Sinks is a set of QAN data destinations.
+// Zero value (and nil) sends QAN data only to PMM Server.
+type Sinks struct {
+	sinks      []Sink
+	skipServer bool
+	l          *logrus.Entry
+}
+
+// New creates sinks from comma-separated list:
+//   - "server" sends data to PMM Server;
+//   - "stdout" writes newline-delimited JSON to standard output;
+//   - "file:///path/to/dir" writes newline-delimited JSON files with rotation to the given directory,
+//     by rows or by columns, see NewFileSink for query parameters.
+//
+// If list is empty, data is sent to PMM Server only.
+func New(list string, l *logrus.Entry) (*Sinks, error) {
+	res := &Sinks{l: l}
+	if strings.TrimSpace(list) == "" {
+		return res, nil
+	}
+
+	res.skipServer = true
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == Server:
+			res.skipServer = false
+
+		case s == Stdout:
+			res.sinks = append(res.sinks, NewWriterSink(os.Stdout))
+
+		case strings.HasPrefix(s, "file:"):
+			u, err := url.Parse(s)
+			if err != nil {
+				res.Close() //nolint:errcheck
+				return nil, errors.Wrapf(err, "failed to parse QAN sink %q", s)
+			}
+			sink, err := NewFileSink(u, l.WithField("sink", u.Path))
+			if err != nil {
+				res.Close() //nolint:errcheck
+				return nil, err
+			}
+			res.sinks = append(res.sinks, sink)
+
+		default:
+			res.Close() //nolint:errcheck
+			return nil, errors.Errorf("unexpected QAN sink %q", s)
+		}
+	}
+
+	return res, nil
+}
+
+// SendToServer returns true if QAN data should be sent to PMM Server.
+func (s *Sinks) SendToServer() bool {
+	return s == nil || !s.skipServer
+}
+
+// HasLocal returns true if QAN data is written to at least one local sink.
+func (s *Sinks) HasLocal() bool {
+	return s != nil && len(s.sinks) != 0
+}
+
+// Write writes QAN data to all local sinks. Errors are logged.
+func (s *Sinks) Write(req *agentpb.QANCollectRequest) {
+	if s == nil {
+		return
+	}
+
+	for _, sink := range s.sinks {
+		if err := sink.Write(req); err != nil {
+			s.l.Errorf("Failed to write QAN data: %s.", err)
+		}
+	}
+}
+
+// Close closes all local sinks.
+func (s *Sinks) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	var res error
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// marshalBuckets returns metrics buckets of the given request as newline-delimited JSON.
+func marshalBuckets(req *agentpb.QANCollectRequest) ([]byte, error) {
+	var res []byte
+	for _, b := range req.MetricsBucket {
+		line, err := protojson.Marshal(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, line...)
+		res = append(res, '\n')
+	}
+	return res, nil
+}
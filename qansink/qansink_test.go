@@ -0,0 +1,176 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qansink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func request(queryIDs ...string) *agentpb.QANCollectRequest {
+	req := new(agentpb.QANCollectRequest)
+	for _, id := range queryIDs {
+		req.MetricsBucket = append(req.MetricsBucket, &agentpb.MetricsBucket{
+			Common: &agentpb.MetricsBucket_Common{
+				Queryid:    id,
+				NumQueries: 1,
+			},
+		})
+	}
+	return req
+}
+
+func readBuckets(t *testing.T, b []byte) []string {
+	t.Helper()
+
+	var res []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		var mb agentpb.MetricsBucket
+		require.NoError(t, protojson.Unmarshal(s.Bytes(), &mb))
+		res = append(res, mb.Common.Queryid)
+	}
+	require.NoError(t, s.Err())
+	return res
+}
+
+func TestNew(t *testing.T) {
+	l := logrus.WithField("test", t.Name())
+
+	for list, expected := range map[string]struct{ server, local bool }{
+		"":              {server: true},
+		"server":        {server: true},
+		"stdout":        {local: true},
+		"stdout,server": {server: true, local: true},
+	} {
+		s, err := New(list, l)
+		require.NoError(t, err, "%q", list)
+		assert.Equal(t, expected.server, s.SendToServer(), "%q", list)
+		assert.Equal(t, expected.local, s.HasLocal(), "%q", list)
+		assert.NoError(t, s.Close())
+	}
+
+	var s *Sinks
+	assert.True(t, s.SendToServer())
+	assert.False(t, s.HasLocal())
+
+	_, err := New("server,kafka://localhost", l)
+	assert.EqualError(t, err, `unexpected QAN sink "kafka://localhost"`)
+
+	_, err = New("file:///tmp/qan?max-size=foo", l)
+	assert.EqualError(t, err, `QAN sink "file:///tmp/qan?max-size=foo": invalid max-size "foo"`)
+
+	_, err = New("file:///tmp/qan?format=parquet", l)
+	assert.EqualError(t, err, `QAN sink "file:///tmp/qan?format=parquet": invalid format "parquet"`)
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriterSink(&buf)
+	require.NoError(t, s.Write(request("1", "2")))
+	require.NoError(t, s.Write(request("3")))
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, []string{"1", "2", "3"}, readBuckets(t, buf.Bytes()))
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+
+	// fit two buckets per file
+	line, err := marshalBuckets(request("1"))
+	require.NoError(t, err)
+	u, err := url.Parse(fmt.Sprintf("file://%s?max-files=2&max-size=%d", dir, 2*len(line)+1))
+	require.NoError(t, err)
+
+	s, err := NewFileSink(u, logrus.WithField("test", t.Name()))
+	require.NoError(t, err)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		require.NoError(t, s.Write(request(id)))
+	}
+	require.NoError(t, s.Close())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	var actual []string
+	for _, f := range files {
+		b, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		actual = append(actual, readBuckets(t, b)...)
+	}
+	assert.Equal(t, []string{"3", "4", "5"}, actual)
+}
+
+func TestColumnarFileSink(t *testing.T) {
+	dir := t.TempDir()
+
+	// ndjson files in the same directory are not touched
+	ndjson := filepath.Join(dir, "qan-20220101T000000.000000000Z.ndjson")
+	require.NoError(t, os.WriteFile(ndjson, nil, 0o600))
+
+	u, err := url.Parse(fmt.Sprintf("file://%s?max-files=1&max-size=1&format=columnar", dir))
+	require.NoError(t, err)
+	s, err := NewFileSink(u, logrus.WithField("test", t.Name()))
+	require.NoError(t, err)
+
+	req := request("1", "2")
+	req.MetricsBucket[1].Mysql = &agentpb.MetricsBucket_MySQL{MRowsSentSum: 5}
+	require.NoError(t, s.Write(req))
+	require.NoError(t, s.Write(new(agentpb.QANCollectRequest))) // skipped
+	require.NoError(t, s.Write(request("3")))
+	require.NoError(t, s.Close())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.FileExists(t, ndjson)
+
+	b, err := os.ReadFile(filepath.Join(dir, files[1].Name()))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(files[1].Name(), ".columnar.jsonl"), "%s", files[1].Name())
+	var block struct {
+		Rows    int
+		Columns map[string][]interface{}
+	}
+	require.NoError(t, json.Unmarshal(b, &block))
+	assert.Equal(t, 1, block.Rows)
+	assert.Equal(t, []interface{}{"3"}, block.Columns["common.queryid"])
+
+	actual, err := marshalColumnar(req)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(actual, &block))
+	assert.Equal(t, 2, block.Rows)
+	assert.Equal(t, []interface{}{"1", "2"}, block.Columns["common.queryid"])
+	assert.Equal(t, []interface{}{nil, float64(5)}, block.Columns["mysql.mRowsSentSum"])
+	for name, column := range block.Columns {
+		assert.Len(t, column, 2, "%s", name)
+	}
+}
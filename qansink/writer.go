@@ -0,0 +1,61 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qansink
+
+import (
+	"io"
+	"sync"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+)
+
+// WriterSink writes newline-delimited JSON to io.Writer.
+type WriterSink struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+// NewWriterSink creates new WriterSink. Writer is not closed by Close.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+// Write implements Sink.
+func (s *WriterSink) Write(req *agentpb.QANCollectRequest) error {
+	b, err := marshalBuckets(req)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, err = s.w.Write(b)
+	return errors.WithStack(err)
+}
+
+// Close implements Sink.
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// check interfaces
+var (
+	_ Sink = (*WriterSink)(nil)
+)
@@ -0,0 +1,179 @@
+-- Corpus of DML queries for dmlToSelect tests (see actions/query_transform_test.go).
+-- Every query ends with ';' and is followed by "-- => " line with the expected SELECT,
+-- or by "-- => ERROR" line if the query can't be converted.
+
+UPDATE t SET a = 1;
+-- => SELECT a = 1 FROM t
+
+UPDATE LOW_PRIORITY IGNORE t SET a = 1, b = b + 1 WHERE id = 2 ORDER BY id DESC LIMIT 10;
+-- => SELECT a = 1, b = b + 1 FROM t WHERE id = 2 ORDER BY id DESC LIMIT 10
+
+update db1.t1 as t set t.name = 'O''Reilly; where' where t.id in (1, 2, 3);
+-- => SELECT t.name = 'O''Reilly; where' FROM db1.t1 as t WHERE t.id in (1, 2, 3)
+
+UPDATE `where` SET `set` = 'limit' WHERE `limit` = "order by";
+-- => SELECT `set` = 'limit' FROM `where` WHERE `limit` = "order by"
+
+UPDATE t1 JOIN t2 ON t1.id = t2.t1_id LEFT JOIN t3 USING (id) SET t1.a = t2.b, t2.c = t3.c WHERE t3.id IS NULL;
+-- => SELECT t1.a = t2.b, t2.c = t3.c FROM t1 JOIN t2 ON t1.id = t2.t1_id LEFT JOIN t3 USING (id) WHERE t3.id IS NULL
+
+UPDATE t1, t2 SET t1.a = t2.a WHERE t1.id = t2.id;
+-- => SELECT t1.a = t2.a FROM t1, t2 WHERE t1.id = t2.id
+
+UPDATE t SET a = (SELECT MAX(b) FROM u WHERE u.id = t.id ORDER BY b LIMIT 1) WHERE id > 10 LIMIT 5;
+-- => SELECT a = (SELECT MAX(b) FROM u WHERE u.id = t.id ORDER BY b LIMIT 1) FROM t WHERE id > 10 LIMIT 5
+
+UPDATE t SET a = CASE WHEN b > 0 THEN 'set' ELSE 'where' END WHERE c IN (SELECT c FROM u WHERE d = 1);
+-- => SELECT a = CASE WHEN b > 0 THEN 'set' ELSE 'where' END FROM t WHERE c IN (SELECT c FROM u WHERE d = 1)
+
+UPDATE /*+ NO_INDEX_MERGE(t) */ t SET a = 1 WHERE b = 2 OR c = 3;
+-- => SELECT /*+ NO_INDEX_MERGE(t) */ a = 1 FROM t WHERE b = 2 OR c = 3
+
+/* leading comment */ UPDATE t -- trailing comment
+SET a = 1 # hash comment
+WHERE b = 'it''s' AND c = "say \"hi\"";
+-- => SELECT a = 1 FROM t WHERE b = 'it''s' AND c = "say \"hi\""
+
+UPDATE t SET a = ? WHERE b = ? AND c = @var;
+-- => SELECT a = ? FROM t WHERE b = ? AND c = @var
+
+WITH cte AS (SELECT id FROM u WHERE x = 1) UPDATE t JOIN cte USING (id) SET t.a = 0;
+-- => WITH cte AS (SELECT id FROM u WHERE x = 1) SELECT t.a = 0 FROM t JOIN cte USING (id)
+
+WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < 5), other AS (SELECT 2) UPDATE t SET a = 1 WHERE id IN (SELECT n FROM seq);
+-- => WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < 5), other AS (SELECT 2) SELECT a = 1 FROM t WHERE id IN (SELECT n FROM seq)
+
+DELETE FROM t;
+-- => SELECT * FROM t
+
+DELETE LOW_PRIORITY QUICK IGNORE FROM t AS a PARTITION (p0, p1) WHERE a.x = 1 ORDER BY a.ts LIMIT 100;
+-- => SELECT * FROM t AS a PARTITION (p0, p1) WHERE a.x = 1 ORDER BY a.ts LIMIT 100
+
+DELETE t1, t2 FROM t1 INNER JOIN t2 ON t1.id = t2.id WHERE t1.x = 'from';
+-- => SELECT t1.*, t2.* FROM t1 INNER JOIN t2 ON t1.id = t2.id WHERE t1.x = 'from'
+
+DELETE t1.* FROM t1 LEFT JOIN t2 ON t1.id = t2.id WHERE t2.id IS NULL;
+-- => SELECT t1.* FROM t1 LEFT JOIN t2 ON t1.id = t2.id WHERE t2.id IS NULL
+
+DELETE FROM t1, t2.* USING t1 JOIN t2 JOIN t3 WHERE t1.id = t2.id AND t2.id = t3.id;
+-- => SELECT t1.*, t2.* FROM t1 JOIN t2 JOIN t3 WHERE t1.id = t2.id AND t2.id = t3.id
+
+DELETE FROM `from` WHERE `where` = 1;
+-- => SELECT * FROM `from` WHERE `where` = 1
+
+DELETE FROM t WHERE id IN (SELECT id FROM (SELECT id FROM t ORDER BY ts LIMIT 10) AS old);
+-- => SELECT * FROM t WHERE id IN (SELECT id FROM (SELECT id FROM t ORDER BY ts LIMIT 10) AS old)
+
+WITH old AS (SELECT id FROM t WHERE ts < NOW() - INTERVAL 1 DAY) DELETE t FROM t JOIN old USING (id);
+-- => WITH old AS (SELECT id FROM t WHERE ts < NOW() - INTERVAL 1 DAY) SELECT t.* FROM t JOIN old USING (id)
+
+INSERT INTO t (a, b, c) VALUES (1, 'x', NULL);
+-- => SELECT * FROM t WHERE a = 1 AND b = 'x' AND c = NULL
+
+INSERT LOW_PRIORITY IGNORE INTO t (a, b) VALUES (1, 'a,b'), (-2, CONCAT('c', ')'));
+-- => SELECT * FROM t WHERE (a = 1 AND b = 'a,b') OR (a = (-2) AND b = (CONCAT('c', ')')))
+
+INSERT INTO t (id, name) VALUES (1, 'x') ON DUPLICATE KEY UPDATE name = VALUES(name);
+-- => SELECT * FROM t WHERE id = 1 AND name = 'x'
+
+INSERT INTO t (id, name) VALUES (1, 'x') AS new (i, n) ON DUPLICATE KEY UPDATE name = new.n;
+-- => SELECT * FROM t WHERE id = 1 AND name = 'x'
+
+INSERT INTO t (id, created) VALUE (7, DEFAULT);
+-- => SELECT * FROM t WHERE id = 7
+
+INSERT INTO t (a) VALUES (DEFAULT);
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t VALUES (1, 2, 3);
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t (a, b) VALUES (1);
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t () VALUES ();
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t (a, b) VALUES ROW(1, 2), ROW(3, 4);
+-- => SELECT * FROM t WHERE (a = 1 AND b = 2) OR (a = 3 AND b = 4)
+
+INSERT t SET a = 1, b.c = 'x' ON DUPLICATE KEY UPDATE a = a + 1;
+-- => SELECT * FROM t WHERE a = 1 AND b.c = 'x'
+
+INSERT INTO t PARTITION (p1) SET a = x OR y;
+-- => SELECT * FROM t PARTITION (p1) WHERE a = (x OR y)
+
+INSERT INTO `values` (`select`) VALUES ('set');
+-- => SELECT * FROM `values` WHERE `select` = 'set'
+
+INSERT INTO t (a, b) SELECT x, y FROM u WHERE z = 1 ORDER BY x LIMIT 10;
+-- => SELECT x, y FROM u WHERE z = 1 ORDER BY x LIMIT 10
+
+INSERT IGNORE INTO t SELECT * FROM u JOIN v USING (id) ON DUPLICATE KEY UPDATE a = v.a;
+-- => SELECT * FROM u JOIN v USING (id)
+
+INSERT INTO t (a) (SELECT x FROM u UNION SELECT y FROM v);
+-- => (SELECT x FROM u UNION SELECT y FROM v)
+
+INSERT INTO t WITH cte AS (SELECT 1 AS a) SELECT a FROM cte;
+-- => WITH cte AS (SELECT 1 AS a) SELECT a FROM cte
+
+INSERT INTO t (a, b) TABLE u ORDER BY a LIMIT 3;
+-- => SELECT * FROM u ORDER BY a LIMIT 3
+
+INSERT INTO t (a, b) VALUES ROW(1, 2) UNION TABLE u;
+-- => ERROR
+
+REPLACE INTO t (id, a) VALUES (1, 2);
+-- => SELECT * FROM t WHERE id = 1 AND a = 2
+
+REPLACE DELAYED t SET id = 1;
+-- => SELECT * FROM t WHERE id = 1
+
+REPLACE INTO t SELECT * FROM u;
+-- => SELECT * FROM u
+
+/*!40000 UPDATE t SET a = 1 */ WHERE b = 2;
+-- => SELECT a = 1 FROM t WHERE b = 2
+
+SELECT * FROM t;
+-- => ERROR
+
+WITH cte AS (SELECT 1) SELECT * FROM cte;
+-- => ERROR
+
+UPDATE t SET a = 1; DROP TABLE t;
+-- => ERROR
+
+UPDATE t SET a = 'unterminated WHERE b = 1;
+-- => ERROR
+
+UPDATE t SET a = (1 WHERE b = 1;
+-- => ERROR
+
+UPDATE t WHERE b = 1;
+-- => ERROR
+
+UPDATE SET a = 1;
+-- => ERROR
+
+UPDATE t SET a = 1 FROM u WHERE t.id = u.id;
+-- => ERROR
+
+DELETE FROM WHERE a = 1;
+-- => ERROR
+
+DELETE FROM t WHERE;
+-- => ERROR
+
+INSERT INTO t;
+-- => ERROR
+
+INSERT INTO t SET a;
+-- => ERROR
+
+INSERT INTO t (a) VALUES (1) RETURNING id;
+-- => ERROR
+
+LOAD DATA INFILE 'data.txt' INTO TABLE t;
+-- => ERROR
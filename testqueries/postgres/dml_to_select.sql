@@ -0,0 +1,122 @@
+-- Corpus of PostgreSQL DML queries for dmlToSelect tests (see actions/query_transform_test.go).
+-- Every query ends with ';' and is followed by "-- => " line with the expected SELECT,
+-- or by "-- => ERROR" line if the query can't be converted.
+
+UPDATE t SET a = 1;
+-- => SELECT a = 1 FROM t
+
+UPDATE ONLY public.t AS x SET a = 1, b = b + 1 WHERE x.id = $1;
+-- => SELECT a = 1, b = b + 1 FROM ONLY public.t AS x WHERE x.id = $1
+
+UPDATE t SET (a, b) = (1, 2) WHERE id = 3 RETURNING *;
+-- => SELECT (a, b) = (1, 2) FROM t WHERE id = 3
+
+UPDATE accounts a SET balance = a.balance + d.amount FROM deposits d WHERE d.account_id = a.id;
+-- => SELECT balance = a.balance + d.amount FROM accounts a, deposits d WHERE d.account_id = a.id
+
+UPDATE t SET a = u.a FROM u JOIN v ON u.id = v.id WHERE t.id = u.id RETURNING t.id, u.a;
+-- => SELECT a = u.a FROM t, u JOIN v ON u.id = v.id WHERE t.id = u.id
+
+UPDATE "order" SET "from" = 'where' WHERE "using" = 'returning';
+-- => SELECT "from" = 'where' FROM "order" WHERE "using" = 'returning'
+
+UPDATE t SET a = 'C:\' WHERE b = 'it''s';
+-- => SELECT a = 'C:\' FROM t WHERE b = 'it''s'
+
+UPDATE t SET a = E'it\'s; where' WHERE b = e'\\';
+-- => SELECT a = E'it\'s; where' FROM t WHERE b = e'\\'
+
+UPDATE t SET body = $$it's; where 1 = 1$$ WHERE id = 1;
+-- => SELECT body = $$it's; where 1 = 1$$ FROM t WHERE id = 1
+
+UPDATE t SET body = $fn$ $$ nested; $$ $fn$ WHERE id = 1;
+-- => SELECT body = $fn$ $$ nested; $$ $fn$ FROM t WHERE id = 1
+
+UPDATE t SET a = b::text, c = d || 'x' WHERE e @> '{1}' AND f ? 'key' AND g # 1 = 0;
+-- => SELECT a = b::text, c = d || 'x' FROM t WHERE e @> '{1}' AND f ? 'key' AND g # 1 = 0
+
+/* leading /* nested */ comment */ UPDATE t --trailing comment
+SET a = 1
+WHERE b = 2;
+-- => SELECT a = 1 FROM t WHERE b = 2
+
+UPDATE t SET a = (SELECT max(b) FROM u WHERE u.id = t.id ORDER BY 1 LIMIT 1) WHERE id > 10;
+-- => SELECT a = (SELECT max(b) FROM u WHERE u.id = t.id ORDER BY 1 LIMIT 1) FROM t WHERE id > 10
+
+WITH c AS MATERIALIZED (SELECT id FROM u WHERE x = 1) UPDATE t SET a = 0 FROM c WHERE t.id = c.id;
+-- => WITH c AS MATERIALIZED (SELECT id FROM u WHERE x = 1) SELECT a = 0 FROM t, c WHERE t.id = c.id
+
+DELETE FROM t;
+-- => SELECT * FROM t
+
+DELETE FROM t WHERE a = 1 RETURNING *;
+-- => SELECT * FROM t WHERE a = 1
+
+DELETE FROM ONLY public.t WHERE a = 1;
+-- => SELECT * FROM ONLY public.t WHERE a = 1
+
+DELETE FROM films USING producers WHERE producer_id = producers.id AND producers.name = 'foo';
+-- => SELECT films.* FROM films, producers WHERE producer_id = producers.id AND producers.name = 'foo'
+
+DELETE FROM public.films AS f USING producers p, studios s WHERE f.producer_id = p.id AND p.studio_id = s.id;
+-- => SELECT f.* FROM public.films AS f, producers p, studios s WHERE f.producer_id = p.id AND p.studio_id = s.id
+
+DELETE FROM "My Table" t USING u WHERE t.id = u.id RETURNING t.id;
+-- => SELECT t.* FROM "My Table" t, u WHERE t.id = u.id
+
+DELETE FROM public."My Table" USING u WHERE "My Table".id = u.id;
+-- => SELECT public."My Table".* FROM public."My Table", u WHERE "My Table".id = u.id
+
+INSERT INTO t (a, b) VALUES (1, 'x');
+-- => SELECT * FROM t WHERE a = 1 AND b = 'x'
+
+INSERT INTO t (a, b) VALUES (1, 'x'), ($1, $2) RETURNING id;
+-- => SELECT * FROM t WHERE (a = 1 AND b = 'x') OR (a = $1 AND b = $2)
+
+INSERT INTO t AS x (a) VALUES (1) ON CONFLICT (a) DO UPDATE SET b = x.b + 1 WHERE x.c > 0 RETURNING x.id;
+-- => SELECT * FROM t AS x WHERE a = 1
+
+INSERT INTO t (a) VALUES (1) ON CONFLICT ON CONSTRAINT t_pkey DO NOTHING;
+-- => SELECT * FROM t WHERE a = 1
+
+INSERT INTO t (id, a) OVERRIDING SYSTEM VALUE VALUES (1, 2);
+-- => SELECT * FROM t WHERE id = 1 AND a = 2
+
+INSERT INTO t (a, b) VALUES (DEFAULT, 'it''s');
+-- => SELECT * FROM t WHERE b = 'it''s'
+
+INSERT INTO t DEFAULT VALUES RETURNING id;
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t VALUES (1, 2);
+-- => SELECT * FROM t LIMIT 1
+
+INSERT INTO t (a) SELECT a FROM u WHERE b = 1 ON CONFLICT DO NOTHING;
+-- => SELECT a FROM u WHERE b = 1
+
+INSERT INTO t TABLE u RETURNING *;
+-- => SELECT * FROM u
+
+WITH s AS (SELECT 1 AS a) INSERT INTO t (a) SELECT a FROM s;
+-- => WITH s AS (SELECT 1 AS a) SELECT a FROM s
+
+REPLACE INTO t VALUES (1);
+-- => ERROR
+
+DELETE t FROM t;
+-- => ERROR
+
+DELETE FROM t WHERE CURRENT OF c;
+-- => ERROR
+
+UPDATE t SET a = 1 FROM;
+-- => ERROR
+
+UPDATE t SET a = $$unterminated;
+-- => ERROR
+
+INSERT t VALUES (1);
+-- => ERROR
+
+INSERT INTO t (a) VALUES (1) ON CONFLICT (a);
+-- => ERROR
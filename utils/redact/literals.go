@@ -0,0 +1,189 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"strings"
+)
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// skipQuoted returns the index after the string literal starting at i with the given quote.
+// Doubled quotes and backslash escapes are handled.
+func skipQuoted(s string, i int, quote byte) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipNumber returns the index after the numeric literal starting at start.
+func skipNumber(s string, start int) int {
+	hex := strings.HasPrefix(s[start:], "0x") || strings.HasPrefix(s[start:], "0X")
+	i := start
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case isDigit(c), c == '.', c == 'x', c == 'X', ('a' <= c && c <= 'f'), ('A' <= c && c <= 'F'):
+			i++
+		case (c == '+' || c == '-') && !hex && i > start && (s[i-1] == 'e' || s[i-1] == 'E'):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// maskSQLLiterals replaces string and numeric literals in SQL query with ?.
+// Identifiers, comments and PostgreSQL $n parameters are kept.
+// Double-quoted strings are literals in MySQL and identifiers in PostgreSQL.
+func maskSQLLiterals(query string, doubleQuotedStrings bool) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			i = skipQuoted(query, i, c)
+			b.WriteString(mask)
+
+		case c == '"' && doubleQuotedStrings:
+			i = skipQuoted(query, i, c)
+			b.WriteString(mask)
+
+		case c == '"' || c == '`':
+			end := skipQuoted(query, i, c)
+			b.WriteString(query[i:end])
+			i = end
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+			b.WriteString(query[i:end])
+			i = end
+
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i
+			}
+			b.WriteString(query[i:end])
+			i = end
+
+		case c == '$' && !doubleQuotedStrings && (i == 0 || !isIdentByte(query[i-1])):
+			// PostgreSQL dollar-quoted string $tag$...$tag$; $1 parameters are kept
+			tagEnd := i + 1
+			for tagEnd < len(query) && isIdentByte(query[tagEnd]) && query[tagEnd] != '$' && !isDigit(query[tagEnd]) {
+				tagEnd++
+			}
+			if tagEnd < len(query) && query[tagEnd] == '$' {
+				tag := query[i : tagEnd+1]
+				end := strings.Index(query[tagEnd+1:], tag)
+				if end < 0 {
+					i = len(query)
+				} else {
+					i = tagEnd + 1 + end + len(tag)
+				}
+				b.WriteString(mask)
+				continue
+			}
+			b.WriteByte(c)
+			i++
+			for i < len(query) && isDigit(query[i]) {
+				b.WriteByte(query[i])
+				i++
+			}
+
+		case isDigit(c) && (i == 0 || !isIdentByte(query[i-1])):
+			i = skipNumber(query, i)
+			b.WriteString(mask)
+
+		case isIdentByte(c):
+			// copy the whole identifier so digits in it are kept
+			start := i
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			b.WriteString(query[start:i])
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// maskJSONLiterals replaces string and numeric values (but not keys) in JSON document with "?".
+func maskJSONLiterals(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '"':
+			end := skipQuoted(query, i, c)
+			next := end
+			for next < len(query) && (query[next] == ' ' || query[next] == '\t' || query[next] == '\n') {
+				next++
+			}
+			if next < len(query) && query[next] == ':' {
+				b.WriteString(query[i:end])
+			} else {
+				b.WriteString(`"` + mask + `"`)
+			}
+			i = end
+
+		case (isDigit(c) || (c == '-' && i+1 < len(query) && isDigit(query[i+1]))) && (i == 0 || !isIdentByte(query[i-1])):
+			if c == '-' {
+				i++
+			}
+			i = skipNumber(query, i)
+			b.WriteString(`"` + mask + `"`)
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,251 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// Output redacts Action output of any format: serialized query results (agentpb.QueryActionResult),
+// JSON documents and tables (the first row contains column names), or plain text.
+//
+// Values of fields and columns matching masked column patterns are replaced,
+// strings in query text fields (like "query" or "blocking_query") are redacted as query examples in given dialect,
+// and rules are applied to all other strings. For plain text, only rules are applied.
+// Output is returned as is if nothing was changed.
+func (r *Redactor) Output(dialect Dialect, b []byte) ([]byte, error) {
+	if !r.Enabled() || len(b) == 0 {
+		return b, nil
+	}
+
+	if json.Valid(b) {
+		return r.outputJSON(dialect, b)
+	}
+
+	var res agentpb.QueryActionResult
+	if err := proto.Unmarshal(b, &res); err == nil && len(res.ProtoReflect().GetUnknown()) == 0 &&
+		(len(res.Columns) != 0 || len(res.Docs) != 0) {
+		return r.outputQueryResult(dialect, b, &res)
+	}
+
+	if len(r.rules) == 0 {
+		return b, nil
+	}
+	s := r.applyRules(string(b))
+	if s == string(b) {
+		return b, nil
+	}
+	return []byte(s), nil
+}
+
+// outputJSON redacts JSON document.
+func (r *Redactor) outputJSON(dialect Dialect, b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	v, changed := r.jsonValue(dialect, "", v)
+	if !changed {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// jsonValue redacts decoded JSON value of the field or column with given name.
+func (r *Redactor) jsonValue(dialect Dialect, name string, v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if r.columnRE != nil && name != "" && r.columnRE.MatchString(name) {
+		return mask, true
+	}
+
+	var changed bool
+	switch v := v.(type) {
+	case string:
+		s := r.outputString(dialect, name, v)
+		return s, s != v
+
+	case map[string]interface{}:
+		for k, e := range v {
+			if e, c := r.jsonValue(dialect, k, e); c {
+				v[k] = e
+				changed = true
+			}
+		}
+
+	case []interface{}:
+		columns := tableColumns(v)
+		for i, e := range v {
+			if columns == nil {
+				if e, c := r.jsonValue(dialect, name, e); c {
+					v[i] = e
+					changed = true
+				}
+				continue
+			}
+
+			// keep column names row as is
+			if i == 0 {
+				continue
+			}
+			row := e.([]interface{})
+			for j, e := range row {
+				if e, c := r.jsonValue(dialect, columns[j], e); c {
+					row[j] = e
+					changed = true
+				}
+			}
+		}
+	}
+
+	return v, changed
+}
+
+// tableColumns returns column names if v is a table (like one returned by jsonRows in actions package):
+// the first row contains column names, and all other rows have the same length. Otherwise, it returns nil.
+func tableColumns(v []interface{}) []string {
+	if len(v) < 2 {
+		return nil
+	}
+
+	header, ok := v[0].([]interface{})
+	if !ok || len(header) == 0 {
+		return nil
+	}
+	columns := make([]string, len(header))
+	for i, c := range header {
+		if columns[i], ok = c.(string); !ok {
+			return nil
+		}
+	}
+
+	for _, row := range v[1:] {
+		if row, ok := row.([]interface{}); !ok || len(row) != len(columns) {
+			return nil
+		}
+	}
+	return columns
+}
+
+// outputQueryResult redacts deserialized query result res; b is its serialized form.
+func (r *Redactor) outputQueryResult(dialect Dialect, b []byte, res *agentpb.QueryActionResult) ([]byte, error) {
+	var changed bool
+	for _, row := range res.Rows {
+		for i, v := range row.Slice {
+			var name string
+			if i < len(res.Columns) {
+				name = res.Columns[i]
+			}
+			if r.queryResultValue(dialect, name, v) {
+				changed = true
+			}
+		}
+	}
+	for _, doc := range res.Docs {
+		for k, v := range doc.Map {
+			if r.queryResultValue(dialect, k, v) {
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return b, nil
+	}
+	b, err := proto.Marshal(res)
+	return b, errors.WithStack(err)
+}
+
+// queryResultValue redacts query result value of the field or column with given name in-place.
+func (r *Redactor) queryResultValue(dialect Dialect, name string, v *agentpb.QueryActionValue) bool {
+	if v == nil || v.GetNil() {
+		return false
+	}
+	if r.columnRE != nil && name != "" && r.columnRE.MatchString(name) {
+		v.Kind = &agentpb.QueryActionValue_Bytes{Bytes: []byte(mask)}
+		return true
+	}
+
+	var changed bool
+	switch k := v.Kind.(type) {
+	case *agentpb.QueryActionValue_Bytes:
+		s := r.outputString(dialect, name, string(k.Bytes))
+		if s != string(k.Bytes) {
+			k.Bytes = []byte(s)
+			changed = true
+		}
+
+	case *agentpb.QueryActionValue_Slice:
+		for _, e := range k.Slice.GetSlice() {
+			if r.queryResultValue(dialect, name, e) {
+				changed = true
+			}
+		}
+
+	case *agentpb.QueryActionValue_Map:
+		for n, e := range k.Map.GetMap() {
+			if r.queryResultValue(dialect, n, e) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// outputString redacts string value of the field or column with given name.
+func (r *Redactor) outputString(dialect Dialect, name, s string) string {
+	if isQueryField(name) {
+		return r.Query(dialect, "", s)
+	}
+	return r.applyRules(s)
+}
+
+// applyRules applies regular expression rules to s.
+func (r *Redactor) applyRules(s string) string {
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// isQueryField returns true if field or column with given name contains query text.
+func isQueryField(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "query", "statement", "info", "sql_text":
+		return true
+	default:
+		return strings.HasSuffix(name, "_query")
+	}
+}
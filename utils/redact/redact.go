@@ -0,0 +1,175 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redact provides redaction of query examples and query results
+// before they leave pmm-agent.
+package redact
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/pkg/errors"
+
+	"github.com/percona/pmm-agent/config"
+)
+
+// Dialect represents query language of examples.
+type Dialect int
+
+// Supported dialects.
+const (
+	MySQL Dialect = iota
+	PostgreSQL
+	MongoDB
+)
+
+// mask replaces redacted values.
+const mask = "?"
+
+type rule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// Redactor redacts query examples and query results according to configuration.
+// Nil Redactor passes everything as is.
+type Redactor struct {
+	maskLiterals bool
+	allowSchemas map[string]struct{}
+	rules        []rule
+
+	// nil if columns are not masked
+	columnRE     *regexp.Regexp // result set column names
+	sqlColumnRE  *regexp.Regexp // column comparisons and assignments in SQL queries
+	jsonColumnRE *regexp.Regexp // fields in MongoDB queries
+}
+
+// New creates a new Redactor.
+func New(cfg *config.QueryExamples) (*Redactor, error) {
+	r := &Redactor{
+		maskLiterals: cfg.MaskLiterals,
+		allowSchemas: make(map[string]struct{}, len(cfg.AllowSchemas)),
+	}
+
+	for _, s := range cfg.AllowSchemas {
+		r.allowSchemas[s] = struct{}{}
+	}
+
+	for i, c := range cfg.Rules {
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query examples rule #%d", i+1)
+		}
+		r.rules = append(r.rules, rule{re: re, replacement: c.Replacement})
+	}
+
+	if len(cfg.MaskColumns) != 0 {
+		patterns := make([]string, len(cfg.MaskColumns))
+		for i, c := range cfg.MaskColumns {
+			if c == "" || strings.Trim(c, "*") == "" {
+				return nil, errors.Errorf("query examples: invalid column pattern %q", c)
+			}
+			patterns[i] = strings.ReplaceAll(regexp.QuoteMeta(c), `\*`, `\w*`)
+		}
+		columns := strings.Join(patterns, "|")
+
+		r.columnRE = regexp.MustCompile(`(?i)^(?:` + columns + `)$`)
+		r.sqlColumnRE = regexp.MustCompile(`(?i)([` + "`" + `"]?\b(?:` + columns + `)\b[` + "`" + `"]?\s*` +
+			`(?:=|<=>|<>|!=|<=|>=|<|>|\bnot\s+like\b|\blike\b|\bnot\s+in\b|\bin\b)\s*)` +
+			`('(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"|\([^()]*\)|[-+]?[0-9][0-9a-zA-Z.+-]*)`)
+		r.jsonColumnRE = regexp.MustCompile(`(?i)("(?:` + columns + `)"\s*:\s*)` +
+			`("(?:[^"\\]|\\.)*"|\[[^\[\]]*\]|[-+]?[0-9][0-9.eE+-]*)`)
+	}
+
+	return r, nil
+}
+
+// Enabled returns true if Redactor changes anything.
+func (r *Redactor) Enabled() bool {
+	return r != nil && (r.maskLiterals || len(r.rules) != 0 || r.columnRE != nil)
+}
+
+// Query returns redacted query example from given schema or database.
+func (r *Redactor) Query(dialect Dialect, schema, query string) string {
+	if !r.Enabled() || query == "" {
+		return query
+	}
+	if _, ok := r.allowSchemas[schema]; ok && schema != "" {
+		return query
+	}
+
+	if r.columnRE != nil {
+		if dialect == MongoDB {
+			query = r.jsonColumnRE.ReplaceAllString(query, `${1}"`+mask+`"`)
+		} else {
+			query = maskSQLColumns(r.sqlColumnRE, query)
+		}
+	}
+
+	if r.maskLiterals {
+		if dialect == MongoDB {
+			query = maskJSONLiterals(query)
+		} else {
+			query = maskSQLLiterals(query, dialect == MySQL)
+		}
+	}
+
+	return r.applyRules(query)
+}
+
+// Buckets redacts query examples and plans in QAN metrics buckets in-place.
+func (r *Redactor) Buckets(buckets []*agentpb.MetricsBucket) {
+	if !r.Enabled() {
+		return
+	}
+
+	for _, b := range buckets {
+		if b.Common == nil {
+			continue
+		}
+
+		dialect := MySQL
+		switch b.Common.AgentType {
+		case inventorypb.AgentType_QAN_POSTGRESQL_PGSTATEMENTS_AGENT, inventorypb.AgentType_QAN_POSTGRESQL_PGSTATMONITOR_AGENT:
+			dialect = PostgreSQL
+		case inventorypb.AgentType_QAN_MONGODB_PROFILER_AGENT:
+			dialect = MongoDB
+		}
+
+		schema := b.Common.Schema
+		if schema == "" {
+			schema = b.Common.Database
+		}
+
+		b.Common.Example = r.Query(dialect, schema, b.Common.Example)
+		if b.Postgresql != nil {
+			b.Postgresql.QueryPlan = r.Query(PostgreSQL, schema, b.Postgresql.QueryPlan)
+		}
+	}
+}
+
+// maskSQLColumns replaces values compared with or assigned to matched columns.
+func maskSQLColumns(re *regexp.Regexp, query string) string {
+	return re.ReplaceAllStringFunc(query, func(m string) string {
+		sm := re.FindStringSubmatch(m)
+		if strings.HasPrefix(sm[2], "(") {
+			return sm[1] + "(" + mask + ")"
+		}
+		return sm[1] + mask
+	})
+}
@@ -0,0 +1,203 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"testing"
+
+	"github.com/percona/pmm/api/agentpb"
+	"github.com/percona/pmm/api/inventorypb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/pmm-agent/config"
+)
+
+func TestMaskLiterals(t *testing.T) {
+	t.Run("SQL", func(t *testing.T) {
+		for _, tc := range []struct {
+			query    string
+			mysql    string
+			postgres string
+		}{{
+			query:    "SELECT * FROM t1 WHERE name = 'O''Brien' AND id IN (1, 2.5, -3e-2) LIMIT 10",
+			mysql:    "SELECT * FROM t1 WHERE name = ? AND id IN (?, ?, -?) LIMIT ?",
+			postgres: "SELECT * FROM t1 WHERE name = ? AND id IN (?, ?, -?) LIMIT ?",
+		}, {
+			query:    `SELECT "col1", ` + "`col2`" + ` FROM t WHERE x = "it's" /* tag 1 */ -- 42`,
+			mysql:    `SELECT ?, ` + "`col2`" + ` FROM t WHERE x = ? /* tag 1 */ -- 42`,
+			postgres: `SELECT "col1", ` + "`col2`" + ` FROM t WHERE x = "it's" /* tag 1 */ -- 42`,
+		}, {
+			query:    `SELECT $1, $body$ secret $body$, 0xFF, 'a\'b'`,
+			mysql:    `SELECT $1, $body$ secret $body$, ?, ?`,
+			postgres: `SELECT $1, ?, ?, ?`,
+		}} {
+			assert.Equal(t, tc.mysql, maskSQLLiterals(tc.query, true), "%s", tc.query)
+			assert.Equal(t, tc.postgres, maskSQLLiterals(tc.query, false), "%s", tc.query)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		query := `{"find": "orders", "filter": {"qty": {"$gt": -10}, "tags": ["a", "b"], "ok": true}, "limit": 1.5e3}`
+		expected := `{"find": "?", "filter": {"qty": {"$gt": "?"}, "tags": ["?", "?"], "ok": true}, "limit": "?"}`
+		assert.Equal(t, expected, maskJSONLiterals(query))
+	})
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := New(&config.QueryExamples{
+		MaskColumns:  []string{"*email*", "card_number"},
+		AllowSchemas: []string{"sakila"},
+		Rules: []config.RedactRule{
+			{Pattern: `\b(\d{4})\d{8}(\d{4})\b`, Replacement: "$1********$2"},
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, r.Enabled())
+
+	t.Run("Query", func(t *testing.T) {
+		for _, tc := range []struct {
+			dialect  Dialect
+			schema   string
+			query    string
+			expected string
+		}{{
+			dialect:  MySQL,
+			query:    "SELECT * FROM users u WHERE u.`user_email` = 'a@b.c' AND card_number IN ('1', '2') AND id = 5",
+			expected: "SELECT * FROM users u WHERE u.`user_email` = ? AND card_number IN (?) AND id = 5",
+		}, {
+			dialect:  PostgreSQL,
+			query:    `UPDATE users SET "email"='x@y.z', note = 'card 1234567812345678' WHERE card_number LIKE '4%'`,
+			expected: `UPDATE users SET "email"=?, note = 'card 1234********5678' WHERE card_number LIKE ?`,
+		}, {
+			dialect:  MongoDB,
+			query:    `{"find": "users", "filter": {"email": "a@b.c", "card_number": 4111111111111111}}`,
+			expected: `{"find": "users", "filter": {"email": "?", "card_number": "?"}}`,
+		}, {
+			dialect:  MySQL,
+			schema:   "sakila",
+			query:    "SELECT * FROM customer WHERE email = 'a@b.c'",
+			expected: "SELECT * FROM customer WHERE email = 'a@b.c'",
+		}} {
+			assert.Equal(t, tc.expected, r.Query(tc.dialect, tc.schema, tc.query), "%s", tc.query)
+		}
+	})
+
+	t.Run("Buckets", func(t *testing.T) {
+		buckets := []*agentpb.MetricsBucket{{
+			Common: &agentpb.MetricsBucket_Common{
+				AgentType: inventorypb.AgentType_QAN_POSTGRESQL_PGSTATMONITOR_AGENT,
+				Database:  "shop",
+				Example:   "SELECT 1 FROM users WHERE email = 'a@b.c'",
+			},
+			Postgresql: &agentpb.MetricsBucket_PostgreSQL{
+				QueryPlan: "Seq Scan on users\n  Filter: (email = 'a@b.c'::text)",
+			},
+		}, {
+			Common: &agentpb.MetricsBucket_Common{
+				AgentType: inventorypb.AgentType_QAN_MYSQL_SLOWLOG_AGENT,
+				Schema:    "sakila",
+				Example:   "SELECT 1 FROM users WHERE email = 'a@b.c'",
+			},
+		}}
+		r.Buckets(buckets)
+		assert.Equal(t, "SELECT 1 FROM users WHERE email = ?", buckets[0].Common.Example)
+		assert.Equal(t, "Seq Scan on users\n  Filter: (email = ?::text)", buckets[0].Postgresql.QueryPlan)
+		assert.Equal(t, "SELECT 1 FROM users WHERE email = 'a@b.c'", buckets[1].Common.Example)
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		t.Run("QuerySQLResult", func(t *testing.T) {
+			b, err := agentpb.MarshalActionQuerySQLResult([]string{"id", "Email", "note", "info"}, [][]interface{}{
+				{int64(1), "a@b.c", "paid with 4111111111111111", "SELECT * FROM t WHERE x = 'secret'"},
+				{int64(2), nil, nil, nil},
+			})
+			require.NoError(t, err)
+
+			b, err = r.Output(MySQL, b)
+			require.NoError(t, err)
+			actual, err := agentpb.UnmarshalActionQueryResult(b)
+			require.NoError(t, err)
+			assert.Equal(t, []map[string]interface{}{
+				{"id": int64(1), "Email": "?", "note": "paid with 4111********1111", "info": "SELECT * FROM t WHERE x = 'secret'"},
+				{"id": int64(2), "Email": nil, "note": nil, "info": nil},
+			}, actual)
+		})
+
+		t.Run("QueryDocsResult", func(t *testing.T) {
+			b, err := agentpb.MarshalActionQueryDocsResult([]map[string]interface{}{
+				{"user": map[string]interface{}{"email": "a@b.c", "card": "4111111111111111"}},
+			})
+			require.NoError(t, err)
+
+			b, err = r.Output(MongoDB, b)
+			require.NoError(t, err)
+			actual, err := agentpb.UnmarshalActionQueryResult(b)
+			require.NoError(t, err)
+			assert.Equal(t, []map[string]interface{}{
+				{"user": map[string]interface{}{"email": "?", "card": "4111********1111"}},
+			}, actual)
+		})
+
+		t.Run("JSONTable", func(t *testing.T) {
+			b, err := r.Output(PostgreSQL, []byte(`[["pid","query","user_email"],[42,"SELECT 1 WHERE card_number = '1'","a@b.c"],[43,null,null]]`))
+			require.NoError(t, err)
+			assert.JSONEq(t, `[["pid","query","user_email"],[42,"SELECT 1 WHERE card_number = ?","?"],[43,null,null]]`, string(b))
+		})
+
+		t.Run("JSONObject", func(t *testing.T) {
+			b, err := r.Output(MySQL, []byte(`{"target":"42","blocking_query":"UPDATE t SET email = 'a@b.c'","note":"<4111111111111111>","id":12345678901234567890}`))
+			require.NoError(t, err)
+			assert.Equal(t, `{"blocking_query":"UPDATE t SET email = ?","id":12345678901234567890,"note":"<4111********1111>","target":"42"}`, string(b))
+		})
+
+		t.Run("Text", func(t *testing.T) {
+			b, err := r.Output(MySQL, []byte("# Card 4111111111111111\n"))
+			require.NoError(t, err)
+			assert.Equal(t, "# Card 4111********1111\n", string(b))
+		})
+
+		t.Run("Unchanged", func(t *testing.T) {
+			for _, expected := range []string{
+				`{"b": 1, "a": "x <y>"}`,
+				`[["id"],[1]]`,
+				"plain text",
+			} {
+				b, err := r.Output(MySQL, []byte(expected))
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(b))
+			}
+		})
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		var r *Redactor
+		assert.False(t, r.Enabled())
+		assert.Equal(t, "SELECT 1", r.Query(MySQL, "", "SELECT 1"))
+		r.Buckets([]*agentpb.MetricsBucket{{Common: &agentpb.MetricsBucket_Common{Example: "SELECT 1"}}})
+		b, err := r.Output(MySQL, []byte(`{"email": "a@b.c"}`))
+		require.NoError(t, err)
+		assert.Equal(t, `{"email": "a@b.c"}`, string(b))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := New(&config.QueryExamples{Rules: []config.RedactRule{{Pattern: "("}}})
+		assert.EqualError(t, err, "query examples rule #1: error parsing regexp: missing closing ): `(`")
+
+		_, err = New(&config.QueryExamples{MaskColumns: []string{"**"}})
+		assert.EqualError(t, err, `query examples: invalid column pattern "**"`)
+	})
+}
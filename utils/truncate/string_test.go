@@ -0,0 +1,36 @@
+// pmm-agent
+// Copyright 2019 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	for s, expected := range map[string]string{
+		"":        "",
+		"абвгд":   "абвгд",
+		"абвгде":  "аб...",
+		"abcdefg": "ab...",
+		"日本語テキスト": "日本...",
+	} {
+		assert.Equal(t, expected, String(s, 5), "%q", s)
+	}
+
+	assert.Equal(t, "аб", String("абвгд", 2))
+}